@@ -0,0 +1,65 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/core/encryption"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/log"
+	"github.com/apisix/manager-api/internal/utils"
+)
+
+func newReencryptCommand() *cobra.Command {
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "reencrypt",
+		Short: "re-encrypt the SSL keys and the consumer secrets with the first key of the data encryption keyring",
+		Long: "Re-encrypt the SSL keys and the consumer secrets with the first key of the data encryption keyring.\n" +
+			"To rotate the keys, put the new key first in the keyrings of both manager-api and APISIX, run\n" +
+			"this command, then remove the old keys from the keyrings. The objects with values decrypted by more\n" +
+			"than one key are reported as ambiguous and left alone, set those values again before removing the\n" +
+			"old keys.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conf.InitConf()
+			log.InitLogger()
+
+			if err := encryption.Init(); err != nil {
+				return err
+			}
+			if err := storage.InitStorage(); err != nil {
+				return err
+			}
+			// the memory storage saves its snapshot on close
+			defer utils.CloseAll()
+			report, err := encryption.Reencrypt(context.TODO(), storage.GenStorage(), conf.ETCDConfig.Prefix, dryRun)
+			if report != nil {
+				bs, _ := json.MarshalIndent(report, "", "  ")
+				fmt.Fprintln(cmd.OutOrStdout(), string(bs))
+			}
+			return err
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "report the objects to re-encrypt without writing them")
+	return cmd
+}
@@ -29,7 +29,6 @@ import (
 	"github.com/apisix/manager-api/internal/conf"
 	"github.com/apisix/manager-api/internal/core/server"
 	"github.com/apisix/manager-api/internal/core/storage"
-	"github.com/apisix/manager-api/internal/core/store"
 	"github.com/apisix/manager-api/internal/log"
 )
 
@@ -45,9 +44,11 @@ var rootCmd = &cobra.Command{
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&conf.ConfigFile, "config", "c", "", "config file")
 	rootCmd.PersistentFlags().StringVarP(&conf.WorkDir, "work-dir", "p", ".", "current work directory")
+	rootCmd.PersistentFlags().StringVar(&conf.StorageType, "storage", "", "storage driver: etcd, standalone or memory, overrides the config file")
 
 	rootCmd.AddCommand(
 		newVersionCommand(),
+		newReencryptCommand(),
 	)
 }
 
@@ -95,6 +96,10 @@ func etcdConnectionChecker() context.CancelFunc {
 	unavailableTimes := 0
 
 	go func() {
+		// there is no connection to check without etcd
+		if conf.StorageConfig.Type != conf.StorageTypeEtcd {
+			return
+		}
 		etcdClient := storage.GenEtcdStorage().GetClient()
 		for {
 			select {
@@ -108,19 +113,11 @@ func etcdConnectionChecker() context.CancelFunc {
 					continue
 				}
 
-				// After multiple failures, the connection is restored
+				// After multiple failures, the connection is restored, the
+				// stores resume their watches on their own
 				if unavailableTimes >= 1 {
-					log.Warnf("etcd connection recovered, but after several connection losses, reinitializing stores, times: %d", unavailableTimes)
+					log.Warnf("etcd connection recovered after several connection losses, times: %d", unavailableTimes)
 					unavailableTimes = 0
-
-					// When this happens, force a full re-initialization of the store
-					store.RangeStore(func(key store.HubKey, store *store.GenericStore) bool {
-						log.Warnf("etcd store reinitializing: resource: %s", key)
-						if err := store.Init(); err != nil {
-							log.Errorf("etcd store reinitialize failed: resource: %s, error: %s", key, err)
-						}
-						return true
-					})
 				} else {
 					log.Info("etcd connection is fine")
 				}
@@ -130,18 +127,5 @@ func etcdConnectionChecker() context.CancelFunc {
 		}
 	}()
 
-	// Timed re-initialization when etcd watch actively exits
-	go func() {
-		for {
-			select {
-			case <-time.Tick(2 * time.Minute):
-				err := store.ReInit()
-				if err != nil {
-					log.Errorf("resource re-initialize failed, err: %v", err)
-				}
-			}
-		}
-	}()
-
 	return cancel
 }
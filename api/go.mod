@@ -5,7 +5,9 @@ go 1.19
 require (
 	github.com/coreos/go-oidc/v3 v3.3.0
 	github.com/evanphx/json-patch/v5 v5.1.0
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/getkin/kin-openapi v0.33.0
+	github.com/ghodss/yaml v1.0.0
 	github.com/gin-contrib/gzip v0.0.3
 	github.com/gin-contrib/static v0.0.0-20200916080430-d45d9a37d28e
 	github.com/gin-gonic/gin v1.9.0
@@ -35,8 +37,6 @@ require (
 	github.com/coreos/go-semver v0.3.0 // indirect
 	github.com/coreos/go-systemd/v22 v22.3.2 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/fsnotify/fsnotify v1.4.9 // indirect
-	github.com/ghodss/yaml v1.0.0 // indirect
 	github.com/gin-contrib/sse v0.1.0 // indirect
 	github.com/go-openapi/jsonpointer v0.19.5 // indirect
 	github.com/go-openapi/swag v0.19.5 // indirect
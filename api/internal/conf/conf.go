@@ -17,6 +17,8 @@
 package conf
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -40,6 +42,17 @@ const (
 	EnvLOCAL = "local"
 	EnvTEST  = "test"
 
+	StorageTypeEtcd       = "etcd"
+	StorageTypeStandalone = "standalone"
+	StorageTypeMemory     = "memory"
+
+	GitOpsDriftRevert = "revert"
+	GitOpsDriftAlert  = "alert"
+
+	// ClusterLocal is the name of the cluster of ETCDConfig among the
+	// clusters
+	ClusterLocal = "local"
+
 	WebDir = "html/"
 
 	DefaultCSP = "default-src 'self'; script-src 'self' 'unsafe-eval' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src 'self' data:"
@@ -58,6 +71,10 @@ var (
 	SSLCert          string
 	SSLKey           string
 	ETCDConfig       *Etcd
+	StorageConfig    = &Storage{Type: StorageTypeEtcd}
+	StorageType      = ""
+	MaxRevisions     = 10
+	TrashRetention   = 7 * 24 * 3600
 	ErrorLogLevel    = "warn"
 	ErrorLogPath     = "logs/error.log"
 	AccessLogPath    = "logs/access.log"
@@ -74,6 +91,27 @@ var (
 	OidcConfig       oauth2.Config
 	OidcExpireTime   int
 	OidcUserInfoURL  string
+	AuditConfig      = &Audit{
+		Storage: AuditStorage{Enabled: true, Retention: 7 * 24 * 3600},
+		Webhook: AuditWebhook{Timeout: 3},
+	}
+	GitOpsConfig    = &GitOps{Interval: 30, DriftPolicy: GitOpsDriftAlert}
+	IntegrityConfig = &Integrity{ServerInfoTTL: 24 * 3600}
+	// BackupConfig is disabled when its dir is empty
+	BackupConfig = &Backup{Retention: 7 * 24 * 3600}
+	// Clusters are the other APISIX clusters managed along with the one of
+	// ETCDConfig
+	Clusters []*Cluster
+	// DataEncryptionConfig is disabled when its keyring is empty
+	DataEncryptionConfig = &DataEncryption{
+		EnableEncryptFields: true,
+		EncryptFields: map[string][]string{
+			"basic-auth": {"password"},
+			"hmac-auth":  {"secret_key"},
+			"jwt-auth":   {"secret", "private_key"},
+			"key-auth":   {"key"},
+		},
+	}
 )
 
 type MTLS struct {
@@ -88,6 +126,153 @@ type Etcd struct {
 	Password  string
 	MTLS      *MTLS
 	Prefix    string
+	// DataPrefix is where manager-api keeps its own data, such as the
+	// revisions, the trash and the audit entries. It's a sibling of Prefix
+	// so that APISIX doesn't watch it.
+	DataPrefix string `mapstructure:"data_prefix"`
+	// MaxTxnOps is --max-txn-ops of etcd
+	MaxTxnOps int `mapstructure:"max_txn_ops"`
+}
+
+type Standalone struct {
+	FilePath string `mapstructure:"file_path"`
+}
+
+type Memory struct {
+	SnapshotFile string `mapstructure:"snapshot_file"`
+}
+
+type Storage struct {
+	Type       string
+	Standalone Standalone
+	Memory     Memory
+}
+
+type Revision struct {
+	MaxRevisions *int `mapstructure:"max_revisions"`
+}
+
+type Trash struct {
+	// Retention is in seconds
+	Retention *int
+}
+
+type AuditFile struct {
+	Path string
+}
+
+type AuditStorage struct {
+	Enabled bool
+	// Retention is in seconds
+	Retention int
+}
+
+type AuditWebhook struct {
+	URL string
+	// Timeout is in seconds
+	Timeout int
+}
+
+type Audit struct {
+	File    AuditFile
+	Storage AuditStorage
+	Webhook AuditWebhook
+}
+
+// auditConf is Audit as configured, where the unset fields keep the
+// defaults.
+type auditConf struct {
+	File    AuditFile
+	Storage struct {
+		Enabled   *bool
+		Retention int
+	}
+	Webhook AuditWebhook
+}
+
+type GitOps struct {
+	// Path is a local directory, a git working tree or a bare git
+	// repository of the resource files, GitOps is disabled when it's empty
+	Path string
+	// Branch is read from a bare repository, HEAD by default
+	Branch string
+	// Dir is the directory of the resource files in Path, the root by
+	// default
+	Dir string
+	// Interval is in seconds
+	Interval int
+	// Selector scopes the reconciled objects by their labels
+	Selector string
+	// Prune deletes the objects in scope which are missing in the files
+	Prune bool
+	// DriftPolicy is how the changes made out of the files are handled,
+	// revert or alert
+	DriftPolicy string `mapstructure:"drift_policy"`
+}
+
+type Integrity struct {
+	// Interval is in seconds, the scheduled scan is disabled when it's 0
+	Interval int
+	// GC deletes the garbage found by the scheduled scan
+	GC bool `mapstructure:"gc"`
+	// ServerInfoTTL is in seconds, the server info of a node which hasn't
+	// reported for it is stale
+	ServerInfoTTL int `mapstructure:"server_info_ttl"`
+}
+
+type Backup struct {
+	// Dir is where the bundles are kept
+	Dir string
+	// Schedule is a cron expression in the local time, such as "0 3 * * *",
+	// the scheduled backup is disabled when it's empty
+	Schedule string
+	// Retention is in seconds, the older bundles are removed except the
+	// latest one
+	Retention int
+	// SigningKey is the file of the ed25519 private key signing the
+	// bundles in PEM, which is generated when it's missing
+	SigningKey string `mapstructure:"signing_key"`
+	// TrustedKeys are the files of the ed25519 public keys in PEM, the
+	// bundles signed by them are restored along with the ones signed by
+	// SigningKey
+	TrustedKeys []string `mapstructure:"trusted_keys"`
+}
+
+// Cluster is another APISIX cluster, such as the production one of a
+// staging cluster, by its name.
+type Cluster struct {
+	Name string
+	Etcd Etcd
+}
+
+// EncryptionKey is a key of the data encryption keyring, the ID refers to
+// it in the reports of the key rotation. The ID is known only to
+// manager-api, it's neither stored with the ciphertext nor given to APISIX.
+type EncryptionKey struct {
+	ID  string
+	Key string
+}
+
+// DataEncryption mirrors data_encryption of APISIX, which has to be given
+// the same keyring to decrypt the fields.
+type DataEncryption struct {
+	// EnableEncryptFields encrypts the EncryptFields of the consumer
+	// plugins, the SSL keys are encrypted whenever Keyring isn't empty
+	EnableEncryptFields bool
+	// Keyring encrypts with the first key, and decrypts with any of them
+	Keyring []EncryptionKey
+	// EncryptFields are the fields by the plugin names
+	EncryptFields map[string][]string
+}
+
+// dataEncryptionConf is DataEncryption as configured, the keyring is
+// given by one of Keyring, KeyringFile and KeyringEnv.
+type dataEncryptionConf struct {
+	EnableEncryptFields *bool `mapstructure:"enable_encrypt_fields"`
+	Keyring             []string
+	KeyringFile         string              `mapstructure:"keyring_file"`
+	KeyringEnv          string              `mapstructure:"keyring_env"`
+	EncryptFields       map[string][]string `mapstructure:"encrypt_fields"`
 }
 
 type SSL struct {
@@ -117,13 +302,22 @@ type Log struct {
 }
 
 type Conf struct {
-	Etcd      Etcd
-	Listen    Listen
-	SSL       SSL
-	Log       Log
-	AllowList []string `mapstructure:"allow_list"`
-	MaxCpu    int      `mapstructure:"max_cpu"`
-	Security  Security
+	Etcd           Etcd
+	Storage        Storage
+	Revision       Revision
+	Trash          Trash
+	Audit          auditConf
+	GitOps         GitOps `mapstructure:"gitops"`
+	Integrity      Integrity
+	Backup         Backup
+	Clusters       []Cluster
+	DataEncryption dataEncryptionConf `mapstructure:"data_encryption"`
+	Listen         Listen
+	SSL            SSL
+	Log            Log
+	AllowList      []string `mapstructure:"allow_list"`
+	MaxCpu         int      `mapstructure:"max_cpu"`
+	Security       Security
 }
 
 type User struct {
@@ -234,6 +428,37 @@ func setupConfig() {
 		initEtcdConfig(config.Conf.Etcd)
 	}
 
+	// storage driver
+	initStorageConfig(config.Conf.Storage)
+
+	// revision history, disabled by 0
+	if config.Conf.Revision.MaxRevisions != nil {
+		MaxRevisions = *config.Conf.Revision.MaxRevisions
+	}
+
+	// recycle bin, disabled by 0
+	if config.Conf.Trash.Retention != nil {
+		TrashRetention = *config.Conf.Trash.Retention
+	}
+
+	// audit sinks
+	initAuditConfig(config.Conf.Audit)
+
+	// gitops reconciler
+	initGitOpsConfig(config.Conf.GitOps)
+
+	// integrity scan
+	initIntegrityConfig(config.Conf.Integrity)
+
+	// backup
+	initBackupConfig(config.Conf.Backup)
+
+	// other clusters
+	initClustersConfig(config.Conf.Clusters)
+
+	// field encryption
+	initDataEncryptionConfig(config.Conf.DataEncryption)
+
 	// error log
 	if config.Conf.Log.ErrorLog.Level != "" {
 		ErrorLogLevel = config.Conf.Log.ErrorLog.Level
@@ -378,6 +603,223 @@ func mergeSchema(apisixSchema, customizeSchema []byte) ([]byte, error) {
 	return json.Marshal(apisixSchemaMap)
 }
 
+// initialize storage driver config
+func initStorageConfig(conf Storage) {
+	// the command line flag takes precedence
+	if StorageType != "" {
+		conf.Type = StorageType
+	}
+
+	switch conf.Type {
+	case "", StorageTypeEtcd:
+		StorageConfig = &Storage{Type: StorageTypeEtcd}
+		return
+	case StorageTypeStandalone:
+		filePath := conf.Standalone.FilePath
+		if filePath == "" {
+			filePath = "conf/apisix.yaml"
+		}
+		StorageConfig = &Storage{
+			Type:       conf.Type,
+			Standalone: Standalone{FilePath: absWorkPath(filePath)},
+		}
+	case StorageTypeMemory:
+		snapshotFile := conf.Memory.SnapshotFile
+		if snapshotFile != "" {
+			snapshotFile = absWorkPath(snapshotFile)
+		}
+		StorageConfig = &Storage{
+			Type:   conf.Type,
+			Memory: Memory{SnapshotFile: snapshotFile},
+		}
+	default:
+		panic(fmt.Sprintf("storage type %s is not supported", conf.Type))
+	}
+
+	// the key prefix is still needed to lay out the resources
+	if ETCDConfig == nil {
+		initEtcdConfig(Etcd{})
+	}
+}
+
+func absWorkPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(WorkDir, path)
+}
+
+func initAuditConfig(conf auditConf) {
+	if conf.File.Path != "" {
+		AuditConfig.File.Path = absWorkPath(conf.File.Path)
+	}
+	if conf.Storage.Enabled != nil {
+		AuditConfig.Storage.Enabled = *conf.Storage.Enabled
+	}
+	// the entries can't be kept in apisix.yaml
+	if StorageConfig.Type == StorageTypeStandalone {
+		AuditConfig.Storage.Enabled = false
+	}
+	if conf.Storage.Retention > 0 {
+		AuditConfig.Storage.Retention = conf.Storage.Retention
+	}
+	AuditConfig.Webhook.URL = conf.Webhook.URL
+	if conf.Webhook.Timeout > 0 {
+		AuditConfig.Webhook.Timeout = conf.Webhook.Timeout
+	}
+}
+
+func initGitOpsConfig(conf GitOps) {
+	if conf.Path == "" {
+		return
+	}
+
+	GitOpsConfig.Path = absWorkPath(conf.Path)
+	GitOpsConfig.Branch = conf.Branch
+	GitOpsConfig.Dir = conf.Dir
+	if conf.Interval > 0 {
+		GitOpsConfig.Interval = conf.Interval
+	}
+	GitOpsConfig.Selector = conf.Selector
+	GitOpsConfig.Prune = conf.Prune
+	switch conf.DriftPolicy {
+	case "":
+	case GitOpsDriftRevert, GitOpsDriftAlert:
+		GitOpsConfig.DriftPolicy = conf.DriftPolicy
+	default:
+		panic(fmt.Sprintf("gitops drift policy %s is not supported", conf.DriftPolicy))
+	}
+}
+
+func initIntegrityConfig(conf Integrity) {
+	if conf.Interval < 0 {
+		panic(fmt.Sprintf("integrity interval %d is invalid", conf.Interval))
+	}
+	IntegrityConfig.Interval = conf.Interval
+	IntegrityConfig.GC = conf.GC
+	if conf.ServerInfoTTL > 0 {
+		IntegrityConfig.ServerInfoTTL = conf.ServerInfoTTL
+	}
+}
+
+func initBackupConfig(conf Backup) {
+	if conf.Dir == "" {
+		return
+	}
+	if conf.Retention < 0 {
+		panic(fmt.Sprintf("backup retention %d is invalid", conf.Retention))
+	}
+
+	BackupConfig.Dir = absWorkPath(conf.Dir)
+	BackupConfig.Schedule = conf.Schedule
+	if conf.Retention > 0 {
+		BackupConfig.Retention = conf.Retention
+	}
+	BackupConfig.SigningKey = absWorkPath("conf/backup.key")
+	if conf.SigningKey != "" {
+		BackupConfig.SigningKey = absWorkPath(conf.SigningKey)
+	}
+	BackupConfig.TrustedKeys = nil
+	for _, path := range conf.TrustedKeys {
+		BackupConfig.TrustedKeys = append(BackupConfig.TrustedKeys, absWorkPath(path))
+	}
+}
+
+func initClustersConfig(conf []Cluster) {
+	var clusters []*Cluster
+	names := map[string]bool{}
+	for i := range conf {
+		c := conf[i]
+		if c.Name == "" {
+			panic(fmt.Sprintf("cluster %d: name is required", i))
+		}
+		if c.Name == ClusterLocal {
+			panic(fmt.Sprintf("cluster %s is reserved for the etcd of the manager", c.Name))
+		}
+		if names[c.Name] {
+			panic(fmt.Sprintf("cluster %s is duplicated", c.Name))
+		}
+		names[c.Name] = true
+		if len(c.Etcd.Endpoints) == 0 {
+			panic(fmt.Sprintf("cluster %s: etcd endpoints are required", c.Name))
+		}
+		if c.Etcd.Prefix == "" {
+			c.Etcd.Prefix = "/apisix"
+		}
+		c.Etcd.DataPrefix = dataPrefix(c.Etcd.Prefix, c.Etcd.DataPrefix)
+		clusters = append(clusters, &c)
+	}
+	Clusters = clusters
+}
+
+func initDataEncryptionConfig(conf dataEncryptionConf) {
+	if conf.EnableEncryptFields != nil {
+		DataEncryptionConfig.EnableEncryptFields = *conf.EnableEncryptFields
+	}
+	if conf.EncryptFields != nil {
+		DataEncryptionConfig.EncryptFields = conf.EncryptFields
+	}
+
+	var entries []string
+	sources := 0
+	if len(conf.Keyring) > 0 {
+		entries = conf.Keyring
+		sources++
+	}
+	if conf.KeyringFile != "" {
+		content, err := ioutil.ReadFile(absWorkPath(conf.KeyringFile))
+		if err != nil {
+			panic(fmt.Sprintf("fail to read data encryption keyring file: %s", err))
+		}
+		entries = strings.Split(string(content), "\n")
+		sources++
+	}
+	if conf.KeyringEnv != "" {
+		entries = strings.Split(os.Getenv(conf.KeyringEnv), ",")
+		sources++
+	}
+	if sources > 1 {
+		panic("data encryption keyring should be given by only one of keyring, keyring_file and keyring_env")
+	}
+
+	keyring, err := parseKeyring(entries)
+	if err != nil {
+		panic(err.Error())
+	}
+	DataEncryptionConfig.Keyring = keyring
+}
+
+// parseKeyring parses the keys given as "<id>:<key>" or "<key>", the ID
+// is derived from the key when it's not given. The keys are of 16
+// characters for AES-128 like APISIX.
+func parseKeyring(entries []string) ([]EncryptionKey, error) {
+	var keyring []EncryptionKey
+	ids := map[string]bool{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key := EncryptionKey{Key: entry}
+		if i := strings.LastIndex(entry, ":"); len(entry) != 16 && i >= 0 {
+			key.ID, key.Key = entry[:i], entry[i+1:]
+		}
+		if key.ID == "" {
+			sum := sha256.Sum256([]byte(key.Key))
+			key.ID = hex.EncodeToString(sum[:4])
+		}
+		if len(key.Key) != 16 {
+			return nil, fmt.Errorf("data encryption key %s should be of 16 characters", key.ID)
+		}
+		if ids[key.ID] {
+			return nil, fmt.Errorf("data encryption key %s is duplicated", key.ID)
+		}
+		ids[key.ID] = true
+		keyring = append(keyring, key)
+	}
+	return keyring, nil
+}
+
 // initialize etcd config
 func initEtcdConfig(conf Etcd) {
 	var endpoints = []string{"127.0.0.1:2379"}
@@ -390,13 +832,32 @@ func initEtcdConfig(conf Etcd) {
 		prefix = conf.Prefix
 	}
 
+	maxTxnOps := 128
+	if conf.MaxTxnOps > 0 {
+		maxTxnOps = conf.MaxTxnOps
+	}
+
 	ETCDConfig = &Etcd{
-		Endpoints: endpoints,
-		Username:  conf.Username,
-		Password:  conf.Password,
-		MTLS:      conf.MTLS,
-		Prefix:    prefix,
+		Endpoints:  endpoints,
+		Username:   conf.Username,
+		Password:   conf.Password,
+		MTLS:       conf.MTLS,
+		Prefix:     prefix,
+		DataPrefix: dataPrefix(prefix, conf.DataPrefix),
+		MaxTxnOps:  maxTxnOps,
+	}
+}
+
+// dataPrefix returns the data prefix as configured, <prefix>_dashboard by
+// default. It must be out of the prefix watched by APISIX.
+func dataPrefix(prefix, configured string) string {
+	if configured == "" {
+		return prefix + "_dashboard"
+	}
+	if configured == prefix || strings.HasPrefix(configured, prefix+"/") {
+		panic(fmt.Sprintf("etcd data_prefix %s should be out of the prefix %s", configured, prefix))
 	}
+	return configured
 }
 
 // initialize parallelism settings
@@ -61,3 +61,58 @@ func Test_mergeSchema(t *testing.T) {
 		})
 	}
 }
+
+func Test_parseKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    []EncryptionKey
+		wantErr string
+	}{
+		{
+			name:    "keys with and without id",
+			entries: []string{"2024:qeddd145sfvddff3", " edd1c9f0985e76a2 ", ""},
+			want: []EncryptionKey{
+				{ID: "2024", Key: "qeddd145sfvddff3"},
+				{ID: "c3f522fa", Key: "edd1c9f0985e76a2"},
+			},
+		},
+		{
+			name:    "colon in key",
+			entries: []string{"qeddd145:fvddff3"},
+			want:    []EncryptionKey{{ID: "98785869", Key: "qeddd145:fvddff3"}},
+		},
+		{
+			name:    "short key",
+			entries: []string{"2024:qeddd145"},
+			wantErr: "data encryption key 2024 should be of 16 characters",
+		},
+		{
+			name:    "duplicated id",
+			entries: []string{"a:qeddd145sfvddff3", "a:edd1c9f0985e76a2"},
+			wantErr: "data encryption key a is duplicated",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseKeyring(tt.entries)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_dataPrefix(t *testing.T) {
+	assert.Equal(t, "/apisix_dashboard", dataPrefix("/apisix", ""))
+	assert.Equal(t, "/dashboard/prod", dataPrefix("/apisix", "/dashboard/prod"))
+	assert.PanicsWithValue(t, "etcd data_prefix /apisix/dashboard should be out of the prefix /apisix", func() {
+		dataPrefix("/apisix", "/apisix/dashboard")
+	})
+	assert.Panics(t, func() {
+		dataPrefix("/apisix", "/apisix")
+	})
+}
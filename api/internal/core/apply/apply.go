@@ -0,0 +1,244 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package apply
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/apisix/manager-api/internal/core/batch"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/core/store"
+)
+
+// Bundle is the desired objects by their types, every object must carry
+// its id, or username for consumers.
+type Bundle map[store.HubKey][]json.RawMessage
+
+type Options struct {
+	// Selector is the label selector scoping the apply, the objects in the
+	// bundle must match it and only the matched objects are pruned. All
+	// the objects are in scope when it's empty.
+	Selector string
+	// Prune deletes the objects in scope which are missing in the bundle
+	Prune bool
+}
+
+// Change is a write to bring the stores to the bundle.
+type Change struct {
+	Action string       `json:"action"`
+	Type   store.HubKey `json:"type"`
+	ID     string       `json:"id"`
+}
+
+// Plan is the changes in the order they are applied: the creates and
+// updates of the objects referred to by others first, then the deletes of
+// the objects referring to others first.
+type Plan struct {
+	Changes []*Change `json:"changes"`
+	// Unchanged is the number of objects already as in the bundle
+	Unchanged int `json:"unchanged"`
+	ops       []batch.Operation
+}
+
+// ChangeError is the error of a change in the plan.
+type ChangeError struct {
+	Change *Change
+	Err    error
+}
+
+func (e *ChangeError) Error() string {
+	return fmt.Sprintf("%s %s/%s: %s", e.Change.Action, e.Change.Type, e.Change.ID, e.Err)
+}
+
+func (e *ChangeError) Unwrap() error {
+	return e.Err
+}
+
+// ignoredFields are maintained by the store, they don't make a change.
+var ignoredFields = []string{"create_time", "update_time", "resource_version"}
+
+// Diff compares the bundle against the stores. The objects in the bundle
+// are decoded and normalized by p the same way as a batch, so that they
+// compare equal to the stored ones they are written as.
+func Diff(ctx context.Context, p *batch.Planner, bundle Bundle, opt Options) (*Plan, error) {
+	selector, err := store.ParseSelector(opt.Selector)
+	if err != nil {
+		return nil, err
+	}
+	known := map[store.HubKey]bool{}
+	for _, typ := range batch.Types {
+		known[typ] = true
+	}
+	var unknown []string
+	for typ := range bundle {
+		if !known[typ] {
+			unknown = append(unknown, string(typ))
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("type %s is invalid", unknown[0])
+	}
+
+	plan := &Plan{Changes: []*Change{}}
+	deletes := make([][]*Change, len(batch.Types))
+	for i, typ := range batch.Types {
+		s := p.Store(typ)
+		desired := map[string]bool{}
+		for j, raw := range bundle[typ] {
+			obj, err := p.Decode(ctx, typ, "", raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s[%d]: %s", typ, j, err)
+			}
+			key := batch.ObjectKey(obj)
+			if key == "" {
+				return nil, fmt.Errorf("%s[%d]: id is required", typ, j)
+			}
+			if desired[key] {
+				return nil, fmt.Errorf("%s/%s is conflicted in the bundle", typ, key)
+			}
+			desired[key] = true
+			if !selector.Matches(batch.ObjectLabels(obj)) {
+				return nil, fmt.Errorf("%s/%s doesn't match the selector %s", typ, key, opt.Selector)
+			}
+
+			stored, _ := s.Get(ctx, key)
+			switch {
+			case stored == nil:
+				plan.add(&Change{Action: batch.ActionCreate, Type: typ, ID: key}, batch.Operation{
+					Op: batch.ActionCreate, Type: typ, ID: key, Body: raw,
+				})
+			case !equal(obj, stored):
+				plan.add(&Change{Action: batch.ActionUpdate, Type: typ, ID: key}, batch.Operation{
+					Op: batch.ActionUpdate, Type: typ, ID: key, Body: raw,
+					ResourceVersion: batch.ResourceVersion(stored),
+				})
+			default:
+				plan.Unchanged++
+			}
+		}
+
+		if !opt.Prune {
+			continue
+		}
+		ret, err := s.List(ctx, store.ListInput{
+			Predicate: func(obj interface{}) bool {
+				return !desired[batch.ObjectKey(obj)] && selector.Matches(batch.ObjectLabels(obj))
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range ret.Rows {
+			deletes[i] = append(deletes[i], &Change{Action: batch.ActionDelete, Type: typ, ID: batch.ObjectKey(obj)})
+		}
+	}
+
+	for i := len(deletes) - 1; i >= 0; i-- {
+		for _, change := range deletes[i] {
+			plan.add(change, batch.Operation{
+				Op:   batch.ActionDelete,
+				Type: change.Type,
+				ID:   change.ID,
+			})
+		}
+	}
+	return plan, nil
+}
+
+// Apply commits the changes in one transaction, after they pass all the
+// checks of a batch. A plan too large for a transaction is refused with
+// storage.ErrTxnTooLarge, nothing is applied.
+func (plan *Plan) Apply(ctx context.Context, p *batch.Planner) error {
+	if len(plan.ops) == 0 {
+		return nil
+	}
+
+	txn, _, err := p.Plan(ctx, plan.ops)
+	if err != nil {
+		var opErr *batch.OperationError
+		if errors.As(err, &opErr) {
+			return &ChangeError{Change: plan.Changes[opErr.Index], Err: opErr.Err}
+		}
+		if errors.Is(err, storage.ErrTxnTooLarge) {
+			return fmt.Errorf("%d changes can't be applied at once, apply the bundle in parts, such as by selectors: %w",
+				len(plan.Changes), err)
+		}
+		return err
+	}
+	return txn.Commit(ctx)
+}
+
+// ApplyParts is Apply for the plans which don't need to be applied all or
+// nothing, such as those of a reconciler syncing again until it's done.
+// The changes are committed in parts within a transaction each, in the
+// order of the plan, see batch.Planner.PlanParts. It returns the number of
+// the changes applied, which are applied even when a later part fails.
+func (plan *Plan) ApplyParts(ctx context.Context, p *batch.Planner) (int, error) {
+	if len(plan.ops) == 0 {
+		return 0, nil
+	}
+
+	parts, _, err := p.PlanParts(ctx, plan.ops)
+	if err != nil {
+		var opErr *batch.OperationError
+		if errors.As(err, &opErr) {
+			return 0, &ChangeError{Change: plan.Changes[opErr.Index], Err: opErr.Err}
+		}
+		return 0, err
+	}
+	return batch.CommitParts(ctx, parts)
+}
+
+func (plan *Plan) add(change *Change, op batch.Operation) {
+	plan.Changes = append(plan.Changes, change)
+	plan.ops = append(plan.ops, op)
+}
+
+// equal reports whether the objects are the same but the fields maintained
+// by the store.
+func equal(desired, stored interface{}) bool {
+	a, err := comparableFields(desired)
+	if err != nil {
+		return false
+	}
+	b, err := comparableFields(stored)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func comparableFields(obj interface{}) (map[string]interface{}, error) {
+	bs, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(bs, &fields); err != nil {
+		return nil, err
+	}
+	for _, field := range ignoredFields {
+		delete(fields, field)
+	}
+	return fields, nil
+}
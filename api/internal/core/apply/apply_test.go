@@ -0,0 +1,180 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package apply
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/core/batch"
+	"github.com/apisix/manager-api/internal/core/batch/batchtest"
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/core/store"
+)
+
+func parseBundle(t *testing.T, s string) Bundle {
+	bundle := Bundle{}
+	assert.Nil(t, json.Unmarshal([]byte(s), &bundle))
+	return bundle
+}
+
+func diffAndApply(t *testing.T, p *batch.Planner, bundle string, opt Options) *Plan {
+	plan, err := Diff(context.TODO(), p, parseBundle(t, bundle), opt)
+	assert.Nil(t, err)
+	assert.Nil(t, plan.Apply(context.TODO(), p))
+	return plan
+}
+
+func waitFor(t *testing.T, s store.Interface, size int) {
+	assert.Eventually(t, func() bool {
+		ret, err := s.List(context.TODO(), store.ListInput{})
+		return err == nil && ret.TotalSize == size
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestApply(t *testing.T) {
+	p := batchtest.NewPlanner(t)
+	routes, upstreams := p.Store(store.HubKeyRoute), p.Store(store.HubKeyUpstream)
+
+	bundle := `{
+		"route": [
+			{"id": "r1", "uri": "/a", "upstream_id": "u1", "labels": {"team": "core"}},
+			{"id": "r2", "uri": "/b", "upstream_id": "u1", "labels": {"team": "core"}}
+		],
+		"upstream": [{"id": "u1", "nodes": {"127.0.0.1:80": 1}, "type": "roundrobin", "labels": {"team": "core"}}]
+	}`
+	plan := diffAndApply(t, p, bundle, Options{})
+	assert.Equal(t, []*Change{
+		{Action: "create", Type: store.HubKeyUpstream, ID: "u1"},
+		{Action: "create", Type: store.HubKeyRoute, ID: "r1"},
+		{Action: "create", Type: store.HubKeyRoute, ID: "r2"},
+	}, plan.Changes)
+	waitFor(t, routes, 2)
+	waitFor(t, upstreams, 1)
+
+	// nothing changes when the stores are as in the bundle
+	plan, err := Diff(context.TODO(), p, parseBundle(t, bundle), Options{})
+	assert.Nil(t, err)
+	assert.Empty(t, plan.Changes)
+	assert.Equal(t, 3, plan.Unchanged)
+
+	// an object of another team is out of scope, so it's never pruned
+	_, err = routes.Create(context.TODO(), &entity.Route{
+		BaseInfo: entity.BaseInfo{ID: "r3"}, URI: "/c", Labels: map[string]string{"team": "other"}})
+	assert.Nil(t, err)
+	waitFor(t, routes, 3)
+
+	plan = diffAndApply(t, p, `{
+		"route": [{"id": "r1", "uri": "/a2", "upstream_id": "u2", "labels": {"team": "core"}}],
+		"upstream": [{"id": "u2", "nodes": {"127.0.0.1:81": 1}, "type": "roundrobin", "labels": {"team": "core"}}]
+	}`, Options{Selector: "team=core", Prune: true})
+	assert.Equal(t, []*Change{
+		{Action: "create", Type: store.HubKeyUpstream, ID: "u2"},
+		{Action: "update", Type: store.HubKeyRoute, ID: "r1"},
+		{Action: "delete", Type: store.HubKeyRoute, ID: "r2"},
+		{Action: "delete", Type: store.HubKeyUpstream, ID: "u1"},
+	}, plan.Changes)
+	waitFor(t, routes, 2)
+	assert.Eventually(t, func() bool {
+		r1, err := routes.Get(context.TODO(), "r1")
+		return err == nil && r1.(*entity.Route).URI == "/a2"
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = routes.Get(context.TODO(), "r3")
+	assert.Nil(t, err)
+	_, err = upstreams.Get(context.TODO(), "u1")
+	assert.NotNil(t, err)
+}
+
+func TestApply_TooLarge(t *testing.T) {
+	p := batchtest.NewPlanner(t)
+
+	var routes []string
+	for i := 0; i <= storage.MaxTxnOps; i++ {
+		routes = append(routes, fmt.Sprintf(`{"id": "r%d", "uri": "/a"}`, i))
+	}
+	plan, err := Diff(context.TODO(), p, parseBundle(t, `{"route": [`+strings.Join(routes, ",")+`]}`), Options{})
+	assert.Nil(t, err)
+	assert.Len(t, plan.Changes, storage.MaxTxnOps+1)
+
+	err = plan.Apply(context.TODO(), p)
+	assert.True(t, errors.Is(err, storage.ErrTxnTooLarge))
+	assert.Contains(t, err.Error(), "129 changes can't be applied at once")
+	ret, err := p.Store(store.HubKeyRoute).List(context.TODO(), store.ListInput{})
+	assert.Nil(t, err)
+	assert.Zero(t, ret.TotalSize)
+}
+
+func TestDiff_Invalid(t *testing.T) {
+	p := batchtest.NewPlanner(t)
+
+	tests := []struct {
+		caseDesc string
+		bundle   string
+		opt      Options
+		wantErr  string
+	}{
+		{
+			caseDesc: "invalid type",
+			bundle:   `{"routes": []}`,
+			wantErr:  "type routes is invalid",
+		},
+		{
+			caseDesc: "missing id",
+			bundle:   `{"route": [{"uri": "/a"}]}`,
+			wantErr:  "route[0]: id is required",
+		},
+		{
+			caseDesc: "conflicted",
+			bundle:   `{"route": [{"id": "r1", "uri": "/a"}, {"id": "r1", "uri": "/b"}]}`,
+			wantErr:  "route/r1 is conflicted in the bundle",
+		},
+		{
+			caseDesc: "out of scope",
+			bundle:   `{"route": [{"id": "r1", "uri": "/a"}]}`,
+			opt:      Options{Selector: "team=core"},
+			wantErr:  "route/r1 doesn't match the selector team=core",
+		},
+		{
+			caseDesc: "invalid selector",
+			bundle:   `{}`,
+			opt:      Options{Selector: "team in core"},
+			wantErr:  "label selector team in core is invalid",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			_, err := Diff(context.TODO(), p, parseBundle(t, tc.bundle), tc.opt)
+			assert.EqualError(t, err, tc.wantErr)
+		})
+	}
+
+	// the references are checked when it's applied
+	plan, err := Diff(context.TODO(), p, parseBundle(t, `{"route": [{"id": "r1", "uri": "/a", "upstream_id": "u1"}]}`), Options{})
+	assert.Nil(t, err)
+	err = plan.Apply(context.TODO(), p)
+	assert.EqualError(t, err, "create route/r1: upstream id: u1 not found")
+	var changeErr *ChangeError
+	assert.True(t, errors.As(err, &changeErr))
+}
@@ -0,0 +1,199 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+
+	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/log"
+	"github.com/apisix/manager-api/internal/utils"
+)
+
+// Entry is a write recorded by the audit.
+type Entry struct {
+	ID         string `json:"id"`
+	Time       int64  `json:"time"`
+	User       string `json:"user,omitempty"`
+	IP         string `json:"ip,omitempty"`
+	RequestID  string `json:"request_id,omitempty"`
+	Cluster    string `json:"cluster,omitempty"`
+	Resource   string `json:"resource"`
+	ResourceID string `json:"resource_id"`
+	Action     string `json:"action"`
+	// Before is empty for a create, and After is empty for a delete. The
+	// secrets are left out of them, such as the private keys of an SSL.
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+	// Diff is the JSON merge patch turning Before into After
+	Diff json.RawMessage `json:"diff,omitempty"`
+}
+
+// Sink is where the entries are written to.
+type Sink interface {
+	Write(ctx context.Context, entries []*Entry) error
+}
+
+// Querier is a sink the entries can be read back from.
+type Querier interface {
+	// Query returns the entries matching the filter, the latest first.
+	Query(ctx context.Context, filter Filter) ([]*Entry, error)
+}
+
+// Filter selects the entries, the empty fields match all.
+type Filter struct {
+	User       string
+	Resource   string
+	ResourceID string
+	Action     string
+	// From and To are the unix timestamps the entries are in, inclusive
+	From int64
+	To   int64
+}
+
+func (f *Filter) Matches(e *Entry) bool {
+	return (f.User == "" || f.User == e.User) &&
+		(f.Resource == "" || f.Resource == e.Resource) &&
+		(f.ResourceID == "" || f.ResourceID == e.ResourceID) &&
+		(f.Action == "" || f.Action == e.Action) &&
+		(f.From == 0 || e.Time >= f.From) &&
+		(f.To == 0 || e.Time <= f.To)
+}
+
+var (
+	sinksLock sync.RWMutex
+	sinks     []Sink
+)
+
+// Init sets up the sinks enabled by the configuration.
+func Init() error {
+	var ret []Sink
+	if conf.AuditConfig.File.Path != "" {
+		s, err := NewFileSink(conf.AuditConfig.File.Path)
+		if err != nil {
+			return err
+		}
+		utils.AppendToClosers(s.Close)
+		ret = append(ret, s)
+	}
+	if conf.AuditConfig.Storage.Enabled {
+		s := NewStorageSink(storage.GenStorage(), conf.ETCDConfig.DataPrefix+"/audit",
+			time.Duration(conf.AuditConfig.Storage.Retention)*time.Second)
+		utils.AppendToClosers(s.Close)
+		ret = append(ret, s)
+	}
+	if conf.AuditConfig.Webhook.URL != "" {
+		ret = append(ret, NewWebhookSink(conf.AuditConfig.Webhook.URL,
+			time.Duration(conf.AuditConfig.Webhook.Timeout)*time.Second))
+	}
+	SetSinks(ret...)
+	return nil
+}
+
+// SetSinks replaces the sinks the entries are written to.
+func SetSinks(s ...Sink) {
+	sinksLock.Lock()
+	defer sinksLock.Unlock()
+	sinks = s
+}
+
+func getSinks() []Sink {
+	sinksLock.RLock()
+	defer sinksLock.RUnlock()
+	return sinks
+}
+
+// Enabled reports whether any sink is set, the entries are dropped
+// otherwise.
+func Enabled() bool {
+	return len(getSinks()) > 0
+}
+
+// Record completes the entries with the request in the context, and writes
+// them to every sink. A failed sink is logged and doesn't fail the others.
+func Record(ctx context.Context, entries ...*Entry) {
+	ss := getSinks()
+	if len(ss) == 0 || len(entries) == 0 {
+		return
+	}
+
+	req := requestFromContext(ctx)
+	now := time.Now().Unix()
+	for _, e := range entries {
+		e.ID = utils.GetFlakeUidStr()
+		e.Time = now
+		e.IP = req.IP
+		e.RequestID = req.RequestID
+		e.Diff = diff(e.Before, e.After)
+	}
+
+	for _, s := range ss {
+		if err := s.Write(ctx, entries); err != nil {
+			log.Errorf("write audit entries to %T failed: %s", s, err)
+		}
+	}
+}
+
+// Query reads the entries back from the first sink supporting it.
+func Query(ctx context.Context, filter Filter) ([]*Entry, error) {
+	for _, s := range getSinks() {
+		if q, ok := s.(Querier); ok {
+			return q.Query(ctx, filter)
+		}
+	}
+	return nil, fmt.Errorf("audit query is not enabled")
+}
+
+func diff(before, after json.RawMessage) json.RawMessage {
+	if before == nil {
+		before = json.RawMessage("{}")
+	}
+	if after == nil {
+		after = json.RawMessage("{}")
+	}
+	patch, err := jsonpatch.CreateMergePatch(before, after)
+	if err != nil {
+		log.Warnf("create merge patch failed: %s", err)
+		return nil
+	}
+	return patch
+}
+
+type requestKey struct{}
+
+type request struct {
+	IP        string
+	RequestID string
+}
+
+// WithRequest returns a context carrying the request the entries are
+// recorded for.
+func WithRequest(ctx context.Context, ip, requestID string) context.Context {
+	return context.WithValue(ctx, requestKey{}, request{IP: ip, RequestID: requestID})
+}
+
+func requestFromContext(ctx context.Context) request {
+	req, _ := ctx.Value(requestKey{}).(request)
+	return req
+}
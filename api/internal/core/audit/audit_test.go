@@ -0,0 +1,140 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/core/storage"
+)
+
+func TestRecord(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "audit.log")
+	fileSink, err := NewFileSink(filePath)
+	assert.Nil(t, err)
+	stg := storage.NewMemoryStorage()
+	storageSink := NewStorageSink(stg, "/apisix/audit", time.Hour)
+	SetSinks(fileSink, storageSink)
+	defer func() {
+		SetSinks()
+		_ = fileSink.Close()
+		_ = storageSink.Close()
+	}()
+
+	ctx := WithRequest(context.TODO(), "127.0.0.1", "req-1")
+	Record(ctx, &Entry{
+		User:       "admin",
+		Resource:   "route",
+		ResourceID: "r1",
+		Action:     "create",
+		After:      json.RawMessage(`{"id":"r1","uri":"/hello"}`),
+	})
+	Record(ctx, &Entry{
+		User:       "admin",
+		Resource:   "route",
+		ResourceID: "r1",
+		Action:     "update",
+		Before:     json.RawMessage(`{"id":"r1","uri":"/hello"}`),
+		After:      json.RawMessage(`{"id":"r1","uri":"/hi","name":"r1"}`),
+	}, &Entry{
+		Resource:   "upstream",
+		ResourceID: "u1",
+		Action:     "delete",
+		Before:     json.RawMessage(`{"id":"u1"}`),
+	})
+
+	entries, err := Query(context.TODO(), Filter{})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "upstream", entries[0].Resource)
+	assert.Equal(t, json.RawMessage(`{"id":null}`), entries[0].Diff)
+	assert.Equal(t, "127.0.0.1", entries[1].IP)
+	assert.Equal(t, "req-1", entries[1].RequestID)
+	assert.Equal(t, json.RawMessage(`{"name":"r1","uri":"/hi"}`), entries[1].Diff)
+	assert.Equal(t, "create", entries[2].Action)
+
+	entries, err = Query(context.TODO(), Filter{User: "admin", ResourceID: "r1", Action: "update"})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	entries, err = Query(context.TODO(), Filter{From: time.Now().Add(time.Hour).Unix()})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 0)
+
+	// every entry is a line of the file
+	f, err := os.Open(filePath)
+	assert.Nil(t, err)
+	defer f.Close()
+	var lines int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		e := &Entry{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), e))
+		lines++
+	}
+	assert.Equal(t, 3, lines)
+
+	// the entries beyond the retention are pruned
+	_, err = stg.Create(context.TODO(), storageSink.key("1"), fmt.Sprintf(`{"id":"1","time":%d}`, time.Now().Add(-2*time.Hour).Unix()))
+	assert.Nil(t, err)
+	_, err = stg.Create(context.TODO(), storageSink.key("2"), "broken")
+	assert.Nil(t, err)
+	storageSink.prune(context.TODO())
+	ret, _, err := stg.List(context.TODO(), "/apisix/audit/")
+	assert.Nil(t, err)
+	assert.Len(t, ret, 3)
+}
+
+func TestQuery_NotEnabled(t *testing.T) {
+	SetSinks(NewWebhookSink("http://127.0.0.1:1", time.Second))
+	defer SetSinks()
+
+	_, err := Query(context.TODO(), Filter{})
+	assert.Equal(t, fmt.Errorf("audit query is not enabled"), err)
+}
+
+func TestWebhookSink(t *testing.T) {
+	received := make(chan *Entry, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := &Entry{}
+		_ = json.NewDecoder(r.Body).Decode(e)
+		received <- e
+	}))
+	defer srv.Close()
+
+	SetSinks(NewWebhookSink(srv.URL, time.Second))
+	defer SetSinks()
+
+	Record(context.TODO(), &Entry{Resource: "route", ResourceID: "r1", Action: "create"})
+	select {
+	case e := <-received:
+		assert.Equal(t, "r1", e.ResourceID)
+		assert.NotEmpty(t, e.ID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook timeout")
+	}
+}
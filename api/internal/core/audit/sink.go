@@ -0,0 +1,229 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/log"
+	"github.com/apisix/manager-api/internal/utils/runtime"
+)
+
+// FileSink appends the entries to a file as JSON lines.
+type FileSink struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open audit file %s failed: %s", path, err)
+	}
+	return &FileSink{file: f}, nil
+}
+
+func (s *FileSink) Write(_ context.Context, entries []*Entry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err := s.file.Write(buf.Bytes())
+	return err
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// storagePruneInterval is how often the entries beyond the retention are
+// removed from the storage.
+var storagePruneInterval = time.Hour
+
+// StorageSink keeps the entries under a prefix of the storage, ordered by
+// their IDs, and removes them after the retention.
+type StorageSink struct {
+	stg       storage.Interface
+	prefix    string
+	retention time.Duration
+	cancel    context.CancelFunc
+}
+
+func NewStorageSink(stg storage.Interface, prefix string, retention time.Duration) *StorageSink {
+	ctx, cancel := context.WithCancel(context.TODO())
+	s := &StorageSink{stg: stg, prefix: prefix, retention: retention, cancel: cancel}
+	go func() {
+		defer runtime.HandlePanic()
+		ticker := time.NewTicker(storagePruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.prune(ctx)
+			}
+		}
+	}()
+	return s
+}
+
+func (s *StorageSink) key(id string) string {
+	// the flake IDs sort as numbers, pad them to sort as keys
+	if len(id) < 20 {
+		id = strings.Repeat("0", 20-len(id)) + id
+	}
+	return s.prefix + "/" + id
+}
+
+func (s *StorageSink) Write(ctx context.Context, entries []*Entry) error {
+	ops := make([]storage.Op, 0, len(entries))
+	for _, e := range entries {
+		bs, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, storage.Op{Type: storage.OpCreate, Key: s.key(e.ID), Value: string(bs)})
+	}
+	_, err := s.stg.Txn(ctx, ops)
+	return err
+}
+
+func (s *StorageSink) list(ctx context.Context) ([]storage.Keypair, []*Entry, error) {
+	ret, _, err := s.stg.List(ctx, s.prefix+"/")
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key > ret[j].Key
+	})
+
+	entries := make([]*Entry, len(ret))
+	for i := range ret {
+		e := &Entry{}
+		if err := json.Unmarshal([]byte(ret[i].Value), e); err != nil {
+			log.Warnf("json unmarshal audit entry of key: %s failed: %s", ret[i].Key, err)
+			continue
+		}
+		entries[i] = e
+	}
+	return ret, entries, nil
+}
+
+func (s *StorageSink) Query(ctx context.Context, filter Filter) ([]*Entry, error) {
+	_, entries, err := s.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*Entry, 0, len(entries))
+	for _, e := range entries {
+		if e != nil && filter.Matches(e) {
+			ret = append(ret, e)
+		}
+	}
+	return ret, nil
+}
+
+// prune removes the entries older than the retention, the broken ones are
+// removed as well.
+func (s *StorageSink) prune(ctx context.Context) {
+	kvs, entries, err := s.list(ctx)
+	if err != nil {
+		log.Warnf("list audit entries failed: %s", err)
+		return
+	}
+
+	deadline := time.Now().Add(-s.retention).Unix()
+	var keys []string
+	for i := range entries {
+		if entries[i] == nil || entries[i].Time < deadline {
+			keys = append(keys, kvs[i].Key)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	if err := s.stg.BatchDelete(ctx, keys); err != nil {
+		log.Warnf("prune audit entries failed: %s", err)
+	}
+}
+
+func (s *StorageSink) Close() error {
+	s.cancel()
+	return nil
+}
+
+// WebhookSink posts every entry as JSON to the URL. It doesn't wait for
+// the response, the failures are only logged.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookSink(url string, timeout time.Duration) *WebhookSink {
+	return &WebhookSink{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *WebhookSink) Write(_ context.Context, entries []*Entry) error {
+	bodies := make([][]byte, 0, len(entries))
+	for _, e := range entries {
+		bs, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		bodies = append(bodies, bs)
+	}
+
+	go func() {
+		defer runtime.HandlePanic()
+		for _, body := range bodies {
+			if err := s.post(body); err != nil {
+				log.Warnf("post audit entry to %s failed: %s", s.url, err)
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *WebhookSink) post(body []byte) error {
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return nil
+}
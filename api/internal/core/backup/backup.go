@@ -0,0 +1,568 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/core/encryption"
+	"github.com/apisix/manager-api/internal/core/migrate"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/log"
+	"github.com/apisix/manager-api/internal/utils"
+	"github.com/apisix/manager-api/internal/utils/runtime"
+)
+
+// FormatVersion is the version of the layout of the bundles.
+const FormatVersion = 1
+
+const (
+	manifestFile  = "manifest.json"
+	signatureFile = "manifest.sig"
+	namePrefix    = "apisix-backup-"
+	nameSuffix    = ".tar.gz"
+	// timeLayout keeps the microseconds, so that the bundles created in
+	// the same second don't conflict and are named in order
+	timeLayout = "20060102T150405.000000Z"
+)
+
+// ManifestFile is a file of the objects of a resource in a bundle.
+type ManifestFile struct {
+	Name     string       `json:"name"`
+	Resource store.HubKey `json:"resource"`
+	Count    int          `json:"count"`
+	// SHA256 is the hex encoded sha256 of the file
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest describes a bundle, it's signed by manifest.sig so the files
+// are verified by their checksums.
+type Manifest struct {
+	FormatVersion int    `json:"format_version"`
+	Cluster       string `json:"cluster"`
+	APISIXVersion string `json:"apisix_version,omitempty"`
+	CreateTime    int64  `json:"create_time"`
+	// KeyID is the id of the key the bundle is signed with, see KeyID
+	KeyID string         `json:"key_id"`
+	Files []ManifestFile `json:"files"`
+}
+
+// Info is a bundle in the directory.
+type Info struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Manifest *Manifest `json:"manifest"`
+}
+
+// Manager writes the bundles to Dir and restores them. A bundle is a
+// tar.gz of manifest.json, manifest.sig and a JSON file of the objects of
+// each resource, the sensitive fields are encrypted in the files the same
+// as in the storage when the data encryption is enabled.
+type Manager struct {
+	Dir string
+	// Retention is how long the bundles are kept, the latest one is
+	// always kept, and all of them are when it's 0
+	Retention time.Duration
+
+	key     ed25519.PrivateKey
+	trusted map[string]ed25519.PublicKey
+	// mu serializes the writes of the bundles and the retention
+	mu sync.Mutex
+}
+
+// NewManager returns the manager of the bundles signed by key, the bundles
+// signed by it or any of the trusted keys can be restored.
+func NewManager(dir string, retention time.Duration, key ed25519.PrivateKey, trusted []ed25519.PublicKey) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	m := &Manager{
+		Dir:       dir,
+		Retention: retention,
+		key:       key,
+		trusted:   map[string]ed25519.PublicKey{},
+	}
+	for _, pub := range append([]ed25519.PublicKey{m.PublicKey()}, trusted...) {
+		m.trusted[KeyID(pub)] = pub
+	}
+	return m, nil
+}
+
+// PublicKey returns the public key of the signing key, which other
+// instances trust to restore the bundles.
+func (m *Manager) PublicKey() ed25519.PublicKey {
+	return m.key.Public().(ed25519.PublicKey)
+}
+
+func bundleName(t time.Time) string {
+	return namePrefix + t.UTC().Format(timeLayout) + nameSuffix
+}
+
+func validName(name string) bool {
+	return filepath.Base(name) == name && strings.HasPrefix(name, namePrefix) && strings.HasSuffix(name, nameSuffix)
+}
+
+// Path returns the path of the bundle.
+func (m *Manager) Path(name string) (string, error) {
+	if !validName(name) {
+		return "", fmt.Errorf("backup name %s is invalid", name)
+	}
+	path := filepath.Join(m.Dir, name)
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("backup %s is not found", name)
+		}
+		return "", err
+	}
+	return path, nil
+}
+
+// Create writes a bundle of all the objects of the cluster in the context,
+// and removes the bundles beyond the retention.
+func (m *Manager) Create(ctx context.Context) (*Info, error) {
+	data, err := migrate.Collect(ctx, migrate.Options{})
+	if err != nil {
+		return nil, err
+	}
+	cluster := store.ClusterFromContext(ctx)
+	if cluster == "" {
+		cluster = conf.ClusterLocal
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	info, err := m.write(data, &Manifest{
+		Cluster:       cluster,
+		APISIXVersion: migrate.APISIXVersion(ctx),
+	}, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	m.prune()
+	return info, nil
+}
+
+// write writes a bundle of the objects created at the time.
+func (m *Manager) write(data *migrate.DataSet, manifest *Manifest, created time.Time) (*Info, error) {
+	files := map[string][]byte{}
+	for _, typ := range migrate.Types {
+		objs := data.Objects(typ)
+		items := make([]json.RawMessage, 0, len(objs))
+		for _, obj := range objs {
+			bs, err := encryption.Marshal(obj)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, bs)
+		}
+		bs, err := json.Marshal(items)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(bs)
+		name := string(typ) + ".json"
+		files[name] = bs
+		manifest.Files = append(manifest.Files, ManifestFile{
+			Name:     name,
+			Resource: typ,
+			Count:    len(objs),
+			SHA256:   hex.EncodeToString(sum[:]),
+		})
+	}
+	manifest.FormatVersion = FormatVersion
+	manifest.CreateTime = created.Unix()
+	manifest.KeyID = KeyID(m.PublicKey())
+	mbs, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(m.key, mbs))
+
+	name := bundleName(created)
+	path := filepath.Join(m.Dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("backup %s is conflicted", name)
+	}
+	tmp, err := ioutil.TempFile(m.Dir, ".backup-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	entries := []entry{{manifestFile, mbs}, {signatureFile, []byte(sig)}}
+	for _, f := range manifest.Files {
+		entries = append(entries, entry{f.Name, files[f.Name]})
+	}
+	err = writeBundle(tmp, entries, time.Unix(manifest.CreateTime, 0))
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("backup %s is written", name)
+	return &Info{Name: name, Size: stat.Size(), Manifest: manifest}, nil
+}
+
+// entry is a file in a bundle.
+type entry struct {
+	name string
+	data []byte
+}
+
+func writeBundle(w io.Writer, entries []entry, modTime time.Time) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    e.name,
+			Mode:    0600,
+			Size:    int64(len(e.data)),
+			ModTime: modTime,
+		}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// readBundle reads the files of the bundle, it stops after the manifest
+// when manifestOnly is true.
+func readBundle(path string, manifestOnly bool) (map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		bs, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[hdr.Name] = bs
+		if manifestOnly && hdr.Name == manifestFile {
+			return files, nil
+		}
+	}
+}
+
+func (m *Manager) info(name string) (*Info, error) {
+	path, err := m.Path(name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files, err := readBundle(path, true)
+	if err != nil {
+		return nil, err
+	}
+	bs, ok := files[manifestFile]
+	if !ok {
+		return nil, fmt.Errorf("backup %s is invalid: %s is missing", name, manifestFile)
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(bs, manifest); err != nil {
+		return nil, fmt.Errorf("backup %s is invalid: %s", name, err)
+	}
+	return &Info{Name: name, Size: stat.Size(), Manifest: manifest}, nil
+}
+
+// List returns the bundles in the directory, the latest first. The ones
+// which can't be read are left out.
+func (m *Manager) List() ([]*Info, error) {
+	entries, err := ioutil.ReadDir(m.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*Info, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !validName(entry.Name()) {
+			continue
+		}
+		info, err := m.info(entry.Name())
+		if err != nil {
+			log.Warnf("read backup %s failed: %s", entry.Name(), err)
+			continue
+		}
+		ret = append(ret, info)
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].Manifest.CreateTime != ret[j].Manifest.CreateTime {
+			return ret[i].Manifest.CreateTime > ret[j].Manifest.CreateTime
+		}
+		return ret[i].Name > ret[j].Name
+	})
+	return ret, nil
+}
+
+// Select returns the bundle of the name, or the latest one created at or
+// before at when the name is empty. The latest bundle is selected when
+// both are empty.
+func (m *Manager) Select(name string, at time.Time) (*Info, error) {
+	if name != "" {
+		return m.info(name)
+	}
+	infos, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if at.IsZero() || info.Manifest.CreateTime <= at.Unix() {
+			return info, nil
+		}
+	}
+	if at.IsZero() {
+		return nil, errors.New("backup is not found")
+	}
+	return nil, fmt.Errorf("backup at %s is not found", at.UTC().Format(time.RFC3339))
+}
+
+// Load verifies the signature and the checksums of the bundle, and returns
+// its objects with the sensitive fields decrypted.
+func (m *Manager) Load(name string) (*Manifest, *migrate.DataSet, error) {
+	path, err := m.Path(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := readBundle(path, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mbs, ok := files[manifestFile]
+	if !ok {
+		return nil, nil, fmt.Errorf("backup %s is invalid: %s is missing", name, manifestFile)
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(files[signatureFile]))
+	if err != nil || len(sig) == 0 {
+		return nil, nil, fmt.Errorf("backup %s is invalid: it's not signed", name)
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(mbs, manifest); err != nil {
+		return nil, nil, fmt.Errorf("backup %s is invalid: %s", name, err)
+	}
+	pub, ok := m.trusted[manifest.KeyID]
+	if !ok {
+		return nil, nil, fmt.Errorf("backup %s is invalid: it's signed by the untrusted key %s", name, manifest.KeyID)
+	}
+	if !ed25519.Verify(pub, mbs, sig) {
+		return nil, nil, fmt.Errorf("backup %s is invalid: signature mismatch", name)
+	}
+	if manifest.FormatVersion > FormatVersion {
+		return nil, nil, fmt.Errorf("backup %s is invalid: format version %d is not supported", name, manifest.FormatVersion)
+	}
+
+	data := migrate.NewDataSet()
+	listed := map[string]bool{manifestFile: true, signatureFile: true}
+	for _, f := range manifest.Files {
+		bs, ok := files[f.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("backup %s is invalid: %s is missing", name, f.Name)
+		}
+		sum := sha256.Sum256(bs)
+		if hex.EncodeToString(sum[:]) != f.SHA256 {
+			return nil, nil, fmt.Errorf("backup %s is invalid: checksum of %s mismatch", name, f.Name)
+		}
+		if err := data.Decode(f.Resource, bs); err != nil {
+			return nil, nil, fmt.Errorf("backup %s is invalid: %s: %s", name, f.Name, err)
+		}
+		listed[f.Name] = true
+	}
+	for file := range files {
+		if !listed[file] {
+			return nil, nil, fmt.Errorf("backup %s is invalid: %s is not in the manifest", name, file)
+		}
+	}
+
+	for _, typ := range migrate.Types {
+		for _, obj := range data.Objects(typ) {
+			encryption.Decrypt(obj)
+		}
+	}
+	return manifest, data, nil
+}
+
+// Restore imports the selected objects of the bundle chosen by Select into
+// the cluster in the context, with the conflict mode of migrate.Import.
+// The objects are committed at once by migrate.ImportDataSet, nothing is
+// restored and storage.ErrTxnTooLarge is returned when they don't fit in a
+// transaction, they can be restored by types or selectors then.
+func (m *Manager) Restore(ctx context.Context, name string, at time.Time, mode migrate.ConflictMode,
+	opt migrate.Options) (*Info, *migrate.ImportResult, error) {
+	info, err := m.Select(name, at)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, data, err := m.Load(info.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, err := migrate.ImportDataSet(ctx, data, mode, opt)
+	return info, result, err
+}
+
+// prune removes the bundles created before the retention, except the
+// latest one.
+func (m *Manager) prune() {
+	if m.Retention <= 0 {
+		return
+	}
+	infos, err := m.List()
+	if err != nil {
+		log.Warnf("list backups failed: %s", err)
+		return
+	}
+
+	deadline := time.Now().Add(-m.Retention).Unix()
+	for i, info := range infos {
+		if i == 0 || info.Manifest.CreateTime >= deadline {
+			continue
+		}
+		if err := os.Remove(filepath.Join(m.Dir, info.Name)); err != nil {
+			log.Warnf("remove backup %s failed: %s", info.Name, err)
+			continue
+		}
+		log.Infof("backup %s is removed by the retention", info.Name)
+	}
+}
+
+var manager *Manager
+
+// Default returns the manager of conf.BackupConfig, it's nil when the
+// backup is disabled.
+func Default() *Manager {
+	return manager
+}
+
+// Init enables the backup when the dir of conf.BackupConfig isn't empty,
+// and starts the scheduled backup of the local cluster when its schedule
+// isn't empty.
+func Init() error {
+	c := conf.BackupConfig
+	if c.Dir == "" {
+		return nil
+	}
+
+	var sched *schedule
+	if c.Schedule != "" {
+		s, err := parseSchedule(c.Schedule)
+		if err != nil {
+			return err
+		}
+		if s.next(time.Now()).IsZero() {
+			return fmt.Errorf("schedule %s is invalid: it never runs", c.Schedule)
+		}
+		sched = s
+	}
+	key, err := LoadSigningKey(c.SigningKey)
+	if err != nil {
+		return err
+	}
+	var trusted []ed25519.PublicKey
+	for _, path := range c.TrustedKeys {
+		pub, err := LoadPublicKey(path)
+		if err != nil {
+			return err
+		}
+		trusted = append(trusted, pub)
+	}
+	m, err := NewManager(c.Dir, time.Duration(c.Retention)*time.Second, key, trusted)
+	if err != nil {
+		return err
+	}
+	manager = m
+	if sched == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		defer runtime.HandlePanic()
+		for {
+			timer := time.NewTimer(time.Until(sched.next(time.Now())))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				if _, err := m.Create(ctx); err != nil {
+					log.Errorf("scheduled backup failed: %s", err)
+				}
+			}
+		}
+	}()
+	utils.AppendToClosers(func() error {
+		cancel()
+		return nil
+	})
+	return nil
+}
@@ -0,0 +1,258 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backup
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/migrate"
+	"github.com/apisix/manager-api/internal/core/store"
+)
+
+func newTestManager(t *testing.T, dir string, trusted ...ed25519.PublicKey) *Manager {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	m, err := NewManager(dir, 0, key, trusted)
+	assert.Nil(t, err)
+	return m
+}
+
+func testDataSet(t *testing.T) *migrate.DataSet {
+	data := migrate.NewDataSet()
+	for _, obj := range []interface{}{
+		&entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, URI: "/r1", UpstreamID: "u1"},
+		&entity.Upstream{BaseInfo: entity.BaseInfo{ID: "u1"}, UpstreamDef: entity.UpstreamDef{Type: "roundrobin"}},
+		&entity.Proto{BaseInfo: entity.BaseInfo{ID: "p1"}, Content: `syntax = "proto3";`},
+		&entity.SystemConfig{ConfigName: "c1"},
+	} {
+		assert.Nil(t, data.Add(obj))
+	}
+	return data
+}
+
+func TestManager(t *testing.T) {
+	dir := t.TempDir()
+	m := newTestManager(t, dir)
+	start := time.Date(2026, 10, 1, 3, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		info, err := m.write(testDataSet(t), &Manifest{Cluster: "local"}, start.AddDate(0, 0, i))
+		assert.Nil(t, err)
+		assert.Equal(t, bundleName(start.AddDate(0, 0, i)), info.Name)
+		assert.Equal(t, start.AddDate(0, 0, i).Unix(), info.Manifest.CreateTime)
+	}
+	_, err := m.write(testDataSet(t), &Manifest{}, start)
+	assert.EqualError(t, err, "backup apisix-backup-20261001T030000.000000Z.tar.gz is conflicted")
+	// the files other than the bundles are left alone
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0600))
+
+	infos, err := m.List()
+	assert.Nil(t, err)
+	assert.Len(t, infos, 3)
+	assert.Equal(t, "apisix-backup-20261003T030000.000000Z.tar.gz", infos[0].Name)
+	assert.Equal(t, "apisix-backup-20261001T030000.000000Z.tar.gz", infos[2].Name)
+	manifest := infos[0].Manifest
+	assert.Equal(t, FormatVersion, manifest.FormatVersion)
+	assert.Equal(t, KeyID(m.PublicKey()), manifest.KeyID)
+	assert.Len(t, manifest.Files, len(migrate.Types))
+	counts := map[store.HubKey]int{}
+	for _, f := range manifest.Files {
+		counts[f.Resource] = f.Count
+	}
+	assert.Equal(t, 1, counts[store.HubKeyRoute])
+	assert.Equal(t, 1, counts[store.HubKeySystemConfig])
+	assert.Equal(t, 0, counts[store.HubKeyConsumer])
+
+	// point in time
+	info, err := m.Select("", time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, "apisix-backup-20261003T030000.000000Z.tar.gz", info.Name)
+	info, err = m.Select("", start.AddDate(0, 0, 1).Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, "apisix-backup-20261002T030000.000000Z.tar.gz", info.Name)
+	_, err = m.Select("", start.Add(-time.Second))
+	assert.EqualError(t, err, "backup at 2026-10-01T02:59:59Z is not found")
+	_, err = m.Select("apisix-backup-20261004T030000.000000Z.tar.gz", time.Time{})
+	assert.EqualError(t, err, "backup apisix-backup-20261004T030000.000000Z.tar.gz is not found")
+	_, err = m.Path("../apisix-backup-20261001T030000.000000Z.tar.gz")
+	assert.EqualError(t, err, "backup name ../apisix-backup-20261001T030000.000000Z.tar.gz is invalid")
+
+	_, data, err := m.Load(info.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, testDataSet(t), data)
+
+	// the bundles before the retention are removed, except the latest one
+	m.Retention = time.Since(start.AddDate(0, 0, 1))
+	m.prune()
+	infos, err = m.List()
+	assert.Nil(t, err)
+	assert.Len(t, infos, 2)
+	m.Retention = time.Second
+	m.prune()
+	infos, err = m.List()
+	assert.Nil(t, err)
+	assert.Len(t, infos, 1)
+	assert.Equal(t, "apisix-backup-20261003T030000.000000Z.tar.gz", infos[0].Name)
+	_, err = os.Stat(filepath.Join(dir, "notes.txt"))
+	assert.Nil(t, err)
+}
+
+func TestManager_SameSecond(t *testing.T) {
+	m := newTestManager(t, t.TempDir())
+	created := time.Date(2026, 10, 1, 3, 0, 0, 0, time.UTC)
+	for _, d := range []time.Duration{0, 1500 * time.Microsecond} {
+		_, err := m.write(testDataSet(t), &Manifest{Cluster: "local"}, created.Add(d))
+		assert.Nil(t, err)
+	}
+
+	// the bundles don't conflict, and the later one is the latest
+	infos, err := m.List()
+	assert.Nil(t, err)
+	assert.Len(t, infos, 2)
+	assert.Equal(t, "apisix-backup-20261001T030000.001500Z.tar.gz", infos[0].Name)
+	assert.Equal(t, "apisix-backup-20261001T030000.000000Z.tar.gz", infos[1].Name)
+	assert.Equal(t, infos[0].Manifest.CreateTime, infos[1].Manifest.CreateTime)
+	info, err := m.Select("", time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, infos[0].Name, info.Name)
+}
+
+func TestManager_Load(t *testing.T) {
+	dir := t.TempDir()
+	m := newTestManager(t, dir)
+	info, err := m.write(testDataSet(t), &Manifest{Cluster: "local"}, time.Now())
+	assert.Nil(t, err)
+	path := filepath.Join(dir, info.Name)
+	files, err := readBundle(path, false)
+	assert.Nil(t, err)
+
+	rewrite := func(f func(files map[string][]byte)) {
+		cp := map[string][]byte{}
+		for name, data := range files {
+			cp[name] = data
+		}
+		f(cp)
+		var entries []entry
+		for name, data := range cp {
+			entries = append(entries, entry{name, data})
+		}
+		out, err := os.Create(path)
+		assert.Nil(t, err)
+		assert.Nil(t, writeBundle(out, entries, time.Now()))
+		assert.Nil(t, out.Close())
+	}
+
+	tests := []struct {
+		caseDesc string
+		modify   func(files map[string][]byte)
+		err      string
+	}{
+		{
+			caseDesc: "tampered file",
+			modify: func(files map[string][]byte) {
+				files["route.json"] = []byte(`[{"id":"r1","uri":"/evil"}]`)
+			},
+			err: "checksum of route.json mismatch",
+		},
+		{
+			caseDesc: "tampered manifest",
+			modify: func(files map[string][]byte) {
+				files[manifestFile] = append(files[manifestFile], ' ')
+			},
+			err: "signature mismatch",
+		},
+		{
+			caseDesc: "unsigned",
+			modify: func(files map[string][]byte) {
+				delete(files, signatureFile)
+			},
+			err: "it's not signed",
+		},
+		{
+			caseDesc: "extra file",
+			modify: func(files map[string][]byte) {
+				files["evil.json"] = []byte(`[]`)
+			},
+			err: "evil.json is not in the manifest",
+		},
+		{
+			caseDesc: "missing file",
+			modify: func(files map[string][]byte) {
+				delete(files, "upstream.json")
+			},
+			err: "upstream.json is missing",
+		},
+	}
+	for _, tc := range tests {
+		rewrite(tc.modify)
+		_, _, err := m.Load(info.Name)
+		assert.EqualError(t, err, "backup "+info.Name+" is invalid: "+tc.err, tc.caseDesc)
+	}
+
+	// signed by another instance
+	rewrite(func(map[string][]byte) {})
+	other := newTestManager(t, dir)
+	_, _, err = other.Load(info.Name)
+	assert.EqualError(t, err, "backup "+info.Name+" is invalid: it's signed by the untrusted key "+KeyID(m.PublicKey()))
+	trusting := newTestManager(t, dir, m.PublicKey())
+	_, data, err := trusting.Load(info.Name)
+	assert.Nil(t, err)
+	assert.Len(t, data.Routes, 1)
+
+	// nothing to import into without the stores
+	_, result, err := trusting.Restore(context.TODO(), "", time.Time{}, migrate.ModeReturn, migrate.Options{})
+	assert.Nil(t, err)
+	assert.Len(t, result.Outcomes, 0)
+}
+
+func TestLoadKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conf", "backup.key")
+	key, err := LoadSigningKey(path)
+	assert.Nil(t, err)
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+	loaded, err := LoadSigningKey(path)
+	assert.Nil(t, err)
+	assert.Equal(t, key, loaded)
+
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	assert.Nil(t, err)
+	pubPath := filepath.Join(t.TempDir(), "backup.pub")
+	assert.Nil(t, ioutil.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+	pub, err := LoadPublicKey(pubPath)
+	assert.Nil(t, err)
+	assert.Equal(t, key.Public(), pub)
+
+	_, err = LoadSigningKey(pubPath)
+	assert.Contains(t, err.Error(), "signing key "+pubPath+" is invalid")
+	_, err = LoadPublicKey(path)
+	assert.Contains(t, err.Error(), "public key "+path+" is invalid")
+}
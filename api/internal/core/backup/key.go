@@ -0,0 +1,107 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backup
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/apisix/manager-api/internal/log"
+)
+
+// KeyID identifies a public key in the manifests, it's the hex encoded
+// first 4 bytes of its sha256.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:4])
+}
+
+// LoadSigningKey reads the ed25519 private key of the PEM file, a key is
+// generated and written to the file when it's missing.
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return generateSigningKey(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(bs)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is invalid: no PEM block found", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s is invalid: %s", path, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is invalid: not an ed25519 key", path)
+	}
+	return priv, nil
+}
+
+func generateSigningKey(path string) (ed25519.PrivateKey, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	bs := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := ioutil.WriteFile(path, bs, 0600); err != nil {
+		return nil, err
+	}
+	log.Infof("generated backup signing key %s of id %s", path, KeyID(priv.Public().(ed25519.PublicKey)))
+	return priv, nil
+}
+
+// LoadPublicKey reads the ed25519 public key of the PEM file.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bs)
+	if block == nil {
+		return nil, fmt.Errorf("public key %s is invalid: no PEM block found", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("public key %s is invalid: %s", path, err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is invalid: not an ed25519 key", path)
+	}
+	return pub, nil
+}
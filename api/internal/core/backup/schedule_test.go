@@ -0,0 +1,76 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"0 3 * * *", ""},
+		{"*/15 0-6,22-23 1,15 * 1-5", ""},
+		{"5/10 * * * 7", ""},
+		{"0 3 * *", "schedule 0 3 * * is invalid: 5 fields are required"},
+		{"60 3 * * *", "schedule 60 3 * * * is invalid: 60 is out of range 0-59"},
+		{"0 3 0 * *", "schedule 0 3 0 * * is invalid: 0 is out of range 1-31"},
+		{"0 5-3 * * *", "schedule 0 5-3 * * * is invalid: 5-3 is out of range 0-23"},
+		{"*/0 * * * *", "schedule */0 * * * * is invalid: step of */0 is invalid"},
+		{"0 3 * JAN *", "schedule 0 3 * JAN * is invalid: JAN is invalid"},
+	}
+	for _, tc := range tests {
+		_, err := parseSchedule(tc.expr)
+		if tc.err == "" {
+			assert.Nil(t, err, tc.expr)
+		} else {
+			assert.EqualError(t, err, tc.err)
+		}
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	// a Wednesday
+	now := time.Date(2026, 10, 14, 10, 20, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 10, 14, 10, 21, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2026, 10, 15, 3, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 14, 10, 30, 0, 0, time.UTC)},
+		{"5/10 10 * * *", time.Date(2026, 10, 14, 10, 25, 0, 0, time.UTC)},
+		// Sunday by 0 and 7
+		{"0 0 * * 0", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		// either the day of the month or the day of the week
+		{"0 0 1 * 5", time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tc := range tests {
+		s, err := parseSchedule(tc.expr)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.want, s.next(now), tc.expr)
+	}
+}
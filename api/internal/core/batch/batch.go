@@ -0,0 +1,674 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package batch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/apisix/manager-api/internal/core/deletion"
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/graph"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/utils"
+	"github.com/apisix/manager-api/internal/utils/consts"
+)
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionPatch  = "patch"
+	ActionDelete = "delete"
+)
+
+// refPrefix marks a string in the id or the body of an operation as the
+// id of the object created by an earlier one, such as "$ref:web".
+const refPrefix = "$ref:"
+
+// Types are the resources which can be written in a batch, the ones
+// referred to by others first.
+var Types = []store.HubKey{
+	store.HubKeyUpstream,
+	store.HubKeyProto,
+	store.HubKeyPluginConfig,
+	store.HubKeyService,
+	store.HubKeySsl,
+	store.HubKeyConsumer,
+	store.HubKeyRoute,
+	store.HubKeyStreamRoute,
+	store.HubKeyGlobalRule,
+}
+
+var entityTypes = map[store.HubKey]reflect.Type{
+	store.HubKeyRoute:        reflect.TypeOf(entity.Route{}),
+	store.HubKeyService:      reflect.TypeOf(entity.Service{}),
+	store.HubKeyUpstream:     reflect.TypeOf(entity.Upstream{}),
+	store.HubKeyPluginConfig: reflect.TypeOf(entity.PluginConfig{}),
+	store.HubKeyStreamRoute:  reflect.TypeOf(entity.StreamRoute{}),
+	store.HubKeyProto:        reflect.TypeOf(entity.Proto{}),
+	store.HubKeySsl:          reflect.TypeOf(entity.SSL{}),
+	store.HubKeyConsumer:     reflect.TypeOf(entity.Consumer{}),
+	store.HubKeyGlobalRule:   reflect.TypeOf(entity.GlobalPlugins{}),
+}
+
+// idFields are the references which must point to existing objects.
+var idFields = map[string]bool{
+	graph.FieldServiceID:      true,
+	graph.FieldUpstreamID:     true,
+	graph.FieldPluginConfigID: true,
+	graph.FieldScriptID:       true,
+	graph.FieldProtoID:        true,
+}
+
+// Operation is a write in a batch.
+type Operation struct {
+	Op   string       `json:"op"`
+	Type store.HubKey `json:"type"`
+	// ID is the key of the object, the username for consumers. It's
+	// optional for create, which takes the id in the body or generates one.
+	ID string `json:"id,omitempty"`
+	// Ref names the created object, so that the later operations can refer
+	// to its id by "$ref:<name>"
+	Ref string `json:"ref,omitempty"`
+	// ResourceVersion makes an update, patch or delete conditional, a patch
+	// is conditional on the version it's applied to by default
+	ResourceVersion int64           `json:"resource_version,omitempty"`
+	Body            json.RawMessage `json:"body,omitempty"`
+}
+
+// Result is the outcome of an operation.
+type Result struct {
+	Op   string       `json:"op"`
+	Type store.HubKey `json:"type"`
+	ID   string       `json:"id"`
+	Ref  string       `json:"ref,omitempty"`
+	// Object is the written object, it's unset for a delete
+	Object interface{} `json:"object,omitempty"`
+}
+
+// OperationError is the error of the operation at Index.
+type OperationError struct {
+	Index int
+	Err   error
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("operations[%d]: %s", e.Index, e.Err)
+}
+
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+// Normalizer prepares the object decoded for a create or update the same
+// way as the handler of its type does.
+type Normalizer func(ctx context.Context, obj interface{}) error
+
+// Planner validates the batches, the stores missing in Stores are taken
+// from the store hub.
+type Planner struct {
+	Stores      map[store.HubKey]store.Interface
+	Normalizers map[store.HubKey]Normalizer
+}
+
+type plan struct {
+	*Planner
+	txn  *store.Txn
+	refs map[string]string
+	// staged are the objects after the batch, nil for the deleted ones
+	staged map[graph.Node]interface{}
+	// writers are the indexes of the operations writing the objects, in
+	// order is the order of the writes
+	writers map[graph.Node]int
+	order   []graph.Node
+	results []*Result
+	// ends are the numbers of the writes in txn after every operation
+	ends []int
+}
+
+// Part is the operations from Start to End of a batch planned by
+// PlanParts, which are committed in a transaction.
+type Part struct {
+	Start int
+	End   int
+	Txn   *store.Txn
+}
+
+// Plan validates the operations in order, and returns the transaction
+// applying all of them along with their results. Besides the checks of
+// every write, the state after the batch must keep the references: the
+// ids referred to must exist, and the deleted objects must no longer be
+// referred to by others. The writes must fit in a single transaction, see
+// store.Txn.CheckSize.
+func (p *Planner) Plan(ctx context.Context, ops []Operation) (*store.Txn, []*Result, error) {
+	b, err := p.plan(ctx, ops)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the batch is all or nothing, refuse it rather than fail in the storage
+	if err := b.txn.CheckSize(); err != nil {
+		return nil, nil, err
+	}
+	return b.txn, b.results, nil
+}
+
+// PlanParts is Plan for the batches which don't need to be applied all or
+// nothing. The writes are split between the operations into parts within
+// a transaction each, an operation is never split. The parts are in the
+// order of the operations, so that when the operations write the objects
+// referred to before their referrers, and delete the referrers before what
+// they refer to, every part committed keeps the references. See
+// CommitParts.
+func (p *Planner) PlanParts(ctx context.Context, ops []Operation) ([]*Part, []*Result, error) {
+	b, err := p.plan(ctx, ops)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var parts []*Part
+	part := &Part{}
+	for i, end := range b.ends {
+		if err := b.txn.Slice(b.startOf(i), end).CheckSize(); err != nil {
+			return nil, nil, &OperationError{Index: i, Err: err}
+		}
+		if i > part.Start && b.txn.Slice(b.startOf(part.Start), end).CheckSize() != nil {
+			parts = append(parts, part)
+			part = &Part{Start: i}
+		}
+		part.End = i + 1
+		part.Txn = b.txn.Slice(b.startOf(part.Start), end)
+	}
+	return append(parts, part), b.results, nil
+}
+
+// CommitParts commits the parts in order, and stops at the first part
+// failing. It returns the number of the operations applied, by the parts
+// before it.
+func CommitParts(ctx context.Context, parts []*Part) (int, error) {
+	applied := 0
+	for _, part := range parts {
+		if err := part.Txn.Commit(ctx); err != nil {
+			return applied, err
+		}
+		applied = part.End
+	}
+	return applied, nil
+}
+
+func (p *Planner) plan(ctx context.Context, ops []Operation) (*plan, error) {
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("operations is required")
+	}
+
+	b := &plan{
+		Planner: p,
+		txn:     store.NewTxn(),
+		refs:    map[string]string{},
+		staged:  map[graph.Node]interface{}{},
+		writers: map[graph.Node]int{},
+	}
+	for i := range ops {
+		if err := b.add(ctx, i, ops[i]); err != nil {
+			return nil, &OperationError{Index: i, Err: err}
+		}
+		b.ends = append(b.ends, b.txn.Len())
+	}
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// startOf returns the index of the first write of the operation.
+func (b *plan) startOf(i int) int {
+	if i == 0 {
+		return 0
+	}
+	return b.ends[i-1]
+}
+
+// Check checks the state after writing the objects out of a batch, such as
+// restoring them, the same as a batch updating them in order: the ids they
+// refer to must exist, and their names must be unique.
+func (p *Planner) Check(ctx context.Context, objs ...interface{}) error {
+	b := &plan{
+		Planner: p,
+		staged:  map[graph.Node]interface{}{},
+		writers: map[graph.Node]int{},
+	}
+	for i, obj := range objs {
+		typ, ok := objectType(obj)
+		if !ok {
+			return fmt.Errorf("type %T is invalid", obj)
+		}
+		node := graph.Node{Type: typ, ID: ObjectKey(obj)}
+		if err := b.claim(node, i); err != nil {
+			return err
+		}
+		b.staged[node] = obj
+	}
+
+	err := b.check(ctx)
+	var opErr *OperationError
+	if errors.As(err, &opErr) {
+		return opErr.Err
+	}
+	return err
+}
+
+// objectType returns the type of the object which can be written in a
+// batch, or a script.
+func objectType(obj interface{}) (store.HubKey, bool) {
+	if _, ok := obj.(*entity.Script); ok {
+		return store.HubKeyScript, true
+	}
+	for typ, t := range entityTypes {
+		if reflect.TypeOf(obj) == reflect.PtrTo(t) {
+			return typ, true
+		}
+	}
+	return "", false
+}
+
+// Store returns the store of the type.
+func (p *Planner) Store(typ store.HubKey) store.Interface {
+	if s, ok := p.Stores[typ]; ok {
+		return s
+	}
+	return store.GetStore(typ)
+}
+
+func (b *plan) add(ctx context.Context, i int, op Operation) error {
+	if _, ok := entityTypes[op.Type]; !ok {
+		return fmt.Errorf("type %s is invalid", op.Type)
+	}
+	if op.Ref != "" && op.Op != ActionCreate {
+		return fmt.Errorf("ref is only valid for create")
+	}
+	id, err := b.resolve(op.ID)
+	if err != nil {
+		return err
+	}
+	body, err := b.resolveBody(op.Body)
+	if err != nil {
+		return err
+	}
+	if op.Op != ActionCreate {
+		if id == "" {
+			return fmt.Errorf("id is required")
+		}
+		if err := b.claim(graph.Node{Type: op.Type, ID: id}, i); err != nil {
+			return err
+		}
+	}
+	if op.ResourceVersion > 0 {
+		ctx = store.WithExpectedVersion(ctx, op.ResourceVersion)
+	}
+
+	s := b.Store(op.Type)
+	var obj interface{}
+	switch op.Op {
+	case ActionCreate:
+		if op.ResourceVersion > 0 {
+			return fmt.Errorf("resource_version is invalid for create")
+		}
+		if obj, err = b.Decode(ctx, op.Type, id, body); err != nil {
+			return err
+		}
+		if err := b.txn.Create(ctx, s, obj); err != nil {
+			return err
+		}
+		id = ObjectKey(obj)
+		if err := b.claim(graph.Node{Type: op.Type, ID: id}, i); err != nil {
+			return err
+		}
+	case ActionUpdate:
+		if obj, err = b.Decode(ctx, op.Type, id, body); err != nil {
+			return err
+		}
+		if err := b.txn.Update(ctx, s, obj, true); err != nil {
+			return err
+		}
+	case ActionPatch:
+		if obj, err = b.patch(ctx, s, op.Type, id, body); err != nil {
+			return err
+		}
+		if op.ResourceVersion == 0 {
+			ctx = store.WithExpectedVersion(ctx, ResourceVersion(obj))
+		}
+		if err := b.txn.Update(ctx, s, obj, false); err != nil {
+			return err
+		}
+	case ActionDelete:
+		if err := b.txn.Delete(ctx, s, id); err != nil {
+			return err
+		}
+		if op.Type == store.HubKeyRoute {
+			if err := b.deleteScript(ctx, i, id); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("op %s is invalid", op.Op)
+	}
+
+	if op.Ref != "" {
+		if _, ok := b.refs[op.Ref]; ok {
+			return fmt.Errorf("ref %s is conflicted", op.Ref)
+		}
+		b.refs[op.Ref] = id
+	}
+	b.staged[graph.Node{Type: op.Type, ID: id}] = obj
+	b.results = append(b.results, &Result{
+		Op:     op.Op,
+		Type:   op.Type,
+		ID:     id,
+		Ref:    op.Ref,
+		Object: obj,
+	})
+	return nil
+}
+
+// claim records the object is written by the operation, an object can be
+// written only once in a batch.
+func (b *plan) claim(node graph.Node, i int) error {
+	if j, ok := b.writers[node]; ok {
+		return fmt.Errorf("%s is already written by operations[%d]", node, j)
+	}
+	b.writers[node] = i
+	b.order = append(b.order, node)
+	return nil
+}
+
+// deleteScript deletes the script of the route along with it, the routes
+// still sharing the script refuse the deletion in check.
+func (b *plan) deleteScript(ctx context.Context, i int, id string) error {
+	s := b.Store(store.HubKeyScript)
+	if script, _ := s.Get(ctx, id); script == nil {
+		return nil
+	}
+	node := graph.Node{Type: store.HubKeyScript, ID: id}
+	if err := b.claim(node, i); err != nil {
+		return err
+	}
+	b.staged[node] = nil
+	return b.txn.Delete(store.WithExpectedVersion(ctx, 0), s, id)
+}
+
+// Decode decodes the body of a create or update the same way as a batch,
+// the id is set as the key of the object when it's not empty.
+func (p *Planner) Decode(ctx context.Context, typ store.HubKey, id string, body []byte) (interface{}, error) {
+	if _, ok := entityTypes[typ]; !ok {
+		return nil, fmt.Errorf("type %s is invalid", typ)
+	}
+	if len(body) == 0 {
+		return nil, fmt.Errorf("body is required")
+	}
+	fields := map[string]interface{}{}
+	if err := unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("body is invalid: %s", err)
+	}
+	if id != "" {
+		keyField := "id"
+		if typ == store.HubKeyConsumer {
+			keyField = "username"
+		}
+		if v, ok := fields[keyField]; ok && v != nil && utils.InterfaceToString(v) != id {
+			return nil, fmt.Errorf("id (%s) doesn't match %s in body (%s)", id, keyField, utils.InterfaceToString(v))
+		}
+		fields[keyField] = id
+	}
+	// routes are enabled by default, the same as filter.SchemaCheck sets
+	if _, ok := fields["status"]; !ok && typ == store.HubKeyRoute {
+		fields["status"] = 1
+	}
+
+	bs, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	obj := reflect.New(entityTypes[typ]).Interface()
+	if err := json.Unmarshal(bs, obj); err != nil {
+		return nil, fmt.Errorf("body is invalid: %s", err)
+	}
+	if normalize, ok := p.Normalizers[typ]; ok {
+		if err := normalize(ctx, obj); err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// patch merges the body into the stored object as a JSON merge patch.
+func (b *plan) patch(ctx context.Context, s store.Interface, typ store.HubKey, id string, body []byte) (interface{}, error) {
+	if len(body) == 0 {
+		return nil, fmt.Errorf("body is required")
+	}
+	stored, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf(consts.IDNotFound, typ, id)
+	}
+	res, err := utils.MergePatch(stored, "", body)
+	if err != nil {
+		return nil, err
+	}
+	obj := reflect.New(entityTypes[typ]).Interface()
+	if err := json.Unmarshal(res, obj); err != nil {
+		return nil, fmt.Errorf("body is invalid: %s", err)
+	}
+	if v, ok := obj.(entity.Versioned); ok {
+		v.SetResourceVersion(ResourceVersion(stored))
+	}
+	return obj, nil
+}
+
+// resolve replaces the reference to a created object by its id.
+func (b *plan) resolve(s string) (string, error) {
+	if !strings.HasPrefix(s, refPrefix) {
+		return s, nil
+	}
+	name := strings.TrimPrefix(s, refPrefix)
+	id, ok := b.refs[name]
+	if !ok {
+		return "", fmt.Errorf("ref %s is not defined by an earlier create", name)
+	}
+	return id, nil
+}
+
+// resolveBody resolves the references in all the strings of the body.
+func (b *plan) resolveBody(body json.RawMessage) ([]byte, error) {
+	if len(body) == 0 || !bytes.Contains(body, []byte(refPrefix)) {
+		return body, nil
+	}
+	var v interface{}
+	if err := unmarshal(body, &v); err != nil {
+		return nil, fmt.Errorf("body is invalid: %s", err)
+	}
+	v, err := b.substitute(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func (b *plan) substitute(v interface{}) (interface{}, error) {
+	var err error
+	switch o := v.(type) {
+	case string:
+		return b.resolve(o)
+	case []interface{}:
+		for i := range o {
+			if o[i], err = b.substitute(o[i]); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		for k := range o {
+			if o[k], err = b.substitute(o[k]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// check validates the references and the names in the state after the
+// batch.
+func (b *plan) check(ctx context.Context) error {
+	sources := map[store.HubKey]graph.Source{}
+	for _, typ := range graph.Types {
+		ret, err := b.Store(typ).List(ctx, store.ListInput{})
+		if err != nil {
+			return err
+		}
+		src := &overlay{rows: ret.Rows, staged: map[string]interface{}{}}
+		for node, obj := range b.staged {
+			if node.Type == typ {
+				src.staged[node.ID] = obj
+			}
+		}
+		sources[typ] = src
+	}
+	g := graph.Build(ctx, sources)
+
+	refused := map[graph.Node][]graph.Edge{}
+	for _, e := range g.Edges() {
+		if e.Field == graph.FieldSNI {
+			continue
+		}
+		if obj, ok := b.staged[e.To]; ok && obj == nil {
+			refused[e.To] = append(refused[e.To], e)
+			continue
+		}
+		if i, ok := b.writers[e.From]; ok && idFields[e.Field] && !g.Has(e.To) {
+			return &OperationError{Index: i, Err: fmt.Errorf(consts.IDNotFound, e.To.Type, e.To.ID)}
+		}
+	}
+	for _, node := range b.order {
+		if edges, ok := refused[node]; ok {
+			return &OperationError{Index: b.writers[node], Err: &deletion.ReferencedError{Edges: edges}}
+		}
+	}
+
+	names := map[store.HubKey]map[string]int{}
+	for typ, src := range sources {
+		names[typ] = map[string]int{}
+		src.Range(ctx, func(_ string, obj interface{}) bool {
+			if name := ObjectName(obj); name != "" {
+				names[typ][name]++
+			}
+			return true
+		})
+	}
+	for _, node := range b.order {
+		if name := ObjectName(b.staged[node]); name != "" && names[node.Type][name] > 1 {
+			return &OperationError{Index: b.writers[node], Err: fmt.Errorf("%s name exists", node.Type)}
+		}
+	}
+	return nil
+}
+
+// overlay is the objects of a store after the batch.
+type overlay struct {
+	rows   []interface{}
+	staged map[string]interface{}
+}
+
+func (o *overlay) Range(_ context.Context, f func(key string, obj interface{}) bool) {
+	for _, obj := range o.rows {
+		key := ObjectKey(obj)
+		if _, ok := o.staged[key]; ok {
+			continue
+		}
+		if !f(key, obj) {
+			return
+		}
+	}
+
+	keys := make([]string, 0, len(o.staged))
+	for key := range o.staged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if o.staged[key] == nil {
+			continue
+		}
+		if !f(key, o.staged[key]) {
+			return
+		}
+	}
+}
+
+// unmarshal keeps the numbers as they are, so that large integers are not
+// rounded by the round trip.
+func unmarshal(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// ObjectKey returns the key of the object, the username for consumers.
+func ObjectKey(obj interface{}) string {
+	switch o := obj.(type) {
+	case *entity.Consumer:
+		return o.Username
+	case *entity.Script:
+		return o.ID
+	}
+	return utils.InterfaceToString(obj.(entity.GetBaseInfo).GetBaseInfo().ID)
+}
+
+// ObjectName returns the name of the types whose names are unique, empty
+// for the others.
+func ObjectName(obj interface{}) string {
+	switch o := obj.(type) {
+	case *entity.Route:
+		return o.Name
+	case *entity.Service:
+		return o.Name
+	case *entity.Upstream:
+		return o.Name
+	}
+	return ""
+}
+
+// ResourceVersion returns the resource version of the object, 0 for the
+// types without it.
+func ResourceVersion(obj interface{}) int64 {
+	if v, ok := obj.(entity.Versioned); ok {
+		return v.GetResourceVersion()
+	}
+	return 0
+}
+
+// ObjectLabels returns the labels of the object, nil for the types without
+// them.
+func ObjectLabels(obj interface{}) map[string]string {
+	v := reflect.Indirect(reflect.ValueOf(obj)).FieldByName("Labels")
+	if !v.IsValid() {
+		return nil
+	}
+	ret, _ := v.Interface().(map[string]string)
+	return ret
+}
@@ -0,0 +1,283 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/core/deletion"
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/core/store/storetest"
+)
+
+func create(t *testing.T, s store.Interface, objs ...interface{}) {
+	for _, obj := range objs {
+		_, err := s.Create(context.TODO(), obj)
+		assert.Nil(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		ret, err := s.List(context.TODO(), store.ListInput{})
+		return err == nil && ret.TotalSize == len(objs)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func keys(t *testing.T, s store.Interface) []string {
+	ret, err := s.List(context.TODO(), store.ListInput{})
+	assert.Nil(t, err)
+	keys := []string{}
+	for _, obj := range ret.Rows {
+		keys = append(keys, ObjectKey(obj))
+	}
+	return keys
+}
+
+func parseOps(t *testing.T, s string) []Operation {
+	var ops []Operation
+	assert.Nil(t, json.Unmarshal([]byte(s), &ops))
+	return ops
+}
+
+func TestPlan_Refs(t *testing.T) {
+	stores := storetest.NewStores(t, storage.NewMemoryStorage(), "")
+	p := &Planner{Stores: stores}
+
+	txn, results, err := p.Plan(context.TODO(), parseOps(t, `[
+		{"op": "create", "type": "upstream", "ref": "up", "body": {"name": "web", "type": "roundrobin", "nodes": {"127.0.0.1:80": 1}}},
+		{"op": "create", "type": "service", "id": "s1", "body": {"upstream_id": "$ref:up"}},
+		{"op": "create", "type": "route", "id": "r1", "body": {"uri": "/a", "service_id": "s1"}},
+		{"op": "create", "type": "route", "ref": "r2", "body": {"uri": "/b", "upstream_id": "$ref:up"}}
+	]`))
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Commit(context.TODO()))
+
+	assert.Len(t, results, 4)
+	upstreamID := results[0].ID
+	assert.NotEmpty(t, upstreamID)
+	assert.Equal(t, "up", results[0].Ref)
+	assert.Equal(t, "s1", results[1].ID)
+	assert.Equal(t, upstreamID, results[1].Object.(*entity.Service).UpstreamID)
+	assert.Equal(t, upstreamID, results[3].Object.(*entity.Route).UpstreamID)
+	assert.Equal(t, entity.Status(1), results[3].Object.(*entity.Route).Status)
+
+	assert.Eventually(t, func() bool {
+		return len(keys(t, stores[store.HubKeyRoute])) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{upstreamID}, keys(t, stores[store.HubKeyUpstream]))
+	assert.Equal(t, []string{"s1"}, keys(t, stores[store.HubKeyService]))
+}
+
+func TestPlan_Invalid(t *testing.T) {
+	stores := storetest.NewStores(t, storage.NewMemoryStorage(), "")
+	create(t, stores[store.HubKeyUpstream], &entity.Upstream{BaseInfo: entity.BaseInfo{ID: "u1"}})
+	create(t, stores[store.HubKeyRoute], &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, Name: "a", UpstreamID: "u1"})
+	p := &Planner{Stores: stores}
+
+	tests := []struct {
+		caseDesc string
+		ops      string
+		wantErr  string
+	}{
+		{
+			caseDesc: "no operation",
+			ops:      `[]`,
+			wantErr:  "operations is required",
+		},
+		{
+			caseDesc: "invalid type",
+			ops:      `[{"op": "create", "type": "script", "body": {}}]`,
+			wantErr:  "operations[0]: type script is invalid",
+		},
+		{
+			caseDesc: "invalid op",
+			ops:      `[{"op": "upsert", "type": "route", "id": "r2"}]`,
+			wantErr:  "operations[0]: op upsert is invalid",
+		},
+		{
+			caseDesc: "undefined ref",
+			ops: `[
+				{"op": "create", "type": "route", "ref": "r", "body": {"uri": "/a"}},
+				{"op": "create", "type": "route", "body": {"uri": "/b", "upstream_id": "$ref:up"}}
+			]`,
+			wantErr: "operations[1]: ref up is not defined by an earlier create",
+		},
+		{
+			caseDesc: "ref of update",
+			ops:      `[{"op": "update", "type": "route", "id": "r1", "ref": "r", "body": {"uri": "/a"}}]`,
+			wantErr:  "operations[0]: ref is only valid for create",
+		},
+		{
+			caseDesc: "written twice",
+			ops: `[
+				{"op": "create", "type": "route", "ref": "r", "body": {"uri": "/a"}},
+				{"op": "delete", "type": "route", "id": "$ref:r"}
+			]`,
+			wantErr: "is already written by operations[0]",
+		},
+		{
+			caseDesc: "id mismatch",
+			ops:      `[{"op": "update", "type": "route", "id": "r1", "body": {"id": "r2", "uri": "/a"}}]`,
+			wantErr:  "operations[0]: id (r1) doesn't match id in body (r2)",
+		},
+		{
+			caseDesc: "dangling id",
+			ops: `[
+				{"op": "create", "type": "route", "id": "r2", "body": {"uri": "/a"}},
+				{"op": "create", "type": "route", "id": "r3", "body": {"uri": "/b", "upstream_id": "u2"}}
+			]`,
+			wantErr: "operations[1]: upstream id: u2 not found",
+		},
+		{
+			caseDesc: "still referenced",
+			ops:      `[{"op": "delete", "type": "upstream", "id": "u1"}]`,
+			wantErr:  "operations[0]: upstream/u1 is referenced by route/r1 (upstream_id)",
+		},
+		{
+			caseDesc: "referenced by a write",
+			ops: `[
+				{"op": "patch", "type": "route", "id": "r1", "body": {"upstream_id": null}},
+				{"op": "delete", "type": "upstream", "id": "u1"},
+				{"op": "create", "type": "service", "id": "s1", "body": {"upstream_id": "u1"}}
+			]`,
+			wantErr: "operations[1]: upstream/u1 is referenced by service/s1 (upstream_id)",
+		},
+		{
+			caseDesc: "name exists",
+			ops:      `[{"op": "create", "type": "route", "id": "r2", "body": {"uri": "/a", "name": "a"}}]`,
+			wantErr:  "operations[0]: route name exists",
+		},
+		{
+			caseDesc: "conflicted",
+			ops:      `[{"op": "create", "type": "upstream", "id": "u1", "body": {}}]`,
+			wantErr:  "operations[0]: key: u1 is conflicted",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			_, _, err := p.Plan(context.TODO(), parseOps(t, tc.ops))
+			assert.NotNil(t, err)
+			if err != nil {
+				assert.Contains(t, err.Error(), tc.wantErr)
+			}
+		})
+	}
+
+	_, _, err := p.Plan(context.TODO(), parseOps(t, `[{"op": "delete", "type": "upstream", "id": "u1"}]`))
+	var refErr *deletion.ReferencedError
+	assert.True(t, errors.As(err, &refErr))
+}
+
+func TestPlan_Writes(t *testing.T) {
+	stores := storetest.NewStores(t, storage.NewMemoryStorage(), "")
+	create(t, stores[store.HubKeyUpstream], &entity.Upstream{BaseInfo: entity.BaseInfo{ID: "u1"}})
+	create(t, stores[store.HubKeyRoute],
+		&entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, Name: "a", URI: "/a", UpstreamID: "u1"},
+		&entity.Route{BaseInfo: entity.BaseInfo{ID: "r2"}, Name: "b", URI: "/b", ScriptID: "r2"})
+	create(t, stores[store.HubKeyScript], &entity.Script{ID: "r2", Script: "lua"})
+	p := &Planner{Stores: stores}
+
+	// the references and names are checked against the state after the
+	// batch, so the route can be moved off the upstream before deleting it
+	txn, results, err := p.Plan(context.TODO(), parseOps(t, `[
+		{"op": "patch", "type": "route", "id": "r1", "body": {"upstream_id": null, "name": "b"}},
+		{"op": "delete", "type": "upstream", "id": "u1"},
+		{"op": "delete", "type": "route", "id": "r2"},
+		{"op": "update", "type": "route", "id": "r3", "body": {"uri": "/c", "name": "a"}}
+	]`))
+	assert.Nil(t, err)
+	assert.Nil(t, results[2].Object)
+	assert.Nil(t, txn.Commit(context.TODO()))
+
+	assert.Eventually(t, func() bool {
+		return len(keys(t, stores[store.HubKeyUpstream])) == 0 &&
+			len(keys(t, stores[store.HubKeyScript])) == 0 &&
+			len(keys(t, stores[store.HubKeyRoute])) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	r1, err := stores[store.HubKeyRoute].Get(context.TODO(), "r1")
+	assert.Nil(t, err)
+	assert.Nil(t, r1.(*entity.Route).UpstreamID)
+	assert.Equal(t, "/a", r1.(*entity.Route).URI)
+	assert.Equal(t, "b", r1.(*entity.Route).Name)
+
+	// a stale resource version fails the whole batch
+	txn, _, err = p.Plan(context.TODO(), parseOps(t, `[
+		{"op": "create", "type": "upstream", "id": "u2", "body": {}},
+		{"op": "delete", "type": "route", "id": "r1", "resource_version": 1}
+	]`))
+	assert.Nil(t, err)
+	err = txn.Commit(context.TODO())
+	assert.True(t, errors.Is(err, storage.ErrRevisionMismatch))
+	assert.Empty(t, keys(t, stores[store.HubKeyUpstream]))
+}
+
+func TestPlanParts(t *testing.T) {
+	defer func(n int) {
+		storage.MaxTxnOps = n
+	}(storage.MaxTxnOps)
+	storage.MaxTxnOps = 2
+
+	stores := storetest.NewStores(t, storage.NewMemoryStorage(), "")
+	create(t, stores[store.HubKeyRoute], &entity.Route{BaseInfo: entity.BaseInfo{ID: "r2"}, URI: "/b", ScriptID: "r2"})
+	create(t, stores[store.HubKeyScript], &entity.Script{ID: "r2", Script: "lua"})
+	p := &Planner{Stores: stores}
+
+	// the route is deleted along with its script in the same part
+	parts, results, err := p.PlanParts(context.TODO(), parseOps(t, `[
+		{"op": "create", "type": "upstream", "id": "u1", "body": {}},
+		{"op": "delete", "type": "route", "id": "r2"},
+		{"op": "create", "type": "route", "id": "r1", "body": {"uri": "/a", "upstream_id": "u1"}}
+	]`))
+	assert.Nil(t, err)
+	assert.Len(t, results, 3)
+	assert.Len(t, parts, 3)
+	for i, part := range parts {
+		assert.Equal(t, i, part.Start)
+		assert.Equal(t, i+1, part.End)
+	}
+	assert.Equal(t, 2, parts[1].Txn.Len())
+
+	applied, err := CommitParts(context.TODO(), parts)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, applied)
+	assert.Eventually(t, func() bool {
+		return len(keys(t, stores[store.HubKeyScript])) == 0 &&
+			len(keys(t, stores[store.HubKeyUpstream])) == 1 &&
+			len(keys(t, stores[store.HubKeyRoute])) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the operations share a part as long as they fit
+	storage.MaxTxnOps = 3
+	parts, _, err = p.PlanParts(context.TODO(), parseOps(t, `[
+		{"op": "create", "type": "upstream", "id": "u2", "body": {}},
+		{"op": "create", "type": "upstream", "id": "u3", "body": {}},
+		{"op": "create", "type": "upstream", "id": "u4", "body": {}},
+		{"op": "create", "type": "upstream", "id": "u5", "body": {}}
+	]`))
+	assert.Nil(t, err)
+	assert.Len(t, parts, 2)
+	assert.Equal(t, 3, parts[0].End)
+	assert.Equal(t, 3, parts[1].Start)
+	assert.Equal(t, 4, parts[1].End)
+}
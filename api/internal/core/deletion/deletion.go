@@ -0,0 +1,507 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package deletion
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/graph"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/utils"
+)
+
+// Mode is how the references to the deleted objects are handled.
+type Mode string
+
+const (
+	// ModeRestrict refuses to delete the objects still referenced
+	ModeRestrict Mode = "restrict"
+	// ModeCascade deletes the objects referring to the deleted ones too
+	ModeCascade Mode = "cascade"
+	// ModeDetach clears the references, and inlines the referenced config
+	// into the objects referring to it where possible
+	ModeDetach Mode = "detach"
+)
+
+// ParseMode parses the mode query parameter, restrict by default.
+func ParseMode(mode string) (Mode, error) {
+	switch Mode(mode) {
+	case "":
+		return ModeRestrict, nil
+	case ModeRestrict, ModeCascade, ModeDetach:
+		return Mode(mode), nil
+	}
+	return "", fmt.Errorf("mode %s is invalid", mode)
+}
+
+// ReferencedError is returned when the objects can't be deleted for the
+// references to them.
+type ReferencedError struct {
+	Edges []graph.Edge
+	// Reason is why the references can't be detached
+	Reason string
+}
+
+func (e *ReferencedError) Error() string {
+	var to []graph.Node
+	from := map[graph.Node][]string{}
+	for _, edge := range e.Edges {
+		if _, ok := from[edge.To]; !ok {
+			to = append(to, edge.To)
+		}
+		from[edge.To] = append(from[edge.To], fmt.Sprintf("%s (%s)", edge.From, edge.Field))
+	}
+
+	msgs := make([]string, 0, len(to))
+	for _, node := range to {
+		msgs = append(msgs, fmt.Sprintf("%s is referenced by %s", node, strings.Join(from[node], ", ")))
+	}
+	msg := strings.Join(msgs, "; ")
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+type referrer struct {
+	edge graph.Edge
+	obj  interface{}
+}
+
+// deleteOrder is the order the types are deleted in, the types referring
+// to others come before the types they refer to.
+var deleteOrder = []store.HubKey{
+	store.HubKeyGlobalRule,
+	store.HubKeyRoute,
+	store.HubKeyStreamRoute,
+	store.HubKeyService,
+	store.HubKeyPluginConfig,
+	store.HubKeyConsumer,
+	store.HubKeyUpstream,
+	store.HubKeyProto,
+	store.HubKeyScript,
+	store.HubKeySsl,
+}
+
+// deleteWrite is the delete of the objects of the type.
+type deleteWrite struct {
+	typ store.HubKey
+	txn *store.Txn
+}
+
+type planner struct {
+	mode    Mode
+	stores  map[store.HubKey]store.Interface
+	txn     *store.Txn
+	deleted map[graph.Node]bool
+	deletes []deleteWrite
+	// updated are the objects detached from the deleted ones, in order
+	updated map[graph.Node]interface{}
+	order   []graph.Node
+}
+
+// Plan returns the transaction deleting the objects of the type by the
+// keys, along with the writes to the objects referring to them by the
+// mode. The stores missing in stores are taken from the store hub.
+//
+// The expected version in ctx only applies to the objects by the keys,
+// the others are written on condition that they are not changed since
+// they are read. The writes are ordered so that no object refers to a
+// deleted one at any point between them: the detached objects come
+// first, then the deletes of the objects referring to others. So they
+// can be committed in parts by store.Txn.CommitParts.
+func Plan(ctx context.Context, mode Mode, stores map[store.HubKey]store.Interface,
+	typ store.HubKey, keys []string) (*store.Txn, error) {
+	p := &planner{
+		mode:    mode,
+		stores:  stores,
+		txn:     store.NewTxn(),
+		deleted: map[graph.Node]bool{},
+		updated: map[graph.Node]interface{}{},
+	}
+
+	// the references between the deleted objects are ignored
+	for _, key := range keys {
+		p.deleted[graph.Node{Type: typ, ID: key}] = true
+	}
+	if err := p.delete(ctx, typ, keys...); err != nil {
+		return nil, err
+	}
+
+	var refs []referrer
+	for _, key := range keys {
+		ret, err := p.prune(ctx, graph.Node{Type: typ, ID: key})
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ret...)
+	}
+	if len(refs) > 0 {
+		edges := make([]graph.Edge, 0, len(refs))
+		for _, ref := range refs {
+			edges = append(edges, ref.edge)
+		}
+		return nil, &ReferencedError{Edges: edges}
+	}
+
+	for _, node := range p.order {
+		if p.deleted[node] {
+			continue
+		}
+		obj := p.updated[node]
+		vctx := store.WithExpectedVersion(ctx, resourceVersion(obj))
+		if err := p.txn.Update(vctx, p.store(node.Type), obj, false); err != nil {
+			return nil, err
+		}
+	}
+	rank := map[store.HubKey]int{}
+	for i, typ := range deleteOrder {
+		rank[typ] = i
+	}
+	sort.SliceStable(p.deletes, func(i, j int) bool {
+		return rank[p.deletes[i].typ] < rank[p.deletes[j].typ]
+	})
+	for _, d := range p.deletes {
+		p.txn.Append(d.txn)
+	}
+	return p.txn, nil
+}
+
+// delete prepares the delete of the objects of the type by the keys, which
+// is added to the transaction in the order of deleteOrder.
+func (p *planner) delete(ctx context.Context, typ store.HubKey, keys ...string) error {
+	txn := store.NewTxn()
+	if err := txn.Delete(ctx, p.store(typ), keys...); err != nil {
+		return err
+	}
+	p.deletes = append(p.deletes, deleteWrite{typ: typ, txn: txn})
+	return nil
+}
+
+func (p *planner) store(typ store.HubKey) store.Interface {
+	if s, ok := p.stores[typ]; ok {
+		return s
+	}
+	return store.GetStore(typ)
+}
+
+// prune deletes what's owned by the deleted node, and handles the
+// references to it by the mode. It returns the references left, which
+// refuse the deletion.
+func (p *planner) prune(ctx context.Context, node graph.Node) ([]referrer, error) {
+	refs, err := p.referrers(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+	if node.Type == store.HubKeyRoute {
+		ret, err := p.deleteScript(ctx, node.ID)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ret...)
+	}
+
+	if p.mode == ModeRestrict {
+		return refs, nil
+	}
+	for _, ref := range refs {
+		if p.deleted[ref.edge.From] {
+			continue
+		}
+		if err := p.handle(ctx, ref); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// deleteScript deletes the script of the route, the routes sharing the
+// script are returned as its referrers.
+func (p *planner) deleteScript(ctx context.Context, id string) ([]referrer, error) {
+	scriptStore := p.store(store.HubKeyScript)
+	if script, _ := scriptStore.Get(ctx, id); script == nil {
+		return nil, nil
+	}
+	script := graph.Node{Type: store.HubKeyScript, ID: id}
+	p.deleted[script] = true
+	if err := p.delete(store.WithExpectedVersion(ctx, 0), store.HubKeyScript, id); err != nil {
+		return nil, err
+	}
+
+	routes, err := p.scan(ctx, store.HubKeyRoute, func(obj interface{}) bool {
+		route := obj.(*entity.Route)
+		return route.ScriptID != nil && utils.InterfaceToString(route.ScriptID) == id
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p.edges(routes, store.HubKeyRoute, script, graph.FieldScriptID), nil
+}
+
+// referrers returns the objects referring to the node, except the deleted
+// ones.
+func (p *planner) referrers(ctx context.Context, node graph.Node) ([]referrer, error) {
+	var lookups []struct {
+		typ   store.HubKey
+		index string
+		field string
+	}
+	add := func(index, field string, types ...store.HubKey) {
+		for _, typ := range types {
+			lookups = append(lookups, struct {
+				typ   store.HubKey
+				index string
+				field string
+			}{typ, index, field})
+		}
+	}
+
+	switch node.Type {
+	case store.HubKeyUpstream:
+		add(store.IndexUpstreamID, graph.FieldUpstreamID,
+			store.HubKeyRoute, store.HubKeyService, store.HubKeyStreamRoute)
+	case store.HubKeyService:
+		add(store.IndexServiceID, graph.FieldServiceID, store.HubKeyRoute)
+	case store.HubKeyPluginConfig:
+		add(store.IndexPluginConfigID, graph.FieldPluginConfigID, store.HubKeyRoute)
+	case store.HubKeyProto:
+		add(store.IndexProtoID, graph.FieldProtoID, store.HubKeyRoute, store.HubKeyService,
+			store.HubKeyConsumer, store.HubKeyPluginConfig, store.HubKeyGlobalRule)
+	case store.HubKeyConsumer:
+		// the whitelists are not indexed, they are scanned instead
+		var ret []referrer
+		for _, typ := range []store.HubKey{store.HubKeyRoute, store.HubKeyService,
+			store.HubKeyPluginConfig, store.HubKeyGlobalRule} {
+			objs, err := p.scan(ctx, typ, func(obj interface{}) bool {
+				return contains(graph.AllowedConsumers(obj.(entity.GetPlugins).GetPlugins()), node.ID)
+			})
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, p.edges(objs, typ, node, graph.FieldWhitelist)...)
+		}
+		return ret, nil
+	}
+
+	var ret []referrer
+	for _, l := range lookups {
+		objs, err := p.store(l.typ).IndexLookup(ctx, l.index, node.ID)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, p.edges(objs, l.typ, node, l.field)...)
+	}
+	return ret, nil
+}
+
+func (p *planner) scan(ctx context.Context, typ store.HubKey, predicate func(obj interface{}) bool) ([]interface{}, error) {
+	ret, err := p.store(typ).List(ctx, store.ListInput{Predicate: predicate})
+	if err != nil {
+		return nil, err
+	}
+	return ret.Rows, nil
+}
+
+func (p *planner) edges(objs []interface{}, typ store.HubKey, to graph.Node, field string) []referrer {
+	ret := make([]referrer, 0, len(objs))
+	for _, obj := range objs {
+		from := graph.Node{Type: typ, ID: objectKey(obj)}
+		if p.deleted[from] {
+			continue
+		}
+		ret = append(ret, referrer{
+			edge: graph.Edge{From: from, To: to, Field: field},
+			obj:  obj,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].edge.From.ID < ret[j].edge.From.ID
+	})
+	return ret
+}
+
+// handle deletes or detaches the referrer of a deleted object.
+func (p *planner) handle(ctx context.Context, ref referrer) error {
+	from := ref.edge.From
+	// removing a consumer from a whitelist still keeps the others allowed,
+	// the referrer is only deleted with the last of them in cascade mode
+	if ref.edge.Field == graph.FieldWhitelist {
+		obj, err := p.copy(from, ref.obj)
+		if err != nil {
+			return err
+		}
+		if removeConsumer(obj.(entity.GetPlugins).GetPlugins(), ref.edge.To.ID) > 0 {
+			return nil
+		}
+		if p.mode == ModeDetach {
+			return &ReferencedError{
+				Edges:  []graph.Edge{ref.edge},
+				Reason: "consumer-restriction allows no other consumer",
+			}
+		}
+	}
+
+	if p.mode == ModeCascade {
+		p.deleted[from] = true
+		vctx := store.WithExpectedVersion(ctx, resourceVersion(ref.obj))
+		if err := p.delete(vctx, from.Type, from.ID); err != nil {
+			return err
+		}
+		_, err := p.prune(ctx, from)
+		return err
+	}
+
+	obj, err := p.copy(from, ref.obj)
+	if err != nil {
+		return err
+	}
+	return p.detach(ctx, obj, ref.edge)
+}
+
+// detach clears the reference of obj to the deleted object, the config of
+// the deleted object is inlined into obj where possible.
+func (p *planner) detach(ctx context.Context, obj interface{}, edge graph.Edge) error {
+	if edge.Field == graph.FieldProtoID {
+		// grpc-transcode can't work without a proto
+		delete(obj.(entity.GetPlugins).GetPlugins(), "grpc-transcode")
+		return nil
+	}
+
+	deleted, err := p.store(edge.To.Type).Get(ctx, edge.To.ID)
+	if err != nil {
+		return err
+	}
+	switch edge.Field {
+	case graph.FieldUpstreamID:
+		def := deleted.(*entity.Upstream).UpstreamDef
+		switch o := obj.(type) {
+		case *entity.Route:
+			o.UpstreamID = nil
+			if o.Upstream == nil {
+				o.Upstream = &def
+			}
+		case *entity.Service:
+			o.UpstreamID = nil
+			if o.Upstream == nil {
+				o.Upstream = &def
+			}
+		case *entity.StreamRoute:
+			o.UpstreamID = nil
+			if o.Upstream == nil {
+				o.Upstream = &def
+			}
+		}
+	case graph.FieldServiceID:
+		route, service := obj.(*entity.Route), deleted.(*entity.Service)
+		route.ServiceID = nil
+		if route.Upstream == nil && route.UpstreamID == nil {
+			route.Upstream, route.UpstreamID = service.Upstream, service.UpstreamID
+		}
+		if route.Host == "" && len(route.Hosts) == 0 {
+			route.Hosts = service.Hosts
+		}
+		route.Plugins = mergePlugins(service.Plugins, route.Plugins)
+		route.EnableWebsocket = route.EnableWebsocket || service.EnableWebsocket
+	case graph.FieldPluginConfigID:
+		route := obj.(*entity.Route)
+		route.PluginConfigID = nil
+		route.Plugins = mergePlugins(deleted.(*entity.PluginConfig).Plugins, route.Plugins)
+	case graph.FieldScriptID:
+		// the route runs the generated script it carries, the script is
+		// only kept for editing it
+		obj.(*entity.Route).ScriptID = nil
+	}
+	return nil
+}
+
+// copy returns the copy of obj to be updated, it's deep copied so that the
+// cached obj is kept intact.
+func (p *planner) copy(node graph.Node, obj interface{}) (interface{}, error) {
+	if ret, ok := p.updated[node]; ok {
+		return ret, nil
+	}
+
+	ret := reflect.New(reflect.TypeOf(obj).Elem()).Interface()
+	if err := utils.ObjectClone(obj, ret); err != nil {
+		return nil, err
+	}
+	if v, ok := ret.(entity.Versioned); ok {
+		v.SetResourceVersion(resourceVersion(obj))
+	}
+	p.updated[node] = ret
+	p.order = append(p.order, node)
+	return ret, nil
+}
+
+func objectKey(obj interface{}) string {
+	if c, ok := obj.(*entity.Consumer); ok {
+		return c.Username
+	}
+	return utils.InterfaceToString(obj.(entity.GetBaseInfo).GetBaseInfo().ID)
+}
+
+func resourceVersion(obj interface{}) int64 {
+	if v, ok := obj.(entity.Versioned); ok {
+		return v.GetResourceVersion()
+	}
+	return 0
+}
+
+// mergePlugins returns the plugins of base overridden by the ones of
+// plugins.
+func mergePlugins(base, plugins map[string]interface{}) map[string]interface{} {
+	if len(base) == 0 {
+		return plugins
+	}
+	ret := make(map[string]interface{}, len(base)+len(plugins))
+	for k, v := range base {
+		ret[k] = v
+	}
+	for k, v := range plugins {
+		ret[k] = v
+	}
+	return ret
+}
+
+// removeConsumer removes the consumer from the whitelist, and returns the
+// number of the consumers left.
+func removeConsumer(plugins map[string]interface{}, username string) int {
+	conf := plugins["consumer-restriction"].(map[string]interface{})
+	whitelist, _ := conf["whitelist"].([]interface{})
+	ret := make([]interface{}, 0, len(whitelist))
+	for _, v := range whitelist {
+		if utils.InterfaceToString(v) != username {
+			ret = append(ret, v)
+		}
+	}
+	conf["whitelist"] = ret
+	return len(ret)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
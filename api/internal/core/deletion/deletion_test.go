@@ -0,0 +1,261 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package deletion
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/core/store/storetest"
+)
+
+func create(t *testing.T, s store.Interface, objs ...interface{}) {
+	for _, obj := range objs {
+		_, err := s.Create(context.TODO(), obj)
+		assert.Nil(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		ret, err := s.List(context.TODO(), store.ListInput{})
+		return err == nil && ret.TotalSize == len(objs)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func keys(t *testing.T, s store.Interface) []string {
+	ret, err := s.List(context.TODO(), store.ListInput{})
+	assert.Nil(t, err)
+	keys := []string{}
+	for _, obj := range ret.Rows {
+		keys = append(keys, objectKey(obj))
+	}
+	return keys
+}
+
+func commit(t *testing.T, stores map[store.HubKey]store.Interface, mode Mode, typ store.HubKey, keys ...string) {
+	txn, err := Plan(context.TODO(), mode, stores, typ, keys)
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Commit(context.TODO()))
+}
+
+func TestParseMode(t *testing.T) {
+	mode, err := ParseMode("")
+	assert.Nil(t, err)
+	assert.Equal(t, ModeRestrict, mode)
+
+	mode, err = ParseMode("cascade")
+	assert.Nil(t, err)
+	assert.Equal(t, ModeCascade, mode)
+
+	_, err = ParseMode("force")
+	assert.Equal(t, fmt.Errorf("mode force is invalid"), err)
+}
+
+func TestPlan_Upstream(t *testing.T) {
+	stores := storetest.NewStores(t, storage.NewMemoryStorage(), "")
+	create(t, stores[store.HubKeyUpstream],
+		&entity.Upstream{BaseInfo: entity.BaseInfo{ID: "u1"}, UpstreamDef: entity.UpstreamDef{Type: "roundrobin"}},
+		&entity.Upstream{BaseInfo: entity.BaseInfo{ID: "u2"}})
+	create(t, stores[store.HubKeyService], &entity.Service{BaseInfo: entity.BaseInfo{ID: "s1"}, UpstreamID: "u1"})
+	create(t, stores[store.HubKeyRoute],
+		&entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, ServiceID: "s1", ScriptID: "r1"},
+		&entity.Route{BaseInfo: entity.BaseInfo{ID: "r2"}, UpstreamID: "u1"},
+		&entity.Route{BaseInfo: entity.BaseInfo{ID: "r3"}, UpstreamID: "u2"})
+	create(t, stores[store.HubKeyScript], &entity.Script{ID: "r1", Script: "local _M = {} return _M"})
+	create(t, stores[store.HubKeyStreamRoute], &entity.StreamRoute{BaseInfo: entity.BaseInfo{ID: "sr1"}, UpstreamID: "u1"})
+
+	// all the referrers are reported, the ones deleted together are not
+	_, err := Plan(context.TODO(), ModeRestrict, stores, store.HubKeyUpstream, []string{"u1", "u2"})
+	assert.EqualError(t, err, "upstream/u1 is referenced by route/r2 (upstream_id), service/s1 (upstream_id), "+
+		"stream_route/sr1 (upstream_id); upstream/u2 is referenced by route/r3 (upstream_id)")
+	_, err = Plan(context.TODO(), ModeRestrict, stores, store.HubKeyRoute, []string{"r1", "r2", "r3"})
+	assert.Nil(t, err)
+
+	commit(t, stores, ModeCascade, store.HubKeyUpstream, "u1")
+	assert.Eventually(t, func() bool {
+		return len(keys(t, stores[store.HubKeyRoute])) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"r3"}, keys(t, stores[store.HubKeyRoute]))
+	assert.Equal(t, []string{"u2"}, keys(t, stores[store.HubKeyUpstream]))
+	assert.Equal(t, []string{}, keys(t, stores[store.HubKeyService]))
+	assert.Equal(t, []string{}, keys(t, stores[store.HubKeyStreamRoute]))
+	assert.Equal(t, []string{}, keys(t, stores[store.HubKeyScript]))
+}
+
+func TestPlan_Detach(t *testing.T) {
+	stores := storetest.NewStores(t, storage.NewMemoryStorage(), "")
+	retries := 2
+	create(t, stores[store.HubKeyUpstream], &entity.Upstream{
+		BaseInfo:    entity.BaseInfo{ID: "u1"},
+		UpstreamDef: entity.UpstreamDef{Type: "roundrobin", Retries: &retries},
+	})
+	create(t, stores[store.HubKeyPluginConfig], &entity.PluginConfig{
+		BaseInfo: entity.BaseInfo{ID: "pc1"},
+		Plugins: map[string]interface{}{
+			"limit-count": map[string]interface{}{"count": float64(1)},
+			"cors":        map[string]interface{}{},
+		},
+	})
+	create(t, stores[store.HubKeyService], &entity.Service{
+		BaseInfo:   entity.BaseInfo{ID: "s1"},
+		UpstreamID: "u2",
+		Hosts:      []string{"foo.com"},
+		Plugins:    map[string]interface{}{"prometheus": map[string]interface{}{}},
+	})
+	create(t, stores[store.HubKeyRoute],
+		&entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, UpstreamID: "u1", PluginConfigID: "pc1",
+			Plugins: map[string]interface{}{"limit-count": map[string]interface{}{"count": float64(2)}}},
+		&entity.Route{BaseInfo: entity.BaseInfo{ID: "r2"}, ServiceID: "s1"})
+
+	route := func(id string) *entity.Route {
+		ret, err := stores[store.HubKeyRoute].Get(context.TODO(), id)
+		assert.Nil(t, err)
+		return ret.(*entity.Route)
+	}
+	// the routes are updated on condition that they are not changed since
+	// they are read, so wait for the cache before the next delete
+	commit(t, stores, ModeDetach, store.HubKeyUpstream, "u1")
+	assert.Eventually(t, func() bool {
+		return route("r1").UpstreamID == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	commit(t, stores, ModeDetach, store.HubKeyPluginConfig, "pc1")
+	assert.Eventually(t, func() bool {
+		return route("r1").PluginConfigID == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	commit(t, stores, ModeDetach, store.HubKeyService, "s1")
+	assert.Eventually(t, func() bool {
+		return route("r2").ServiceID == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	r1 := route("r1")
+	assert.Nil(t, r1.UpstreamID)
+	assert.Equal(t, "roundrobin", r1.Upstream.Type)
+	assert.Equal(t, 2, *r1.Upstream.Retries)
+	assert.Nil(t, r1.PluginConfigID)
+	// the plugins of the route override the ones of the plugin config
+	assert.Equal(t, map[string]interface{}{
+		"limit-count": map[string]interface{}{"count": float64(2)},
+		"cors":        map[string]interface{}{},
+	}, r1.Plugins)
+
+	r2 := route("r2")
+	assert.Nil(t, r2.ServiceID)
+	assert.Equal(t, "u2", r2.UpstreamID)
+	assert.Equal(t, []string{"foo.com"}, r2.Hosts)
+	assert.Equal(t, map[string]interface{}{"prometheus": map[string]interface{}{}}, r2.Plugins)
+}
+
+func TestPlan_Consumer(t *testing.T) {
+	stores := storetest.NewStores(t, storage.NewMemoryStorage(), "")
+	whitelist := func(usernames ...interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"consumer-restriction": map[string]interface{}{"whitelist": usernames},
+		}
+	}
+	create(t, stores[store.HubKeyConsumer], &entity.Consumer{Username: "jack"}, &entity.Consumer{Username: "rose"})
+	create(t, stores[store.HubKeyRoute],
+		&entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, Plugins: whitelist("jack", "rose")},
+		&entity.Route{BaseInfo: entity.BaseInfo{ID: "r2"}, Plugins: whitelist("jack")})
+
+	_, err := Plan(context.TODO(), ModeRestrict, stores, store.HubKeyConsumer, []string{"jack"})
+	assert.EqualError(t, err, "consumer/jack is referenced by route/r1 (plugins.consumer-restriction.whitelist), "+
+		"route/r2 (plugins.consumer-restriction.whitelist)")
+	// removing jack from the whitelist of r2 would allow every consumer
+	_, err = Plan(context.TODO(), ModeDetach, stores, store.HubKeyConsumer, []string{"jack"})
+	assert.EqualError(t, err, "consumer/jack is referenced by route/r2 (plugins.consumer-restriction.whitelist): "+
+		"consumer-restriction allows no other consumer")
+
+	commit(t, stores, ModeCascade, store.HubKeyConsumer, "jack")
+	assert.Eventually(t, func() bool {
+		return len(keys(t, stores[store.HubKeyRoute])) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	ret, err := stores[store.HubKeyRoute].Get(context.TODO(), "r1")
+	assert.Nil(t, err)
+	assert.Equal(t, whitelist("rose"), ret.(*entity.Route).Plugins)
+}
+
+func TestPlan_Proto(t *testing.T) {
+	stores := storetest.NewStores(t, storage.NewMemoryStorage(), "")
+	grpc := map[string]interface{}{"proto_id": "p1"}
+	create(t, stores[store.HubKeyProto], &entity.Proto{BaseInfo: entity.BaseInfo{ID: "p1"}, Content: "syntax = \"proto3\";"})
+	create(t, stores[store.HubKeyGlobalRule], &entity.GlobalPlugins{
+		BaseInfo: entity.BaseInfo{ID: "g1"},
+		Plugins:  map[string]interface{}{"grpc-transcode": grpc, "cors": map[string]interface{}{}},
+	})
+
+	_, err := Plan(context.TODO(), ModeRestrict, stores, store.HubKeyProto, []string{"p1"})
+	assert.EqualError(t, err, "proto/p1 is referenced by global_rule/g1 (plugins.grpc-transcode.proto_id)")
+
+	// grpc-transcode can't be kept without its proto
+	commit(t, stores, ModeDetach, store.HubKeyProto, "p1")
+	assert.Eventually(t, func() bool {
+		ret, err := stores[store.HubKeyGlobalRule].Get(context.TODO(), "g1")
+		return err == nil && len(ret.(*entity.GlobalPlugins).Plugins) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{}, keys(t, stores[store.HubKeyProto]))
+}
+
+func TestPlan_Parts(t *testing.T) {
+	defer func(n int) {
+		storage.MaxTxnOps = n
+	}(storage.MaxTxnOps)
+	// every write is committed on its own
+	storage.MaxTxnOps = 1
+
+	stg := storage.NewMemoryStorage()
+	stores := storetest.NewStores(t, stg, "")
+	create(t, stores[store.HubKeyUpstream], &entity.Upstream{BaseInfo: entity.BaseInfo{ID: "u1"}})
+	create(t, stores[store.HubKeyService], &entity.Service{BaseInfo: entity.BaseInfo{ID: "s1"}, UpstreamID: "u1"})
+	create(t, stores[store.HubKeyRoute],
+		&entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, ServiceID: "s1", ScriptID: "r1"},
+		&entity.Route{BaseInfo: entity.BaseInfo{ID: "r2"}, UpstreamID: "u1"})
+	create(t, stores[store.HubKeyScript], &entity.Script{ID: "r1", Script: "local _M = {} return _M"})
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	events := stg.Watch(ctx, "/apisix", 0)
+
+	txn, err := Plan(ctx, ModeCascade, stores, store.HubKeyUpstream, []string{"u1"})
+	assert.Nil(t, err)
+	assert.Len(t, txn.Split(), 5)
+	assert.Nil(t, txn.CommitParts(ctx))
+
+	// the referrers are deleted before what they refer to
+	var deleted []string
+	for len(deleted) < 5 {
+		select {
+		case resp := <-events:
+			for _, e := range resp.Events {
+				deleted = append(deleted, e.Key)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("deleted: %v", deleted)
+		}
+	}
+	assert.Equal(t, []string{
+		"/apisix/routes/r2",
+		"/apisix/routes/r1",
+		"/apisix/services/s1",
+		"/apisix/upstreams/u1",
+		"/apisix/scripts/r1",
+	}, deleted)
+}
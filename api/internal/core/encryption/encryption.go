@@ -0,0 +1,283 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/log"
+)
+
+// Encryptor encrypts the sensitive fields of the SSLs and the consumers:
+// the private keys of an SSL, and the configured fields of the consumer
+// plugins such as the key of key-auth.
+type Encryptor struct {
+	keyring *Keyring
+	// fields are the fields by the plugin names, the consumer plugins are
+	// left alone when it's nil
+	fields map[string][]string
+}
+
+func NewEncryptor(c *conf.DataEncryption) (*Encryptor, error) {
+	keyring, err := NewKeyring(c.Keyring)
+	if err != nil {
+		return nil, err
+	}
+	e := &Encryptor{keyring: keyring}
+	if c.EnableEncryptFields {
+		e.fields = c.EncryptFields
+	}
+	return e, nil
+}
+
+// isPEM tells a plaintext private key from an encrypted one the same way as
+// APISIX.
+func isPEM(value string) bool {
+	return strings.HasPrefix(value, "---")
+}
+
+// visit replaces every sensitive field of obj with the return of f, pem
+// tells whether it's a private key.
+func (e *Encryptor) visit(obj interface{}, f func(value string, pem bool) string) {
+	switch o := obj.(type) {
+	case *entity.SSL:
+		if o.Key != "" {
+			o.Key = f(o.Key, true)
+		}
+		for i := range o.Keys {
+			if o.Keys[i] != "" {
+				o.Keys[i] = f(o.Keys[i], true)
+			}
+		}
+	case *entity.Consumer:
+		for name, fields := range e.fields {
+			plugin, ok := o.Plugins[name].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for _, field := range fields {
+				if value, ok := plugin[field].(string); ok && value != "" {
+					plugin[field] = f(value, false)
+				}
+			}
+		}
+	}
+}
+
+// Encrypt encrypts the sensitive fields of obj in place, the private keys
+// which are encrypted already are left alone.
+func (e *Encryptor) Encrypt(obj interface{}) {
+	e.visit(obj, func(value string, pem bool) string {
+		if pem && !isPEM(value) {
+			return value
+		}
+		return e.keyring.Encrypt(value)
+	})
+}
+
+// candidates returns the plaintexts of the value by the keys of the
+// keyring. Only the plaintexts in PEM are taken for a private key, so that
+// a wrong key passing the check of the padding is still told apart.
+func (e *Encryptor) candidates(value string, pem bool) []Candidate {
+	candidates := e.keyring.Candidates(value)
+	if !pem {
+		return candidates
+	}
+	var ret []Candidate
+	for _, c := range candidates {
+		if isPEM(c.Plain) {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// Decrypt decrypts the sensitive fields of obj in place, the fields no key
+// decrypts are left alone since they're stored before the encryption is
+// enabled, or with a key removed from the keyring.
+func (e *Encryptor) Decrypt(obj interface{}) {
+	e.visit(obj, func(value string, pem bool) string {
+		if pem && isPEM(value) {
+			return value
+		}
+		candidates := e.candidates(value, pem)
+		if len(candidates) == 0 {
+			if pem {
+				log.Warnf("private key can't be decrypted by the keyring")
+			}
+			return value
+		}
+		return candidates[0].Plain
+	})
+}
+
+// Reencrypt encrypts the sensitive fields of obj in place with the primary
+// key, which are encrypted with another key or not encrypted. It returns
+// whether any field is changed, and whether any field is decrypted by more
+// than one key. Such a field is left alone, as rewriting it with the
+// plaintext of a wrong key would lose it.
+func (e *Encryptor) Reencrypt(obj interface{}) (bool, bool) {
+	changed, ambiguous := false, false
+	e.visit(obj, func(value string, pem bool) string {
+		if !pem || !isPEM(value) {
+			candidates := e.candidates(value, pem)
+			switch {
+			case len(candidates) > 1:
+				ambiguous = true
+				return value
+			case len(candidates) == 1 && candidates[0].ID == e.keyring.Primary():
+				return value
+			case len(candidates) == 1:
+				value = candidates[0].Plain
+			case pem:
+				// encrypted with a key out of the keyring
+				return value
+			}
+		}
+		changed = true
+		return e.keyring.Encrypt(value)
+	})
+	return changed, ambiguous
+}
+
+var encryptor *Encryptor
+
+// Init enables the encryption when the keyring of conf.DataEncryptionConfig
+// isn't empty.
+func Init() error {
+	encryptor = nil
+	if len(conf.DataEncryptionConfig.Keyring) == 0 {
+		return nil
+	}
+	e, err := NewEncryptor(conf.DataEncryptionConfig)
+	if err != nil {
+		return err
+	}
+	encryptor = e
+	return nil
+}
+
+func Enabled() bool {
+	return encryptor != nil
+}
+
+func sensitive(obj interface{}) bool {
+	switch obj.(type) {
+	case *entity.SSL, *entity.Consumer:
+		return true
+	}
+	return false
+}
+
+// Marshal encodes obj as JSON with its sensitive fields encrypted, obj
+// itself is left alone.
+func Marshal(obj interface{}) ([]byte, error) {
+	bs, err := json.Marshal(obj)
+	if err != nil || encryptor == nil || !sensitive(obj) {
+		return bs, err
+	}
+
+	cp := reflect.New(reflect.TypeOf(obj).Elem()).Interface()
+	if err := json.Unmarshal(bs, cp); err != nil {
+		return nil, err
+	}
+	encryptor.Encrypt(cp)
+	return json.Marshal(cp)
+}
+
+// Decrypt decrypts the sensitive fields of obj in place, obj is left alone
+// when the encryption is disabled.
+func Decrypt(obj interface{}) {
+	if encryptor != nil {
+		encryptor.Decrypt(obj)
+	}
+}
+
+// Report is the result of Reencrypt.
+type Report struct {
+	// Primary is the ID of the key the fields are encrypted with
+	Primary string `json:"primary"`
+	// Reencrypted are the storage keys of the objects written
+	Reencrypted []string `json:"reencrypted"`
+	// Ambiguous are the storage keys of the objects with fields decrypted
+	// by more than one key, which are left alone and have to be fixed by
+	// hand, such as by setting them again
+	Ambiguous []string `json:"ambiguous"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// Reencrypt encrypts the sensitive fields of the SSLs and the consumers
+// under the prefix of the storage with the primary key, so the other keys
+// can be removed from the keyrings of both the manager and APISIX after it.
+// The fields not encrypted yet are encrypted as well. An object is only
+// written if it's unchanged since it's read, and nothing is written in a
+// dry run. The revisions and the trash are left alone.
+func Reencrypt(ctx context.Context, stg storage.Interface, prefix string, dryRun bool) (*Report, error) {
+	if encryptor == nil {
+		return nil, fmt.Errorf("data encryption is disabled")
+	}
+
+	report := &Report{Primary: encryptor.keyring.Primary(), Reencrypted: []string{}, Ambiguous: []string{}}
+	resources := []struct {
+		path string
+		typ  reflect.Type
+	}{
+		{prefix + "/ssls/", reflect.TypeOf(entity.SSL{})},
+		{prefix + "/consumers/", reflect.TypeOf(entity.Consumer{})},
+	}
+	for _, r := range resources {
+		kvs, _, err := stg.List(ctx, r.path)
+		if err != nil {
+			return report, err
+		}
+		for _, kv := range kvs {
+			obj := reflect.New(r.typ).Interface()
+			if err := json.Unmarshal([]byte(kv.Value), obj); err != nil {
+				return report, fmt.Errorf("%s: %s", kv.Key, err)
+			}
+			changed, ambiguous := encryptor.Reencrypt(obj)
+			if ambiguous {
+				log.Warnf("%s has fields decrypted by more than one key, which are left alone", kv.Key)
+				report.Ambiguous = append(report.Ambiguous, kv.Key)
+			}
+			if !changed {
+				if !ambiguous {
+					report.Unchanged++
+				}
+				continue
+			}
+			if !dryRun {
+				bs, err := json.Marshal(obj)
+				if err != nil {
+					return report, fmt.Errorf("%s: %s", kv.Key, err)
+				}
+				if _, err := stg.Update(ctx, kv.Key, string(bs), kv.Revision); err != nil {
+					return report, fmt.Errorf("%s: %w", kv.Key, err)
+				}
+			}
+			report.Reencrypted = append(report.Reencrypted, kv.Key)
+		}
+	}
+	return report, nil
+}
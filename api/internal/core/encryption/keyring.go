@@ -0,0 +1,131 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/apisix/manager-api/internal/conf"
+)
+
+// ErrUndecryptable is returned when no key of the keyring decrypts a value,
+// which is usually a value stored before the encryption is enabled.
+var ErrUndecryptable = errors.New("value can't be decrypted by the keyring")
+
+type key struct {
+	id    string
+	block cipher.Block
+	iv    []byte
+}
+
+// Keyring encrypts and decrypts the values the way data_encryption of
+// APISIX does: AES-128-CBC with the key as the IV, and the ciphertext in
+// base64. It's not AES-GCM, because AES-128-CBC is the only format the
+// keyring of APISIX decrypts. The ciphertext doesn't name its key, so it's
+// decrypted by trying the keys in order, the same as APISIX does, while
+// the first key encrypts.
+type Keyring struct {
+	keys []key
+}
+
+func NewKeyring(keys []conf.EncryptionKey) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("keyring is empty")
+	}
+	k := &Keyring{}
+	for _, c := range keys {
+		block, err := aes.NewCipher([]byte(c.Key))
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %s", c.ID, err)
+		}
+		k.keys = append(k.keys, key{id: c.ID, block: block, iv: []byte(c.Key)})
+	}
+	return k, nil
+}
+
+// Primary returns the ID of the key encrypting the values.
+func (k *Keyring) Primary() string {
+	return k.keys[0].id
+}
+
+// Encrypt encrypts the value with the primary key.
+func (k *Keyring) Encrypt(plain string) string {
+	primary := k.keys[0]
+	padding := aes.BlockSize - len(plain)%aes.BlockSize
+	src := append([]byte(plain), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	dst := make([]byte, len(src))
+	cipher.NewCBCEncrypter(primary.block, primary.iv).CryptBlocks(dst, src)
+	return base64.StdEncoding.EncodeToString(dst)
+}
+
+// Decrypt decrypts the value with the first key giving a valid padding,
+// the same as APISIX does, and returns the ID of the key. A wrong key gives
+// a valid padding now and then, see Candidates.
+func (k *Keyring) Decrypt(text string) (string, string, error) {
+	candidates := k.Candidates(text)
+	if len(candidates) == 0 {
+		return "", "", ErrUndecryptable
+	}
+	return candidates[0].Plain, candidates[0].ID, nil
+}
+
+// Candidate is the plaintext of a value decrypted by a key.
+type Candidate struct {
+	Plain string
+	ID    string
+}
+
+// Candidates decrypts the value with every key, and returns the plaintexts
+// with a valid padding in the order of the keys. The padding is the only
+// check of a key, which a wrong key passes for about one value in 256 and
+// turns it into garbage, so more than one candidate means the key of the
+// value can't be told.
+func (k *Keyring) Candidates(text string) []Candidate {
+	src, err := base64.StdEncoding.DecodeString(text)
+	if err != nil || len(src) == 0 || len(src)%aes.BlockSize != 0 {
+		return nil
+	}
+	var ret []Candidate
+	for _, c := range k.keys {
+		dst := make([]byte, len(src))
+		cipher.NewCBCDecrypter(c.block, c.iv).CryptBlocks(dst, src)
+		if plain, ok := unpad(dst); ok {
+			ret = append(ret, Candidate{Plain: string(plain), ID: c.id})
+		}
+	}
+	return ret
+}
+
+// unpad strips the PKCS#7 padding, it fails when the padding is invalid
+// which is how a wrong key is told apart.
+func unpad(b []byte) ([]byte, bool) {
+	padding := int(b[len(b)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, false
+	}
+	for _, c := range b[len(b)-padding:] {
+		if int(c) != padding {
+			return nil, false
+		}
+	}
+	return b[:len(b)-padding], true
+}
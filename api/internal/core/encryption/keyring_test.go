@@ -0,0 +1,81 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package encryption
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/conf"
+)
+
+func TestKeyring(t *testing.T) {
+	_, err := NewKeyring(nil)
+	assert.EqualError(t, err, "keyring is empty")
+
+	keyring, err := NewKeyring([]conf.EncryptionKey{
+		{ID: "new", Key: "qeddd145sfvddff3"},
+		{ID: "old", Key: "edd1c9f0985e76a2"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "new", keyring.Primary())
+
+	// the same as APISIX encrypts with the same keyring
+	assert.Equal(t, "4y+JvURBE6ZwRbbgaryrhg==", keyring.Encrypt("auth-one"))
+
+	plain, id, err := keyring.Decrypt("4y+JvURBE6ZwRbbgaryrhg==")
+	assert.Nil(t, err)
+	assert.Equal(t, "auth-one", plain)
+	assert.Equal(t, "new", id)
+
+	plain, id, err = keyring.Decrypt("RjeV6km+KxQJN2rfu8Y7aA==")
+	assert.Nil(t, err)
+	assert.Equal(t, "auth-one", plain)
+	assert.Equal(t, "old", id)
+
+	// a wrong key gives a valid padding now and then, the first key is
+	// taken as APISIX does
+	candidates := keyring.Candidates("Q1z/IhuPfaofFxUWKTzgOg==")
+	assert.Len(t, candidates, 2)
+	assert.Equal(t, "new", candidates[0].ID)
+	assert.Equal(t, Candidate{Plain: "auth-121", ID: "old"}, candidates[1])
+	plain, id, err = keyring.Decrypt("Q1z/IhuPfaofFxUWKTzgOg==")
+	assert.Nil(t, err)
+	assert.Equal(t, candidates[0].Plain, plain)
+	assert.Equal(t, "new", id)
+
+	// a block of padding
+	text := keyring.Encrypt("0123456789abcdef")
+	plain, _, err = keyring.Decrypt(text)
+	assert.Nil(t, err)
+	assert.Equal(t, "0123456789abcdef", plain)
+
+	for _, text := range []string{"auth-one", "", "YXV0aC1vbmU=", "4y+JvURBE6ZwRbbgaryrhg"} {
+		_, _, err = keyring.Decrypt(text)
+		assert.Equal(t, ErrUndecryptable, err, text)
+		assert.Empty(t, keyring.Candidates(text), text)
+	}
+
+	other, err := NewKeyring([]conf.EncryptionKey{{ID: "other", Key: "0123456789abcdef"}})
+	assert.Nil(t, err)
+	_, _, err = other.Decrypt("4y+JvURBE6ZwRbbgaryrhg==")
+	assert.Equal(t, ErrUndecryptable, err)
+
+	_, err = NewKeyring([]conf.EncryptionKey{{ID: "short", Key: "abc"}})
+	assert.EqualError(t, err, "key short: crypto/aes: invalid key size 3")
+}
@@ -27,6 +27,9 @@ type BaseInfo struct {
 	ID         interface{} `json:"id"`
 	CreateTime int64       `json:"create_time,omitempty"`
 	UpdateTime int64       `json:"update_time,omitempty"`
+	// ResourceVersion is the etcd ModRevision of the stored object,
+	// it is filled when reading and never persisted.
+	ResourceVersion int64 `json:"resource_version,omitempty"`
 }
 
 func (info *BaseInfo) GetBaseInfo() *BaseInfo {
@@ -212,6 +215,8 @@ type Consumer struct {
 	Labels     map[string]string      `json:"labels,omitempty"`
 	CreateTime int64                  `json:"create_time,omitempty"`
 	UpdateTime int64                  `json:"update_time,omitempty"`
+
+	ResourceVersion int64 `json:"resource_version,omitempty"`
 }
 
 type SSLClient struct {
@@ -312,4 +317,6 @@ type SystemConfig struct {
 	Payload    map[string]interface{} `json:"payload,omitempty"`
 	CreateTime int64                  `json:"create_time,omitempty"`
 	UpdateTime int64                  `json:"update_time,omitempty"`
+
+	ResourceVersion int64 `json:"resource_version,omitempty"`
 }
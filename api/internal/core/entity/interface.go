@@ -24,6 +24,68 @@ type GetPlugins interface {
 	GetPlugins() map[string]interface{}
 }
 
+// Versioned is implemented by entities carrying the storage revision
+// they were read at, used for optimistic concurrency control.
+type Versioned interface {
+	GetResourceVersion() int64
+	SetResourceVersion(version int64)
+}
+
+// Timestamped is implemented by entities carrying their create and update
+// time, which the lists are ordered by.
+type Timestamped interface {
+	GetCreateTime() int64
+	GetUpdateTime() int64
+}
+
+func (info *BaseInfo) GetResourceVersion() int64 {
+	return info.ResourceVersion
+}
+
+func (info *BaseInfo) SetResourceVersion(version int64) {
+	info.ResourceVersion = version
+}
+
+func (c *Consumer) GetResourceVersion() int64 {
+	return c.ResourceVersion
+}
+
+func (c *Consumer) SetResourceVersion(version int64) {
+	c.ResourceVersion = version
+}
+
+func (s *SystemConfig) GetResourceVersion() int64 {
+	return s.ResourceVersion
+}
+
+func (s *SystemConfig) SetResourceVersion(version int64) {
+	s.ResourceVersion = version
+}
+
+func (info *BaseInfo) GetCreateTime() int64 {
+	return info.CreateTime
+}
+
+func (info *BaseInfo) GetUpdateTime() int64 {
+	return info.UpdateTime
+}
+
+func (c *Consumer) GetCreateTime() int64 {
+	return c.CreateTime
+}
+
+func (c *Consumer) GetUpdateTime() int64 {
+	return c.UpdateTime
+}
+
+func (s *SystemConfig) GetCreateTime() int64 {
+	return s.CreateTime
+}
+
+func (s *SystemConfig) GetUpdateTime() int64 {
+	return s.UpdateTime
+}
+
 func (r *Route) GetPlugins() map[string]interface{} {
 	return r.Plugins
 }
@@ -0,0 +1,342 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package gitops
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/core/apply"
+	"github.com/apisix/manager-api/internal/core/batch"
+	"github.com/apisix/manager-api/internal/core/graph"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/log"
+	"github.com/apisix/manager-api/internal/utils"
+	"github.com/apisix/manager-api/internal/utils/runtime"
+)
+
+// User is who the writes of the reconciler are recorded by in the history
+// and the audit.
+const User = "gitops"
+
+// Options are the settings of a reconciler.
+type Options struct {
+	Interval time.Duration
+	// Selector limits the objects reconciled, the others in the stores are
+	// left alone
+	Selector string
+	// Prune deletes the objects in the scope of Selector which are not in
+	// the files
+	Prune bool
+	// DriftPolicy is conf.GitOpsDriftRevert or conf.GitOpsDriftAlert
+	DriftPolicy string
+}
+
+// FileError is why a resource file can't be synced.
+type FileError struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// Status is the result of the latest sync.
+type Status struct {
+	Enabled     bool   `json:"enabled"`
+	Path        string `json:"path,omitempty"`
+	DriftPolicy string `json:"drift_policy,omitempty"`
+	// Commit is the commit of the files last synced
+	Commit        string `json:"commit"`
+	LastSyncTime  int64  `json:"last_sync_time"`
+	LastCheckTime int64  `json:"last_check_time"`
+	// Applied are the changes written by the last sync, including a sync
+	// failing after it has written a part of them
+	Applied []*apply.Change `json:"applied"`
+	// Pending are the changes left by a sync failing after it has written
+	// a part of them, the next sync carries on with them
+	Pending []*apply.Change `json:"pending"`
+	// Drift are the changes made to the stores rather than to the files
+	// since the last sync, they are reverted by the revert policy and only
+	// reported by the alert policy
+	Drift     []*apply.Change `json:"drift"`
+	Errors    []*FileError    `json:"errors"`
+	LastError string          `json:"last_error,omitempty"`
+}
+
+// Reconciler syncs the resource files of a source into the stores.
+type Reconciler struct {
+	source  Source
+	planner *batch.Planner
+	opt     Options
+
+	lock   sync.RWMutex
+	status Status
+	// digest is of the files last synced, the changes found while the files
+	// stay the same are drift
+	digest string
+}
+
+func NewReconciler(source Source, planner *batch.Planner, opt Options) *Reconciler {
+	return &Reconciler{
+		source:  source,
+		planner: planner,
+		opt:     opt,
+		status: Status{
+			Enabled:     true,
+			DriftPolicy: opt.DriftPolicy,
+			Applied:     []*apply.Change{},
+			Pending:     []*apply.Change{},
+			Drift:       []*apply.Change{},
+			Errors:      []*FileError{},
+		},
+	}
+}
+
+// Status returns a copy of the status.
+func (r *Reconciler) Status() Status {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.status
+}
+
+// Run syncs at every interval until the context is done.
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.opt.Interval)
+	defer ticker.Stop()
+	for {
+		r.Sync(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync reconciles the files into the stores once. Nothing is written when
+// any file is invalid. The changes are written in parts within a
+// transaction each, the objects referred to first, so that a commit too
+// large for a transaction is synced as well; when a part fails, those
+// before it are kept and recorded in the status.
+func (r *Reconciler) Sync(ctx context.Context) {
+	now := time.Now().Unix()
+	snapshot, err := r.source.Read()
+	if err != nil {
+		r.fail(now, err, nil)
+		return
+	}
+	// a wrong path or dir would prune everything otherwise
+	if len(snapshot.Files) == 0 {
+		r.fail(now, errors.New("no resource file is found"), nil)
+		return
+	}
+
+	bundle, origins, fileErrs := r.load(ctx, snapshot)
+	if len(fileErrs) > 0 {
+		r.fail(now, fmt.Errorf("%d resource files are invalid", len(fileErrs)), fileErrs)
+		return
+	}
+	plan, err := apply.Diff(ctx, r.planner, bundle, apply.Options{
+		Selector: r.opt.Selector,
+		Prune:    r.opt.Prune,
+	})
+	if err != nil {
+		r.fail(now, err, nil)
+		return
+	}
+
+	d := digest(snapshot.Files)
+	r.lock.RLock()
+	synced := r.digest == d
+	r.lock.RUnlock()
+
+	drift := []*apply.Change{}
+	if synced && len(plan.Changes) > 0 {
+		drift = plan.Changes
+		log.Warnf("gitops: %d changes drift from commit %s: %s",
+			len(drift), snapshot.Commit, changesString(drift))
+		if r.opt.DriftPolicy != conf.GitOpsDriftRevert {
+			r.lock.Lock()
+			r.status.LastCheckTime = now
+			r.status.Drift = drift
+			r.status.Errors = []*FileError{}
+			r.status.LastError = ""
+			r.lock.Unlock()
+			return
+		}
+	}
+
+	applied, err := plan.ApplyParts(store.WithUser(ctx, User), r.planner)
+	if applied > 0 && err != nil {
+		log.Warnf("gitops: %d of %d changes of commit %s synced: %s",
+			applied, len(plan.Changes), snapshot.Commit, changesString(plan.Changes[:applied]))
+		r.lock.Lock()
+		r.status.LastSyncTime = now
+		r.status.Applied = plan.Changes[:applied]
+		r.status.Pending = plan.Changes[applied:]
+		r.lock.Unlock()
+	}
+	if err != nil {
+		var changeErr *apply.ChangeError
+		if errors.As(err, &changeErr) {
+			if file, ok := origins[graph.Node{Type: changeErr.Change.Type, ID: changeErr.Change.ID}]; ok {
+				fileErrs = []*FileError{{File: file, Error: err.Error()}}
+			}
+		}
+		r.fail(now, err, fileErrs)
+		return
+	}
+	if len(plan.Changes) > 0 {
+		log.Infof("gitops: commit %s synced: %s", snapshot.Commit, changesString(plan.Changes))
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.digest = d
+	r.status.Commit = snapshot.Commit
+	r.status.LastSyncTime = now
+	r.status.LastCheckTime = now
+	r.status.Applied = plan.Changes
+	r.status.Pending = []*apply.Change{}
+	r.status.Drift = drift
+	r.status.Errors = []*FileError{}
+	r.status.LastError = ""
+}
+
+// load merges the files into a bundle, and returns the file of every
+// object. The files are checked one by one, so that an error is reported
+// along with the file it's in.
+func (r *Reconciler) load(ctx context.Context, snapshot *Snapshot) (apply.Bundle, map[graph.Node]string, []*FileError) {
+	names := make([]string, 0, len(snapshot.Files))
+	for name := range snapshot.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bundle := apply.Bundle{}
+	origins := map[graph.Node]string{}
+	var errs []*FileError
+	for _, name := range names {
+		if err := r.loadFile(ctx, bundle, origins, name, snapshot.Files[name]); err != nil {
+			errs = append(errs, &FileError{File: name, Error: err.Error()})
+		}
+	}
+	return bundle, origins, errs
+}
+
+func (r *Reconciler) loadFile(ctx context.Context, bundle apply.Bundle, origins map[graph.Node]string,
+	name string, content []byte) error {
+	js, err := yaml.YAMLToJSON(content)
+	if err != nil {
+		return err
+	}
+	file := apply.Bundle{}
+	if err := json.Unmarshal(js, &file); err != nil {
+		return err
+	}
+	if _, err := apply.Diff(ctx, r.planner, file, apply.Options{Selector: r.opt.Selector}); err != nil {
+		return err
+	}
+
+	for typ, raws := range file {
+		for _, raw := range raws {
+			obj, err := r.planner.Decode(ctx, typ, "", raw)
+			if err != nil {
+				return err
+			}
+			node := graph.Node{Type: typ, ID: batch.ObjectKey(obj)}
+			if other, ok := origins[node]; ok {
+				return fmt.Errorf("%s is also defined in %s", node, other)
+			}
+			origins[node] = name
+			bundle[typ] = append(bundle[typ], raw)
+		}
+	}
+	return nil
+}
+
+func (r *Reconciler) fail(now int64, err error, fileErrs []*FileError) {
+	log.Errorf("gitops: sync failed: %s", err)
+	if fileErrs == nil {
+		fileErrs = []*FileError{}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.status.LastCheckTime = now
+	r.status.Errors = fileErrs
+	r.status.LastError = err.Error()
+}
+
+func changesString(changes []*apply.Change) string {
+	ret := ""
+	for i, c := range changes {
+		if i > 0 {
+			ret += ", "
+		}
+		ret += fmt.Sprintf("%s %s/%s", c.Action, c.Type, c.ID)
+	}
+	return ret
+}
+
+var reconciler *Reconciler
+
+// Init starts the reconciler configured by conf.GitOpsConfig, it's
+// disabled when no path is configured.
+func Init(planner *batch.Planner) error {
+	c := conf.GitOpsConfig
+	if c.Path == "" {
+		return nil
+	}
+	source, err := NewSource(c.Path, c.Branch, c.Dir)
+	if err != nil {
+		return err
+	}
+	r := NewReconciler(source, planner, Options{
+		Interval:    time.Duration(c.Interval) * time.Second,
+		Selector:    c.Selector,
+		Prune:       c.Prune,
+		DriftPolicy: c.DriftPolicy,
+	})
+	r.status.Path = c.Path
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		defer runtime.HandlePanic()
+		r.Run(ctx)
+	}()
+	utils.AppendToClosers(func() error {
+		cancel()
+		return nil
+	})
+	reconciler = r
+	return nil
+}
+
+// GetStatus returns the status of the reconciler started by Init.
+func GetStatus() Status {
+	if reconciler == nil {
+		return Status{Applied: []*apply.Change{}, Pending: []*apply.Change{}, Drift: []*apply.Change{},
+			Errors: []*FileError{}}
+	}
+	return reconciler.Status()
+}
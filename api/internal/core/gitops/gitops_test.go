@@ -0,0 +1,222 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package gitops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/core/apply"
+	"github.com/apisix/manager-api/internal/core/batch"
+	"github.com/apisix/manager-api/internal/core/batch/batchtest"
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/core/store/storetest"
+)
+
+// syncUntil syncs until the stores have caught up with the writes and the
+// status is as expected.
+func syncUntil(t *testing.T, r *Reconciler, f func(s Status) bool) {
+	assert.Eventually(t, func() bool {
+		r.Sync(context.TODO())
+		return f(r.Status())
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func upstreamNodes(t *testing.T, p *batch.Planner, id string) interface{} {
+	obj, err := p.Store(store.HubKeyUpstream).Get(context.TODO(), id)
+	if err != nil {
+		return nil
+	}
+	return obj.(*entity.Upstream).Nodes
+}
+
+const routesFile = `
+route:
+- id: r1
+  uri: /a
+  upstream_id: u1
+  labels:
+    team: core
+`
+
+const upstreamsFile = `
+upstream:
+- id: u1
+  type: roundrobin
+  nodes:
+    127.0.0.1:80: 1
+  labels:
+    team: core
+`
+
+func TestReconciler_Sync(t *testing.T) {
+	_, work := newBareRepo(t)
+	writeFile(t, filepath.Join(work, "routes.yaml"), routesFile)
+	writeFile(t, filepath.Join(work, "upstreams.yaml"), upstreamsFile)
+	commit := commitAndPush(t, work)
+
+	p := batchtest.NewPlanner(t)
+	source, err := NewSource(work, "", "")
+	assert.Nil(t, err)
+	r := NewReconciler(source, p, Options{Selector: "team=core", Prune: true, DriftPolicy: conf.GitOpsDriftAlert})
+
+	r.Sync(context.TODO())
+	status := r.Status()
+	assert.Equal(t, commit, status.Commit)
+	assert.Equal(t, "", status.LastError)
+	assert.Equal(t, []*apply.Change{
+		{Action: "create", Type: store.HubKeyUpstream, ID: "u1"},
+		{Action: "create", Type: store.HubKeyRoute, ID: "r1"},
+	}, status.Applied)
+	syncUntil(t, r, func(s Status) bool {
+		return len(s.Applied) == 0 && len(s.Drift) == 0
+	})
+
+	// drift is only reported by the alert policy
+	u, err := p.Store(store.HubKeyUpstream).Get(context.TODO(), "u1")
+	assert.Nil(t, err)
+	drifted := *u.(*entity.Upstream)
+	drifted.Nodes = map[string]interface{}{"127.0.0.1:81": float64(1)}
+	_, err = p.Store(store.HubKeyUpstream).Update(context.TODO(), &drifted, false)
+	assert.Nil(t, err)
+	syncUntil(t, r, func(s Status) bool {
+		return len(s.Drift) == 1
+	})
+	assert.Equal(t, []*apply.Change{{Action: "update", Type: store.HubKeyUpstream, ID: "u1"}}, r.Status().Drift)
+	assert.Equal(t, drifted.Nodes, upstreamNodes(t, p, "u1"))
+
+	// and reverted by the revert policy
+	r.opt.DriftPolicy = conf.GitOpsDriftRevert
+	r.Sync(context.TODO())
+	assert.Equal(t, []*apply.Change{{Action: "update", Type: store.HubKeyUpstream, ID: "u1"}}, r.Status().Applied)
+	assert.Eventually(t, func() bool {
+		nodes, _ := upstreamNodes(t, p, "u1").(map[string]interface{})
+		_, ok := nodes["127.0.0.1:80"]
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// an invalid file fails the whole sync, and is reported
+	writeFile(t, filepath.Join(work, "routes.yaml"), "route:\n- id: r1\n  uri: /a\n  upstream_id: u2\n")
+	writeFile(t, filepath.Join(work, "broken.yaml"), "route: [")
+	r.Sync(context.TODO())
+	status = r.Status()
+	assert.Equal(t, "2 resource files are invalid", status.LastError)
+	assert.Len(t, status.Errors, 2)
+	assert.Equal(t, "broken.yaml", status.Errors[0].File)
+	assert.Equal(t, "routes.yaml", status.Errors[1].File)
+	assert.Contains(t, status.Errors[1].Error, "doesn't match the selector")
+
+	// the reference is checked across the files
+	writeFile(t, filepath.Join(work, "broken.yaml"), "")
+	writeFile(t, filepath.Join(work, "routes.yaml"), routesFile+"- id: r2\n  uri: /b\n  upstream_id: u2\n  labels:\n    team: core\n")
+	r.Sync(context.TODO())
+	status = r.Status()
+	assert.Equal(t, []*FileError{{File: "routes.yaml", Error: status.LastError}}, status.Errors)
+	assert.Contains(t, status.LastError, "upstream id: u2 not found")
+
+	// an object defined twice
+	writeFile(t, filepath.Join(work, "broken.yaml"), upstreamsFile)
+	r.Sync(context.TODO())
+	status = r.Status()
+	assert.Equal(t, []*FileError{{File: "upstreams.yaml", Error: "upstream/u1 is also defined in broken.yaml"}},
+		status.Errors)
+
+	// the objects deleted from the files are pruned
+	writeFile(t, filepath.Join(work, "broken.yaml"), "")
+	writeFile(t, filepath.Join(work, "routes.yaml"), "route: []\n")
+	commit = commitAndPush(t, work)
+	r.Sync(context.TODO())
+	status = r.Status()
+	assert.Equal(t, "", status.LastError)
+	assert.Equal(t, commit, status.Commit)
+	assert.Equal(t, []*apply.Change{{Action: "delete", Type: store.HubKeyRoute, ID: "r1"}}, status.Applied)
+	assert.Equal(t, []*FileError{}, status.Errors)
+}
+
+func TestReconciler_Sync_NoFile(t *testing.T) {
+	p := batchtest.NewPlanner(t)
+	source, err := NewSource(t.TempDir(), "", "")
+	assert.Nil(t, err)
+	r := NewReconciler(source, p, Options{Prune: true})
+	r.Sync(context.TODO())
+	assert.Equal(t, "no resource file is found", r.Status().LastError)
+}
+
+// failStorage fails the transactions after the first n.
+type failStorage struct {
+	storage.Interface
+	n int
+}
+
+func (s *failStorage) Txn(ctx context.Context, ops []storage.Op) (int64, error) {
+	if s.n == 0 {
+		return 0, errors.New("txn failed")
+	}
+	s.n--
+	return s.Interface.Txn(ctx, ops)
+}
+
+func TestReconciler_Sync_Parts(t *testing.T) {
+	_, work := newBareRepo(t)
+	upstreams, routes := "upstream:\n", "route:\n"
+	for i := 0; i < 70; i++ {
+		upstreams += fmt.Sprintf("- id: u%d\n  type: roundrobin\n  nodes:\n    127.0.0.1:80: 1\n", i)
+		routes += fmt.Sprintf("- id: r%d\n  uri: /r%d\n  upstream_id: u%d\n", i, i, i)
+	}
+	writeFile(t, filepath.Join(work, "upstreams.yaml"), upstreams)
+	writeFile(t, filepath.Join(work, "routes.yaml"), routes)
+	commit := commitAndPush(t, work)
+
+	stg := &failStorage{Interface: storage.NewMemoryStorage(), n: 1}
+	p := &batch.Planner{Stores: storetest.NewStores(t, stg, "")}
+	source, err := NewSource(work, "", "")
+	assert.Nil(t, err)
+	r := NewReconciler(source, p, Options{})
+
+	// the upstreams are written in the first part, before the routes
+	// referring to them, and the progress is recorded when a part fails
+	r.Sync(context.TODO())
+	status := r.Status()
+	assert.Equal(t, "txn failed", status.LastError)
+	assert.Equal(t, "", status.Commit)
+	assert.Len(t, status.Applied, storage.MaxTxnOps)
+	assert.Len(t, status.Pending, 140-storage.MaxTxnOps)
+	for _, change := range status.Applied[:70] {
+		assert.Equal(t, store.HubKeyUpstream, change.Type)
+	}
+	for _, change := range status.Pending {
+		assert.Equal(t, store.HubKeyRoute, change.Type)
+	}
+
+	// and the next sync carries on with the rest
+	stg.n = -1
+	syncUntil(t, r, func(s Status) bool {
+		return s.Commit == commit && len(s.Applied) == 0 && len(s.Pending) == 0
+	})
+	ret, err := p.Store(store.HubKeyRoute).List(context.TODO(), store.ListInput{})
+	assert.Nil(t, err)
+	assert.Equal(t, 70, ret.TotalSize)
+}
@@ -0,0 +1,203 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package gitops
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Snapshot is the resource files at a commit.
+type Snapshot struct {
+	// Commit is the commit hash, it's empty when the files are not in a git
+	// repository
+	Commit string
+	// Files are the contents by the paths relative to the directory
+	Files map[string][]byte
+}
+
+// Source reads the resource files.
+type Source interface {
+	Read() (*Snapshot, error)
+}
+
+// NewSource returns the source of the path by its kind: a bare git
+// repository is read from the branch by the git command, a directory is
+// read as it is, along with the commit of the git working tree it's in.
+func NewSource(root, branch, dir string) (Source, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	if isBareRepo(root) {
+		if branch == "" {
+			branch = "HEAD"
+		}
+		return &gitSource{repo: root, branch: branch, dir: dir}, nil
+	}
+	return &dirSource{root: root, dir: dir}, nil
+}
+
+func isBareRepo(root string) bool {
+	if _, err := os.Stat(filepath.Join(root, "HEAD")); err != nil {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(root, "objects"))
+	return err == nil && info.IsDir()
+}
+
+// isResourceFile reports whether the file holds resources by its extension.
+func isResourceFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+type dirSource struct {
+	root string
+	dir  string
+}
+
+func (s *dirSource) Read() (*Snapshot, error) {
+	base := filepath.Join(s.root, s.dir)
+	files := map[string][]byte{}
+	err := filepath.Walk(base, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isResourceFile(info.Name()) {
+			return nil
+		}
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = content
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the files may be changed in the working tree without a commit, so
+	// the commit is only reported rather than telling the changes
+	commit, _ := git(s.root, "rev-parse", "HEAD")
+	return &Snapshot{Commit: commit, Files: files}, nil
+}
+
+type gitSource struct {
+	repo   string
+	branch string
+	dir    string
+}
+
+func (s *gitSource) Read() (*Snapshot, error) {
+	commit, err := git(s.repo, "rev-parse", "--verify", s.branch+"^{commit}")
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"ls-tree", "-r", "-z", "--name-only", commit}
+	if s.dir != "" {
+		args = append(args, "--", strings.TrimSuffix(s.dir, "/")+"/")
+	}
+	out, err := git(s.repo, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{}
+	for _, name := range strings.Split(out, "\x00") {
+		if name == "" || !isResourceFile(name) {
+			continue
+		}
+		content, err := gitOutput(s.repo, "cat-file", "blob", commit+":"+name)
+		if err != nil {
+			return nil, err
+		}
+		rel := name
+		if s.dir != "" {
+			rel = strings.TrimPrefix(name, strings.TrimSuffix(s.dir, "/")+"/")
+		}
+		files[rel] = content
+	}
+	return &Snapshot{Commit: commit, Files: files}, nil
+}
+
+// git runs the git command in the repository, and returns the output
+// without the trailing newline.
+func git(repo string, args ...string) (string, error) {
+	out, err := gitOutput(repo, args...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// gitOutput runs the git command in the repository, and returns the output
+// as it is, such as the content of a file.
+func gitOutput(repo string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s failed: %s: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func digest(files map[string][]byte) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write(files[name])
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
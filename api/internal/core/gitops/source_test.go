@@ -0,0 +1,124 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package gitops
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, name, content string) {
+	assert.Nil(t, os.MkdirAll(filepath.Dir(name), 0755))
+	assert.Nil(t, ioutil.WriteFile(name, []byte(content), 0644))
+}
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	out, err := git(dir, args...)
+	assert.Nil(t, err)
+	return out
+}
+
+// newBareRepo returns a bare repository and the working tree pushing to it.
+func newBareRepo(t *testing.T) (string, string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	repo, work := filepath.Join(root, "repo.git"), filepath.Join(root, "work")
+	runGit(t, root, "init", "-q", "--bare", repo)
+	runGit(t, root, "clone", "-q", repo, work)
+	return repo, work
+}
+
+func commitAndPush(t *testing.T, work string) string {
+	runGit(t, work, "add", "-A")
+	runGit(t, work, "commit", "-q", "-m", "update")
+	runGit(t, work, "push", "-q", "origin", "HEAD:refs/heads/main")
+	return runGit(t, work, "rev-parse", "HEAD")
+}
+
+func TestGitSource(t *testing.T) {
+	repo, work := newBareRepo(t)
+	writeFile(t, filepath.Join(work, "apisix", "routes.yaml"), "route: []\n")
+	writeFile(t, filepath.Join(work, "apisix", "upstreams", "u.json"), `{"upstream": []}`)
+	writeFile(t, filepath.Join(work, "apisix", "README.md"), "not a resource file")
+	writeFile(t, filepath.Join(work, "other.yaml"), "route: []\n")
+	commit := commitAndPush(t, work)
+
+	source, err := NewSource(repo, "main", "apisix")
+	assert.Nil(t, err)
+	assert.IsType(t, &gitSource{}, source)
+	snapshot, err := source.Read()
+	assert.Nil(t, err)
+	assert.Equal(t, &Snapshot{
+		Commit: commit,
+		Files: map[string][]byte{
+			"routes.yaml":      []byte("route: []\n"),
+			"upstreams/u.json": []byte(`{"upstream": []}`),
+		},
+	}, snapshot)
+
+	// the files not pushed yet are not read
+	writeFile(t, filepath.Join(work, "apisix", "ssl.yaml"), "ssl: []\n")
+	snapshot, err = source.Read()
+	assert.Nil(t, err)
+	assert.Equal(t, commit, snapshot.Commit)
+	assert.Len(t, snapshot.Files, 2)
+
+	commit = commitAndPush(t, work)
+	snapshot, err = source.Read()
+	assert.Nil(t, err)
+	assert.Equal(t, commit, snapshot.Commit)
+	assert.Len(t, snapshot.Files, 3)
+
+	// a missing branch
+	source, err = NewSource(repo, "dev", "")
+	assert.Nil(t, err)
+	_, err = source.Read()
+	assert.Contains(t, err.Error(), "git rev-parse failed")
+}
+
+func TestDirSource(t *testing.T) {
+	_, work := newBareRepo(t)
+	writeFile(t, filepath.Join(work, "routes.yml"), "route: []\n")
+	commit := commitAndPush(t, work)
+
+	source, err := NewSource(work, "", "")
+	assert.Nil(t, err)
+	assert.IsType(t, &dirSource{}, source)
+
+	// the changes in the working tree are read before they are committed
+	writeFile(t, filepath.Join(work, "conf", "ssl.yaml"), "ssl: []\n")
+	snapshot, err := source.Read()
+	assert.Nil(t, err)
+	assert.Equal(t, &Snapshot{
+		Commit: commit,
+		Files: map[string][]byte{
+			"routes.yml":    []byte("route: []\n"),
+			"conf/ssl.yaml": []byte("ssl: []\n"),
+		},
+	}, snapshot)
+
+	_, err = NewSource(filepath.Join(work, "routes.yml"), "", "")
+	assert.Contains(t, err.Error(), "is not a directory")
+}
@@ -0,0 +1,296 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package graph
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/utils"
+	"github.com/apisix/manager-api/internal/utils/consts"
+)
+
+// Types are the resources in the graph.
+var Types = []store.HubKey{
+	store.HubKeyRoute,
+	store.HubKeyService,
+	store.HubKeyUpstream,
+	store.HubKeyPluginConfig,
+	store.HubKeyScript,
+	store.HubKeyStreamRoute,
+	store.HubKeyProto,
+	store.HubKeySsl,
+	store.HubKeyConsumer,
+	store.HubKeyGlobalRule,
+}
+
+const (
+	FieldServiceID      = "service_id"
+	FieldUpstreamID     = "upstream_id"
+	FieldPluginConfigID = "plugin_config_id"
+	FieldScriptID       = "script_id"
+	FieldProtoID        = "plugins.grpc-transcode.proto_id"
+	// FieldWhitelist is the reference of consumer-restriction to the
+	// consumers it allows
+	FieldWhitelist = "plugins.consumer-restriction.whitelist"
+	// FieldSNI is the reference of the hosts to the SSL serving them
+	FieldSNI = "sni"
+)
+
+type Node struct {
+	Type store.HubKey `json:"type"`
+	ID   string       `json:"id"`
+}
+
+func (n Node) String() string {
+	return string(n.Type) + "/" + n.ID
+}
+
+// Edge is a reference of From to To by the field of From.
+type Edge struct {
+	From  Node   `json:"from"`
+	To    Node   `json:"to"`
+	Field string `json:"field"`
+}
+
+// Source is where the objects of a type are read from, such as a store.
+type Source interface {
+	Range(ctx context.Context, f func(key string, obj interface{}) bool)
+}
+
+// Graph is the references between all the objects.
+type Graph struct {
+	nodes map[Node]struct{}
+	edges []Edge
+}
+
+// Dependencies are the references of a node to others, and from others.
+type Dependencies struct {
+	Node
+	Outbound []Edge `json:"outbound"`
+	Inbound  []Edge `json:"inbound"`
+}
+
+// BuildFromStores builds the graph from the objects of all the stores.
+func BuildFromStores(ctx context.Context) *Graph {
+	sources := map[store.HubKey]Source{}
+	for _, typ := range Types {
+		sources[typ] = store.GetStore(typ)
+	}
+	return Build(ctx, sources)
+}
+
+// Build builds the graph from the objects of the sources. A reference to a
+// missing object is kept, so that the dangling references can be found.
+func Build(ctx context.Context, sources map[store.HubKey]Source) *Graph {
+	g := &Graph{nodes: map[Node]struct{}{}}
+
+	var ssls []sslNode
+	if s, ok := sources[store.HubKeySsl]; ok {
+		s.Range(ctx, func(key string, obj interface{}) bool {
+			ssl := obj.(*entity.SSL)
+			snis := ssl.Snis
+			if ssl.Sni != "" {
+				snis = append([]string{ssl.Sni}, snis...)
+			}
+			ssls = append(ssls, sslNode{id: key, snis: snis})
+			return true
+		})
+	}
+	sort.Slice(ssls, func(i, j int) bool {
+		return ssls[i].id < ssls[j].id
+	})
+
+	for _, typ := range Types {
+		s, ok := sources[typ]
+		if !ok {
+			continue
+		}
+		s.Range(ctx, func(key string, obj interface{}) bool {
+			from := Node{Type: typ, ID: key}
+			g.nodes[from] = struct{}{}
+			for _, ref := range references(obj) {
+				g.edges = append(g.edges, Edge{From: from, To: ref.to, Field: ref.field})
+			}
+			for _, host := range hosts(obj) {
+				for _, ssl := range ssls {
+					if ssl.matches(host) {
+						g.edges = append(g.edges, Edge{
+							From:  from,
+							To:    Node{Type: store.HubKeySsl, ID: ssl.id},
+							Field: FieldSNI,
+						})
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	g.edges = dedupe(g.edges)
+	return g
+}
+
+// Dependencies returns the edges from and to the node.
+func (g *Graph) Dependencies(node Node) (*Dependencies, error) {
+	if _, ok := g.nodes[node]; !ok {
+		return nil, fmt.Errorf(consts.IDNotFound, node.Type, node.ID)
+	}
+
+	ret := &Dependencies{Node: node, Outbound: []Edge{}, Inbound: []Edge{}}
+	for _, e := range g.edges {
+		if e.From == node {
+			ret.Outbound = append(ret.Outbound, e)
+		}
+		if e.To == node {
+			ret.Inbound = append(ret.Inbound, e)
+		}
+	}
+	return ret, nil
+}
+
+// Has reports whether the object of the node exists.
+func (g *Graph) Has(node Node) bool {
+	_, ok := g.nodes[node]
+	return ok
+}
+
+// Edges returns all the edges, ordered by From, To and Field.
+func (g *Graph) Edges() []Edge {
+	return g.edges
+}
+
+type reference struct {
+	to    Node
+	field string
+}
+
+// references returns the objects referred to by the ids in the object.
+func references(obj interface{}) []reference {
+	var ret []reference
+	add := func(typ store.HubKey, id interface{}, field string) {
+		if id == nil {
+			return
+		}
+		if s := utils.InterfaceToString(id); s != "" {
+			ret = append(ret, reference{to: Node{Type: typ, ID: s}, field: field})
+		}
+	}
+
+	switch o := obj.(type) {
+	case *entity.Route:
+		add(store.HubKeyService, o.ServiceID, FieldServiceID)
+		add(store.HubKeyUpstream, o.UpstreamID, FieldUpstreamID)
+		add(store.HubKeyPluginConfig, o.PluginConfigID, FieldPluginConfigID)
+		add(store.HubKeyScript, o.ScriptID, FieldScriptID)
+	case *entity.Service:
+		add(store.HubKeyUpstream, o.UpstreamID, FieldUpstreamID)
+	case *entity.StreamRoute:
+		add(store.HubKeyUpstream, o.UpstreamID, FieldUpstreamID)
+	}
+
+	if p, ok := obj.(entity.GetPlugins); ok {
+		if conf, ok := p.GetPlugins()["grpc-transcode"].(map[string]interface{}); ok {
+			add(store.HubKeyProto, conf["proto_id"], FieldProtoID)
+		}
+		for _, username := range AllowedConsumers(p.GetPlugins()) {
+			add(store.HubKeyConsumer, username, FieldWhitelist)
+		}
+	}
+	return ret
+}
+
+// AllowedConsumers returns the consumers in the whitelist of the
+// consumer-restriction plugin, when it restricts by consumer name.
+func AllowedConsumers(plugins map[string]interface{}) []string {
+	conf, ok := plugins["consumer-restriction"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if typ, _ := conf["type"].(string); typ != "" && typ != "consumer_name" {
+		return nil
+	}
+	whitelist, _ := conf["whitelist"].([]interface{})
+	ret := make([]string, 0, len(whitelist))
+	for _, v := range whitelist {
+		ret = append(ret, utils.InterfaceToString(v))
+	}
+	return ret
+}
+
+// hosts returns the hosts the object serves, which are matched against the
+// SNIs of the SSLs.
+func hosts(obj interface{}) []string {
+	switch o := obj.(type) {
+	case *entity.Route:
+		if o.Host != "" {
+			return append([]string{o.Host}, o.Hosts...)
+		}
+		return o.Hosts
+	case *entity.Service:
+		return o.Hosts
+	case *entity.StreamRoute:
+		if o.SNI != "" {
+			return []string{o.SNI}
+		}
+	}
+	return nil
+}
+
+type sslNode struct {
+	id   string
+	snis []string
+}
+
+// matches reports whether the SSL serves the host, a wildcard SNI such as
+// `*.example.com` matches a single level of subdomain, as APISIX does.
+func (s *sslNode) matches(host string) bool {
+	host = strings.ToLower(host)
+	for _, sni := range s.snis {
+		sni = strings.ToLower(sni)
+		if sni == host {
+			return true
+		}
+		if strings.HasPrefix(sni, "*.") && strings.HasSuffix(host, sni[1:]) &&
+			!strings.Contains(strings.TrimSuffix(host, sni[1:]), ".") {
+			return true
+		}
+	}
+	return false
+}
+
+func dedupe(edges []Edge) []Edge {
+	sort.Slice(edges, func(i, j int) bool {
+		return edgeKey(edges[i]) < edgeKey(edges[j])
+	})
+	ret := edges[:0]
+	for i := range edges {
+		if i > 0 && edges[i] == edges[i-1] {
+			continue
+		}
+		ret = append(ret, edges[i])
+	}
+	return ret
+}
+
+func edgeKey(e Edge) string {
+	return e.From.String() + "\x00" + e.To.String() + "\x00" + e.Field
+}
@@ -0,0 +1,150 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package graph
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/store"
+)
+
+type mapSource map[string]interface{}
+
+func (m mapSource) Range(_ context.Context, f func(key string, obj interface{}) bool) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !f(k, m[k]) {
+			return
+		}
+	}
+}
+
+func testGraph() *Graph {
+	grpc := map[string]interface{}{
+		"grpc-transcode": map[string]interface{}{"proto_id": "p1"},
+	}
+	return Build(context.TODO(), map[store.HubKey]Source{
+		store.HubKeyRoute: mapSource{
+			"r1": &entity.Route{
+				BaseInfo:       entity.BaseInfo{ID: "r1"},
+				ServiceID:      "s1",
+				PluginConfigID: "pc1",
+				ScriptID:       "r1",
+				Hosts:          []string{"foo.example.com", "bar.com"},
+			},
+			"r2": &entity.Route{
+				BaseInfo:   entity.BaseInfo{ID: "r2"},
+				UpstreamID: "u1",
+				Host:       "a.b.example.com",
+				Plugins: map[string]interface{}{
+					"consumer-restriction": map[string]interface{}{"whitelist": []interface{}{"jack", "rose"}},
+				},
+			},
+		},
+		store.HubKeyService: mapSource{"s1": &entity.Service{
+			BaseInfo:   entity.BaseInfo{ID: "s1"},
+			UpstreamID: "u1",
+			Plugins: map[string]interface{}{
+				"consumer-restriction": map[string]interface{}{"type": "route_id", "whitelist": []interface{}{"jack"}},
+			},
+		}},
+		store.HubKeyUpstream:     mapSource{"u1": &entity.Upstream{BaseInfo: entity.BaseInfo{ID: "u1"}}},
+		store.HubKeyPluginConfig: mapSource{"pc1": &entity.PluginConfig{BaseInfo: entity.BaseInfo{ID: "pc1"}, Plugins: grpc}},
+		store.HubKeyScript:       mapSource{"r1": &entity.Script{ID: "r1"}},
+		store.HubKeyStreamRoute:  mapSource{"sr1": &entity.StreamRoute{BaseInfo: entity.BaseInfo{ID: "sr1"}, UpstreamID: "u1", SNI: "BAR.com"}},
+		store.HubKeyProto:        mapSource{"p1": &entity.Proto{BaseInfo: entity.BaseInfo{ID: "p1"}}},
+		store.HubKeySsl: mapSource{
+			"ssl1": &entity.SSL{BaseInfo: entity.BaseInfo{ID: "ssl1"}, Snis: []string{"*.example.com"}},
+			"ssl2": &entity.SSL{BaseInfo: entity.BaseInfo{ID: "ssl2"}, Sni: "bar.com"},
+		},
+		store.HubKeyConsumer: mapSource{"jack": &entity.Consumer{Username: "jack", Plugins: grpc}},
+	})
+}
+
+func TestGraph_Dependencies(t *testing.T) {
+	g := testGraph()
+	node := func(typ store.HubKey, id string) Node {
+		return Node{Type: typ, ID: id}
+	}
+
+	deps, err := g.Dependencies(node(store.HubKeyRoute, "r1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []Edge{
+		{From: node(store.HubKeyRoute, "r1"), To: node(store.HubKeyPluginConfig, "pc1"), Field: FieldPluginConfigID},
+		{From: node(store.HubKeyRoute, "r1"), To: node(store.HubKeyScript, "r1"), Field: FieldScriptID},
+		{From: node(store.HubKeyRoute, "r1"), To: node(store.HubKeyService, "s1"), Field: FieldServiceID},
+		{From: node(store.HubKeyRoute, "r1"), To: node(store.HubKeySsl, "ssl1"), Field: FieldSNI},
+		{From: node(store.HubKeyRoute, "r1"), To: node(store.HubKeySsl, "ssl2"), Field: FieldSNI},
+	}, deps.Outbound)
+	assert.Equal(t, []Edge{}, deps.Inbound)
+
+	deps, err = g.Dependencies(node(store.HubKeyUpstream, "u1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []Edge{}, deps.Outbound)
+	assert.Equal(t, []Edge{
+		{From: node(store.HubKeyRoute, "r2"), To: node(store.HubKeyUpstream, "u1"), Field: FieldUpstreamID},
+		{From: node(store.HubKeyService, "s1"), To: node(store.HubKeyUpstream, "u1"), Field: FieldUpstreamID},
+		{From: node(store.HubKeyStreamRoute, "sr1"), To: node(store.HubKeyUpstream, "u1"), Field: FieldUpstreamID},
+	}, deps.Inbound)
+
+	// the wildcard matches a single level of subdomain only
+	deps, err = g.Dependencies(node(store.HubKeySsl, "ssl1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []Edge{
+		{From: node(store.HubKeyRoute, "r1"), To: node(store.HubKeySsl, "ssl1"), Field: FieldSNI},
+	}, deps.Inbound)
+	deps, err = g.Dependencies(node(store.HubKeySsl, "ssl2"))
+	assert.Nil(t, err)
+	assert.Len(t, deps.Inbound, 2)
+
+	deps, err = g.Dependencies(node(store.HubKeyProto, "p1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []Edge{
+		{From: node(store.HubKeyConsumer, "jack"), To: node(store.HubKeyProto, "p1"), Field: FieldProtoID},
+		{From: node(store.HubKeyPluginConfig, "pc1"), To: node(store.HubKeyProto, "p1"), Field: FieldProtoID},
+	}, deps.Inbound)
+
+	// only the whitelists of consumer names refer to consumers
+	deps, err = g.Dependencies(node(store.HubKeyConsumer, "jack"))
+	assert.Nil(t, err)
+	assert.Equal(t, []Edge{
+		{From: node(store.HubKeyRoute, "r2"), To: node(store.HubKeyConsumer, "jack"), Field: FieldWhitelist},
+	}, deps.Inbound)
+
+	_, err = g.Dependencies(node(store.HubKeyUpstream, "u2"))
+	assert.Equal(t, fmt.Errorf("upstream id: u2 not found"), err)
+}
+
+func TestRender(t *testing.T) {
+	edges := []Edge{
+		{From: Node{Type: store.HubKeyRoute, ID: "r-1"}, To: Node{Type: store.HubKeyUpstream, ID: "u1"}, Field: FieldUpstreamID},
+	}
+	assert.Equal(t, "digraph dependencies {\n"+
+		`  "route/r-1" -> "upstream/u1" [label="upstream_id"];`+"\n}\n", DOT(edges))
+	assert.Equal(t, "graph LR\n"+
+		`  route_r_1["route/r-1"] -->|upstream_id| upstream_u1["upstream/u1"]`+"\n", Mermaid(edges))
+}
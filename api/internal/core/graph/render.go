@@ -0,0 +1,59 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package graph
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatJSON    = "json"
+	FormatDOT     = "dot"
+	FormatMermaid = "mermaid"
+)
+
+var mermaidIDPattern = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// DOT renders the edges in the DOT language of Graphviz.
+func DOT(edges []Edge) string {
+	var b strings.Builder
+	b.WriteString("digraph dependencies {\n")
+	for _, e := range edges {
+		fmt.Fprintf(&b, "  %s -> %s [label=%s];\n",
+			strconv.Quote(e.From.String()), strconv.Quote(e.To.String()), strconv.Quote(e.Field))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the edges as a Mermaid flowchart.
+func Mermaid(edges []Edge) string {
+	var b strings.Builder
+	b.WriteString("graph LR\n")
+	for _, e := range edges {
+		fmt.Fprintf(&b, "  %s -->|%s| %s\n", mermaidNode(e.From), e.Field, mermaidNode(e.To))
+	}
+	return b.String()
+}
+
+func mermaidNode(n Node) string {
+	id := mermaidIDPattern.ReplaceAllString(n.String(), "_")
+	return fmt.Sprintf(`%s["%s"]`, id, strings.ReplaceAll(n.String(), `"`, "#quot;"))
+}
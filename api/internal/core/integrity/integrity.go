@@ -0,0 +1,283 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package integrity
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/graph"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/log"
+	"github.com/apisix/manager-api/internal/utils"
+	"github.com/apisix/manager-api/internal/utils/runtime"
+)
+
+// User is who the deletions of the scheduled GC are recorded by in the
+// history and the audit.
+const User = "integrity"
+
+const (
+	// KindDangling is a reference to a missing object
+	KindDangling = "dangling"
+	// KindOrphan is a script or a proto which nothing refers to
+	KindOrphan = "orphan"
+	// KindStale is the server info of a node which stopped reporting
+	KindStale = "stale"
+	// KindInvalid is an object failing the current schema
+	KindInvalid = "invalid"
+	// KindMalformed is an entry which can't be loaded, see
+	// store.QuarantineItem
+	KindMalformed = "malformed"
+)
+
+// collectable are the kinds of the issues whose objects are deleted by
+// the GC, the others need a human to fix.
+var collectable = map[string]bool{
+	KindOrphan: true,
+	KindStale:  true,
+}
+
+// orphanTypes are the resources which are garbage once nothing refers to
+// them, the others are used on their own.
+var orphanTypes = []store.HubKey{store.HubKeyScript, store.HubKeyProto}
+
+// Issue is an object found broken by the scan.
+type Issue struct {
+	Kind string     `json:"kind"`
+	Node graph.Node `json:"node"`
+	// Reference is the dangling reference of the object
+	Reference *graph.Edge `json:"reference,omitempty"`
+	Message   string      `json:"message"`
+}
+
+// Report is the result of a scan.
+type Report struct {
+	Time int64 `json:"time"`
+	// Summary is the number of the issues by their kinds
+	Summary map[string]int `json:"summary"`
+	Issues  []*Issue       `json:"issues"`
+}
+
+// Store is a store scanned by the checker, such as a store.GenericStore.
+type Store interface {
+	store.Interface
+	graph.Source
+	Validate(obj interface{}) error
+}
+
+// Checker scans the stores for the broken objects, and deletes the
+// garbage among them.
+type Checker struct {
+	Stores map[store.HubKey]Store
+	// ServerInfoTTL is how long a node can go without reporting before its
+	// server info is stale, there is no stale one when it's 0
+	ServerInfoTTL time.Duration
+}
+
+// NewChecker returns the checker of the stores of store.RangeStore.
+func NewChecker() *Checker {
+	c := &Checker{
+		Stores:        map[store.HubKey]Store{},
+		ServerInfoTTL: time.Duration(conf.IntegrityConfig.ServerInfoTTL) * time.Second,
+	}
+	store.RangeStore(func(key store.HubKey, s *store.GenericStore) bool {
+		c.Stores[key] = s
+		return true
+	})
+	return c
+}
+
+// Scan reports the dangling references, the orphans, the stale server
+// info, the objects failing the current schema and the malformed entries.
+func (c *Checker) Scan(ctx context.Context) *Report {
+	report := &Report{Time: time.Now().Unix(), Summary: map[string]int{}, Issues: []*Issue{}}
+	add := func(issue *Issue) {
+		report.Issues = append(report.Issues, issue)
+		report.Summary[issue.Kind]++
+	}
+
+	sources := map[store.HubKey]graph.Source{}
+	for _, typ := range graph.Types {
+		if s, ok := c.Stores[typ]; ok {
+			sources[typ] = s
+		}
+	}
+	g := graph.Build(ctx, sources)
+	referred := map[graph.Node]bool{}
+	for _, e := range g.Edges() {
+		e := e
+		referred[e.To] = true
+		if _, ok := sources[e.To.Type]; ok && !g.Has(e.To) {
+			add(&Issue{
+				Kind:      KindDangling,
+				Node:      e.From,
+				Reference: &e,
+				Message:   fmt.Sprintf("%s refers to %s by %s, which is not found", e.From, e.To, e.Field),
+			})
+		}
+	}
+
+	for _, typ := range orphanTypes {
+		s, ok := c.Stores[typ]
+		if !ok {
+			continue
+		}
+		s.Range(ctx, func(key string, _ interface{}) bool {
+			node := graph.Node{Type: typ, ID: key}
+			if !referred[node] {
+				add(&Issue{
+					Kind:    KindOrphan,
+					Node:    node,
+					Message: fmt.Sprintf("%s is not referred to by any object", node),
+				})
+			}
+			return true
+		})
+	}
+
+	if s, ok := c.Stores[store.HubKeyServerInfo]; ok && c.ServerInfoTTL > 0 {
+		deadline := time.Now().Add(-c.ServerInfoTTL).Unix()
+		s.Range(ctx, func(key string, obj interface{}) bool {
+			info := obj.(*entity.ServerInfo)
+			if info.LastReportTime < deadline {
+				add(&Issue{
+					Kind: KindStale,
+					Node: graph.Node{Type: store.HubKeyServerInfo, ID: key},
+					Message: fmt.Sprintf("node %s hasn't reported since %s", info.Hostname,
+						time.Unix(info.LastReportTime, 0).UTC().Format(time.RFC3339)),
+				})
+			}
+			return true
+		})
+	}
+
+	for typ, s := range c.Stores {
+		s.Range(ctx, func(key string, obj interface{}) bool {
+			if err := s.Validate(obj); err != nil {
+				add(&Issue{
+					Kind:    KindInvalid,
+					Node:    graph.Node{Type: typ, ID: key},
+					Message: err.Error(),
+				})
+			}
+			return true
+		})
+		for _, item := range s.ListQuarantine(ctx) {
+			add(&Issue{
+				Kind:    KindMalformed,
+				Node:    graph.Node{Type: typ, ID: item.Key},
+				Message: item.Error,
+			})
+		}
+	}
+
+	sort.SliceStable(report.Issues, func(i, j int) bool {
+		a, b := report.Issues[i], report.Issues[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Node.String() < b.Node.String()
+	})
+	return report
+}
+
+// Collect deletes the objects of the issues of the kinds found by a fresh
+// scan in a single transaction, all the collectable kinds when kinds is
+// empty. It returns the deleted objects.
+func (c *Checker) Collect(ctx context.Context, kinds []string) ([]graph.Node, error) {
+	wanted := map[string]bool{}
+	for _, kind := range kinds {
+		if !collectable[kind] {
+			return nil, fmt.Errorf("kind %s is not collectable", kind)
+		}
+		wanted[kind] = true
+	}
+	if len(wanted) == 0 {
+		wanted = collectable
+	}
+
+	txn := store.NewTxn()
+	deleted := []graph.Node{}
+	for _, issue := range c.Scan(ctx).Issues {
+		if !wanted[issue.Kind] {
+			continue
+		}
+		if err := txn.Delete(ctx, c.Stores[issue.Node.Type], issue.Node.ID); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, issue.Node)
+	}
+	if err := txn.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// run scans once for the scheduled mode, and collects the garbage when gc
+// is true.
+func (c *Checker) run(ctx context.Context, gc bool) {
+	report := c.Scan(ctx)
+	if len(report.Issues) > 0 {
+		log.Warnf("integrity scan found issues: %v", report.Summary)
+	}
+	if !gc {
+		return
+	}
+
+	deleted, err := c.Collect(store.WithUser(ctx, User), nil)
+	if err != nil {
+		log.Errorf("integrity gc failed: %s", err)
+		return
+	}
+	if len(deleted) > 0 {
+		log.Infof("integrity gc deleted: %v", deleted)
+	}
+}
+
+// Init starts the scheduled scan of conf.IntegrityConfig when its
+// interval isn't 0.
+func Init() {
+	c := conf.IntegrityConfig
+	if c.Interval <= 0 {
+		return
+	}
+
+	checker := NewChecker()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		defer runtime.HandlePanic()
+		ticker := time.NewTicker(time.Duration(c.Interval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checker.run(ctx, c.GC)
+			}
+		}
+	}()
+	utils.AppendToClosers(func() error {
+		cancel()
+		return nil
+	})
+}
@@ -0,0 +1,146 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package integrity
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/graph"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/utils"
+)
+
+func newTestStore(t *testing.T, stg storage.Interface, key store.HubKey, path string, typ interface{}, validator store.Validator) *store.GenericStore {
+	s, err := store.NewGenericStore(store.GenericStoreOption{
+		BasePath: "/apisix/" + path,
+		HubKey:   key,
+		ObjType:  reflect.TypeOf(typ),
+		KeyFunc: func(obj interface{}) string {
+			if o, ok := obj.(entity.GetBaseInfo); ok {
+				return utils.InterfaceToString(o.GetBaseInfo().ID)
+			}
+			return obj.(*entity.Script).ID
+		},
+		Validator: validator,
+	})
+	assert.Nil(t, err)
+	s.Stg = stg
+	assert.Nil(t, s.Init())
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	return s
+}
+
+func TestChecker(t *testing.T) {
+	ctx := context.TODO()
+	stg := storage.NewMemoryStorage()
+	now := time.Now().Unix()
+	for key, value := range map[string]string{
+		"/apisix/upstreams/u1": `{"id":"u1","nodes":{"127.0.0.1:80":1},"type":"roundrobin"}`,
+		"/apisix/upstreams/u2": `{"id":"u2","nodes":{"127.0.0.1:80":1},"type":"roundrobin","retries":-1}`,
+		"/apisix/routes/r1": `{"id":"r1","uri":"/r1","upstream_id":"u1","script_id":"r1",` +
+			`"plugins":{"grpc-transcode":{"proto_id":"p1"}}}`,
+		"/apisix/routes/r2":                 `{"id":"r2","uri":"/r2","upstream_id":"u404"}`,
+		"/apisix/routes/r3":                 `{"id":"r3","uri":`,
+		"/apisix/scripts/r1":                `{"id":"r1","script":"local _M = {} return _M"}`,
+		"/apisix/scripts/s2":                `{"id":"s2","script":"local _M = {} return _M"}`,
+		"/apisix/protos/p1":                 `{"id":"p1","content":"syntax = \"proto3\";"}`,
+		"/apisix/protos/p2":                 `{"id":"p2","content":"syntax = \"proto3\";"}`,
+		"/apisix/data_plane/server_info/n1": fmt.Sprintf(`{"id":"n1","hostname":"n1","last_report_time":%d}`, now),
+		"/apisix/data_plane/server_info/n2": `{"id":"n2","hostname":"n2","last_report_time":1600000000}`,
+	} {
+		_, err := stg.Create(ctx, key, value)
+		assert.Nil(t, err)
+	}
+
+	upstreamValidator, err := store.NewAPISIXJsonSchemaValidator("main.upstream")
+	assert.Nil(t, err)
+	c := &Checker{
+		Stores: map[store.HubKey]Store{
+			store.HubKeyRoute:      newTestStore(t, stg, store.HubKeyRoute, "routes", entity.Route{}, nil),
+			store.HubKeyUpstream:   newTestStore(t, stg, store.HubKeyUpstream, "upstreams", entity.Upstream{}, upstreamValidator),
+			store.HubKeyScript:     newTestStore(t, stg, store.HubKeyScript, "scripts", entity.Script{}, nil),
+			store.HubKeyProto:      newTestStore(t, stg, store.HubKeyProto, "protos", entity.Proto{}, nil),
+			store.HubKeyServerInfo: newTestStore(t, stg, store.HubKeyServerInfo, "data_plane/server_info", entity.ServerInfo{}, nil),
+		},
+		ServerInfoTTL: time.Hour,
+	}
+
+	report := c.Scan(ctx)
+	assert.Equal(t, map[string]int{
+		KindDangling:  1,
+		KindInvalid:   1,
+		KindMalformed: 1,
+		KindOrphan:    2,
+		KindStale:     1,
+	}, report.Summary)
+
+	var issues []string
+	for _, issue := range report.Issues {
+		issues = append(issues, issue.Kind+" "+issue.Node.String())
+	}
+	assert.Equal(t, []string{
+		"dangling route/r2",
+		"invalid upstream/u2",
+		"malformed route/r3",
+		"orphan proto/p2",
+		"orphan script/s2",
+		"stale server_info/n2",
+	}, issues)
+	assert.Equal(t, &graph.Edge{
+		From:  graph.Node{Type: store.HubKeyRoute, ID: "r2"},
+		To:    graph.Node{Type: store.HubKeyUpstream, ID: "u404"},
+		Field: graph.FieldUpstreamID,
+	}, report.Issues[0].Reference)
+	assert.Equal(t, "route/r2 refers to upstream/u404 by upstream_id, which is not found", report.Issues[0].Message)
+	assert.Equal(t, "node n2 hasn't reported since 2020-09-13T12:26:40Z", report.Issues[5].Message)
+
+	_, err = c.Collect(ctx, []string{KindDangling})
+	assert.EqualError(t, err, "kind dangling is not collectable")
+
+	// nothing is deleted in a dry run
+	deleted, err := c.Collect(store.WithDryRun(ctx), []string{KindOrphan})
+	assert.Nil(t, err)
+	assert.Equal(t, []graph.Node{
+		{Type: store.HubKeyProto, ID: "p2"},
+		{Type: store.HubKeyScript, ID: "s2"},
+	}, deleted)
+	assert.Equal(t, 2, c.Scan(ctx).Summary[KindOrphan])
+
+	deleted, err = c.Collect(ctx, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []graph.Node{
+		{Type: store.HubKeyProto, ID: "p2"},
+		{Type: store.HubKeyScript, ID: "s2"},
+		{Type: store.HubKeyServerInfo, ID: "n2"},
+	}, deleted)
+	assert.Eventually(t, func() bool {
+		summary := c.Scan(ctx).Summary
+		return summary[KindOrphan] == 0 && summary[KindStale] == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = c.Stores[store.HubKeyProto].Get(ctx, "p1")
+	assert.Nil(t, err)
+}
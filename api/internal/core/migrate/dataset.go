@@ -18,7 +18,10 @@
 package migrate
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"reflect"
 
 	"github.com/apisix/manager-api/internal/core/entity"
 	"github.com/apisix/manager-api/internal/core/store"
@@ -33,6 +36,26 @@ type DataSet struct {
 	Scripts       []*entity.Script
 	GlobalPlugins []*entity.GlobalPlugins
 	PluginConfigs []*entity.PluginConfig
+	StreamRoutes  []*entity.StreamRoute
+	Protos        []*entity.Proto
+	SystemConfigs []*entity.SystemConfig
+	ServerInfos   []*entity.ServerInfo
+}
+
+// dataSetFields are the fields of DataSet by the types of their objects.
+var dataSetFields = map[store.HubKey]string{
+	store.HubKeyConsumer:     "Consumers",
+	store.HubKeyRoute:        "Routes",
+	store.HubKeyService:      "Services",
+	store.HubKeySsl:          "SSLs",
+	store.HubKeyUpstream:     "Upstreams",
+	store.HubKeyScript:       "Scripts",
+	store.HubKeyGlobalRule:   "GlobalPlugins",
+	store.HubKeyPluginConfig: "PluginConfigs",
+	store.HubKeyStreamRoute:  "StreamRoutes",
+	store.HubKeyProto:        "Protos",
+	store.HubKeySystemConfig: "SystemConfigs",
+	store.HubKeyServerInfo:   "ServerInfos",
 }
 
 func newDataSet() *DataSet {
@@ -45,6 +68,10 @@ func newDataSet() *DataSet {
 		Scripts:       make([]*entity.Script, 0),
 		GlobalPlugins: make([]*entity.GlobalPlugins, 0),
 		PluginConfigs: make([]*entity.PluginConfig, 0),
+		StreamRoutes:  make([]*entity.StreamRoute, 0),
+		Protos:        make([]*entity.Proto, 0),
+		SystemConfigs: make([]*entity.SystemConfig, 0),
+		ServerInfos:   make([]*entity.ServerInfo, 0),
 	}
 }
 
@@ -98,7 +125,56 @@ func (a *DataSet) rangeData(key store.HubKey, f func(int, interface{}) bool) {
 				break
 			}
 		}
+	case store.HubKeyStreamRoute:
+		for i, v := range a.StreamRoutes {
+			if !f(i, v) {
+				break
+			}
+		}
+	case store.HubKeyProto:
+		for i, v := range a.Protos {
+			if !f(i, v) {
+				break
+			}
+		}
+	case store.HubKeySystemConfig:
+		for i, v := range a.SystemConfigs {
+			if !f(i, v) {
+				break
+			}
+		}
+	case store.HubKeyServerInfo:
+		for i, v := range a.ServerInfos {
+			if !f(i, v) {
+				break
+			}
+		}
+	}
+}
+
+// NewDataSet returns an empty data set.
+func NewDataSet() *DataSet {
+	return newDataSet()
+}
+
+// Objects returns the objects of the type.
+func (a *DataSet) Objects(key store.HubKey) []interface{} {
+	ret := make([]interface{}, 0)
+	a.rangeData(key, func(_ int, obj interface{}) bool {
+		ret = append(ret, obj)
+		return true
+	})
+	return ret
+}
+
+// Decode decodes the JSON array of the objects of the type, which replace
+// the ones of the data set.
+func (a *DataSet) Decode(key store.HubKey, data []byte) error {
+	name, ok := dataSetFields[key]
+	if !ok {
+		return fmt.Errorf("type %s is invalid", key)
 	}
+	return json.Unmarshal(data, reflect.ValueOf(a).Elem().FieldByName(name).Addr().Interface())
 }
 
 func (a *DataSet) Add(obj interface{}) error {
@@ -120,6 +196,14 @@ func (a *DataSet) Add(obj interface{}) error {
 		a.GlobalPlugins = append(a.GlobalPlugins, obj)
 	case *entity.PluginConfig:
 		a.PluginConfigs = append(a.PluginConfigs, obj)
+	case *entity.StreamRoute:
+		a.StreamRoutes = append(a.StreamRoutes, obj)
+	case *entity.Proto:
+		a.Protos = append(a.Protos, obj)
+	case *entity.SystemConfig:
+		a.SystemConfigs = append(a.SystemConfigs, obj)
+	case *entity.ServerInfo:
+		a.ServerInfos = append(a.ServerInfos, obj)
 	default:
 		err = errors.New("Unknown type of obj")
 	}
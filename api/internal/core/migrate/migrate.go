@@ -19,9 +19,17 @@ package migrate
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/apisix/manager-api/internal/core/batch"
+	"github.com/apisix/manager-api/internal/core/entity"
 	"github.com/apisix/manager-api/internal/core/store"
 	"github.com/apisix/manager-api/internal/log"
 )
@@ -30,10 +38,164 @@ var (
 	ErrConflict = errors.New("conflict")
 )
 
-func Export(ctx context.Context) ([]byte, error) {
-	exportData := newDataSet()
+// FormatVersion is the version of the format of the exported data. The data
+// exported before the format is versioned is a bare DataSet.
+const FormatVersion = 2
+
+// Types are all the resources exported, in the order they are imported.
+var Types = []store.HubKey{
+	store.HubKeyUpstream,
+	store.HubKeyProto,
+	store.HubKeyScript,
+	store.HubKeyPluginConfig,
+	store.HubKeyService,
+	store.HubKeySsl,
+	store.HubKeyConsumer,
+	store.HubKeyRoute,
+	store.HubKeyStreamRoute,
+	store.HubKeyGlobalRule,
+	store.HubKeySystemConfig,
+	store.HubKeyServerInfo,
+}
+
+// Header describes the exported data.
+type Header struct {
+	FormatVersion int `json:"format_version"`
+	// APISIXVersion is the version the data was last migrated to, or the
+	// one of the data plane when it was never migrated
+	APISIXVersion string `json:"apisix_version,omitempty"`
+	ExportTime    int64  `json:"export_time"`
+	// Checksum is the hex encoded sha256 of the data
+	Checksum string         `json:"checksum"`
+	Types    []store.HubKey `json:"types,omitempty"`
+	Selector string         `json:"selector,omitempty"`
+}
+
+// File is the exported DataSet along with its header.
+type File struct {
+	Header Header          `json:"header"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// Options selects the objects exported or imported, all of them when it's
+// empty.
+type Options struct {
+	Types []store.HubKey
+	// Selector selects the objects by their labels, see store.ParseSelector
+	Selector string
+}
+
+// OptionsError is returned when the types or the selector of Options are
+// invalid.
+type OptionsError struct {
+	Err error
+}
+
+func (e *OptionsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *OptionsError) Unwrap() error {
+	return e.Err
+}
+
+// filter returns whether the objects of the type are selected, and the
+// predicate of the objects.
+func (o Options) filter() (func(key store.HubKey) bool, func(obj interface{}) bool, error) {
+	known := map[store.HubKey]bool{}
+	for _, typ := range Types {
+		known[typ] = true
+	}
+	types := map[store.HubKey]bool{}
+	for _, typ := range o.Types {
+		if !known[typ] {
+			return nil, nil, &OptionsError{Err: fmt.Errorf("type %s is invalid", typ)}
+		}
+		types[typ] = true
+	}
+	selector, err := store.ParseSelector(o.Selector)
+	if err != nil {
+		return nil, nil, &OptionsError{Err: err}
+	}
+
+	return func(key store.HubKey) bool {
+			return len(types) == 0 || types[key]
+		}, func(obj interface{}) bool {
+			return selector.Matches(batch.ObjectLabels(obj))
+		}, nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hubStores returns the stores of store.RangeStore.
+func hubStores() store.Hub {
+	hub := store.Hub{}
 	store.RangeStore(func(key store.HubKey, s *store.GenericStore) bool {
+		hub[key] = s
+		return true
+	})
+	return hub
+}
+
+// rangeStores calls f with the stores of the hub in the order of Types.
+func rangeStores(hub store.Hub, f func(key store.HubKey, s *store.GenericStore) bool) {
+	for _, key := range Types {
+		if s, ok := hub[key]; ok && !f(key, s) {
+			return
+		}
+	}
+}
+
+// APISIXVersion returns the version the data of the cluster in the context
+// was last migrated to, and the latest version of its data plane when it
+// was never migrated.
+func APISIXVersion(ctx context.Context) string {
+	return apisixVersion(ctx, hubStores())
+}
+
+func apisixVersion(ctx context.Context, hub store.Hub) string {
+	if m, err := NewMigrator(ctx); err == nil {
+		if applied, err := m.Version(ctx); err == nil && applied != nil {
+			return applied.Version
+		}
+	}
+
+	version := ""
+	if s, ok := hub[store.HubKeyServerInfo]; ok {
+		s.Range(ctx, func(_ string, obj interface{}) bool {
+			v := obj.(*entity.ServerInfo).Version
+			if _, err := parseVersion(v); err == nil && (version == "" || compareVersion(v, version) > 0) {
+				version = v
+			}
+			return true
+		})
+	}
+	return version
+}
+
+// Collect returns the selected objects of all the stores.
+func Collect(ctx context.Context, opt Options) (*DataSet, error) {
+	return collectHub(ctx, hubStores(), opt)
+}
+
+func collectHub(ctx context.Context, hub store.Hub, opt Options) (*DataSet, error) {
+	typeFilter, predicate, err := opt.filter()
+	if err != nil {
+		return nil, err
+	}
+
+	exportData := newDataSet()
+	rangeStores(hub, func(key store.HubKey, s *store.GenericStore) bool {
+		if !typeFilter(key) {
+			return true
+		}
 		s.Range(ctx, func(_ string, obj interface{}) bool {
+			if !predicate(obj) {
+				return true
+			}
 			err := exportData.Add(obj)
 			if err != nil {
 				log.Errorf("Add obj to export list failed:%s", err)
@@ -43,13 +205,37 @@ func Export(ctx context.Context) ([]byte, error) {
 		})
 		return true
 	})
+	return exportData, nil
+}
+
+// Export returns the selected objects of all the stores as a File. An
+// OptionsError is returned for the invalid options.
+func Export(ctx context.Context, opt Options) ([]byte, error) {
+	return exportHub(ctx, hubStores(), opt)
+}
+
+func exportHub(ctx context.Context, hub store.Hub, opt Options) ([]byte, error) {
+	exportData, err := collectHub(ctx, hub, opt)
+	if err != nil {
+		return nil, err
+	}
 
 	data, err := json.Marshal(exportData)
 	if err != nil {
 		return nil, err
 	}
 
-	return data, nil
+	return json.Marshal(&File{
+		Header: Header{
+			FormatVersion: FormatVersion,
+			APISIXVersion: apisixVersion(ctx, hub),
+			ExportTime:    time.Now().Unix(),
+			Checksum:      checksum(data),
+			Types:         opt.Types,
+			Selector:      opt.Selector,
+		},
+		Data: data,
+	})
 }
 
 type ConflictMode int
@@ -60,40 +246,199 @@ const (
 	ModeSkip
 )
 
-func Import(ctx context.Context, data []byte, mode ConflictMode) (*DataSet, error) {
+const (
+	OutcomeCreated     = "created"
+	OutcomeOverwritten = "overwritten"
+	// OutcomeSkipped is an object existing already with ModeSkip, or any
+	// object of an import aborted by the conflicts with ModeReturn
+	OutcomeSkipped  = "skipped"
+	OutcomeConflict = "conflict"
+	OutcomeFailed   = "failed"
+)
+
+// Outcome is the result of importing an object.
+type Outcome struct {
+	Type    store.HubKey `json:"type"`
+	Key     string       `json:"key"`
+	Outcome string       `json:"outcome"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// ImportResult is the outcomes of all the imported objects.
+type ImportResult struct {
+	// Header is nil for the data exported before the format is versioned
+	Header *Header `json:"header"`
+	// Conflicts are the imported objects existing already
+	Conflicts *DataSet `json:"conflicts"`
+	// Summary is the number of the objects by their outcomes
+	Summary  map[string]int `json:"summary"`
+	Outcomes []*Outcome     `json:"outcomes"`
+}
+
+func (r *ImportResult) add(outcome *Outcome) {
+	r.Outcomes = append(r.Outcomes, outcome)
+	r.Summary[outcome.Outcome]++
+}
+
+func (r *ImportResult) sort() {
+	sort.SliceStable(r.Outcomes, func(i, j int) bool {
+		a, b := r.Outcomes[i], r.Outcomes[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Key < b.Key
+	})
+}
+
+// parseFile returns the header and the data set of a File, or of a bare
+// DataSet exported before the format is versioned.
+func parseFile(data []byte) (*Header, *DataSet, error) {
+	file := &File{}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, nil, err
+	}
+	var header *Header
+	if file.Header.FormatVersion == 0 {
+		file.Data = data
+	} else {
+		header = &file.Header
+		if header.FormatVersion > FormatVersion {
+			return nil, nil, fmt.Errorf("format version %d is not supported", header.FormatVersion)
+		}
+		if checksum(file.Data) != header.Checksum {
+			return nil, nil, errors.New("checksum mismatch, the data may be broken")
+		}
+	}
+
 	importData := newDataSet()
-	err := json.Unmarshal(data, &importData)
+	if err := json.Unmarshal(file.Data, importData); err != nil {
+		return nil, nil, err
+	}
+	return header, importData, nil
+}
+
+// Import imports the selected objects of the data exported by Export, see
+// ImportDataSet.
+func Import(ctx context.Context, data []byte, mode ConflictMode, opt Options) (*ImportResult, error) {
+	return importHub(ctx, hubStores(), data, mode, opt)
+}
+
+func importHub(ctx context.Context, hub store.Hub, data []byte, mode ConflictMode, opt Options) (*ImportResult, error) {
+	header, importData, err := parseFile(data)
 	if err != nil {
 		return nil, err
 	}
-	conflict, conflictData := isConflicted(ctx, importData)
-	if conflict && mode == ModeReturn {
-		return conflictData, ErrConflict
+	result, err := importDataSet(ctx, hub, importData, mode, opt)
+	if result != nil {
+		result.Header = header
 	}
-	store.RangeStore(func(key store.HubKey, s *store.GenericStore) bool {
-		importData.rangeData(key, func(i int, obj interface{}) bool {
-			_, e := s.CreateCheck(obj)
-			if e != nil {
-				switch mode {
-				case ModeSkip:
-					return true
-				case ModeOverwrite:
-					_, e := s.Update(ctx, obj, true)
-					if e != nil {
-						err = e
-						return false
-					}
-				}
-			} else {
-				_, e := s.Create(ctx, obj)
-				if err != nil {
-					err = e
-					return false
-				}
+	return result, err
+}
+
+// ImportDataSet imports the selected objects, and reports the outcome of
+// each. The objects existing already are overwritten or skipped by the
+// mode, or nothing is imported and ErrConflict is returned with
+// ModeReturn. The objects failing the checks are left out, and the others
+// are committed in a single transaction. Nothing is imported and
+// storage.ErrTxnTooLarge is returned when they don't fit in it.
+func ImportDataSet(ctx context.Context, importData *DataSet, mode ConflictMode, opt Options) (*ImportResult, error) {
+	return importDataSet(ctx, hubStores(), importData, mode, opt)
+}
+
+func importDataSet(ctx context.Context, hub store.Hub, importData *DataSet, mode ConflictMode, opt Options) (*ImportResult, error) {
+	typeFilter, predicate, err := opt.filter()
+	if err != nil {
+		return nil, err
+	}
+
+	type item struct {
+		key      store.HubKey
+		s        *store.GenericStore
+		obj      interface{}
+		id       string
+		conflict bool
+	}
+	var items []*item
+	conflicts := 0
+	result := &ImportResult{Conflicts: newDataSet(), Summary: map[string]int{}, Outcomes: []*Outcome{}}
+	rangeStores(hub, func(key store.HubKey, s *store.GenericStore) bool {
+		if !typeFilter(key) {
+			return true
+		}
+		importData.rangeData(key, func(_ int, obj interface{}) bool {
+			if !predicate(obj) {
+				return true
+			}
+			it := &item{
+				key: key,
+				s:   s,
+				obj: obj,
+				id:  strings.TrimPrefix(s.GetObjStorageKey(obj), s.BasePath()+"/"),
+			}
+			// Only check key of store conflict for now, the objects
+			// failing the other checks fail on their own.
+			// TODO: Maybe check name of some entiries.
+			_, e := s.Get(ctx, it.id)
+			it.conflict = e == nil
+			if it.conflict {
+				conflicts++
+				_ = result.Conflicts.Add(obj)
 			}
+			items = append(items, it)
 			return true
 		})
 		return true
 	})
-	return nil, err
+
+	if conflicts > 0 && mode == ModeReturn {
+		for _, it := range items {
+			outcome := &Outcome{Type: it.key, Key: it.id, Outcome: OutcomeSkipped}
+			if it.conflict {
+				outcome.Outcome = OutcomeConflict
+			}
+			result.add(outcome)
+		}
+		result.sort()
+		return result, ErrConflict
+	}
+
+	// all the imported data is committed in one transaction
+	txn := store.NewTxn()
+	var pending []*Outcome
+	for _, it := range items {
+		outcome := &Outcome{Type: it.key, Key: it.id, Outcome: OutcomeCreated}
+		var e error
+		switch {
+		case !it.conflict:
+			e = txn.Create(ctx, it.s, it.obj)
+		case mode == ModeSkip:
+			outcome.Outcome = OutcomeSkipped
+			result.add(outcome)
+			continue
+		default:
+			outcome.Outcome = OutcomeOverwritten
+			e = txn.Update(ctx, it.s, it.obj, true)
+		}
+		if e != nil {
+			outcome.Outcome, outcome.Error = OutcomeFailed, e.Error()
+			result.add(outcome)
+			continue
+		}
+		pending = append(pending, outcome)
+	}
+
+	if err := txn.CheckSize(); err != nil {
+		return nil, fmt.Errorf("%d objects are too many to import at once, import them by types or selectors: %w",
+			len(pending), err)
+	}
+	if err := txn.Commit(ctx); err != nil {
+		for _, outcome := range pending {
+			outcome.Outcome, outcome.Error = OutcomeFailed, err.Error()
+		}
+	}
+	for _, outcome := range pending {
+		result.add(outcome)
+	}
+	result.sort()
+	return result, nil
 }
@@ -0,0 +1,280 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/utils"
+)
+
+func newTestHub(t *testing.T, values map[string]string) store.Hub {
+	ctx := context.TODO()
+	stg := storage.NewMemoryStorage()
+	for key, value := range values {
+		_, err := stg.Create(ctx, key, value)
+		assert.Nil(t, err)
+	}
+
+	upstreamValidator, err := store.NewAPISIXJsonSchemaValidator("main.upstream")
+	assert.Nil(t, err)
+	hub := store.Hub{}
+	for key, typ := range map[store.HubKey]interface{}{
+		store.HubKeyUpstream:     entity.Upstream{},
+		store.HubKeyRoute:        entity.Route{},
+		store.HubKeyStreamRoute:  entity.StreamRoute{},
+		store.HubKeyProto:        entity.Proto{},
+		store.HubKeySystemConfig: entity.SystemConfig{},
+		store.HubKeyServerInfo:   entity.ServerInfo{},
+	} {
+		opt := store.GenericStoreOption{
+			BasePath: "/apisix/" + string(key),
+			HubKey:   key,
+			ObjType:  reflect.TypeOf(typ),
+			KeyFunc: func(obj interface{}) string {
+				if o, ok := obj.(*entity.SystemConfig); ok {
+					return o.ConfigName
+				}
+				return utils.InterfaceToString(obj.(entity.GetBaseInfo).GetBaseInfo().ID)
+			},
+		}
+		if key == store.HubKeyUpstream {
+			opt.Validator = upstreamValidator
+		}
+		s, err := store.NewGenericStore(opt)
+		assert.Nil(t, err)
+		s.Stg = stg
+		assert.Nil(t, s.Init())
+		t.Cleanup(func() {
+			_ = s.Close()
+		})
+		hub[key] = s
+	}
+	return hub
+}
+
+func newTestSource(t *testing.T) store.Hub {
+	return newTestHub(t, map[string]string{
+		"/apisix/upstream/u1":      `{"id":"u1","nodes":{"127.0.0.1:80":1},"type":"roundrobin","labels":{"env":"prod"}}`,
+		"/apisix/upstream/u2":      `{"id":"u2","nodes":{"127.0.0.1:80":1},"type":"roundrobin","labels":{"env":"dev"}}`,
+		"/apisix/route/r1":         `{"id":"r1","uri":"/r1","upstream_id":"u1","labels":{"env":"prod"}}`,
+		"/apisix/stream_route/sr1": `{"id":"sr1","server_port":9100,"upstream_id":"u2"}`,
+		"/apisix/proto/p1":         `{"id":"p1","content":"syntax = \"proto3\";"}`,
+		"/apisix/system_config/c1": `{"config_name":"c1","payload":{"a":1}}`,
+		"/apisix/server_info/n1":   `{"id":"n1","hostname":"n1","version":"3.2.0"}`,
+		"/apisix/server_info/n2":   `{"id":"n2","hostname":"n2","version":"3.1.0"}`,
+	})
+}
+
+func TestExport(t *testing.T) {
+	ctx := context.TODO()
+	hub := newTestSource(t)
+
+	bs, err := exportHub(ctx, hub, Options{})
+	assert.Nil(t, err)
+	file := &File{}
+	assert.Nil(t, json.Unmarshal(bs, file))
+	assert.Equal(t, FormatVersion, file.Header.FormatVersion)
+	assert.Equal(t, "3.2.0", file.Header.APISIXVersion)
+	assert.Equal(t, checksum(file.Data), file.Header.Checksum)
+	assert.InDelta(t, time.Now().Unix(), file.Header.ExportTime, 5)
+	data := newDataSet()
+	assert.Nil(t, json.Unmarshal(file.Data, data))
+	assert.Len(t, data.Upstreams, 2)
+	assert.Len(t, data.Routes, 1)
+	assert.Len(t, data.StreamRoutes, 1)
+	assert.Len(t, data.Protos, 1)
+	assert.Len(t, data.SystemConfigs, 1)
+	assert.Len(t, data.ServerInfos, 2)
+
+	// selective
+	bs, err = exportHub(ctx, hub, Options{
+		Types:    []store.HubKey{store.HubKeyUpstream, store.HubKeyStreamRoute},
+		Selector: "env=prod",
+	})
+	assert.Nil(t, err)
+	file = &File{}
+	assert.Nil(t, json.Unmarshal(bs, file))
+	assert.Equal(t, []store.HubKey{store.HubKeyUpstream, store.HubKeyStreamRoute}, file.Header.Types)
+	assert.Equal(t, "env=prod", file.Header.Selector)
+	data = newDataSet()
+	assert.Nil(t, json.Unmarshal(file.Data, data))
+	assert.Len(t, data.Upstreams, 1)
+	assert.Equal(t, "u1", data.Upstreams[0].ID)
+	assert.Len(t, data.Routes, 0)
+	assert.Len(t, data.StreamRoutes, 0)
+
+	var optErr *OptionsError
+	_, err = exportHub(ctx, hub, Options{Types: []store.HubKey{"unknown"}})
+	assert.EqualError(t, err, "type unknown is invalid")
+	assert.True(t, errors.As(err, &optErr))
+	_, err = exportHub(ctx, hub, Options{Selector: "env in prod"})
+	assert.EqualError(t, err, "label selector env in prod is invalid")
+	assert.True(t, errors.As(err, &optErr))
+}
+
+func outcomes(result *ImportResult) []string {
+	var ret []string
+	for _, o := range result.Outcomes {
+		ret = append(ret, string(o.Type)+"/"+o.Key+" "+o.Outcome)
+	}
+	return ret
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.TODO()
+	bs, err := exportHub(ctx, newTestSource(t), Options{
+		Types: []store.HubKey{store.HubKeyUpstream, store.HubKeyRoute, store.HubKeyStreamRoute, store.HubKeySystemConfig},
+	})
+	assert.Nil(t, err)
+
+	target := newTestHub(t, map[string]string{
+		"/apisix/upstream/u1": `{"id":"u1","nodes":{"127.0.0.2:80":1},"type":"roundrobin"}`,
+	})
+
+	// nothing is imported on conflicts
+	result, err := importHub(ctx, target, bs, ModeReturn, Options{})
+	assert.Equal(t, ErrConflict, err)
+	assert.Equal(t, FormatVersion, result.Header.FormatVersion)
+	assert.Len(t, result.Conflicts.Upstreams, 1)
+	assert.Equal(t, map[string]int{OutcomeConflict: 1, OutcomeSkipped: 4}, result.Summary)
+	assert.Equal(t, []string{
+		"route/r1 skipped",
+		"stream_route/sr1 skipped",
+		"system_config/c1 skipped",
+		"upstream/u1 conflict",
+		"upstream/u2 skipped",
+	}, outcomes(result))
+
+	// selective
+	result, err = importHub(ctx, target, bs, ModeReturn, Options{
+		Types:    []store.HubKey{store.HubKeyUpstream, store.HubKeyRoute},
+		Selector: "env=dev",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"upstream/u2 created"}, outcomes(result))
+	assert.Eventually(t, func() bool {
+		_, err := target[store.HubKeyUpstream].Get(ctx, "u2")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	result, err = importHub(ctx, target, bs, ModeSkip, Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"route/r1 created",
+		"stream_route/sr1 created",
+		"system_config/c1 created",
+		"upstream/u1 skipped",
+		"upstream/u2 skipped",
+	}, outcomes(result))
+	assert.Eventually(t, func() bool {
+		_, err := target[store.HubKeySystemConfig].Get(ctx, "c1")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	obj, err := target[store.HubKeyUpstream].Get(ctx, "u1")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"127.0.0.2:80": float64(1)}, obj.(*entity.Upstream).Nodes)
+
+	result, err = importHub(ctx, target, bs, ModeOverwrite, Options{Types: []store.HubKey{store.HubKeyUpstream}})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{OutcomeOverwritten: 2}, result.Summary)
+	assert.Eventually(t, func() bool {
+		obj, err := target[store.HubKeyUpstream].Get(ctx, "u1")
+		return err == nil && obj.(*entity.Upstream).Labels["env"] == "prod"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err = importHub(ctx, target, []byte(strings.Replace(string(bs), "u2", "u3", 1)), ModeSkip, Options{})
+	assert.EqualError(t, err, "checksum mismatch, the data may be broken")
+	_, err = importHub(ctx, target, []byte(`{"header":{"format_version":3},"data":{}}`), ModeSkip, Options{})
+	assert.EqualError(t, err, "format version 3 is not supported")
+}
+
+func TestImport_Outcomes(t *testing.T) {
+	ctx := context.TODO()
+	target := newTestHub(t, nil)
+
+	// the data exported before the format is versioned, with an object
+	// failing the checks
+	result, err := importHub(ctx, target, []byte(`{
+		"Upstreams":[
+			{"id":"u1","nodes":{"127.0.0.1:80":1},"type":"roundrobin"},
+			{"id":"u2","nodes":{"127.0.0.1:80":1},"type":"roundrobin","retries":-1}
+		],
+		"Protos":[{"id":"p1","content":"syntax = \"proto3\";"}]
+	}`), ModeReturn, Options{})
+	assert.Nil(t, err)
+	assert.Nil(t, result.Header)
+	assert.Equal(t, []string{
+		"proto/p1 created",
+		"upstream/u1 created",
+		"upstream/u2 failed",
+	}, outcomes(result))
+	assert.Contains(t, result.Outcomes[2].Error, "retries")
+	assert.Eventually(t, func() bool {
+		_, err := target[store.HubKeyProto].Get(ctx, "p1")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = target[store.HubKeyUpstream].Get(ctx, "u2")
+	assert.NotNil(t, err)
+}
+
+func TestImport_Large(t *testing.T) {
+	ctx := context.TODO()
+	target := newTestHub(t, nil)
+
+	data := newDataSet()
+	n := storage.MaxTxnOps + 10
+	for i := 0; i < n; i++ {
+		data.Protos = append(data.Protos, &entity.Proto{
+			BaseInfo: entity.BaseInfo{ID: fmt.Sprintf("p%d", i)},
+			Content:  `syntax = "proto3";`,
+		})
+	}
+	// the import is all or nothing, so it's refused as a whole
+	_, err := importDataSet(ctx, target, data, ModeReturn, Options{})
+	assert.True(t, errors.Is(err, storage.ErrTxnTooLarge))
+	assert.Contains(t, err.Error(), "138 objects are too many to import at once")
+	ret, err := target[store.HubKeyProto].List(ctx, store.ListInput{})
+	assert.Nil(t, err)
+	assert.Zero(t, ret.TotalSize)
+
+	// a larger limit takes it at once
+	defer func(n int) {
+		storage.MaxTxnOps = n
+	}(storage.MaxTxnOps)
+	storage.MaxTxnOps = 2 * n
+	result, err := importDataSet(ctx, target, data, ModeReturn, Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{OutcomeCreated: n}, result.Summary)
+	assert.Eventually(t, func() bool {
+		_, err := target[store.HubKeyProto].Get(ctx, fmt.Sprintf("p%d", n-1))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,74 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migrate
+
+import (
+	"github.com/apisix/manager-api/internal/core/store"
+)
+
+// pluginResources are the resources which have plugins.
+var pluginResources = []store.HubKey{
+	store.HubKeyConsumer,
+	store.HubKeyGlobalRule,
+	store.HubKeyPluginConfig,
+	store.HubKeyRoute,
+	store.HubKeyService,
+	store.HubKeyStreamRoute,
+}
+
+func init() {
+	Register(&Migration{
+		From:        "2.15",
+		To:          "3.0",
+		Description: "move the disable field of the plugins to _meta.disable",
+		Resources:   pluginResources,
+		Transform:   movePluginDisable,
+	})
+}
+
+// movePluginDisable moves the disable field of every plugin, which APISIX
+// 3.0 reads from _meta instead. The one of _meta wins when both are set.
+func movePluginDisable(obj map[string]interface{}) (bool, error) {
+	plugins, ok := obj["plugins"].(map[string]interface{})
+	if !ok {
+		return false, nil
+	}
+
+	changed := false
+	for _, plugin := range plugins {
+		conf, ok := plugin.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		disable, ok := conf["disable"]
+		if !ok {
+			continue
+		}
+		meta, ok := conf["_meta"].(map[string]interface{})
+		if !ok {
+			meta = map[string]interface{}{}
+			conf["_meta"] = meta
+		}
+		if _, ok := meta["disable"]; !ok {
+			meta["disable"] = disable
+		}
+		delete(conf, "disable")
+		changed = true
+	}
+	return changed, nil
+}
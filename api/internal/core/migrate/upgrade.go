@@ -0,0 +1,429 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/log"
+)
+
+// maxBatchSize is the most objects written in a transaction by Upgrade,
+// and the batch size when the migrator's is 0. Every object takes two
+// operations, its backup and itself, and a transaction carries at most
+// storage.MaxTxnOps.
+func maxBatchSize() int {
+	return storage.MaxTxnOps / 2
+}
+
+// Migration rewrites the stored objects of the resources from what APISIX
+// From expects to what APISIX To expects, such as moving a deprecated
+// plugin field to where it's read now.
+type Migration struct {
+	From        string         `json:"from"`
+	To          string         `json:"to"`
+	Description string         `json:"description"`
+	Resources   []store.HubKey `json:"resources"`
+	// Transform rewrites obj in place, and returns whether it's changed.
+	// It must leave alone the objects migrated already, since an upgrade
+	// interrupted is run again from the start.
+	Transform func(obj map[string]interface{}) (bool, error) `json:"-"`
+}
+
+var migrations []*Migration
+
+// Register adds a migration, it panics when the versions are invalid.
+func Register(m *Migration) {
+	if _, err := parseVersion(m.From); err != nil {
+		panic(err)
+	}
+	if _, err := parseVersion(m.To); err != nil {
+		panic(err)
+	}
+	if compareVersion(m.From, m.To) >= 0 {
+		panic(fmt.Sprintf("migration from %s to %s is not an upgrade", m.From, m.To))
+	}
+
+	migrations = append(migrations, m)
+	sort.SliceStable(migrations, func(i, j int) bool {
+		if c := compareVersion(migrations[i].From, migrations[j].From); c != 0 {
+			return c < 0
+		}
+		return compareVersion(migrations[i].To, migrations[j].To) < 0
+	})
+}
+
+// Migrations returns the registered migrations ordered by their versions.
+func Migrations() []*Migration {
+	return append([]*Migration{}, migrations...)
+}
+
+// LatestVersion returns the latest version the migrations upgrade to, it's
+// empty when there is no migration.
+func LatestVersion() string {
+	latest := ""
+	for _, m := range migrations {
+		if latest == "" || compareVersion(m.To, latest) > 0 {
+			latest = m.To
+		}
+	}
+	return latest
+}
+
+// parseVersion parses an APISIX version such as 3.0 or 2.15.1.
+func parseVersion(version string) ([]int, error) {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	ret := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("version %s is invalid", version)
+		}
+		ret[i] = n
+	}
+	return ret, nil
+}
+
+// compareVersion compares two valid versions, the missing parts are 0.
+func compareVersion(a, b string) int {
+	x, _ := parseVersion(a)
+	y, _ := parseVersion(b)
+	for i := 0; i < len(x) || i < len(y); i++ {
+		var m, n int
+		if i < len(x) {
+			m = x[i]
+		}
+		if i < len(y) {
+			n = y[i]
+		}
+		if m != n {
+			if m < n {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// AppliedVersion is the record of the last upgrade under the prefix.
+type AppliedVersion struct {
+	Version    string `json:"version"`
+	UpdateTime int64  `json:"update_time"`
+	// Backup is where the objects changed by the upgrade are kept as
+	// they were before
+	Backup string `json:"backup,omitempty"`
+}
+
+// Change is an object rewritten by a migration.
+type Change struct {
+	Resource store.HubKey    `json:"resource"`
+	Key      string          `json:"key"`
+	Before   json.RawMessage `json:"before"`
+	After    json.RawMessage `json:"after"`
+}
+
+// Step is a migration run by an upgrade, and the objects it rewrites.
+type Step struct {
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	Description string    `json:"description"`
+	Changes     []*Change `json:"changes"`
+}
+
+// Report is the result of an upgrade.
+type Report struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	DryRun bool    `json:"dry_run"`
+	Steps  []*Step `json:"steps"`
+	// Changed is the number of the objects rewritten
+	Changed int    `json:"changed"`
+	Backup  string `json:"backup,omitempty"`
+}
+
+// object is a stored object loaded by an upgrade.
+type object struct {
+	resource store.HubKey
+	key      string
+	revision int64
+	raw      string
+	value    map[string]interface{}
+	changed  bool
+}
+
+// Migrator upgrades the objects stored under Prefix of Stg.
+type Migrator struct {
+	Stg    storage.Interface
+	Prefix string
+	// Paths are the storage paths of the resources, see
+	// store.GenericStore.BasePath
+	Paths map[store.HubKey]string
+	// BatchSize is the number of the objects written in a transaction, the
+	// most a transaction carries when it's 0 or larger
+	BatchSize int
+}
+
+// NewMigrator returns the migrator of the cluster in the context.
+func NewMigrator(ctx context.Context) (*Migrator, error) {
+	name := store.ClusterFromContext(ctx)
+	hub, ok := store.GetCluster(name)
+	if !ok {
+		return nil, fmt.Errorf("cluster %s is not found", name)
+	}
+
+	m := &Migrator{Prefix: conf.ETCDConfig.Prefix, Paths: map[store.HubKey]string{}}
+	for _, c := range conf.Clusters {
+		if name != "" && c.Name == name {
+			m.Prefix = c.Etcd.Prefix
+		}
+	}
+	for key, s := range hub {
+		if key == "" || s == nil {
+			continue
+		}
+		m.Stg = s.Stg
+		m.Paths[key] = s.BasePath()
+	}
+	if m.Stg == nil {
+		return nil, fmt.Errorf("cluster %s has no store", name)
+	}
+	return m, nil
+}
+
+func (m *Migrator) versionKey() string {
+	return m.Prefix + "/migrations/version"
+}
+
+func (m *Migrator) backupPath(version string) string {
+	return m.Prefix + "/migrations/backup/" + version
+}
+
+// Version returns the record of the last upgrade, it's nil when the
+// objects were never upgraded.
+func (m *Migrator) Version(ctx context.Context) (*AppliedVersion, error) {
+	ret, _, err := m.Stg.List(ctx, m.versionKey())
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range ret {
+		if kv.Key != m.versionKey() {
+			continue
+		}
+		applied := &AppliedVersion{}
+		if err := json.Unmarshal([]byte(kv.Value), applied); err != nil {
+			return nil, fmt.Errorf("json unmarshal applied version failed: %s", err)
+		}
+		return applied, nil
+	}
+	return nil, nil
+}
+
+// Pending returns the migrations an upgrade from the version to target
+// runs, all the migrations up to target when from is empty.
+func Pending(from, target string) []*Migration {
+	var ret []*Migration
+	for _, m := range migrations {
+		if from != "" && compareVersion(m.From, from) < 0 {
+			continue
+		}
+		if compareVersion(m.To, target) > 0 {
+			continue
+		}
+		ret = append(ret, m)
+	}
+	return ret
+}
+
+// load reads the objects of the resource ordered by their keys, the
+// entries which aren't JSON objects are left to the quarantine.
+func (m *Migrator) load(ctx context.Context, resource store.HubKey) ([]*object, error) {
+	path, ok := m.Paths[resource]
+	if !ok {
+		return nil, nil
+	}
+	ret, _, err := m.Stg.List(ctx, path+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	objs := make([]*object, 0, len(ret))
+	for _, kv := range ret {
+		value := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(kv.Value), &value); err != nil {
+			log.Warnf("migration skips key: %s: %s", kv.Key, err)
+			continue
+		}
+		objs = append(objs, &object{
+			resource: resource,
+			key:      kv.Key,
+			revision: kv.Revision,
+			raw:      kv.Value,
+			value:    value,
+		})
+	}
+	sort.Slice(objs, func(i, j int) bool {
+		return objs[i].key < objs[j].key
+	})
+	return objs, nil
+}
+
+// Upgrade runs the pending migrations from the applied version to target,
+// the latest version when it's empty, and reports the objects each
+// rewrites. Nothing is written in a dry run context, see
+// store.WithDryRun. Otherwise the objects are written in batches, each
+// in a transaction with the backup of the objects, and target is recorded
+// as the applied version at last. A batch fails when its objects are
+// modified during the upgrade, the upgrade can be run again then.
+func (m *Migrator) Upgrade(ctx context.Context, target string) (*Report, error) {
+	applied, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	from := ""
+	if applied != nil {
+		from = applied.Version
+	}
+	if target == "" {
+		target = LatestVersion()
+	}
+	if target == "" {
+		return nil, errors.New("target version is required")
+	}
+	if _, err := parseVersion(target); err != nil {
+		return nil, fmt.Errorf("target %s", err)
+	}
+	if from != "" && compareVersion(target, from) < 0 {
+		return nil, fmt.Errorf("target version %s is invalid: it's older than the applied version %s", target, from)
+	}
+
+	report := &Report{From: from, To: target, DryRun: store.IsDryRun(ctx), Steps: []*Step{}}
+	loaded := map[store.HubKey][]*object{}
+	var changed []*object
+	for _, mg := range Pending(from, target) {
+		step := &Step{From: mg.From, To: mg.To, Description: mg.Description, Changes: []*Change{}}
+		for _, resource := range mg.Resources {
+			objs, ok := loaded[resource]
+			if !ok {
+				if objs, err = m.load(ctx, resource); err != nil {
+					return nil, err
+				}
+				loaded[resource] = objs
+			}
+			for _, obj := range objs {
+				before, err := json.Marshal(obj.value)
+				if err != nil {
+					return nil, err
+				}
+				ok, err := mg.Transform(obj.value)
+				if err != nil {
+					return nil, fmt.Errorf("migration from %s to %s of key: %s failed: %s", mg.From, mg.To, obj.key, err)
+				}
+				if !ok {
+					continue
+				}
+				after, err := json.Marshal(obj.value)
+				if err != nil {
+					return nil, err
+				}
+				step.Changes = append(step.Changes, &Change{
+					Resource: resource,
+					Key:      strings.TrimPrefix(obj.key, m.Paths[resource]+"/"),
+					Before:   before,
+					After:    after,
+				})
+				if !obj.changed {
+					obj.changed = true
+					changed = append(changed, obj)
+				}
+			}
+		}
+		report.Steps = append(report.Steps, step)
+	}
+	report.Changed = len(changed)
+	if report.DryRun || target == from {
+		return report, nil
+	}
+
+	if len(changed) > 0 {
+		report.Backup = m.backupPath(target)
+	}
+	if err := m.write(ctx, changed, report.Backup); err != nil {
+		return nil, err
+	}
+
+	bs, err := json.Marshal(AppliedVersion{
+		Version:    target,
+		UpdateTime: time.Now().Unix(),
+		Backup:     report.Backup,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.Stg.Update(ctx, m.versionKey(), string(bs), 0); err != nil {
+		return nil, err
+	}
+	log.Infof("migrated %s from version %q to %s, %d objects changed", m.Prefix, from, target, len(changed))
+	return report, nil
+}
+
+// write writes the changed objects in batches, along with their original
+// values under the backup path.
+func (m *Migrator) write(ctx context.Context, changed []*object, backup string) error {
+	size := m.BatchSize
+	if max := maxBatchSize(); size <= 0 || size > max {
+		size = max
+	}
+	for i := 0; i < len(changed); i += size {
+		end := i + size
+		if end > len(changed) {
+			end = len(changed)
+		}
+		ops := make([]storage.Op, 0, 2*(end-i))
+		for _, obj := range changed[i:end] {
+			bs, err := json.Marshal(obj.value)
+			if err != nil {
+				return err
+			}
+			ops = append(ops, storage.Op{
+				Type:  storage.OpUpdate,
+				Key:   backup + "/" + strings.TrimPrefix(obj.key, m.Prefix+"/"),
+				Value: obj.raw,
+			}, storage.Op{
+				Type:     storage.OpUpdate,
+				Key:      obj.key,
+				Value:    string(bs),
+				Revision: obj.revision,
+			})
+		}
+		if _, err := m.Stg.Txn(ctx, ops); err != nil {
+			return fmt.Errorf("migration batch %d failed: %w", i/size+1, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/core/store"
+)
+
+func TestCompareVersion(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"3.0", "3.0.0", 0},
+		{"2.15", "3.0", -1},
+		{"2.15.1", "2.15", 1},
+		{"2.9", "2.10", -1},
+		{"v3.1", "3.0.9", 1},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, compareVersion(tc.a, tc.b), tc.a+" vs "+tc.b)
+	}
+
+	_, err := parseVersion("3.x")
+	assert.EqualError(t, err, "version 3.x is invalid")
+}
+
+func TestPending(t *testing.T) {
+	ms := Pending("", "3.0")
+	assert.Len(t, ms, 1)
+	assert.Equal(t, "2.15", ms[0].From)
+
+	assert.Len(t, Pending("2.15", "3.0"), 1)
+	assert.Len(t, Pending("3.0", "3.0"), 0)
+	assert.Len(t, Pending("", "2.15"), 0)
+}
+
+func TestMovePluginDisable(t *testing.T) {
+	obj := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal([]byte(`{"plugins":{
+		"limit-count":{"count":1,"disable":true},
+		"cors":{"disable":false,"_meta":{"disable":true,"priority":1}},
+		"echo":{"body":"ok"}}}`), &obj))
+
+	changed, err := movePluginDisable(obj)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	bs, _ := json.Marshal(obj)
+	assert.JSONEq(t, `{"plugins":{
+		"limit-count":{"count":1,"_meta":{"disable":true}},
+		"cors":{"_meta":{"disable":true,"priority":1}},
+		"echo":{"body":"ok"}}}`, string(bs))
+
+	changed, err = movePluginDisable(obj)
+	assert.Nil(t, err)
+	assert.False(t, changed)
+}
+
+func newTestMigrator(t *testing.T) (*Migrator, *storage.MemoryStorage) {
+	ctx := context.TODO()
+	stg := storage.NewMemoryStorage()
+	for key, value := range map[string]string{
+		"/apisix/routes/r1":      `{"id":"r1","uri":"/r1","plugins":{"limit-count":{"count":1,"disable":true}}}`,
+		"/apisix/routes/r2":      `{"id":"r2","uri":"/r2"}`,
+		"/apisix/routes/r3":      `{"id":"r3","uri":`,
+		"/apisix/services/s1":    `{"id":"s1","plugins":{"cors":{"disable":false}}}`,
+		"/apisix/consumers/jack": `{"username":"jack","plugins":{"key-auth":{"key":"k","disable":true}}}`,
+	} {
+		_, err := stg.Create(ctx, key, value)
+		assert.Nil(t, err)
+	}
+	return &Migrator{
+		Stg:    stg,
+		Prefix: "/apisix",
+		Paths: map[store.HubKey]string{
+			store.HubKeyRoute:    "/apisix/routes",
+			store.HubKeyService:  "/apisix/services",
+			store.HubKeyConsumer: "/apisix/consumers",
+		},
+		BatchSize: 2,
+	}, stg
+}
+
+func TestMigrator_Upgrade(t *testing.T) {
+	ctx := context.TODO()
+	m, stg := newTestMigrator(t)
+
+	applied, err := m.Version(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, applied)
+
+	// dry run
+	report, err := m.Upgrade(store.WithDryRun(ctx), "")
+	assert.Nil(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, "", report.From)
+	assert.Equal(t, "3.0", report.To)
+	assert.Equal(t, 3, report.Changed)
+	assert.Len(t, report.Steps, 1)
+	changes := report.Steps[0].Changes
+	assert.Len(t, changes, 3)
+	assert.Equal(t, store.HubKeyConsumer, changes[0].Resource)
+	assert.Equal(t, "jack", changes[0].Key)
+	assert.Equal(t, "r1", changes[1].Key)
+	assert.JSONEq(t, `{"id":"r1","uri":"/r1","plugins":{"limit-count":{"count":1,"disable":true}}}`, string(changes[1].Before))
+	assert.JSONEq(t, `{"id":"r1","uri":"/r1","plugins":{"limit-count":{"count":1,"_meta":{"disable":true}}}}`, string(changes[1].After))
+	assert.Equal(t, "", report.Backup)
+	val, err := stg.Get(ctx, "/apisix/routes/r1")
+	assert.Nil(t, err)
+	assert.Contains(t, val, `"disable":true}`)
+	applied, err = m.Version(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, applied)
+
+	_, err = m.Upgrade(ctx, "2.x")
+	assert.EqualError(t, err, "target version 2.x is invalid")
+
+	report, err = m.Upgrade(ctx, "3.0")
+	assert.Nil(t, err)
+	assert.False(t, report.DryRun)
+	assert.Equal(t, 3, report.Changed)
+	assert.Equal(t, "/apisix/migrations/backup/3.0", report.Backup)
+	val, err = stg.Get(ctx, "/apisix/services/s1")
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"id":"s1","plugins":{"cors":{"_meta":{"disable":false}}}}`, val)
+	val, err = stg.Get(ctx, "/apisix/migrations/backup/3.0/services/s1")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"id":"s1","plugins":{"cors":{"disable":false}}}`, val)
+	val, err = stg.Get(ctx, "/apisix/routes/r3")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"id":"r3","uri":`, val)
+	_, err = stg.Get(ctx, "/apisix/migrations/backup/3.0/routes/r2")
+	assert.NotNil(t, err)
+
+	applied, err = m.Version(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "3.0", applied.Version)
+	assert.Equal(t, "/apisix/migrations/backup/3.0", applied.Backup)
+
+	// nothing is pending
+	report, err = m.Upgrade(ctx, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "3.0", report.From)
+	assert.Len(t, report.Steps, 0)
+	assert.Equal(t, 0, report.Changed)
+
+	_, err = m.Upgrade(ctx, "2.15")
+	assert.EqualError(t, err, "target version 2.15 is invalid: it's older than the applied version 3.0")
+}
+
+func TestMigrator_UpgradeConflict(t *testing.T) {
+	ctx := context.TODO()
+	m, stg := newTestMigrator(t)
+	m.BatchSize = 10
+
+	// the objects are modified between the listing and the write
+	conflict := &conflictStorage{Interface: stg, key: "/apisix/services/s1"}
+	m.Stg = conflict
+	_, err := m.Upgrade(ctx, "")
+	assert.True(t, errors.Is(err, storage.ErrRevisionMismatch))
+
+	val, err := stg.Get(ctx, "/apisix/routes/r1")
+	assert.Nil(t, err)
+	assert.Contains(t, val, `"disable":true}`)
+	applied, err := m.Version(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, applied)
+}
+
+func TestMigrator_UpgradeBatchSize(t *testing.T) {
+	ctx := context.TODO()
+	m, stg := newTestMigrator(t)
+	for i := 0; i < storage.MaxTxnOps; i++ {
+		_, err := stg.Create(ctx, fmt.Sprintf("/apisix/routes/x%d", i),
+			fmt.Sprintf(`{"id":"x%d","uri":"/x","plugins":{"limit-count":{"count":1,"disable":true}}}`, i))
+		assert.Nil(t, err)
+	}
+
+	// a batch size too large for a transaction is cut down
+	m.BatchSize = 1000
+	sized := &sizeStorage{Interface: stg}
+	m.Stg = sized
+	report, err := m.Upgrade(ctx, "")
+	assert.Nil(t, err)
+	assert.Equal(t, storage.MaxTxnOps+3, report.Changed)
+	assert.Equal(t, storage.MaxTxnOps, sized.max)
+}
+
+// sizeStorage records the most operations in a transaction.
+type sizeStorage struct {
+	storage.Interface
+	max int
+}
+
+func (s *sizeStorage) Txn(ctx context.Context, ops []storage.Op) (int64, error) {
+	if len(ops) > s.max {
+		s.max = len(ops)
+	}
+	return s.Interface.Txn(ctx, ops)
+}
+
+// conflictStorage modifies the key right after it's listed.
+type conflictStorage struct {
+	storage.Interface
+	key string
+}
+
+func (s *conflictStorage) List(ctx context.Context, key string) ([]storage.Keypair, int64, error) {
+	ret, revision, err := s.Interface.List(ctx, key)
+	for _, kv := range ret {
+		if kv.Key == s.key {
+			if _, err := s.Interface.Update(ctx, kv.Key, kv.Value, 0); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	return ret, revision, err
+}
@@ -0,0 +1,58 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package promote
+
+import (
+	"fmt"
+
+	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/core/batch"
+	"github.com/apisix/manager-api/internal/core/store"
+)
+
+// PlannerFunc returns the planner on the stores of a cluster, the stores of
+// GetStore are used when stores is nil.
+type PlannerFunc func(stores map[store.HubKey]store.Interface) *batch.Planner
+
+var newPlanner PlannerFunc = func(stores map[store.HubKey]store.Interface) *batch.Planner {
+	return &batch.Planner{Stores: stores}
+}
+
+// Init sets how the planners of the clusters are made.
+func Init(f PlannerFunc) {
+	newPlanner = f
+}
+
+// GetCluster returns the cluster of store.GetCluster by its name.
+func GetCluster(name string) (*Cluster, error) {
+	hub, ok := store.GetCluster(name)
+	if !ok {
+		if err := store.ClusterError(name); err != nil {
+			return nil, fmt.Errorf("cluster %s is unavailable: %s", name, err)
+		}
+		return nil, fmt.Errorf("cluster %s not found", name)
+	}
+	if name == conf.ClusterLocal {
+		return &Cluster{Name: name, Planner: newPlanner(nil)}, nil
+	}
+
+	stores := map[store.HubKey]store.Interface{}
+	for key, s := range hub {
+		stores[key] = s
+	}
+	return &Cluster{Name: name, Planner: newPlanner(stores)}, nil
+}
@@ -0,0 +1,499 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package promote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+
+	"github.com/apisix/manager-api/internal/core/batch"
+	"github.com/apisix/manager-api/internal/core/graph"
+	"github.com/apisix/manager-api/internal/core/migrate"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/utils"
+	"github.com/apisix/manager-api/internal/utils/consts"
+)
+
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionUnchanged = "unchanged"
+	// ActionConflict is an object differing from the one in the target,
+	// which is overwritten or skipped by the conflict mode
+	ActionConflict = "conflict"
+	ActionSkip     = "skip"
+)
+
+// dependencyFields are the references promoted along with the objects,
+// the consumers in the whitelists are not, as their credentials differ
+// between the clusters.
+var dependencyFields = map[string]bool{
+	graph.FieldServiceID:      true,
+	graph.FieldUpstreamID:     true,
+	graph.FieldPluginConfigID: true,
+	graph.FieldProtoID:        true,
+}
+
+// ignoredFields are maintained by the stores of each cluster.
+var ignoredFields = []string{"create_time", "update_time", "resource_version"}
+
+// Cluster is an APISIX cluster the objects are promoted between, its
+// planner reads and writes the stores of the cluster.
+type Cluster struct {
+	Name    string
+	Planner *batch.Planner
+}
+
+type Options struct {
+	// Resources are the objects selected by their ids
+	Resources []graph.Node
+	// Selector selects the objects by their labels, along with Resources
+	Selector string
+	// IDs are the ids in the target by the objects in the source, such as
+	// "upstream/u1": "u2". An object not in IDs keeps its id, unless an
+	// object of the same name is in the target, whose id is taken.
+	IDs map[string]string
+}
+
+// Item is an object in the promotion.
+type Item struct {
+	Type     store.HubKey `json:"type"`
+	SourceID string       `json:"source_id"`
+	TargetID string       `json:"target_id"`
+	Action   string       `json:"action"`
+	// Dependency is true for the objects promoted since the selected ones
+	// refer to them
+	Dependency bool `json:"dependency"`
+	// Diff is the JSON merge patch turning the object in the target into
+	// the promoted one
+	Diff json.RawMessage `json:"diff,omitempty"`
+
+	body            []byte
+	resourceVersion int64
+}
+
+// Result is the objects in the order they are promoted, the referred
+// ones first.
+type Result struct {
+	Source string  `json:"source"`
+	Target string  `json:"target"`
+	Items  []*Item `json:"items"`
+}
+
+// ConflictError is returned by the ModeReturn promotion when any object
+// differs from the target.
+type ConflictError struct {
+	Target string
+	Items  []*Item
+}
+
+func (e *ConflictError) Error() string {
+	objects := make([]string, 0, len(e.Items))
+	for _, item := range e.Items {
+		objects = append(objects, string(item.Type)+"/"+item.TargetID)
+	}
+	return fmt.Sprintf("%d objects are conflicted in %s: %s", len(e.Items), e.Target, strings.Join(objects, ", "))
+}
+
+// ItemError is the error of an object written to the target.
+type ItemError struct {
+	Item *Item
+	Err  error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("%s %s/%s: %s", e.Item.Action, e.Item.Type, e.Item.TargetID, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// PartialError is returned when a part of the objects is promoted before
+// the writes fail.
+type PartialError struct {
+	Promoted []*Item
+	Pending  []*Item
+	Err      error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d of %d objects are promoted, promote again for the rest: %s",
+		len(e.Promoted), len(e.Promoted)+len(e.Pending), e.Err)
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+// Diff compares the selected objects of the source, along with the objects
+// they refer to, against the target after the ids are rewritten.
+func Diff(ctx context.Context, source, target *Cluster, opt Options) (*Result, error) {
+	if source.Name == target.Name {
+		return nil, fmt.Errorf("source and target are the same cluster %s", source.Name)
+	}
+	nodes, err := selectObjects(ctx, source, opt)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := targetIDs(ctx, source, target, nodes, opt.IDs)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &Result{Source: source.Name, Target: target.Name, Items: []*Item{}}
+	for _, typ := range batch.Types {
+		var keys []string
+		for node := range nodes {
+			if node.Type == typ {
+				keys = append(keys, node.ID)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			node := graph.Node{Type: typ, ID: key}
+			item, err := diffObject(ctx, source, target, node, ids)
+			if err != nil {
+				return nil, err
+			}
+			item.Dependency = !nodes[node]
+			ret.Items = append(ret.Items, item)
+		}
+	}
+	return ret, nil
+}
+
+// Promote writes the objects of the diff to the target, the objects
+// differing from the target are handled by mode. The objects are written
+// in parts within a transaction each, in the order of the diff so that the
+// objects referred to are written first. When a part fails, the objects
+// before it are promoted already and a PartialError is returned, promoting
+// again writes the rest.
+func (r *Result) Promote(ctx context.Context, target *Cluster, mode migrate.ConflictMode) error {
+	var conflicts []*Item
+	for _, item := range r.Items {
+		if item.Action == ActionConflict {
+			conflicts = append(conflicts, item)
+		}
+	}
+	if len(conflicts) > 0 && mode == migrate.ModeReturn {
+		return &ConflictError{Target: target.Name, Items: conflicts}
+	}
+
+	var ops []batch.Operation
+	var written []*Item
+	for _, item := range r.Items {
+		switch item.Action {
+		case ActionConflict:
+			if mode == migrate.ModeSkip {
+				item.Action = ActionSkip
+				continue
+			}
+			item.Action = ActionUpdate
+		case ActionUnchanged:
+			continue
+		}
+		ops = append(ops, batch.Operation{
+			Op:              item.Action,
+			Type:            item.Type,
+			ID:              item.TargetID,
+			ResourceVersion: item.resourceVersion,
+			Body:            item.body,
+		})
+		written = append(written, item)
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+
+	parts, _, err := target.Planner.PlanParts(ctx, ops)
+	if err != nil {
+		var opErr *batch.OperationError
+		if errors.As(err, &opErr) {
+			return &ItemError{Item: written[opErr.Index], Err: opErr.Err}
+		}
+		return err
+	}
+	promoted, err := batch.CommitParts(ctx, parts)
+	if err != nil && promoted > 0 {
+		return &PartialError{Promoted: written[:promoted], Pending: written[promoted:], Err: err}
+	}
+	return err
+}
+
+// selectObjects returns the selected objects and the objects they refer
+// to, which are false in the result.
+func selectObjects(ctx context.Context, source *Cluster, opt Options) (map[graph.Node]bool, error) {
+	if len(opt.Resources) == 0 && opt.Selector == "" {
+		return nil, errors.New("resources or selector is required")
+	}
+	types := map[store.HubKey]bool{}
+	for _, typ := range batch.Types {
+		types[typ] = true
+	}
+
+	ret := map[graph.Node]bool{}
+	for _, node := range opt.Resources {
+		if !types[node.Type] {
+			return nil, fmt.Errorf("type %s is invalid", node.Type)
+		}
+		if _, err := source.Planner.Store(node.Type).Get(ctx, node.ID); err != nil {
+			return nil, fmt.Errorf(consts.IDNotFound, node.Type, node.ID)
+		}
+		ret[node] = true
+	}
+	if opt.Selector != "" {
+		selector, err := store.ParseSelector(opt.Selector)
+		if err != nil {
+			return nil, err
+		}
+		for _, typ := range batch.Types {
+			rows, err := source.Planner.Store(typ).List(ctx, store.ListInput{
+				Predicate: func(obj interface{}) bool {
+					return selector.Matches(batch.ObjectLabels(obj))
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			for _, obj := range rows.Rows {
+				ret[graph.Node{Type: typ, ID: batch.ObjectKey(obj)}] = true
+			}
+		}
+	}
+
+	g, err := buildGraph(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	queue := make([]graph.Node, 0, len(ret))
+	for node := range ret {
+		queue = append(queue, node)
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		deps, err := g.Dependencies(node)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range deps.Outbound {
+			if e.Field == graph.FieldScriptID {
+				return nil, fmt.Errorf("%s has a script, which can't be promoted", node)
+			}
+			if !dependencyFields[e.Field] {
+				continue
+			}
+			// a dangling reference is left to the checks of the target
+			if _, ok := ret[e.To]; ok || !g.Has(e.To) {
+				continue
+			}
+			ret[e.To] = false
+			queue = append(queue, e.To)
+		}
+	}
+	return ret, nil
+}
+
+// targetIDs returns the ids in the target by the objects in the source.
+func targetIDs(ctx context.Context, source, target *Cluster, nodes map[graph.Node]bool,
+	overrides map[string]string) (map[graph.Node]string, error) {
+	ret := map[graph.Node]string{}
+	for key, id := range overrides {
+		i := strings.Index(key, "/")
+		if i < 0 {
+			return nil, fmt.Errorf("%s is invalid, it must be <type>/<id>", key)
+		}
+		node := graph.Node{Type: store.HubKey(key[:i]), ID: key[i+1:]}
+		if node.Type == store.HubKeyConsumer {
+			return nil, fmt.Errorf("consumer %s can't be renamed", node.ID)
+		}
+		if _, ok := nodes[node]; !ok {
+			return nil, fmt.Errorf("%s is not promoted", key)
+		}
+		ret[node] = id
+	}
+
+	for node := range nodes {
+		if _, ok := ret[node]; ok {
+			continue
+		}
+		ret[node] = node.ID
+		obj, err := source.Planner.Store(node.Type).Get(ctx, node.ID)
+		if err != nil {
+			return nil, err
+		}
+		name := batch.ObjectName(obj)
+		if name == "" {
+			continue
+		}
+		rows, err := target.Planner.Store(node.Type).List(ctx, store.ListInput{
+			Predicate: func(obj interface{}) bool {
+				return batch.ObjectName(obj) == name
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(rows.Rows) > 0 {
+			ret[node] = batch.ObjectKey(rows.Rows[0])
+		}
+	}
+	return ret, nil
+}
+
+// diffObject rewrites the object of the source with the ids in the target,
+// and compares it to the one in the target.
+func diffObject(ctx context.Context, source, target *Cluster, node graph.Node, ids map[graph.Node]string) (*Item, error) {
+	obj, err := source.Planner.Store(node.Type).Get(ctx, node.ID)
+	if err != nil {
+		return nil, err
+	}
+	body, err := rewrite(obj, node, ids)
+	if err != nil {
+		return nil, err
+	}
+	item := &Item{Type: node.Type, SourceID: node.ID, TargetID: ids[node], body: body}
+
+	stored, _ := target.Planner.Store(node.Type).Get(ctx, item.TargetID)
+	if stored == nil {
+		item.Action = ActionCreate
+		return item, nil
+	}
+	item.resourceVersion = batch.ResourceVersion(stored)
+
+	desired, err := target.Planner.Decode(ctx, node.Type, item.TargetID, body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", node, err)
+	}
+	a, err := comparableJSON(stored)
+	if err != nil {
+		return nil, err
+	}
+	b, err := comparableJSON(desired)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(a, b) {
+		item.Action = ActionUnchanged
+		return item, nil
+	}
+	item.Action = ActionConflict
+	item.Diff, err = jsonpatch.CreateMergePatch(a, b)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// rewrite returns the body of the object with its id and references in the
+// target.
+func rewrite(obj interface{}, node graph.Node, ids map[graph.Node]string) ([]byte, error) {
+	fields, err := toFields(obj)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range ignoredFields {
+		delete(fields, field)
+	}
+	if id := ids[node]; id != node.ID {
+		fields["id"] = id
+	}
+
+	refs := map[string]store.HubKey{
+		graph.FieldServiceID:      store.HubKeyService,
+		graph.FieldUpstreamID:     store.HubKeyUpstream,
+		graph.FieldPluginConfigID: store.HubKeyPluginConfig,
+	}
+	for field, typ := range refs {
+		rewriteRef(fields, field, typ, ids)
+	}
+	if plugins, ok := fields["plugins"].(map[string]interface{}); ok {
+		if conf, ok := plugins["grpc-transcode"].(map[string]interface{}); ok {
+			rewriteRef(conf, "proto_id", store.HubKeyProto, ids)
+		}
+	}
+	return json.Marshal(fields)
+}
+
+func rewriteRef(fields map[string]interface{}, field string, typ store.HubKey, ids map[graph.Node]string) {
+	v, ok := fields[field]
+	if !ok || v == nil {
+		return
+	}
+	ref := graph.Node{Type: typ, ID: utils.InterfaceToString(v)}
+	if id, ok := ids[ref]; ok && id != ref.ID {
+		fields[field] = id
+	}
+}
+
+func toFields(obj interface{}) (map[string]interface{}, error) {
+	bs, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// comparableJSON returns the object without the fields maintained by the
+// stores, the keys are sorted so that the equal objects are equal bytes.
+func comparableJSON(obj interface{}) ([]byte, error) {
+	fields, err := toFields(obj)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range ignoredFields {
+		delete(fields, field)
+	}
+	return json.Marshal(fields)
+}
+
+// buildGraph builds the references between the objects of the cluster.
+func buildGraph(ctx context.Context, c *Cluster) (*graph.Graph, error) {
+	sources := map[store.HubKey]graph.Source{}
+	for _, typ := range graph.Types {
+		ret, err := c.Planner.Store(typ).List(ctx, store.ListInput{})
+		if err != nil {
+			return nil, err
+		}
+		sources[typ] = rows(ret.Rows)
+	}
+	return graph.Build(ctx, sources), nil
+}
+
+type rows []interface{}
+
+func (r rows) Range(_ context.Context, f func(key string, obj interface{}) bool) {
+	for _, obj := range r {
+		if !f(batch.ObjectKey(obj), obj) {
+			return
+		}
+	}
+}
@@ -0,0 +1,324 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package promote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/core/batch"
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/graph"
+	"github.com/apisix/manager-api/internal/core/migrate"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/core/store"
+)
+
+func newTestCluster(t *testing.T, name string) *Cluster {
+	return newTestClusterOn(t, name, storage.NewMemoryStorage())
+}
+
+func newTestClusterOn(t *testing.T, name string, stg storage.Interface) *Cluster {
+	hub, err := store.NewHub(stg, "/apisix", "/apisix_dashboard")
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = hub.Close()
+	})
+	stores := map[store.HubKey]store.Interface{}
+	for key, s := range hub {
+		stores[key] = s
+	}
+	return &Cluster{Name: name, Planner: &batch.Planner{Stores: stores}}
+}
+
+// write writes the objects to the cluster, and waits for its stores.
+func write(t *testing.T, c *Cluster, ops ...batch.Operation) {
+	txn, results, err := c.Planner.Plan(context.TODO(), ops)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Nil(t, txn.Commit(context.TODO()))
+	for _, ret := range results {
+		waitFor(t, c, ret.Type, ret.ID, func(obj interface{}) bool {
+			return obj != nil
+		})
+	}
+}
+
+func waitFor(t *testing.T, c *Cluster, typ store.HubKey, id string, f func(obj interface{}) bool) {
+	assert.Eventually(t, func() bool {
+		obj, _ := c.Planner.Store(typ).Get(context.TODO(), id)
+		return f(obj)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func create(typ store.HubKey, body string) batch.Operation {
+	return batch.Operation{Op: batch.ActionCreate, Type: typ, Body: json.RawMessage(body)}
+}
+
+func actions(ret *Result) map[string]string {
+	m := map[string]string{}
+	for _, item := range ret.Items {
+		m[string(item.Type)+"/"+item.SourceID+">"+item.TargetID] = item.Action
+	}
+	return m
+}
+
+func TestPromote(t *testing.T) {
+	staging, prod := newTestCluster(t, "staging"), newTestCluster(t, "prod")
+	write(t, staging,
+		create(store.HubKeyUpstream, `{"id": "u1", "name": "up", "type": "roundrobin", "nodes": {"10.0.0.1:80": 1}}`),
+		create(store.HubKeyService, `{"id": "s1", "upstream_id": "u1"}`),
+		create(store.HubKeyRoute, `{"id": "r1", "name": "a", "uri": "/a", "service_id": "s1", "labels": {"team": "core"}}`),
+		create(store.HubKeyRoute, `{"id": "r2", "name": "b", "uri": "/b", "upstream_id": "u1"}`),
+	)
+	// the upstream of the same name in prod is taken by the promoted objects
+	write(t, prod,
+		create(store.HubKeyUpstream, `{"id": "u9", "name": "up", "type": "roundrobin", "nodes": {"10.0.1.1:80": 1}}`),
+	)
+
+	opt := Options{Resources: []graph.Node{{Type: store.HubKeyRoute, ID: "r1"}}}
+	ret, err := Diff(context.TODO(), staging, prod, opt)
+	assert.Nil(t, err)
+	assert.Equal(t, "staging", ret.Source)
+	assert.Equal(t, "prod", ret.Target)
+	assert.Len(t, ret.Items, 3)
+	assert.Equal(t, &Item{
+		Type: store.HubKeyUpstream, SourceID: "u1", TargetID: "u9", Action: ActionConflict, Dependency: true,
+		Diff: json.RawMessage(`{"nodes":{"10.0.0.1:80":1,"10.0.1.1:80":null}}`),
+	}, withoutBody(ret.Items[0]))
+	assert.Equal(t, &Item{Type: store.HubKeyService, SourceID: "s1", TargetID: "s1", Action: ActionCreate, Dependency: true},
+		withoutBody(ret.Items[1]))
+	assert.Equal(t, &Item{Type: store.HubKeyRoute, SourceID: "r1", TargetID: "r1", Action: ActionCreate},
+		withoutBody(ret.Items[2]))
+
+	// nothing is written with the return mode
+	err = ret.Promote(context.TODO(), prod, migrate.ModeReturn)
+	assert.EqualError(t, err, "1 objects are conflicted in prod: upstream/u9")
+	var conflictErr *ConflictError
+	assert.True(t, errors.As(err, &conflictErr))
+
+	// the upstream in prod is kept with the skip mode, and referred to by
+	// the promoted service
+	assert.Nil(t, ret.Promote(context.TODO(), prod, migrate.ModeSkip))
+	assert.Equal(t, map[string]string{
+		"upstream/u1>u9": ActionSkip,
+		"service/s1>s1":  ActionCreate,
+		"route/r1>r1":    ActionCreate,
+	}, actions(ret))
+	waitFor(t, prod, store.HubKeyRoute, "r1", func(obj interface{}) bool {
+		return obj != nil
+	})
+	s, err := prod.Planner.Store(store.HubKeyService).Get(context.TODO(), "s1")
+	assert.Nil(t, err)
+	assert.Equal(t, "u9", s.(*entity.Service).UpstreamID)
+
+	// and overwritten with the overwrite mode, the objects are selected by
+	// labels this time
+	ret, err = Diff(context.TODO(), staging, prod, Options{Selector: "team=core"})
+	assert.Nil(t, err)
+	assert.Nil(t, ret.Promote(context.TODO(), prod, migrate.ModeOverwrite))
+	assert.Equal(t, map[string]string{
+		"upstream/u1>u9": ActionUpdate,
+		"service/s1>s1":  ActionUnchanged,
+		"route/r1>r1":    ActionUnchanged,
+	}, actions(ret))
+	waitFor(t, prod, store.HubKeyUpstream, "u9", func(obj interface{}) bool {
+		nodes, _ := obj.(*entity.Upstream).Nodes.(map[string]interface{})
+		_, ok := nodes["10.0.0.1:80"]
+		return len(nodes) == 1 && ok
+	})
+
+	// the objects are renamed by the ids
+	ret, err = Diff(context.TODO(), staging, prod, Options{
+		Resources: []graph.Node{{Type: store.HubKeyRoute, ID: "r2"}},
+		IDs:       map[string]string{"route/r2": "r200"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"upstream/u1>u9": ActionUnchanged,
+		"route/r2>r200":  ActionCreate,
+	}, actions(ret))
+	assert.Nil(t, ret.Promote(context.TODO(), prod, migrate.ModeReturn))
+	waitFor(t, prod, store.HubKeyRoute, "r200", func(obj interface{}) bool {
+		return obj != nil && obj.(*entity.Route).UpstreamID == "u9"
+	})
+
+	// the name taken by another object fails the promotion
+	ret, err = Diff(context.TODO(), staging, prod, Options{
+		Resources: []graph.Node{{Type: store.HubKeyRoute, ID: "r1"}},
+		IDs:       map[string]string{"route/r1": "r100"},
+	})
+	assert.Nil(t, err)
+	err = ret.Promote(context.TODO(), prod, migrate.ModeReturn)
+	assert.EqualError(t, err, "create route/r100: route name exists")
+	var itemErr *ItemError
+	assert.True(t, errors.As(err, &itemErr))
+}
+
+// failStorage fails the transactions after the first n.
+type failStorage struct {
+	storage.Interface
+	n int
+}
+
+func (s *failStorage) Txn(ctx context.Context, ops []storage.Op) (int64, error) {
+	if s.n == 0 {
+		return 0, errors.New("txn failed")
+	}
+	s.n--
+	return s.Interface.Txn(ctx, ops)
+}
+
+func TestPromote_Parts(t *testing.T) {
+	staging := newTestCluster(t, "staging")
+	stg := &failStorage{Interface: storage.NewMemoryStorage(), n: 1}
+	prod := newTestClusterOn(t, "prod", stg)
+
+	var upstreams, routes []batch.Operation
+	for i := 0; i < 70; i++ {
+		upstreams = append(upstreams, create(store.HubKeyUpstream,
+			fmt.Sprintf(`{"id": "u%d", "type": "roundrobin", "nodes": {"10.0.0.1:80": 1}}`, i)))
+		routes = append(routes, create(store.HubKeyRoute,
+			fmt.Sprintf(`{"id": "r%d", "name": "r%d", "uri": "/r%d", "upstream_id": "u%d", "labels": {"team": "core"}}`, i, i, i, i)))
+	}
+	// the revisions take a storage operation each as well
+	for _, ops := range [][]batch.Operation{upstreams[:35], upstreams[35:], routes[:35], routes[35:]} {
+		write(t, staging, ops...)
+	}
+
+	// the upstreams are promoted before the routes referring to them, and
+	// the objects promoted are reported when a part fails
+	ret, err := Diff(context.TODO(), staging, prod, Options{Selector: "team=core"})
+	assert.Nil(t, err)
+	assert.Len(t, ret.Items, 140)
+	err = ret.Promote(context.TODO(), prod, migrate.ModeReturn)
+	var partialErr *PartialError
+	if !assert.True(t, errors.As(err, &partialErr)) {
+		t.FailNow()
+	}
+	assert.Len(t, partialErr.Promoted, 140-len(partialErr.Pending))
+	for _, item := range partialErr.Promoted {
+		assert.Equal(t, store.HubKeyUpstream, item.Type)
+	}
+	assert.Equal(t, store.HubKeyRoute, partialErr.Pending[len(partialErr.Pending)-1].Type)
+	assert.EqualError(t, err, fmt.Sprintf("%d of 140 objects are promoted, promote again for the rest: txn failed",
+		len(partialErr.Promoted)))
+
+	// and promoting again writes the rest
+	stg.n = -1
+	for _, item := range partialErr.Promoted {
+		waitFor(t, prod, item.Type, item.TargetID, func(obj interface{}) bool {
+			return obj != nil
+		})
+	}
+	ret, err = Diff(context.TODO(), staging, prod, Options{Selector: "team=core"})
+	assert.Nil(t, err)
+	assert.Nil(t, ret.Promote(context.TODO(), prod, migrate.ModeReturn))
+	waitFor(t, prod, store.HubKeyRoute, "r69", func(obj interface{}) bool {
+		return obj != nil
+	})
+}
+
+func withoutBody(item *Item) *Item {
+	ret := *item
+	ret.body = nil
+	ret.resourceVersion = 0
+	return &ret
+}
+
+func TestDiff_Error(t *testing.T) {
+	staging, prod := newTestCluster(t, "staging"), newTestCluster(t, "prod")
+	write(t, staging,
+		create(store.HubKeyConsumer, `{"username": "jack"}`),
+		create(store.HubKeyService, `{"id": "s1"}`),
+		create(store.HubKeyRoute, `{"id": "r1", "name": "a", "uri": "/a", "service_id": "s1"}`),
+	)
+
+	tests := []struct {
+		caseDesc string
+		source   *Cluster
+		opt      Options
+		wantErr  string
+	}{
+		{
+			caseDesc: "same cluster",
+			source:   prod,
+			opt:      Options{Selector: "team=core"},
+			wantErr:  "source and target are the same cluster prod",
+		},
+		{
+			caseDesc: "nothing selected",
+			source:   staging,
+			wantErr:  "resources or selector is required",
+		},
+		{
+			caseDesc: "invalid type",
+			source:   staging,
+			opt:      Options{Resources: []graph.Node{{Type: store.HubKeyScript, ID: "r1"}}},
+			wantErr:  "type script is invalid",
+		},
+		{
+			caseDesc: "not found",
+			source:   staging,
+			opt:      Options{Resources: []graph.Node{{Type: store.HubKeyRoute, ID: "r2"}}},
+			wantErr:  "route id: r2 not found",
+		},
+		{
+			caseDesc: "invalid selector",
+			source:   staging,
+			opt:      Options{Selector: "team in (core"},
+			wantErr:  "label selector team in (core is invalid",
+		},
+		{
+			caseDesc: "rename consumer",
+			source:   staging,
+			opt: Options{
+				Resources: []graph.Node{{Type: store.HubKeyConsumer, ID: "jack"}},
+				IDs:       map[string]string{"consumer/jack": "tom"},
+			},
+			wantErr: "consumer jack can't be renamed",
+		},
+		{
+			caseDesc: "rename not promoted",
+			source:   staging,
+			opt: Options{
+				Resources: []graph.Node{{Type: store.HubKeyRoute, ID: "r1"}},
+				IDs:       map[string]string{"upstream/u1": "u2"},
+			},
+			wantErr: "upstream/u1 is not promoted",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			if tc.source == prod {
+				_, err := Diff(context.TODO(), prod, prod, tc.opt)
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			_, err := Diff(context.TODO(), tc.source, prod, tc.opt)
+			assert.EqualError(t, err, tc.wantErr)
+		})
+	}
+}
@@ -27,6 +27,7 @@ import (
 
 	"github.com/apisix/manager-api/internal"
 	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/filter"
 	"github.com/apisix/manager-api/internal/handler"
 )
 
@@ -36,7 +37,8 @@ func (s *server) setupAPI() {
 		var newMws []droplet.Middleware
 		// default middleware order: resp_reshape, auto_input, traffic_log
 		// We should put err_transform at second to catch all error
-		newMws = append(newMws, mws[0], &handler.ErrorTransformMiddleware{})
+		// and resource_version right before resp_reshape to see the reshaped output
+		newMws = append(newMws, mws[0], &handler.ErrorTransformMiddleware{}, &handler.ResourceVersionMiddleware{})
 		newMws = append(newMws, mws[1:]...)
 		return newMws
 	}
@@ -48,7 +50,7 @@ func (s *server) setupAPI() {
 	addr := net.JoinHostPort(conf.ServerHost, strconv.Itoa(conf.ServerPort))
 	s.server = &http.Server{
 		Addr:         addr,
-		Handler:      r,
+		Handler:      filter.ClusterPath(r),
 		ReadTimeout:  time.Duration(1000) * time.Millisecond,
 		WriteTimeout: time.Duration(5000) * time.Millisecond,
 	}
@@ -58,7 +60,7 @@ func (s *server) setupAPI() {
 		addrSSL := net.JoinHostPort(conf.SSLHost, strconv.Itoa(conf.SSLPort))
 		s.serverSSL = &http.Server{
 			Addr:         addrSSL,
-			Handler:      r,
+			Handler:      filter.ClusterPath(r),
 			ReadTimeout:  time.Duration(1000) * time.Millisecond,
 			WriteTimeout: time.Duration(5000) * time.Millisecond,
 			TLSConfig: &tls.Config{
@@ -17,20 +17,48 @@
 package server
 
 import (
-	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/core/audit"
+	"github.com/apisix/manager-api/internal/core/backup"
+	"github.com/apisix/manager-api/internal/core/encryption"
+	"github.com/apisix/manager-api/internal/core/gitops"
+	"github.com/apisix/manager-api/internal/core/integrity"
+	"github.com/apisix/manager-api/internal/core/promote"
 	"github.com/apisix/manager-api/internal/core/storage"
 	"github.com/apisix/manager-api/internal/core/store"
+	handlerbatch "github.com/apisix/manager-api/internal/handler/batch"
 	"github.com/apisix/manager-api/internal/log"
 )
 
 func (s *server) setupStore() error {
-	if err := storage.InitETCDClient(conf.ETCDConfig); err != nil {
-		log.Errorf("init etcd client fail: %v", err)
+	if err := storage.InitStorage(); err != nil {
+		log.Errorf("init storage fail: %v", err)
+		return err
+	}
+	if err := audit.Init(); err != nil {
+		log.Errorf("init audit fail: %v", err)
+		return err
+	}
+	if err := encryption.Init(); err != nil {
+		log.Errorf("init data encryption fail: %v", err)
 		return err
 	}
 	if err := store.InitStores(); err != nil {
 		log.Errorf("init stores fail: %v", err)
 		return err
 	}
+	if err := store.InitClusters(); err != nil {
+		log.Errorf("init clusters fail: %v", err)
+		return err
+	}
+	promote.Init(handlerbatch.NewClusterPlanner)
+	integrity.Init()
+	if err := backup.Init(); err != nil {
+		log.Errorf("init backup fail: %v", err)
+		return err
+	}
+	if err := gitops.Init(handlerbatch.NewPlanner()); err != nil {
+		log.Errorf("init gitops fail: %v", err)
+		return err
+	}
 	return nil
 }
@@ -55,6 +55,28 @@ type EtcdV3Storage struct {
 }
 
 func InitETCDClient(etcdConf *conf.Etcd) error {
+	cli, err := newETCDClient(etcdConf)
+	if err != nil {
+		return err
+	}
+
+	etcdClient = cli
+	utils.AppendToClosers(Close)
+	return nil
+}
+
+// NewEtcdStorage connects to another etcd than the one of InitETCDClient,
+// such as the etcd of another APISIX cluster. The client is closed by the
+// Close of the storage.
+func NewEtcdStorage(etcdConf *conf.Etcd) (*EtcdV3Storage, error) {
+	cli, err := newETCDClient(etcdConf)
+	if err != nil {
+		return nil, err
+	}
+	return &EtcdV3Storage{client: cli}, nil
+}
+
+func newETCDClient(etcdConf *conf.Etcd) (*clientv3.Client, error) {
 	config := clientv3.Config{
 		Endpoints:   etcdConf.Endpoints,
 		DialTimeout: 5 * time.Second,
@@ -71,7 +93,7 @@ func InitETCDClient(etcdConf *conf.Etcd) error {
 		}
 		tlsConfig, err := tlsInfo.ClientConfig()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		config.TLS = tlsConfig
 	}
@@ -79,12 +101,9 @@ func InitETCDClient(etcdConf *conf.Etcd) error {
 	cli, err := clientv3.New(config)
 	if err != nil {
 		log.Errorf("init etcd failed: %s", err)
-		return fmt.Errorf("init etcd failed: %s", err)
+		return nil, fmt.Errorf("init etcd failed: %s", err)
 	}
-
-	etcdClient = cli
-	utils.AppendToClosers(Close)
-	return nil
+	return cli, nil
 }
 
 func GenEtcdStorage() *EtcdV3Storage {
@@ -101,6 +120,11 @@ func Close() error {
 	return nil
 }
 
+// Close closes the client of the storage.
+func (s *EtcdV3Storage) Close() error {
+	return s.client.Close()
+}
+
 func (s *EtcdV3Storage) Get(ctx context.Context, key string) (string, error) {
 	resp, err := s.client.Get(ctx, key)
 	if err != nil {
@@ -115,11 +139,11 @@ func (s *EtcdV3Storage) Get(ctx context.Context, key string) (string, error) {
 	return string(resp.Kvs[0].Value), nil
 }
 
-func (s *EtcdV3Storage) List(ctx context.Context, key string) ([]Keypair, error) {
+func (s *EtcdV3Storage) List(ctx context.Context, key string) ([]Keypair, int64, error) {
 	resp, err := s.client.Get(ctx, key, clientv3.WithPrefix())
 	if err != nil {
 		log.Errorf("etcd get failed: %s", err)
-		return nil, fmt.Errorf("etcd get failed: %s", err)
+		return nil, 0, fmt.Errorf("etcd get failed: %s", err)
 	}
 	var ret []Keypair
 	for i := range resp.Kvs {
@@ -136,31 +160,55 @@ func (s *EtcdV3Storage) List(ctx context.Context, key string) ([]Keypair, error)
 		}
 
 		data := Keypair{
-			Key:   key,
-			Value: value,
+			Key:      key,
+			Value:    value,
+			Revision: resp.Kvs[i].ModRevision,
 		}
 		ret = append(ret, data)
 	}
 
-	return ret, nil
+	return ret, resp.Header.Revision, nil
 }
 
-func (s *EtcdV3Storage) Create(ctx context.Context, key, val string) error {
-	_, err := s.client.Put(ctx, key, val)
+func (s *EtcdV3Storage) Create(ctx context.Context, key, val string) (int64, error) {
+	resp, err := s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, val)).
+		Commit()
 	if err != nil {
 		log.Errorf("etcd put failed: %s", err)
-		return fmt.Errorf("etcd put failed: %s", err)
+		return 0, fmt.Errorf("etcd put failed: %s", err)
 	}
-	return nil
+	if !resp.Succeeded {
+		log.Warnf("key: %s is conflicted", key)
+		return 0, fmt.Errorf("key: %s is conflicted", key)
+	}
+	return resp.Header.Revision, nil
 }
 
-func (s *EtcdV3Storage) Update(ctx context.Context, key, val string) error {
-	_, err := s.client.Put(ctx, key, val)
+func (s *EtcdV3Storage) Update(ctx context.Context, key, val string, revision int64) (int64, error) {
+	if revision <= 0 {
+		resp, err := s.client.Put(ctx, key, val)
+		if err != nil {
+			log.Errorf("etcd put failed: %s", err)
+			return 0, fmt.Errorf("etcd put failed: %s", err)
+		}
+		return resp.Header.Revision, nil
+	}
+
+	resp, err := s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", revision)).
+		Then(clientv3.OpPut(key, val)).
+		Commit()
 	if err != nil {
 		log.Errorf("etcd put failed: %s", err)
-		return fmt.Errorf("etcd put failed: %s", err)
+		return 0, fmt.Errorf("etcd put failed: %s", err)
 	}
-	return nil
+	if !resp.Succeeded {
+		log.Warnf("key: %s revision %d is outdated", key, revision)
+		return 0, fmt.Errorf("key: %s: %w", key, ErrRevisionMismatch)
+	}
+	return resp.Header.Revision, nil
 }
 
 func (s *EtcdV3Storage) BatchDelete(ctx context.Context, keys []string) error {
@@ -178,12 +226,108 @@ func (s *EtcdV3Storage) BatchDelete(ctx context.Context, keys []string) error {
 	return nil
 }
 
-func (s *EtcdV3Storage) Watch(ctx context.Context, key string) <-chan WatchResponse {
-	eventChan := s.client.Watch(ctx, key, clientv3.WithPrefix())
+func (s *EtcdV3Storage) Delete(ctx context.Context, key string, revision int64) error {
+	if revision <= 0 {
+		return s.BatchDelete(ctx, []string{key})
+	}
+
+	resp, err := s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", revision)).
+		Then(clientv3.OpDelete(key)).
+		Else(clientv3.OpGet(key, clientv3.WithCountOnly())).
+		Commit()
+	if err != nil {
+		log.Errorf("delete etcd key[%s] failed: %s", key, err)
+		return fmt.Errorf("delete etcd key[%s] failed: %s", key, err)
+	}
+	if !resp.Succeeded {
+		if len(resp.Responses) > 0 && resp.Responses[0].GetResponseRange().Count == 0 {
+			log.Warnf("key: %s is not found", key)
+			return fmt.Errorf("key: %s is not found", key)
+		}
+		log.Warnf("key: %s revision %d is outdated", key, revision)
+		return fmt.Errorf("key: %s: %w", key, ErrRevisionMismatch)
+	}
+	return nil
+}
+
+// Txn applies ops in a single etcd transaction. Since etcd limits the
+// number of operations in a transaction with --max-txn-ops, more than
+// MaxTxnOps ops are rejected without being sent.
+func (s *EtcdV3Storage) Txn(ctx context.Context, ops []Op) (int64, error) {
+	if len(ops) > MaxTxnOps {
+		return 0, fmt.Errorf("%w: %d operations exceed the limit of %d", ErrTxnTooLarge, len(ops), MaxTxnOps)
+	}
+	var cmps []clientv3.Cmp
+	var thenOps, elseOps []clientv3.Op
+	for i := range ops {
+		op := ops[i]
+		switch op.Type {
+		case OpCreate:
+			cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(op.Key), "=", 0))
+			thenOps = append(thenOps, clientv3.OpPut(op.Key, op.Value))
+		case OpUpdate:
+			if op.Revision > 0 {
+				cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(op.Key), "=", op.Revision))
+			}
+			thenOps = append(thenOps, clientv3.OpPut(op.Key, op.Value))
+		case OpDelete:
+			if op.Revision > 0 {
+				cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(op.Key), "=", op.Revision))
+			}
+			thenOps = append(thenOps, clientv3.OpDelete(op.Key))
+		default:
+			return 0, fmt.Errorf("op type %d of key: %s is invalid", op.Type, op.Key)
+		}
+		// fetch the current state of every key to report which compare failed
+		elseOps = append(elseOps, clientv3.OpGet(op.Key, clientv3.WithKeysOnly()))
+	}
+
+	resp, err := s.client.Txn(ctx).If(cmps...).Then(thenOps...).Else(elseOps...).Commit()
+	if err != nil {
+		log.Errorf("etcd txn failed: %s", err)
+		return 0, fmt.Errorf("etcd txn failed: %s", err)
+	}
+	if resp.Succeeded {
+		return resp.Header.Revision, nil
+	}
+
+	for i := range ops {
+		var modRevision int64
+		if kvs := resp.Responses[i].GetResponseRange().Kvs; len(kvs) > 0 {
+			modRevision = kvs[0].ModRevision
+		}
+		switch {
+		case ops[i].Type == OpCreate && modRevision > 0:
+			log.Warnf("key: %s is conflicted", ops[i].Key)
+			return 0, fmt.Errorf("key: %s is conflicted", ops[i].Key)
+		case ops[i].Type != OpCreate && ops[i].Revision > 0 && modRevision != ops[i].Revision:
+			log.Warnf("key: %s revision %d is outdated", ops[i].Key, ops[i].Revision)
+			return 0, fmt.Errorf("key: %s: %w", ops[i].Key, ErrRevisionMismatch)
+		}
+	}
+	return 0, fmt.Errorf("etcd txn failed: compare failed")
+}
+
+func (s *EtcdV3Storage) Watch(ctx context.Context, key string, revision int64) <-chan WatchResponse {
+	opts := []clientv3.OpOption{clientv3.WithPrefix()}
+	if revision > 0 {
+		opts = append(opts, clientv3.WithRev(revision))
+	}
+	eventChan := s.client.Watch(ctx, key, opts...)
 	ch := make(chan WatchResponse, 1)
 	go func() {
 		defer runtime.HandlePanic()
 		for event := range eventChan {
+			if event.CompactRevision != 0 {
+				log.Warnf("etcd watch compacted: key: %s revision: %d compacted: %d", key, revision, event.CompactRevision)
+				select {
+				case ch <- WatchResponse{Error: ErrCompacted, Canceled: true}:
+				case <-ctx.Done():
+				}
+				close(ch)
+				return
+			}
 			if event.Err() != nil {
 				log.Errorf("etcd watch error: key: %s err: %v", key, event.Err())
 				close(ch)
@@ -191,6 +335,7 @@ func (s *EtcdV3Storage) Watch(ctx context.Context, key string) <-chan WatchRespo
 			}
 
 			output := WatchResponse{
+				Revision: event.Header.Revision,
 				Canceled: event.Canceled,
 			}
 
@@ -209,8 +354,9 @@ func (s *EtcdV3Storage) Watch(ctx context.Context, key string) <-chan WatchRespo
 
 				e := Event{
 					Keypair: Keypair{
-						Key:   key,
-						Value: value,
+						Key:      key,
+						Value:    value,
+						Revision: event.Events[i].Kv.ModRevision,
 					},
 				}
 				switch event.Events[i].Type {
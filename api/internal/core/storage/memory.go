@@ -0,0 +1,417 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/apisix/manager-api/internal/log"
+	"github.com/apisix/manager-api/internal/utils"
+	"github.com/apisix/manager-api/internal/utils/runtime"
+)
+
+// memoryHistoryLimit is the number of events kept for watches to resume
+// from, older revisions are compacted.
+const memoryHistoryLimit = 1000
+
+var (
+	memoryStorage *MemoryStorage
+)
+
+type memoryItem struct {
+	value    string
+	revision int64
+	// seq keeps the order in which the keys were created
+	seq int64
+}
+
+type memoryWatcher struct {
+	prefix string
+	// pending keeps the responses not delivered yet, so that a slow
+	// watcher never blocks the writes
+	pending []WatchResponse
+	wakeup  chan struct{}
+}
+
+// MemoryStorage keeps everything in memory with etcd like revisions, it's
+// meant for local development and tests.
+type MemoryStorage struct {
+	mu       sync.Mutex
+	revision int64
+	seq      int64
+	items    map[string]*memoryItem
+	watchers map[*memoryWatcher]struct{}
+	// history keeps the latest events, and compacted is the highest
+	// revision dropped from it
+	history   []Event
+	compacted int64
+	// persist is called with the items to be applied before they take
+	// effect, the write is rejected on error
+	persist func(items map[string]*memoryItem) error
+}
+
+type memorySnapshot struct {
+	Revision int64     `json:"revision"`
+	Items    []Keypair `json:"items"`
+}
+
+// InitMemoryStorage initializes the memory storage, and when snapshotFile
+// is set, loads it at startup and saves it back on shutdown.
+func InitMemoryStorage(snapshotFile string) error {
+	s := NewMemoryStorage()
+	if snapshotFile != "" {
+		if err := s.LoadSnapshot(snapshotFile); err != nil {
+			log.Errorf("load memory storage snapshot failed: %s", err)
+			return fmt.Errorf("load memory storage snapshot failed: %s", err)
+		}
+		utils.AppendToClosers(func() error {
+			return s.SaveSnapshot(snapshotFile)
+		})
+	}
+
+	memoryStorage = s
+	return nil
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		revision: 1,
+		items:    map[string]*memoryItem{},
+		watchers: map[*memoryWatcher]struct{}{},
+	}
+}
+
+// LoadSnapshot replaces the items with the snapshot, a missing snapshot
+// file is ignored.
+func (s *MemoryStorage) LoadSnapshot(filePath string) error {
+	content, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snapshot memorySnapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return fmt.Errorf("json unmarshal failed: %s", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items = make(map[string]*memoryItem, len(snapshot.Items))
+	s.seq = 0
+	for i := range snapshot.Items {
+		s.seq++
+		s.items[snapshot.Items[i].Key] = &memoryItem{
+			value:    snapshot.Items[i].Value,
+			revision: snapshot.Items[i].Revision,
+			seq:      s.seq,
+		}
+	}
+	if snapshot.Revision > s.revision {
+		s.revision = snapshot.Revision
+	}
+	// there is no history before the snapshot
+	s.history = nil
+	s.compacted = s.revision
+	return nil
+}
+
+// SaveSnapshot writes all the items to the file as JSON.
+func (s *MemoryStorage) SaveSnapshot(filePath string) error {
+	s.mu.Lock()
+	snapshot := memorySnapshot{Revision: s.revision, Items: s.list("")}
+	s.mu.Unlock()
+
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		log.Errorf("json marshal failed: %s", err)
+		return fmt.Errorf("json marshal failed: %s", err)
+	}
+	if err := writeFileAtomic(filePath, content); err != nil {
+		log.Errorf("save memory storage snapshot failed: %s", err)
+		return fmt.Errorf("save memory storage snapshot failed: %s", err)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) Get(_ context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[key]
+	if !ok {
+		log.Warnf("key: %s is not found", key)
+		return "", fmt.Errorf("key: %s is not found", key)
+	}
+	return item.value, nil
+}
+
+func (s *MemoryStorage) List(_ context.Context, key string) ([]Keypair, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(key), s.revision, nil
+}
+
+func (s *MemoryStorage) list(prefix string) []Keypair {
+	var ret []Keypair
+	for k, item := range s.items {
+		if strings.HasPrefix(k, prefix) {
+			ret = append(ret, Keypair{Key: k, Value: item.value, Revision: item.revision})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key < ret[j].Key
+	})
+	return ret
+}
+
+func (s *MemoryStorage) Create(ctx context.Context, key, val string) (int64, error) {
+	return s.Txn(ctx, []Op{{Type: OpCreate, Key: key, Value: val}})
+}
+
+func (s *MemoryStorage) Update(ctx context.Context, key, val string, revision int64) (int64, error) {
+	return s.Txn(ctx, []Op{{Type: OpUpdate, Key: key, Value: val, Revision: revision}})
+}
+
+func (s *MemoryStorage) BatchDelete(_ context.Context, keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ops := make([]Op, 0, len(keys))
+	for i := range keys {
+		if _, ok := s.items[keys[i]]; !ok {
+			log.Warnf("key: %s is not found", keys[i])
+			return fmt.Errorf("key: %s is not found", keys[i])
+		}
+		ops = append(ops, Op{Type: OpDelete, Key: keys[i]})
+	}
+	_, err := s.apply(ops)
+	return err
+}
+
+func (s *MemoryStorage) Delete(ctx context.Context, key string, revision int64) error {
+	if revision <= 0 {
+		return s.BatchDelete(ctx, []string{key})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[key]; !ok {
+		log.Warnf("key: %s is not found", key)
+		return fmt.Errorf("key: %s is not found", key)
+	}
+	if err := s.compare([]Op{{Type: OpDelete, Key: key, Revision: revision}}); err != nil {
+		return err
+	}
+	_, err := s.apply([]Op{{Type: OpDelete, Key: key}})
+	return err
+}
+
+func (s *MemoryStorage) Txn(_ context.Context, ops []Op) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.compare(ops); err != nil {
+		return 0, err
+	}
+	return s.apply(ops)
+}
+
+func (s *MemoryStorage) Watch(ctx context.Context, key string, revision int64) <-chan WatchResponse {
+	w := &memoryWatcher{
+		prefix: key,
+		wakeup: make(chan struct{}, 1),
+	}
+	ch := make(chan WatchResponse, 1)
+
+	s.mu.Lock()
+	if revision > 0 && revision <= s.compacted {
+		s.mu.Unlock()
+		log.Warnf("memory watch compacted: key: %s revision: %d compacted: %d", key, revision, s.compacted)
+		ch <- WatchResponse{Error: ErrCompacted, Canceled: true}
+		close(ch)
+		return ch
+	}
+	if revision > 0 {
+		// replay the history, one response per revision
+		for i := range s.history {
+			e := s.history[i]
+			if e.Revision < revision || !strings.HasPrefix(e.Key, key) {
+				continue
+			}
+			if n := len(w.pending); n > 0 && w.pending[n-1].Revision == e.Revision {
+				w.pending[n-1].Events = append(w.pending[n-1].Events, e)
+				continue
+			}
+			w.pending = append(w.pending, WatchResponse{Events: []Event{e}, Revision: e.Revision})
+		}
+	}
+	s.watchers[w] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		defer runtime.HandlePanic()
+		defer close(ch)
+		defer func() {
+			s.mu.Lock()
+			delete(s.watchers, w)
+			s.mu.Unlock()
+		}()
+
+		for {
+			s.mu.Lock()
+			pending := w.pending
+			w.pending = nil
+			s.mu.Unlock()
+
+			for i := range pending {
+				select {
+				case ch <- pending[i]:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-w.wakeup:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// compare checks the ops against the current items the same way as the
+// compares of an etcd transaction.
+func (s *MemoryStorage) compare(ops []Op) error {
+	for i := range ops {
+		var revision int64
+		if item, ok := s.items[ops[i].Key]; ok {
+			revision = item.revision
+		}
+
+		switch ops[i].Type {
+		case OpCreate:
+			if revision > 0 {
+				log.Warnf("key: %s is conflicted", ops[i].Key)
+				return fmt.Errorf("key: %s is conflicted", ops[i].Key)
+			}
+		case OpUpdate, OpDelete:
+			if ops[i].Revision > 0 && ops[i].Revision != revision {
+				log.Warnf("key: %s revision %d is outdated", ops[i].Key, ops[i].Revision)
+				return fmt.Errorf("key: %s: %w", ops[i].Key, ErrRevisionMismatch)
+			}
+		default:
+			return fmt.Errorf("op type %d of key: %s is invalid", ops[i].Type, ops[i].Key)
+		}
+	}
+	return nil
+}
+
+// apply applies ops at the next revision and notifies the watchers. It
+// must be called with the lock held.
+func (s *MemoryStorage) apply(ops []Op) (int64, error) {
+	revision := s.revision + 1
+	items := make(map[string]*memoryItem, len(s.items))
+	for k, item := range s.items {
+		items[k] = item
+	}
+
+	seq := s.seq
+	var events []Event
+	for i := range ops {
+		if ops[i].Type == OpDelete {
+			if _, ok := items[ops[i].Key]; !ok {
+				continue
+			}
+			delete(items, ops[i].Key)
+			events = append(events, Event{
+				Keypair: Keypair{Key: ops[i].Key, Revision: revision},
+				Type:    EventTypeDelete,
+			})
+			continue
+		}
+
+		item := &memoryItem{value: ops[i].Value, revision: revision}
+		if old, ok := items[ops[i].Key]; ok {
+			item.seq = old.seq
+		} else {
+			seq++
+			item.seq = seq
+		}
+		items[ops[i].Key] = item
+		events = append(events, Event{
+			Keypair: Keypair{Key: ops[i].Key, Value: ops[i].Value, Revision: revision},
+			Type:    EventTypePut,
+		})
+	}
+
+	if s.persist != nil {
+		if err := s.persist(items); err != nil {
+			return 0, err
+		}
+	}
+
+	s.items = items
+	s.seq = seq
+	s.revision = revision
+	s.notify(events)
+	return revision, nil
+}
+
+// notify records the events of the current revision in the history and
+// queues them to the watchers by their prefixes. It must be called with
+// the lock held.
+func (s *MemoryStorage) notify(events []Event) {
+	s.history = append(s.history, events...)
+	if n := len(s.history) - memoryHistoryLimit; n > 0 {
+		s.compacted = s.history[n-1].Revision
+		s.history = append([]Event(nil), s.history[n:]...)
+	}
+
+	for w := range s.watchers {
+		output := WatchResponse{Revision: s.revision}
+		for i := range events {
+			if strings.HasPrefix(events[i].Key, w.prefix) {
+				output.Events = append(output.Events, events[i])
+			}
+		}
+		if len(output.Events) == 0 {
+			continue
+		}
+
+		w.pending = append(w.pending, output)
+		select {
+		case w.wakeup <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,171 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.TODO()
+
+	rev, err := s.Create(ctx, "/apisix/routes/r1", `{"id":"r1"}`)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), rev)
+	_, err = s.Create(ctx, "/apisix/routes/r1", `{"id":"r1"}`)
+	assert.Equal(t, fmt.Errorf("key: /apisix/routes/r1 is conflicted"), err)
+	_, err = s.Create(ctx, "/apisix/routes_other/r1", `{"id":"r1"}`)
+	assert.Nil(t, err)
+
+	_, err = s.Update(ctx, "/apisix/routes/r1", `{"id":"r1","uri":"/hi"}`, 3)
+	assert.True(t, errors.Is(err, ErrRevisionMismatch))
+	rev, err = s.Update(ctx, "/apisix/routes/r1", `{"id":"r1","uri":"/hi"}`, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), rev)
+	val, err := s.Get(ctx, "/apisix/routes/r1")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"id":"r1","uri":"/hi"}`, val)
+
+	ret, _, err := s.List(ctx, "/apisix/routes/")
+	assert.Nil(t, err)
+	assert.Equal(t, []Keypair{{Key: "/apisix/routes/r1", Value: `{"id":"r1","uri":"/hi"}`, Revision: 4}}, ret)
+
+	// a failed compare leaves everything untouched
+	_, err = s.Txn(ctx, []Op{
+		{Type: OpDelete, Key: "/apisix/routes_other/r1"},
+		{Type: OpDelete, Key: "/apisix/routes/r1", Revision: 2},
+	})
+	assert.True(t, errors.Is(err, ErrRevisionMismatch))
+	_, err = s.Get(ctx, "/apisix/routes_other/r1")
+	assert.Nil(t, err)
+
+	err = s.Delete(ctx, "/apisix/routes/r2", 4)
+	assert.Equal(t, fmt.Errorf("key: /apisix/routes/r2 is not found"), err)
+	err = s.Delete(ctx, "/apisix/routes/r1", 4)
+	assert.Nil(t, err)
+	err = s.BatchDelete(ctx, []string{"/apisix/routes_other/r1", "/apisix/routes/r1"})
+	assert.Equal(t, fmt.Errorf("key: /apisix/routes/r1 is not found"), err)
+	ret, _, err = s.List(ctx, "/apisix/")
+	assert.Nil(t, err)
+	assert.Len(t, ret, 1)
+}
+
+func TestMemoryStorage_Watch(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx, cancel := context.WithCancel(context.TODO())
+	ch := s.Watch(ctx, "/apisix/routes/", 0)
+
+	_, err := s.Txn(context.TODO(), []Op{
+		{Type: OpCreate, Key: "/apisix/routes/r1", Value: `{"id":"r1"}`},
+		{Type: OpCreate, Key: "/apisix/scripts/r1", Value: `{"id":"r1"}`},
+	})
+	assert.Nil(t, err)
+	err = s.BatchDelete(context.TODO(), []string{"/apisix/routes/r1"})
+	assert.Nil(t, err)
+
+	var got []WatchResponse
+	for len(got) < 2 {
+		select {
+		case resp := <-ch:
+			got = append(got, resp)
+		case <-time.After(5 * time.Second):
+			t.Fatal("watch event timeout")
+		}
+	}
+	assert.Equal(t, []WatchResponse{
+		{Events: []Event{{Keypair: Keypair{Key: "/apisix/routes/r1", Value: `{"id":"r1"}`, Revision: 2}, Type: EventTypePut}}, Revision: 2},
+		{Events: []Event{{Keypair: Keypair{Key: "/apisix/routes/r1", Revision: 3}, Type: EventTypeDelete}}, Revision: 3},
+	}, got)
+
+	cancel()
+	for range ch {
+	}
+	// nobody is watching any more
+	_, err = s.Create(context.TODO(), "/apisix/routes/r2", `{"id":"r2"}`)
+	assert.Nil(t, err)
+}
+
+func TestMemoryStorage_Snapshot(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "snapshot.json")
+	s := NewMemoryStorage()
+	assert.Nil(t, s.LoadSnapshot(filePath))
+
+	_, err := s.Create(context.TODO(), "/apisix/routes/r1", `{"id":"r1"}`)
+	assert.Nil(t, err)
+	_, err = s.Create(context.TODO(), "/apisix/routes/r2", `{"id":"r2"}`)
+	assert.Nil(t, err)
+	assert.Nil(t, s.SaveSnapshot(filePath))
+
+	s2 := NewMemoryStorage()
+	assert.Nil(t, s2.LoadSnapshot(filePath))
+	ret, rev, err := s2.List(context.TODO(), "/apisix/routes/")
+	assert.Nil(t, err)
+	assert.Equal(t, []Keypair{
+		{Key: "/apisix/routes/r1", Value: `{"id":"r1"}`, Revision: 2},
+		{Key: "/apisix/routes/r2", Value: `{"id":"r2"}`, Revision: 3},
+	}, ret)
+	assert.Equal(t, int64(3), rev)
+
+	// no history is kept in the snapshot
+	resp := <-s2.Watch(context.TODO(), "/apisix/routes/", 3)
+	assert.Equal(t, WatchResponse{Error: ErrCompacted, Canceled: true}, resp)
+
+	// revisions go on from the snapshot
+	rev, err = s2.Update(context.TODO(), "/apisix/routes/r1", `{"id":"r1"}`, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), rev)
+}
+
+func TestMemoryStorage_WatchFromRevision(t *testing.T) {
+	s := NewMemoryStorage()
+	for i := 0; i < memoryHistoryLimit; i++ {
+		_, err := s.Update(context.TODO(), fmt.Sprintf("/apisix/routes/r%d", i%3), `{}`, 0)
+		assert.Nil(t, err)
+	}
+	_, err := s.Txn(context.TODO(), []Op{
+		{Type: OpDelete, Key: "/apisix/routes/r0"},
+		{Type: OpUpdate, Key: "/apisix/services/s1", Value: `{}`},
+	})
+	assert.Nil(t, err)
+	_, rev, err := s.List(context.TODO(), "/apisix/routes/")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(memoryHistoryLimit+2), rev)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	ch := s.Watch(ctx, "/apisix/routes/", rev-1)
+	assert.Equal(t, WatchResponse{
+		Events:   []Event{{Keypair: Keypair{Key: "/apisix/routes/r0", Value: `{}`, Revision: rev - 1}, Type: EventTypePut}},
+		Revision: rev - 1,
+	}, <-ch)
+	assert.Equal(t, WatchResponse{
+		Events:   []Event{{Keypair: Keypair{Key: "/apisix/routes/r0", Revision: rev}, Type: EventTypeDelete}},
+		Revision: rev,
+	}, <-ch)
+
+	// the oldest events have been dropped
+	resp := <-s.Watch(context.TODO(), "/apisix/routes/", 2)
+	assert.Equal(t, WatchResponse{Error: ErrCompacted, Canceled: true}, resp)
+}
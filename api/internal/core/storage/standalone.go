@@ -0,0 +1,417 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/ghodss/yaml"
+
+	"github.com/apisix/manager-api/internal/log"
+	"github.com/apisix/manager-api/internal/utils"
+	"github.com/apisix/manager-api/internal/utils/runtime"
+)
+
+// StandaloneEndMarker must end the apisix.yaml, APISIX ignores the file
+// until the marker shows up, so a half written file is never loaded.
+const StandaloneEndMarker = "#END"
+
+var (
+	standaloneStorage *StandaloneStorage
+)
+
+// StandaloneStorage keeps the resources in the apisix.yaml of APISIX
+// standalone mode. A key like <prefix>/routes/1 is the item with id 1 in
+// the routes list of the file. The file is rewritten before every write
+// of the embedded MemoryStorage takes effect.
+//
+// Revisions are kept in memory only, they start over when the file is
+// loaded again.
+type StandaloneStorage struct {
+	*MemoryStorage
+	filePath string
+	prefix   string
+	// others keeps the top level fields which are not resource lists
+	others map[string]json.RawMessage
+	// content is the last content read or written
+	content   []byte
+	fsWatcher *fsnotify.Watcher
+}
+
+type standaloneEntry struct {
+	key   string
+	value string
+}
+
+func InitStandaloneStorage(filePath, prefix string) error {
+	s, err := NewStandaloneStorage(filePath, prefix)
+	if err != nil {
+		log.Errorf("init standalone storage failed: %s", err)
+		return fmt.Errorf("init standalone storage failed: %s", err)
+	}
+
+	standaloneStorage = s
+	utils.AppendToClosers(s.Close)
+	return nil
+}
+
+func NewStandaloneStorage(filePath, prefix string) (*StandaloneStorage, error) {
+	s := &StandaloneStorage{
+		MemoryStorage: NewMemoryStorage(),
+		filePath:      filePath,
+		prefix:        prefix,
+		others:        map[string]json.RawMessage{},
+	}
+
+	content, err := ioutil.ReadFile(filePath)
+	switch {
+	case os.IsNotExist(err):
+		if err := s.write(s.items); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		entries, others, err := s.parse(content)
+		if err != nil {
+			return nil, err
+		}
+		for i := range entries {
+			s.seq++
+			s.items[entries[i].key] = &memoryItem{value: entries[i].value, revision: s.revision, seq: s.seq}
+		}
+		s.others = others
+		s.content = content
+	}
+	s.persist = s.write
+
+	// watch the directory since the file is replaced on every rewrite
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := fsWatcher.Add(filepath.Dir(filePath)); err != nil {
+		_ = fsWatcher.Close()
+		return nil, err
+	}
+	s.fsWatcher = fsWatcher
+	go s.watchFile()
+
+	return s, nil
+}
+
+func (s *StandaloneStorage) Close() error {
+	if err := s.fsWatcher.Close(); err != nil {
+		log.Errorf("standalone file watcher close failed: %s", err)
+		return err
+	}
+	return nil
+}
+
+// write rewrites the whole file atomically by renaming a temporary file
+// over it, so APISIX never reads a partial file.
+func (s *StandaloneStorage) write(items map[string]*memoryItem) error {
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return items[keys[i]].seq < items[keys[j]].seq
+	})
+
+	doc := make(map[string]interface{}, len(s.others))
+	for k, v := range s.others {
+		doc[k] = v
+	}
+	sections := map[string][]json.RawMessage{}
+	for i := range keys {
+		section, _, err := s.splitKey(keys[i])
+		if err != nil {
+			return err
+		}
+		sections[section] = append(sections[section], json.RawMessage(items[keys[i]].value))
+	}
+	for section, list := range sections {
+		doc[section] = list
+	}
+
+	j, err := json.Marshal(doc)
+	if err != nil {
+		log.Errorf("json marshal failed: %s", err)
+		return fmt.Errorf("json marshal failed: %s", err)
+	}
+	content, err := yaml.JSONToYAML(j)
+	if err != nil {
+		log.Errorf("yaml marshal failed: %s", err)
+		return fmt.Errorf("yaml marshal failed: %s", err)
+	}
+	if len(doc) == 0 {
+		content = nil
+	}
+	content = append(content, []byte(StandaloneEndMarker+"\n")...)
+
+	if err := writeFileAtomic(s.filePath, content); err != nil {
+		log.Errorf("standalone file write failed: %s", err)
+		return fmt.Errorf("standalone file write failed: %s", err)
+	}
+	s.content = content
+	return nil
+}
+
+func writeFileAtomic(filePath string, content []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filePath); err == nil {
+		mode = info.Mode()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// no-op once renamed
+		_ = os.Remove(f.Name())
+	}()
+
+	if _, err := f.Write(content); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filePath)
+}
+
+// parse returns the items of the resource lists in the order of the file,
+// and the other top level fields as they are.
+func (s *StandaloneStorage) parse(content []byte) ([]standaloneEntry, map[string]json.RawMessage, error) {
+	if !bytes.HasSuffix(bytes.TrimRight(content, " \t\r\n"), []byte(StandaloneEndMarker)) {
+		return nil, nil, fmt.Errorf("file %s is incomplete, %s is missing", s.filePath, StandaloneEndMarker)
+	}
+
+	j, err := yaml.YAMLToJSON(content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("yaml unmarshal failed: %s", err)
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(j, &doc); err != nil {
+		return nil, nil, fmt.Errorf("json unmarshal failed: %s", err)
+	}
+
+	sections := make([]string, 0, len(doc))
+	for section := range doc {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+
+	var entries []standaloneEntry
+	others := map[string]json.RawMessage{}
+	keys := map[string]bool{}
+	for _, section := range sections {
+		var list []json.RawMessage
+		if err := json.Unmarshal(doc[section], &list); err != nil {
+			others[section] = doc[section]
+			continue
+		}
+
+		var sectionEntries []standaloneEntry
+		for i := range list {
+			value, err := canonicalJSON(list[i])
+			if err != nil {
+				sectionEntries = nil
+				break
+			}
+			key := s.prefix + "/" + section + "/" + itemID(section, value, i)
+			if keys[key] {
+				return nil, nil, fmt.Errorf("key: %s is duplicated", key)
+			}
+			keys[key] = true
+			sectionEntries = append(sectionEntries, standaloneEntry{key: key, value: value})
+		}
+		if sectionEntries == nil && len(list) > 0 {
+			// not a list of objects, such as the plugins list
+			others[section] = doc[section]
+			continue
+		}
+		entries = append(entries, sectionEntries...)
+	}
+
+	return entries, others, nil
+}
+
+// splitKey returns the list and id of the item which the key refers to.
+func (s *StandaloneStorage) splitKey(key string) (string, string, error) {
+	rest := strings.TrimPrefix(key, s.prefix+"/")
+	i := strings.LastIndex(rest, "/")
+	if rest == key || i <= 0 || i == len(rest)-1 {
+		return "", "", fmt.Errorf("key: %s is invalid", key)
+	}
+	return rest[:i], rest[i+1:], nil
+}
+
+func (s *StandaloneStorage) watchFile() {
+	defer runtime.HandlePanic()
+	for {
+		select {
+		case event, ok := <-s.fsWatcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != filepath.Clean(s.filePath) {
+				continue
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) == 0 {
+				continue
+			}
+			if err := s.reload(); err != nil {
+				log.Warnf("standalone file reload failed: %s", err)
+			}
+		case err, ok := <-s.fsWatcher.Errors:
+			if !ok {
+				return
+			}
+			log.Errorf("standalone file watch error: %s", err)
+		}
+	}
+}
+
+// reload loads the file changed by others, and notifies the watchers of
+// the differences.
+func (s *StandaloneStorage) reload() error {
+	// read with the lock held, or our own write may be read back stale
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	content, err := ioutil.ReadFile(s.filePath)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(content, s.content) {
+		return nil
+	}
+	entries, others, err := s.parse(content)
+	if err != nil {
+		return err
+	}
+
+	revision := s.revision + 1
+	items := make(map[string]*memoryItem, len(entries))
+	var events []Event
+	for i, entry := range entries {
+		item := &memoryItem{value: entry.value, revision: revision, seq: int64(i + 1)}
+		if old, ok := s.items[entry.key]; ok && sameJSON(old.value, entry.value) {
+			item.revision = old.revision
+		} else {
+			events = append(events, Event{
+				Keypair: Keypair{Key: entry.key, Value: entry.value, Revision: revision},
+				Type:    EventTypePut,
+			})
+		}
+		items[entry.key] = item
+	}
+	for key := range s.items {
+		if _, ok := items[key]; !ok {
+			events = append(events, Event{
+				Keypair: Keypair{Key: key, Revision: revision},
+				Type:    EventTypeDelete,
+			})
+		}
+	}
+
+	s.items = items
+	s.seq = int64(len(entries))
+	s.others = others
+	s.content = content
+	if len(events) > 0 {
+		s.revision = revision
+		s.notify(events)
+	}
+	return nil
+}
+
+// canonicalJSON re-encodes the object with sorted fields, so that the same
+// item read back from the file has the same value.
+func canonicalJSON(data []byte) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var obj map[string]interface{}
+	if err := decoder.Decode(&obj); err != nil {
+		return "", err
+	}
+	if obj == nil {
+		return "", fmt.Errorf("value is not an object")
+	}
+
+	ret, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	return string(ret), nil
+}
+
+func sameJSON(a, b string) bool {
+	if a == b {
+		return true
+	}
+	canonical, err := canonicalJSON([]byte(a))
+	return err == nil && canonical == b
+}
+
+// itemID returns the id of the i-th item of the list, the position is
+// used when the id is missing, the same as APISIX does.
+func itemID(section, value string, i int) string {
+	field := "id"
+	switch section {
+	case "consumers":
+		field = "username"
+	case "system_config":
+		field = "config_name"
+	}
+
+	var obj map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&obj); err == nil {
+		switch id := obj[field].(type) {
+		case string:
+			if id != "" {
+				return id
+			}
+		case json.Number:
+			return id.String()
+		}
+	}
+	return strconv.Itoa(i + 1)
+}
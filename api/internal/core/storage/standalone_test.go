@@ -0,0 +1,190 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestStandaloneStorage(t *testing.T, content string) (*StandaloneStorage, string) {
+	filePath := filepath.Join(t.TempDir(), "apisix.yaml")
+	if content != "" {
+		err := ioutil.WriteFile(filePath, []byte(content), 0644)
+		assert.Nil(t, err)
+	}
+	s, err := NewStandaloneStorage(filePath, "/apisix")
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	return s, filePath
+}
+
+func readTestFile(t *testing.T, filePath string) string {
+	content, err := ioutil.ReadFile(filePath)
+	assert.Nil(t, err)
+	return string(content)
+}
+
+func TestNewStandaloneStorage(t *testing.T) {
+	s, filePath := newTestStandaloneStorage(t, "")
+	assert.Equal(t, StandaloneEndMarker+"\n", readTestFile(t, filePath))
+	ret, _, err := s.List(context.TODO(), "/apisix/routes")
+	assert.Nil(t, err)
+	assert.Len(t, ret, 0)
+
+	s, _ = newTestStandaloneStorage(t, `
+routes:
+  - id: r1
+    uri: /hello
+  - uri: /world
+consumers:
+  - username: jack
+plugins:
+  - name: limit-count
+upstreams: []
+#END
+`)
+	ret, _, err = s.List(context.TODO(), "/apisix/")
+	assert.Nil(t, err)
+	assert.Equal(t, []Keypair{
+		{Key: "/apisix/consumers/jack", Value: `{"username":"jack"}`, Revision: 1},
+		{Key: "/apisix/plugins/1", Value: `{"name":"limit-count"}`, Revision: 1},
+		{Key: "/apisix/routes/2", Value: `{"uri":"/world"}`, Revision: 1},
+		{Key: "/apisix/routes/r1", Value: `{"id":"r1","uri":"/hello"}`, Revision: 1},
+	}, ret)
+
+	filePath = filepath.Join(t.TempDir(), "apisix.yaml")
+	err = ioutil.WriteFile(filePath, []byte("routes:\n  - id: r1\n"), 0644)
+	assert.Nil(t, err)
+	_, err = NewStandaloneStorage(filePath, "/apisix")
+	assert.Equal(t, fmt.Errorf("file %s is incomplete, #END is missing", filePath), err)
+
+	err = ioutil.WriteFile(filePath, []byte("routes:\n  - id: r1\n  - id: r1\n#END\n"), 0644)
+	assert.Nil(t, err)
+	_, err = NewStandaloneStorage(filePath, "/apisix")
+	assert.Equal(t, fmt.Errorf("key: /apisix/routes/r1 is duplicated"), err)
+}
+
+func TestStandaloneStorage_Write(t *testing.T) {
+	s, filePath := newTestStandaloneStorage(t, "plugins:\n  - name: limit-count\n#END\n")
+	ctx := context.TODO()
+
+	rev, err := s.Create(ctx, "/apisix/routes/r1", `{"id":"r1","uri":"/hello"}`)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), rev)
+	_, err = s.Create(ctx, "/apisix/routes/r1", `{"id":"r1","uri":"/hello"}`)
+	assert.Equal(t, fmt.Errorf("key: /apisix/routes/r1 is conflicted"), err)
+	_, err = s.Create(ctx, "/apisix/r1", `{"id":"r1"}`)
+	assert.Equal(t, fmt.Errorf("key: /apisix/r1 is invalid"), err)
+
+	content := readTestFile(t, filePath)
+	assert.True(t, strings.HasSuffix(content, "#END\n"))
+	assert.Contains(t, content, "name: limit-count")
+	assert.Contains(t, content, "uri: /hello")
+
+	_, err = s.Update(ctx, "/apisix/routes/r1", `{"id":"r1","uri":"/hi"}`, 1)
+	assert.True(t, errors.Is(err, ErrRevisionMismatch))
+	rev, err = s.Update(ctx, "/apisix/routes/r1", `{"id":"r1","uri":"/hi"}`, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), rev)
+	val, err := s.Get(ctx, "/apisix/routes/r1")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"id":"r1","uri":"/hi"}`, val)
+
+	// a failed compare leaves everything untouched
+	_, err = s.Txn(ctx, []Op{
+		{Type: OpCreate, Key: "/apisix/scripts/r1", Value: `{"id":"r1"}`},
+		{Type: OpUpdate, Key: "/apisix/routes/r1", Value: `{"id":"r1"}`, Revision: 2},
+	})
+	assert.True(t, errors.Is(err, ErrRevisionMismatch))
+	_, err = s.Get(ctx, "/apisix/scripts/r1")
+	assert.Equal(t, fmt.Errorf("key: /apisix/scripts/r1 is not found"), err)
+
+	rev, err = s.Txn(ctx, []Op{
+		{Type: OpCreate, Key: "/apisix/scripts/r1", Value: `{"id":"r1"}`},
+		{Type: OpUpdate, Key: "/apisix/routes/r1", Value: `{"id":"r1"}`, Revision: 3},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), rev)
+
+	err = s.Delete(ctx, "/apisix/routes/r1", 3)
+	assert.True(t, errors.Is(err, ErrRevisionMismatch))
+	err = s.Delete(ctx, "/apisix/routes/r1", 4)
+	assert.Nil(t, err)
+	err = s.BatchDelete(ctx, []string{"/apisix/scripts/r1", "/apisix/routes/r1"})
+	assert.Equal(t, fmt.Errorf("key: /apisix/routes/r1 is not found"), err)
+	err = s.BatchDelete(ctx, []string{"/apisix/scripts/r1"})
+	assert.Nil(t, err)
+
+	// reload from the file
+	s2, err := NewStandaloneStorage(filePath, "/apisix")
+	assert.Nil(t, err)
+	defer s2.Close()
+	ret, _, err := s2.List(ctx, "/apisix/")
+	assert.Nil(t, err)
+	assert.Equal(t, []Keypair{
+		{Key: "/apisix/plugins/1", Value: `{"name":"limit-count"}`, Revision: 1},
+	}, ret)
+}
+
+func TestStandaloneStorage_Watch(t *testing.T) {
+	s, filePath := newTestStandaloneStorage(t, "routes:\n  - id: r1\n    uri: /hello\n#END\n")
+	ctx, cancel := context.WithCancel(context.TODO())
+	ch := s.Watch(ctx, "/apisix/routes", 0)
+
+	next := func() WatchResponse {
+		select {
+		case resp := <-ch:
+			return resp
+		case <-time.After(5 * time.Second):
+			t.Fatal("watch event timeout")
+		}
+		return WatchResponse{}
+	}
+
+	_, err := s.Create(context.TODO(), "/apisix/routes/r2", `{"id":"r2","uri":"/world"}`)
+	assert.Nil(t, err)
+	_, err = s.Create(context.TODO(), "/apisix/services/s1", `{"id":"s1"}`)
+	assert.Nil(t, err)
+	assert.Equal(t, WatchResponse{Events: []Event{{
+		Keypair: Keypair{Key: "/apisix/routes/r2", Value: `{"id":"r2","uri":"/world"}`, Revision: 2},
+		Type:    EventTypePut,
+	}}, Revision: 2}, next())
+
+	// edited by others
+	err = ioutil.WriteFile(filePath, []byte("routes:\n  - id: r1\n    uri: /hi\n#END\n"), 0644)
+	assert.Nil(t, err)
+	resp := next()
+	assert.ElementsMatch(t, []Event{
+		{Keypair: Keypair{Key: "/apisix/routes/r1", Value: `{"id":"r1","uri":"/hi"}`, Revision: 4}, Type: EventTypePut},
+		{Keypair: Keypair{Key: "/apisix/routes/r2", Revision: 4}, Type: EventTypeDelete},
+	}, resp.Events)
+
+	cancel()
+	for range ch {
+	}
+}
@@ -16,19 +16,106 @@
  */
 package storage
 
-import "context"
+import (
+	"context"
+	"errors"
+
+	"github.com/apisix/manager-api/internal/conf"
+)
+
+var (
+	// ErrRevisionMismatch is returned when a conditional write is rejected
+	// because the key has been modified since the expected revision.
+	ErrRevisionMismatch = errors.New("resource version mismatch")
+
+	// ErrCompacted is returned by a watch when the revision it starts from
+	// has been compacted, the keys must be listed again.
+	ErrCompacted = errors.New("required revision has been compacted")
+
+	// ErrTxnTooLarge is returned when a transaction carries more than
+	// MaxTxnOps operations.
+	ErrTxnTooLarge = errors.New("too many operations in a transaction")
+)
+
+// MaxTxnOps is the most operations a transaction can carry, etcd rejects
+// larger transactions. It's etcd.max_txn_ops of the config, which must
+// match --max-txn-ops of etcd, 128 by default.
+var MaxTxnOps = 128
+
+// InitStorage initializes the storage driver selected by the configuration.
+func InitStorage() error {
+	if conf.ETCDConfig.MaxTxnOps > 0 {
+		MaxTxnOps = conf.ETCDConfig.MaxTxnOps
+	}
+	switch conf.StorageConfig.Type {
+	case conf.StorageTypeStandalone:
+		return InitStandaloneStorage(conf.StorageConfig.Standalone.FilePath, conf.ETCDConfig.Prefix)
+	case conf.StorageTypeMemory:
+		return InitMemoryStorage(conf.StorageConfig.Memory.SnapshotFile)
+	default:
+		return InitETCDClient(conf.ETCDConfig)
+	}
+}
+
+// GenStorage returns the storage driver selected by the configuration.
+func GenStorage() Interface {
+	switch conf.StorageConfig.Type {
+	case conf.StorageTypeStandalone:
+		return standaloneStorage
+	case conf.StorageTypeMemory:
+		return memoryStorage
+	default:
+		return GenEtcdStorage()
+	}
+}
 
 type Interface interface {
 	Get(ctx context.Context, key string) (string, error)
-	List(ctx context.Context, key string) ([]Keypair, error)
-	Create(ctx context.Context, key, val string) error
-	Update(ctx context.Context, key, val string) error
+	// List returns the keys with the prefix, and the revision they are
+	// read at.
+	List(ctx context.Context, key string) ([]Keypair, int64, error)
+	// Create writes the key only if it does not exist yet, and returns
+	// the revision of the write.
+	Create(ctx context.Context, key, val string) (int64, error)
+	// Update writes the key and returns the revision of the write. When
+	// revision is greater than 0, the write only succeeds if the key was
+	// last modified at that revision.
+	Update(ctx context.Context, key, val string, revision int64) (int64, error)
 	BatchDelete(ctx context.Context, keys []string) error
-	Watch(ctx context.Context, key string) <-chan WatchResponse
+	// Delete removes a single key, guarded by revision the same way as Update.
+	Delete(ctx context.Context, key string, revision int64) error
+	// Txn applies all ops atomically: either every op is applied or none
+	// is. It returns the revision of the transaction. ops must not exceed
+	// MaxTxnOps.
+	Txn(ctx context.Context, ops []Op) (int64, error)
+	// Watch watches the keys with the prefix. When revision is greater than
+	// 0, the events since that revision are sent first, or ErrCompacted
+	// if they are no longer kept.
+	Watch(ctx context.Context, key string, revision int64) <-chan WatchResponse
+}
+
+type OpType int
+
+const (
+	// OpCreate puts the key only if it does not exist yet.
+	OpCreate OpType = iota
+	// OpUpdate puts the key, guarded by Revision if it is greater than 0.
+	OpUpdate
+	// OpDelete deletes the key, guarded by Revision if it is greater than 0.
+	OpDelete
+)
+
+type Op struct {
+	Type     OpType
+	Key      string
+	Value    string
+	Revision int64
 }
 
 type WatchResponse struct {
-	Events   []Event
+	Events []Event
+	// Revision is the revision of the storage when the response is sent
+	Revision int64
 	Error    error
 	Canceled bool
 }
@@ -36,6 +123,8 @@ type WatchResponse struct {
 type Keypair struct {
 	Key   string
 	Value string
+	// Revision is the revision at which the key was last modified.
+	Revision int64
 }
 
 type Event struct {
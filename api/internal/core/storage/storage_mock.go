@@ -42,12 +42,33 @@ func (_m *MockInterface) BatchDelete(ctx context.Context, keys []string) error {
 }
 
 // Create provides a mock function with given fields: ctx, key, val
-func (_m *MockInterface) Create(ctx context.Context, key string, val string) error {
+func (_m *MockInterface) Create(ctx context.Context, key string, val string) (int64, error) {
 	ret := _m.Called(ctx, key, val)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) int64); ok {
 		r0 = rf(ctx, key, val)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, key, val)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Delete provides a mock function with given fields: ctx, key, revision
+func (_m *MockInterface) Delete(ctx context.Context, key string, revision int64) error {
+	ret := _m.Called(ctx, key, revision)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64) error); ok {
+		r0 = rf(ctx, key, revision)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -77,7 +98,7 @@ func (_m *MockInterface) Get(ctx context.Context, key string) (string, error) {
 }
 
 // List provides a mock function with given fields: ctx, key
-func (_m *MockInterface) List(ctx context.Context, key string) ([]Keypair, error) {
+func (_m *MockInterface) List(ctx context.Context, key string) ([]Keypair, int64, error) {
 	ret := _m.Called(ctx, key)
 
 	var r0 []Keypair
@@ -89,9 +110,37 @@ func (_m *MockInterface) List(ctx context.Context, key string) ([]Keypair, error
 		}
 	}
 
-	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+	var r1 int64
+	if rf, ok := ret.Get(1).(func(context.Context, string) int64); ok {
 		r1 = rf(ctx, key)
+	} else {
+		r1 = ret.Get(1).(int64)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, string) error); ok {
+		r2 = rf(ctx, key)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// Txn provides a mock function with given fields: ctx, ops
+func (_m *MockInterface) Txn(ctx context.Context, ops []Op) (int64, error) {
+	ret := _m.Called(ctx, ops)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, []Op) int64); ok {
+		r0 = rf(ctx, ops)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []Op) error); ok {
+		r1 = rf(ctx, ops)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -99,27 +148,34 @@ func (_m *MockInterface) List(ctx context.Context, key string) ([]Keypair, error
 	return r0, r1
 }
 
-// Update provides a mock function with given fields: ctx, key, val
-func (_m *MockInterface) Update(ctx context.Context, key string, val string) error {
-	ret := _m.Called(ctx, key, val)
+// Update provides a mock function with given fields: ctx, key, val, revision
+func (_m *MockInterface) Update(ctx context.Context, key string, val string, revision int64) (int64, error) {
+	ret := _m.Called(ctx, key, val, revision)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
-		r0 = rf(ctx, key, val)
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, int64) int64); ok {
+		r0 = rf(ctx, key, val, revision)
 	} else {
-		r0 = ret.Error(0)
+		r0 = ret.Get(0).(int64)
 	}
 
-	return r0
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, int64) error); ok {
+		r1 = rf(ctx, key, val, revision)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
-// Watch provides a mock function with given fields: ctx, key
-func (_m *MockInterface) Watch(ctx context.Context, key string) <-chan WatchResponse {
-	ret := _m.Called(ctx, key)
+// Watch provides a mock function with given fields: ctx, key, revision
+func (_m *MockInterface) Watch(ctx context.Context, key string, revision int64) <-chan WatchResponse {
+	ret := _m.Called(ctx, key, revision)
 
 	var r0 <-chan WatchResponse
-	if rf, ok := ret.Get(0).(func(context.Context, string) <-chan WatchResponse); ok {
-		r0 = rf(ctx, key)
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64) <-chan WatchResponse); ok {
+		r0 = rf(ctx, key, revision)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(<-chan WatchResponse)
@@ -0,0 +1,237 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/log"
+	"github.com/apisix/manager-api/internal/utils"
+	"github.com/apisix/manager-api/internal/utils/runtime"
+)
+
+type clusterKey struct{}
+
+var (
+	clustersLock sync.RWMutex
+	// clusters are the hubs of the clusters other than the local one
+	clusters = map[string]Hub{}
+	// starting are the last errors of the clusters whose hubs are not
+	// started yet
+	starting = map[string]error{}
+)
+
+var errNotStarted = errors.New("not connected yet")
+
+// ClusterStatus is the health of a cluster, it's healthy when all of its
+// stores are in sync with its storage.
+type ClusterStatus struct {
+	Name    string       `json:"name"`
+	Healthy bool         `json:"healthy"`
+	Stores  []SyncStatus `json:"stores"`
+	// LastError is why the cluster isn't connected yet
+	LastError string `json:"last_error,omitempty"`
+}
+
+// InitClusters connects to the clusters of conf.Clusters in the background,
+// so that an unreachable cluster doesn't keep the manager from starting.
+// A cluster is retried until its stores are initialized, it's unhealthy
+// and can't be read or written until then.
+func InitClusters() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	for _, c := range conf.Clusters {
+		c := c
+		setStarting(c.Name, errNotStarted)
+		wg.Add(1)
+		go func() {
+			defer runtime.HandlePanic()
+			defer wg.Done()
+			var stg *storage.EtcdV3Storage
+			hub, ok := startCluster(ctx, c.Name, func() (Hub, error) {
+				var err error
+				if stg, err = storage.NewEtcdStorage(&c.Etcd); err != nil {
+					return nil, err
+				}
+				hub, err := NewHub(stg, c.Etcd.Prefix, c.Etcd.DataPrefix)
+				if err != nil {
+					_ = stg.Close()
+				}
+				return hub, err
+			})
+			if !ok {
+				return
+			}
+			<-ctx.Done()
+			_ = hub.Close()
+			_ = stg.Close()
+		}()
+	}
+	utils.AppendToClosers(func() error {
+		cancel()
+		wg.Wait()
+		return nil
+	})
+	return nil
+}
+
+// startCluster connects to the cluster until it succeeds or the context is
+// done, the delay between the attempts doubles the same as the watches.
+func startCluster(ctx context.Context, name string, connect func() (Hub, error)) (Hub, bool) {
+	backoff := watchMinBackoff
+	for {
+		hub, err := connect()
+		if err == nil {
+			AddCluster(name, hub)
+			log.Infof("cluster %s is connected", name)
+			return hub, true
+		}
+		setStarting(name, err)
+		log.Warnf("cluster %s: %s, retry in %s", name, err, backoff)
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > watchMaxBackoff {
+			backoff = watchMaxBackoff
+		}
+	}
+}
+
+func setStarting(name string, err error) {
+	clustersLock.Lock()
+	defer clustersLock.Unlock()
+	starting[name] = err
+}
+
+// ClusterError returns why the cluster isn't connected yet, nil for the
+// clusters connected and the unknown ones.
+func ClusterError(name string) error {
+	clustersLock.RLock()
+	defer clustersLock.RUnlock()
+	return starting[name]
+}
+
+// AddCluster adds the hub of a cluster, the stores of GetStore pass the
+// calls on to it for the contexts of WithCluster.
+func AddCluster(name string, hub Hub) {
+	clustersLock.Lock()
+	defer clustersLock.Unlock()
+	clusters[name] = hub
+	delete(starting, name)
+}
+
+// RemoveCluster removes the hub of a cluster, it's not closed.
+func RemoveCluster(name string) {
+	clustersLock.Lock()
+	defer clustersLock.Unlock()
+	delete(clusters, name)
+	delete(starting, name)
+}
+
+// GetCluster returns the hub of a cluster, the hub of GetStore for the
+// local cluster.
+func GetCluster(name string) (Hub, bool) {
+	if name == "" || name == conf.ClusterLocal {
+		return storeHub, true
+	}
+	clustersLock.RLock()
+	defer clustersLock.RUnlock()
+	hub, ok := clusters[name]
+	return hub, ok
+}
+
+// ClusterNames returns the names of all the clusters, the local one first.
+// The clusters not connected yet are included.
+func ClusterNames() []string {
+	clustersLock.RLock()
+	defer clustersLock.RUnlock()
+	ret := make([]string, 0, len(clusters)+len(starting))
+	for name := range clusters {
+		ret = append(ret, name)
+	}
+	for name := range starting {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return append([]string{conf.ClusterLocal}, ret...)
+}
+
+// ClusterStatuses returns the health of all the clusters, the local one
+// first.
+func ClusterStatuses() []ClusterStatus {
+	names := ClusterNames()
+	ret := make([]ClusterStatus, 0, len(names))
+	for _, name := range names {
+		hub, ok := GetCluster(name)
+		if !ok {
+			if err := ClusterError(name); err != nil {
+				ret = append(ret, ClusterStatus{Name: name, Stores: []SyncStatus{}, LastError: err.Error()})
+			}
+			continue
+		}
+		status := ClusterStatus{Name: name, Healthy: true, Stores: hub.SyncStatuses()}
+		for _, s := range status.Stores {
+			if !s.Synced {
+				status.Healthy = false
+			}
+		}
+		ret = append(ret, status)
+	}
+	return ret
+}
+
+// WithCluster returns a context for which the stores of GetStore read and
+// write the cluster, the local cluster is empty.
+func WithCluster(ctx context.Context, name string) context.Context {
+	if name == conf.ClusterLocal {
+		name = ""
+	}
+	return context.WithValue(ctx, clusterKey{}, name)
+}
+
+// ClusterFromContext returns the cluster set by WithCluster, it's empty for
+// the local cluster.
+func ClusterFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(clusterKey{}).(string)
+	return name
+}
+
+// route returns the store of the cluster in the context, which is s itself
+// for the local cluster and the stores out of GetStore.
+func (s *GenericStore) route(ctx context.Context) *GenericStore {
+	if !s.routing {
+		return s
+	}
+	name := ClusterFromContext(ctx)
+	if name == "" {
+		return s
+	}
+	clustersLock.RLock()
+	defer clustersLock.RUnlock()
+	if c, ok := clusters[name][s.opt.HubKey]; ok {
+		return c
+	}
+	return s
+}
@@ -0,0 +1,61 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/storage"
+)
+
+type dryRunKey struct{}
+
+// WithDryRun returns a context where the writes of the stores go through
+// all the checks, but are not applied.
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// IsDryRun reports whether the writes are not applied in the context.
+func IsDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
+
+// checkRevisions checks the expected versions of the ops against the
+// cache, which is what the storage would do if they were applied.
+func checkRevisions(ops []*Op) error {
+	for _, op := range ops {
+		if op.Revision <= 0 || op.owner == nil {
+			continue
+		}
+		var version int64
+		if obj, ok := op.owner.cache.Load(op.key); ok {
+			if v, ok := obj.(entity.Versioned); ok {
+				version = v.GetResourceVersion()
+			}
+		} else if item, ok := op.owner.quarantine.Load(op.key); ok {
+			version = item.(*QuarantineItem).Revision
+		}
+		if version != op.Revision {
+			return fmt.Errorf("key: %s: %w", op.key, storage.ErrRevisionMismatch)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/apisix/manager-api/internal/core/audit"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/log"
+	"github.com/apisix/manager-api/internal/utils"
+)
+
+const (
+	RevisionActionCreate = "create"
+	RevisionActionUpdate = "update"
+	RevisionActionDelete = "delete"
+)
+
+// Revision is a change recorded in the history of an object.
+type Revision struct {
+	// Revision is the storage revision of the change, which is the
+	// resource_version of the object after it
+	Revision int64  `json:"revision,omitempty"`
+	Action   string `json:"action"`
+	User     string `json:"user,omitempty"`
+	Time     int64  `json:"time"`
+	// Object is the object after the change, or before it for a delete
+	Object json.RawMessage `json:"object"`
+}
+
+type userKey struct{}
+
+// WithUser returns a context carrying the user making the changes.
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFromContext returns the user set by WithUser.
+func UserFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+func (s *GenericStore) historyEnabled() bool {
+	return s.opt.HistoryPath != "" && s.opt.HistoryLimit > 0
+}
+
+func (s *GenericStore) historyPrefix(key string) string {
+	return fmt.Sprintf("%s/%s/", s.opt.HistoryPath, key)
+}
+
+// historyOp returns the write recording the change of the object, which
+// is committed along with the change. The keys of a history are ordered
+// by the time, the storage revision is only known after the commit.
+func (s *GenericStore) historyOp(ctx context.Context, key, action string, value []byte) *storage.Op {
+	if !s.historyEnabled() {
+		return nil
+	}
+
+	bs, err := json.Marshal(Revision{
+		Action: action,
+		User:   UserFromContext(ctx),
+		Time:   time.Now().Unix(),
+		Object: value,
+	})
+	if err != nil {
+		log.Errorf("json marshal failed: %s", err)
+		return nil
+	}
+	return &storage.Op{
+		Type:  storage.OpCreate,
+		Key:   fmt.Sprintf("%s%020d", s.historyPrefix(key), utils.GetFlakeUid()),
+		Value: string(bs),
+	}
+}
+
+// pruneHistory drops the oldest revisions beyond the limit, it's best
+// effort and a failure only leaves more revisions than the limit.
+func (s *GenericStore) pruneHistory(ctx context.Context, key string) {
+	ret, _, err := s.Stg.List(ctx, s.historyPrefix(key))
+	if err != nil {
+		log.Warnf("list history of key: %s failed: %s", key, err)
+		return
+	}
+	if len(ret) <= s.opt.HistoryLimit {
+		return
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key < ret[j].Key
+	})
+	var keys []string
+	for i := 0; i < len(ret)-s.opt.HistoryLimit; i++ {
+		keys = append(keys, ret[i].Key)
+	}
+	if err := s.Stg.BatchDelete(ctx, keys); err != nil {
+		log.Warnf("prune history of key: %s failed: %s", key, err)
+	}
+}
+
+// History returns the recorded revisions of the object, the latest first.
+func (s *GenericStore) History(ctx context.Context, key string) ([]*Revision, error) {
+	if c := s.route(ctx); c != s {
+		return c.History(ctx, key)
+	}
+	if !s.historyEnabled() {
+		return nil, fmt.Errorf("history of %s is not enabled", s.opt.HubKey)
+	}
+
+	ret, _, err := s.Stg.List(ctx, s.historyPrefix(key))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key > ret[j].Key
+	})
+
+	revisions := make([]*Revision, 0, len(ret))
+	for i := range ret {
+		revision := &Revision{}
+		if err := json.Unmarshal([]byte(ret[i].Value), revision); err != nil {
+			log.Warnf("json unmarshal revision of key: %s failed: %s", ret[i].Key, err)
+			continue
+		}
+		revision.Revision = ret[i].Revision
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
+
+// GetRevision returns the object at the recorded revision. It's not
+// checked against the current state, which the caller restoring it must do
+// the same as writing it through the API of its type.
+func (s *GenericStore) GetRevision(ctx context.Context, key string, revision int64) (interface{}, error) {
+	if c := s.route(ctx); c != s {
+		return c.GetRevision(ctx, key, revision)
+	}
+	revisions, err := s.History(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range revisions {
+		if r.Revision == revision {
+			return s.StringToObjPtr(string(r.Object), key)
+		}
+	}
+	return nil, fmt.Errorf("revision %d is not found", revision)
+}
+
+// marshalCopy marshals a copy of the cached object, which must not be
+// changed by marshal.
+func marshalCopy(obj interface{}) ([]byte, error) {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr {
+		return marshal(obj)
+	}
+	cp := reflect.New(v.Elem().Type())
+	cp.Elem().Set(v.Elem())
+	return marshal(cp.Interface())
+}
+
+// recordOps records the committed writes to the audit, with the secrets
+// redacted.
+func recordOps(ctx context.Context, ops []*Op) {
+	if !audit.Enabled() {
+		return
+	}
+
+	user, cluster := UserFromContext(ctx), ClusterFromContext(ctx)
+	entries := make([]*audit.Entry, 0, len(ops))
+	for _, op := range ops {
+		if op.owner == nil {
+			continue
+		}
+		// the entries leave the storage, keep the secrets out of them
+		typ := op.owner.opt.HubKey
+		e := &audit.Entry{
+			User:       user,
+			Cluster:    cluster,
+			Resource:   string(typ),
+			ResourceID: op.key,
+			Action:     op.action,
+			Before:     RedactJSON(typ, op.before),
+		}
+		if op.Type != storage.OpDelete {
+			e.After = RedactJSON(typ, []byte(op.Value))
+		}
+		entries = append(entries, e)
+	}
+	audit.Record(ctx, entries...)
+}
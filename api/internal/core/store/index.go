@@ -0,0 +1,141 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/utils"
+)
+
+const (
+	IndexName           = "name"
+	IndexUpstreamID     = "upstream_id"
+	IndexServiceID      = "service_id"
+	IndexPluginConfigID = "plugin_config_id"
+	// IndexLabel indexes every label by both "key" and "key:value"
+	IndexLabel = "label"
+	// IndexProtoID indexes the protos used by the grpc-transcode plugin
+	IndexProtoID = "proto_id"
+)
+
+// IndexFunc returns the values an object is indexed by, empty values are
+// not indexed.
+type IndexFunc func(obj interface{}) []string
+
+// IndexLookup returns the objects with the value in the index, ordered by
+// their keys.
+func (s *GenericStore) IndexLookup(ctx context.Context, index, value string) ([]interface{}, error) {
+	if c := s.route(ctx); c != s {
+		return c.IndexLookup(ctx, index, value)
+	}
+	if _, ok := s.opt.Indexes[index]; !ok {
+		return nil, fmt.Errorf("index %s is not defined", index)
+	}
+
+	s.cacheLock.RLock()
+	defer s.cacheLock.RUnlock()
+
+	keys := make([]string, 0, len(s.indices[index][value]))
+	for key := range s.indices[index][value] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ret := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		if obj, ok := s.cache.Load(key); ok {
+			ret = append(ret, obj)
+		}
+	}
+	return ret, nil
+}
+
+// cacheStore puts the object into the cache and keeps the indexes.
+func (s *GenericStore) cacheStore(key string, obj interface{}) {
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+
+	if old, ok := s.cache.Load(key); ok {
+		s.unindex(key, old)
+	}
+	s.cache.Store(key, obj)
+	s.index(key, obj)
+	s.snapshot = nil
+}
+
+// cacheDelete removes the object from the cache and the indexes.
+func (s *GenericStore) cacheDelete(key string) {
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+
+	if old, ok := s.cache.Load(key); ok {
+		s.unindex(key, old)
+	}
+	s.cache.Delete(key)
+	s.snapshot = nil
+}
+
+func (s *GenericStore) index(key string, obj interface{}) {
+	for name, f := range s.opt.Indexes {
+		for _, value := range f(obj) {
+			if value == "" {
+				continue
+			}
+			if s.indices == nil {
+				s.indices = map[string]map[string]map[string]struct{}{}
+			}
+			if s.indices[name] == nil {
+				s.indices[name] = map[string]map[string]struct{}{}
+			}
+			if s.indices[name][value] == nil {
+				s.indices[name][value] = map[string]struct{}{}
+			}
+			s.indices[name][value][key] = struct{}{}
+		}
+	}
+}
+
+func (s *GenericStore) unindex(key string, obj interface{}) {
+	for name, f := range s.opt.Indexes {
+		for _, value := range f(obj) {
+			delete(s.indices[name][value], key)
+			if len(s.indices[name][value]) == 0 {
+				delete(s.indices[name], value)
+			}
+		}
+	}
+}
+
+func labelIndex(labels map[string]string) []string {
+	ret := make([]string, 0, len(labels)*2)
+	for k, v := range labels {
+		ret = append(ret, k, k+":"+v)
+	}
+	return ret
+}
+
+func protoIDIndex(obj interface{}) []string {
+	conf, ok := obj.(entity.GetPlugins).GetPlugins()["grpc-transcode"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return []string{utils.InterfaceToString(conf["proto_id"])}
+}
@@ -0,0 +1,168 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/log"
+)
+
+// QuarantineItem is an entry of the storage which can't be loaded into the
+// store, such as a malformed object written by another tool. It's kept out
+// of the cache until it's repaired or deleted, and the rest of the store
+// keeps working.
+type QuarantineItem struct {
+	Resource HubKey `json:"resource"`
+	Key      string `json:"key"`
+	// Value is the raw value in the storage, which may carry secrets such
+	// as the private key of an SSL, see WithoutValue. Error is why it
+	// can't be loaded
+	Value string `json:"value,omitempty"`
+	Error string `json:"error"`
+	// Size is the length of Value
+	Size int `json:"size"`
+	// Revision is the storage revision the entry was last modified at
+	Revision int64 `json:"revision"`
+	// Time is when it's quarantined
+	Time int64 `json:"time"`
+}
+
+// WithoutValue returns a copy of the item without the raw value. The value
+// is malformed, so the secrets in it can't be redacted reliably.
+func (item *QuarantineItem) WithoutValue() *QuarantineItem {
+	c := *item
+	c.Value = ""
+	return &c
+}
+
+// quarantineStore quarantines the entry instead of loading it, the object
+// of the key loaded before is removed from the cache.
+func (s *GenericStore) quarantineStore(key string, kv storage.Keypair, err error) {
+	log.Errorf("quarantine key: %s of %s: %s", key, s.opt.HubKey, err)
+	s.cacheDelete(key)
+	s.quarantine.Store(key, &QuarantineItem{
+		Resource: s.opt.HubKey,
+		Key:      key,
+		Value:    kv.Value,
+		Error:    err.Error(),
+		Size:     len(kv.Value),
+		Revision: kv.Revision,
+		Time:     time.Now().Unix(),
+	})
+}
+
+// ListQuarantine returns the quarantined entries ordered by the keys.
+func (s *GenericStore) ListQuarantine(ctx context.Context) []*QuarantineItem {
+	if c := s.route(ctx); c != s {
+		return c.ListQuarantine(ctx)
+	}
+
+	items := make([]*QuarantineItem, 0)
+	s.quarantine.Range(func(_, value interface{}) bool {
+		items = append(items, value.(*QuarantineItem))
+		return true
+	})
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Key < items[j].Key
+	})
+	return items
+}
+
+func (s *GenericStore) getQuarantine(key string) (*QuarantineItem, error) {
+	item, ok := s.quarantine.Load(key)
+	if !ok {
+		log.Warnf("key: %s is not found in the quarantine", key)
+		return nil, fmt.Errorf("key: %s is not found in the quarantine", key)
+	}
+	return item.(*QuarantineItem), nil
+}
+
+// PrepareRepair runs the same checks as Create on the object of the value,
+// and returns the write replacing the quarantined entry of the key with it.
+// The write fails if the entry is modified since it's quarantined.
+func (s *GenericStore) PrepareRepair(ctx context.Context, key, value string) (*Op, error) {
+	if c := s.route(ctx); c != s {
+		return c.PrepareRepair(ctx, key, value)
+	}
+	item, err := s.getQuarantine(key)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := s.StringToObjPtr(value, key)
+	if err != nil {
+		return nil, err
+	}
+	if k := s.opt.KeyFunc(obj); k != key {
+		return nil, fmt.Errorf("key: %s doesn't match the key of the object: %s", key, k)
+	}
+	if setter, ok := obj.(entity.GetBaseInfo); ok {
+		info := setter.GetBaseInfo()
+		info.Creating()
+	}
+	if err := s.ingestValidate(obj); err != nil {
+		return nil, err
+	}
+
+	bs, err := marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &Op{
+		Op: storage.Op{
+			Type:     storage.OpUpdate,
+			Key:      s.GetStorageKey(key),
+			Value:    string(bs),
+			Revision: item.Revision,
+		},
+		obj:     obj,
+		history: s.historyOp(ctx, key, RevisionActionUpdate, bs),
+		owner:   s,
+		key:     key,
+		action:  RevisionActionUpdate,
+	}, nil
+}
+
+// PrepareDiscard returns the write deleting the quarantined entry of the
+// key, which isn't kept in the trash. The write fails if the entry is
+// modified since it's quarantined.
+func (s *GenericStore) PrepareDiscard(ctx context.Context, key string) (*Op, error) {
+	if c := s.route(ctx); c != s {
+		return c.PrepareDiscard(ctx, key)
+	}
+	item, err := s.getQuarantine(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Op{
+		Op: storage.Op{
+			Type:     storage.OpDelete,
+			Key:      s.GetStorageKey(key),
+			Revision: item.Revision,
+		},
+		owner:  s,
+		key:    key,
+		action: RevisionActionDelete,
+	}, nil
+}
@@ -0,0 +1,251 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// ListQuery is the query shared by all the list endpoints, the fields are
+// the top level JSON fields of the objects.
+type ListQuery struct {
+	SortBy string `json:"sort_by" form:"sort_by" auto_read:"sort_by"`
+	// Order is asc or desc, asc by default
+	Order string `json:"order" form:"order" auto_read:"order"`
+	// Fields is the comma separated fields to return, all by default
+	Fields        string `json:"fields" form:"fields" auto_read:"fields"`
+	LabelSelector string `json:"label_selector" form:"label_selector" auto_read:"label_selector"`
+}
+
+type compiledQuery struct {
+	selector Selector
+	// labels, sortBy are the indexes of the struct fields, labels is nil
+	// when the objects have no labels
+	labels []int
+	sortBy []int
+	desc   bool
+	fields []string
+}
+
+func (s *GenericStore) compileQuery(q ListQuery) (*compiledQuery, error) {
+	ret := &compiledQuery{}
+
+	switch q.Order {
+	case "", OrderAsc:
+	case OrderDesc:
+		ret.desc = true
+	default:
+		return nil, fmt.Errorf("order %s is invalid", q.Order)
+	}
+
+	if q.SortBy != "" {
+		index, ok := s.fieldIndex(q.SortBy)
+		if !ok || !sortable(s.opt.ObjType.FieldByIndex(index).Type.Kind()) {
+			return nil, fmt.Errorf("sort_by %s is invalid", q.SortBy)
+		}
+		ret.sortBy = index
+	}
+
+	if q.Fields != "" {
+		for _, field := range strings.Split(q.Fields, ",") {
+			field = strings.TrimSpace(field)
+			if _, ok := s.fieldIndex(field); !ok {
+				return nil, fmt.Errorf("fields %s is invalid", q.Fields)
+			}
+			ret.fields = append(ret.fields, field)
+		}
+	}
+
+	if q.LabelSelector != "" {
+		selector, err := ParseSelector(q.LabelSelector)
+		if err != nil {
+			return nil, err
+		}
+		ret.selector = selector
+		if index, ok := s.fieldIndex("labels"); ok {
+			ret.labels = index
+		}
+	}
+
+	return ret, nil
+}
+
+// ordered reports whether the query changes the default order.
+func (q *compiledQuery) ordered() bool {
+	return q.sortBy != nil || q.desc
+}
+
+func (q *compiledQuery) matches(obj interface{}) bool {
+	if q.selector == nil {
+		return true
+	}
+	var labels map[string]string
+	if q.labels != nil {
+		labels, _ = reflect.Indirect(reflect.ValueOf(obj)).FieldByIndex(q.labels).Interface().(map[string]string)
+	}
+	return q.selector.Matches(labels)
+}
+
+func (q *compiledQuery) less(i, j *snapshotItem) bool {
+	c := 0
+	if q.sortBy != nil {
+		c = compareValues(
+			reflect.Indirect(reflect.ValueOf(i.obj)).FieldByIndex(q.sortBy),
+			reflect.Indirect(reflect.ValueOf(j.obj)).FieldByIndex(q.sortBy),
+		)
+	}
+	if c == 0 {
+		c = i.compare(j)
+	}
+	if q.desc {
+		c = -c
+	}
+	return c < 0
+}
+
+// project keeps only the fields asked for of the row.
+func (q *compiledQuery) project(row interface{}) (interface{}, error) {
+	if q.fields == nil {
+		return row, nil
+	}
+
+	data, err := json.Marshal(row)
+	if err != nil {
+		return nil, fmt.Errorf("json marshal failed: %s", err)
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("json unmarshal failed: %s", err)
+	}
+
+	ret := make(map[string]json.RawMessage, len(q.fields))
+	for _, field := range q.fields {
+		if v, ok := all[field]; ok {
+			ret[field] = v
+		}
+	}
+	return ret, nil
+}
+
+func (s *GenericStore) fieldIndex(name string) ([]int, bool) {
+	if s.opt.ObjType == nil || s.opt.ObjType.Kind() != reflect.Struct {
+		return nil, false
+	}
+	return jsonFieldIndex(s.opt.ObjType, name)
+}
+
+// jsonFieldIndex returns the index of the struct field encoded as name,
+// the fields of the embedded structs are looked up as encoding/json does.
+func jsonFieldIndex(t reflect.Type, name string) ([]int, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			if index, ok := jsonFieldIndex(f.Type, name); ok {
+				return append([]int{i}, index...), true
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		if tag == name {
+			return []int{i}, true
+		}
+	}
+	return nil, false
+}
+
+func sortable(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Bool, reflect.String, reflect.Interface,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// compareValues compares the scalar values, empty interfaces go first and
+// values of different kinds are compared as strings.
+func compareValues(a, b reflect.Value) int {
+	for a.Kind() == reflect.Interface && !a.IsNil() {
+		a = a.Elem()
+	}
+	for b.Kind() == reflect.Interface && !b.IsNil() {
+		b = b.Elem()
+	}
+	aNil := a.Kind() == reflect.Interface
+	bNil := b.Kind() == reflect.Interface
+	switch {
+	case aNil && bNil:
+		return 0
+	case aNil:
+		return -1
+	case bNil:
+		return 1
+	}
+
+	if af, ok := toFloat(a); ok {
+		if bf, ok := toFloat(b); ok {
+			switch {
+			case af < bf:
+				return -1
+			case af > bf:
+				return 1
+			}
+			return 0
+		}
+	}
+	if a.Kind() == reflect.Bool && b.Kind() == reflect.Bool {
+		switch {
+		case a.Bool() == b.Bool():
+			return 0
+		case b.Bool():
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(fmt.Sprint(a.Interface()), fmt.Sprint(b.Interface()))
+}
+
+func toFloat(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
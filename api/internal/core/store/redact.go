@@ -0,0 +1,81 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/core/entity"
+)
+
+// Redact removes the secrets from the object in place, the same as the SSL
+// API hides the private keys: the private keys of an SSL, and the fields of
+// the consumer plugins listed in the encrypt_fields of data_encryption, such
+// as the key of key-auth. The object must not be one in the cache.
+func Redact(obj interface{}) {
+	switch o := obj.(type) {
+	case *entity.SSL:
+		o.Key = ""
+		o.Keys = nil
+	case *entity.Consumer:
+		redactPlugins(o.Plugins)
+	}
+}
+
+// RedactJSON is Redact on the JSON of an object of the type, the data is
+// returned as is when it's not an object.
+func RedactJSON(typ HubKey, data []byte) []byte {
+	if typ != HubKeySsl && typ != HubKeyConsumer {
+		return data
+	}
+
+	obj := map[string]interface{}{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// keep the numbers as they are
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return data
+	}
+	switch typ {
+	case HubKeySsl:
+		delete(obj, "key")
+		delete(obj, "keys")
+	case HubKeyConsumer:
+		plugins, _ := obj["plugins"].(map[string]interface{})
+		redactPlugins(plugins)
+	}
+
+	bs, err := json.Marshal(obj)
+	if err != nil {
+		return data
+	}
+	return bs
+}
+
+func redactPlugins(plugins map[string]interface{}) {
+	for name, fields := range conf.DataEncryptionConfig.EncryptFields {
+		plugin, ok := plugins[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, field := range fields {
+			delete(plugin, field)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type selectorOp int
+
+const (
+	selectorExists selectorOp = iota
+	selectorNotExists
+	selectorEquals
+	selectorNotEquals
+	selectorIn
+	selectorNotIn
+)
+
+var (
+	selectorKeyPattern = regexp.MustCompile(`^[^\s!=(),]+$`)
+	// the values may be empty, the same as Kubernetes
+	selectorValuePattern = regexp.MustCompile(`^[^\s!=(),]*$`)
+	selectorSetPattern   = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+)
+
+type requirement struct {
+	key    string
+	op     selectorOp
+	values map[string]struct{}
+}
+
+// Selector is a label selector in the syntax of Kubernetes, such as
+// `env in (prod,staging),!deprecated,team!=core`. All the requirements
+// must match.
+type Selector []requirement
+
+// ParseSelector parses the comma separated requirements, which are one of
+// `key`, `!key`, `key=value`, `key==value`, `key!=value`,
+// `key in (v1,v2)` and `key notin (v1,v2)`.
+func ParseSelector(selector string) (Selector, error) {
+	var ret Selector
+	for _, expr := range splitSelector(selector) {
+		expr = strings.TrimSpace(expr)
+		if expr == "" {
+			continue
+		}
+		r, ok := parseRequirement(expr)
+		if !ok {
+			return nil, fmt.Errorf("label selector %s is invalid", selector)
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+// splitSelector splits the requirements by the commas out of parentheses.
+func splitSelector(selector string) []string {
+	var ret []string
+	depth, start := 0, 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				ret = append(ret, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(ret, selector[start:])
+}
+
+func parseRequirement(expr string) (requirement, bool) {
+	var r requirement
+	if m := selectorSetPattern.FindStringSubmatch(expr); m != nil {
+		r.key, r.op = m[1], selectorIn
+		if m[2] == "notin" {
+			r.op = selectorNotIn
+		}
+		r.values = map[string]struct{}{}
+		for _, v := range strings.Split(m[3], ",") {
+			v = strings.TrimSpace(v)
+			if !selectorValuePattern.MatchString(v) {
+				return r, false
+			}
+			r.values[v] = struct{}{}
+		}
+		return r, selectorKeyPattern.MatchString(r.key)
+	}
+
+	var value string
+	switch {
+	case strings.HasPrefix(expr, "!") && !strings.Contains(expr, "="):
+		r.key, r.op = strings.TrimSpace(expr[1:]), selectorNotExists
+		return r, selectorKeyPattern.MatchString(r.key)
+	case strings.Contains(expr, "!="):
+		r.op = selectorNotEquals
+		r.key, value = splitRequirement(expr, "!=")
+	case strings.Contains(expr, "=="):
+		r.op = selectorEquals
+		r.key, value = splitRequirement(expr, "==")
+	case strings.Contains(expr, "="):
+		r.op = selectorEquals
+		r.key, value = splitRequirement(expr, "=")
+	default:
+		r.key, r.op = expr, selectorExists
+		return r, selectorKeyPattern.MatchString(r.key)
+	}
+
+	r.values = map[string]struct{}{value: {}}
+	return r, selectorKeyPattern.MatchString(r.key) && selectorValuePattern.MatchString(value)
+}
+
+func splitRequirement(expr, op string) (string, string) {
+	kv := strings.SplitN(expr, op, 2)
+	return strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+}
+
+// Matches reports whether the labels meet all the requirements.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		value, exists := labels[r.key]
+		_, in := r.values[value]
+		switch r.op {
+		case selectorExists:
+			if !exists {
+				return false
+			}
+		case selectorNotExists:
+			if exists {
+				return false
+			}
+		case selectorEquals, selectorIn:
+			if !exists || !in {
+				return false
+			}
+		case selectorNotEquals, selectorNotIn:
+			if exists && in {
+				return false
+			}
+		}
+	}
+	return true
+}
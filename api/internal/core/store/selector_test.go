@@ -0,0 +1,68 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package store
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelector_Matches(t *testing.T) {
+	labels := map[string]string{"env": "prod", "team": "core", "stage": ""}
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{selector: "", want: true},
+		{selector: "env", want: true},
+		{selector: "!env", want: false},
+		{selector: "!deprecated", want: true},
+		{selector: "env=prod", want: true},
+		{selector: "env==prod", want: true},
+		{selector: "env = staging", want: false},
+		{selector: "team!=core", want: false},
+		{selector: "owner!=core", want: true},
+		{selector: "env in (prod, staging)", want: true},
+		{selector: "env in (staging)", want: false},
+		{selector: "env notin (prod,staging)", want: false},
+		{selector: "owner notin (prod)", want: true},
+		{selector: "env in (prod,staging),!deprecated,team!=core", want: false},
+		{selector: "env in (prod,staging),!deprecated,team!=web", want: true},
+		{selector: "stage=", want: true},
+		{selector: "env=", want: false},
+		{selector: "owner!=", want: true},
+		{selector: "stage!=", want: false},
+		{selector: "env in (prod,)", want: true},
+		{selector: "stage in (a,)", want: true},
+		{selector: "stage notin ()", want: false},
+	}
+
+	for _, tc := range tests {
+		selector, err := ParseSelector(tc.selector)
+		assert.Nil(t, err, tc.selector)
+		assert.Equal(t, tc.want, selector.Matches(labels), tc.selector)
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	for _, selector := range []string{"=prod", "=", "env in prod", "!", "env in (a b)", "env!", "env=a=b"} {
+		_, err := ParseSelector(selector)
+		assert.Equal(t, fmt.Errorf("label selector %s is invalid", selector), err, selector)
+	}
+}
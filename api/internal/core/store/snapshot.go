@@ -0,0 +1,213 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/utils"
+)
+
+// snapshotItem is an object in the order of the lists, by the create time,
+// the update time, then the key.
+type snapshotItem struct {
+	CreateTime int64  `json:"c"`
+	UpdateTime int64  `json:"u"`
+	Key        string `json:"k"`
+	obj        interface{}
+}
+
+func newSnapshotItem(key string, obj interface{}) snapshotItem {
+	item := snapshotItem{Key: key, obj: obj}
+	if t, ok := obj.(entity.Timestamped); ok {
+		item.CreateTime = t.GetCreateTime()
+		item.UpdateTime = t.GetUpdateTime()
+	}
+	return item
+}
+
+func objID(obj interface{}) string {
+	switch obj := obj.(type) {
+	case entity.GetBaseInfo:
+		return utils.InterfaceToString(obj.GetBaseInfo().ID)
+	case *entity.Consumer:
+		return obj.Username
+	case *entity.SystemConfig:
+		return obj.ConfigName
+	}
+	return ""
+}
+
+func (i *snapshotItem) less(j *snapshotItem) bool {
+	return i.compare(j) < 0
+}
+
+func (i *snapshotItem) compare(j *snapshotItem) int {
+	switch {
+	case i.CreateTime != j.CreateTime:
+		return compareInt64(i.CreateTime, j.CreateTime)
+	case i.UpdateTime != j.UpdateTime:
+		return compareInt64(i.UpdateTime, j.UpdateTime)
+	}
+	return strings.Compare(i.Key, j.Key)
+}
+
+func compareInt64(a, b int64) int {
+	if a < b {
+		return -1
+	}
+	return 1
+}
+
+// sortedSnapshot returns the objects in order, the snapshot is rebuilt
+// only after the cache changed. The returned slice must not be modified.
+func (s *GenericStore) sortedSnapshot() []snapshotItem {
+	s.cacheLock.RLock()
+	defer s.cacheLock.RUnlock()
+	s.snapshotLock.Lock()
+	defer s.snapshotLock.Unlock()
+
+	if s.snapshot != nil {
+		return s.snapshot
+	}
+
+	items := make([]snapshotItem, 0)
+	s.cache.Range(func(key, value interface{}) bool {
+		items = append(items, newSnapshotItem(key.(string), value))
+		return true
+	})
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].less(&items[j])
+	})
+	s.snapshot = items
+	return items
+}
+
+// listSnapshot lists the objects in the order of the snapshot, or of the
+// query when it has one, only the rows of the page are formatted.
+func (s *GenericStore) listSnapshot(input ListInput, query *compiledQuery) (*ListOutput, error) {
+	items := s.sortedSnapshot()
+	predicate := input.Predicate
+
+	if query.ordered() {
+		if input.Cursor != "" {
+			return nil, fmt.Errorf("cursor is invalid with a custom order")
+		}
+		var matched []snapshotItem
+		for i := range items {
+			if predicate == nil || predicate(items[i].obj) {
+				matched = append(matched, items[i])
+			}
+		}
+		sort.Slice(matched, func(i, j int) bool {
+			return query.less(&matched[i], &matched[j])
+		})
+		items, predicate = matched, nil
+	}
+
+	// start is the position of the first item after the cursor
+	start := 0
+	if input.Cursor != "" {
+		after, err := decodeCursor(input.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		start = sort.Search(len(items), func(i int) bool {
+			return after.less(&items[i])
+		})
+	}
+
+	limit, skip := 0, 0
+	if input.PageSize > 0 && input.Cursor != "" {
+		limit = input.PageSize
+	} else if input.PageSize > 0 && input.PageNumber > 0 {
+		limit = input.PageSize
+		skip = (input.PageNumber - 1) * input.PageSize
+	}
+
+	// without the total, a cursor page is scanned from the cursor and
+	// stops at the first item after the page
+	counting := input.Cursor == "" || input.WithTotal
+	begin := 0
+	if !counting {
+		begin = start
+	}
+
+	output := &ListOutput{Rows: []interface{}{}}
+	var last *snapshotItem
+	for i := begin; i < len(items); i++ {
+		if predicate != nil && !predicate(items[i].obj) {
+			continue
+		}
+		if counting {
+			output.TotalSize++
+		}
+		if i < start {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if limit > 0 && len(output.Rows) == limit {
+			// the cursor only works in the order of the snapshot
+			if !query.ordered() {
+				output.NextCursor = encodeCursor(last)
+			}
+			if !counting {
+				break
+			}
+			continue
+		}
+
+		value := items[i].obj
+		if input.Format != nil {
+			value = input.Format(value)
+		}
+		value, err := query.project(value)
+		if err != nil {
+			return nil, err
+		}
+		output.Rows = append(output.Rows, value)
+		last = &items[i]
+	}
+
+	return output, nil
+}
+
+func encodeCursor(item *snapshotItem) string {
+	// marshalling the exported fields of snapshotItem never fails
+	data, _ := json.Marshal(item)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (*snapshotItem, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("cursor %s is invalid", cursor)
+	}
+	var item snapshotItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return nil, fmt.Errorf("cursor %s is invalid", cursor)
+	}
+	return &item, nil
+}
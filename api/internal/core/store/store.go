@@ -20,8 +20,8 @@ package store
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
 	"reflect"
 	"sort"
 	"sync"
@@ -29,20 +29,29 @@ import (
 
 	"github.com/shiningrush/droplet/data"
 
+	"github.com/apisix/manager-api/internal/core/audit"
+	"github.com/apisix/manager-api/internal/core/encryption"
 	"github.com/apisix/manager-api/internal/core/entity"
 	"github.com/apisix/manager-api/internal/core/storage"
 	"github.com/apisix/manager-api/internal/log"
-	"github.com/apisix/manager-api/internal/utils"
 	"github.com/apisix/manager-api/internal/utils/runtime"
 )
 
 var (
-	storeNeedReInit = make([]*GenericStore, 0)
+	// watchMinBackoff and watchMaxBackoff bound the delay before a closed
+	// watch is resumed, the delay doubles on every failed attempt.
+	watchMinBackoff = 500 * time.Millisecond
+	watchMaxBackoff = 30 * time.Second
 )
 
 type Pagination struct {
 	PageSize   int `json:"page_size" form:"page_size" auto_read:"page_size"`
 	PageNumber int `json:"page" form:"page" auto_read:"page"`
+	// Cursor is the next_cursor of the previous page, the page number is
+	// ignored when it's set
+	Cursor string `json:"cursor" form:"cursor" auto_read:"cursor"`
+	// WithTotal counts the total size of a cursor page, which is 0 otherwise
+	WithTotal bool `json:"with_total" form:"with_total" auto_read:"with_total"`
 }
 
 type Interface interface {
@@ -52,6 +61,92 @@ type Interface interface {
 	Create(ctx context.Context, obj interface{}) (interface{}, error)
 	Update(ctx context.Context, obj interface{}, createIfNotExist bool) (interface{}, error)
 	BatchDelete(ctx context.Context, keys []string) error
+	// PrepareCreate, PrepareUpdate and PrepareDelete run the same checks
+	// as Create, Update and BatchDelete, but return the write as an Op
+	// instead of applying it, so that it can be committed with Commit.
+	PrepareCreate(ctx context.Context, obj interface{}) (*Op, error)
+	PrepareUpdate(ctx context.Context, obj interface{}, createIfNotExist bool) (*Op, error)
+	PrepareDelete(ctx context.Context, key string) (*Op, error)
+	// Commit applies ops, which may be prepared by other stores, atomically.
+	Commit(ctx context.Context, ops []*Op) error
+	// IndexLookup returns the objects with the value in the named index.
+	IndexLookup(ctx context.Context, index, value string) ([]interface{}, error)
+	// History returns the recorded revisions of the object, the latest first.
+	History(ctx context.Context, key string) ([]*Revision, error)
+	// GetRevision returns the object at the recorded revision.
+	GetRevision(ctx context.Context, key string, revision int64) (interface{}, error)
+	// ListTrash, GetTrash and PurgeTrash read and remove the deleted
+	// objects, PrepareUndelete recreates one of them.
+	ListTrash(ctx context.Context) ([]*TrashItem, error)
+	GetTrash(ctx context.Context, key string) (interface{}, error)
+	PrepareUndelete(ctx context.Context, obj interface{}) (*Op, error)
+	PurgeTrash(ctx context.Context, keys []string) error
+	// ListQuarantine returns the entries which can't be loaded, and
+	// PrepareRepair and PrepareDiscard replace and delete one of them.
+	ListQuarantine(ctx context.Context) []*QuarantineItem
+	PrepareRepair(ctx context.Context, key, value string) (*Op, error)
+	PrepareDiscard(ctx context.Context, key string) (*Op, error)
+}
+
+// Op is a write prepared by a store.
+type Op struct {
+	storage.Op
+	obj interface{}
+	// history records the write, it's nil when the store keeps no history
+	history *storage.Op
+	// trash moves the deleted object into the trash, or removes the
+	// recreated object from it
+	trash  *storage.Op
+	owner  *GenericStore
+	key    string
+	action string
+	// before is the object before the write, only kept for the audit and
+	// the history
+	before []byte
+}
+
+// size returns the number of storage operations the write takes.
+func (op *Op) size() int {
+	n := 1
+	if op.history != nil {
+		n++
+	}
+	if op.trash != nil {
+		n++
+	}
+	return n
+}
+
+// checkTxnSize rejects the ops taking more storage operations than a
+// transaction can carry.
+func checkTxnSize(ops []*Op) error {
+	n := 0
+	for i := range ops {
+		n += ops[i].size()
+	}
+	if n > storage.MaxTxnOps {
+		return fmt.Errorf("%w: %d writes take %d operations, more than the limit of %d set by etcd.max_txn_ops",
+			storage.ErrTxnTooLarge, len(ops), n, storage.MaxTxnOps)
+	}
+	return nil
+}
+
+// splitOps splits the ops in order into the parts within
+// storage.MaxTxnOps.
+func splitOps(ops []*Op) [][]*Op {
+	var parts [][]*Op
+	start, n := 0, 0
+	for i, op := range ops {
+		if size := op.size(); n+size > storage.MaxTxnOps && i > start {
+			parts = append(parts, ops[start:i])
+			start, n = i, 0
+		}
+		n += op.size()
+	}
+	if start < len(ops) {
+		parts = append(parts, ops[start:])
+	}
+	return parts
 }
 
 type GenericStore struct {
@@ -60,9 +155,39 @@ type GenericStore struct {
 
 	cache sync.Map
 	opt   GenericStoreOption
+	// quarantine keeps the entries which can't be loaded into the cache
+	quarantine sync.Map
+
+	// cacheLock guards the changes of the cache, the indices and the
+	// snapshot. indices maps the index name and value to the keys of the
+	// objects, snapshot keeps the objects in order, nil when it's stale.
+	cacheLock    sync.RWMutex
+	indices      map[string]map[string]map[string]struct{}
+	snapshotLock sync.Mutex
+	snapshot     []snapshotItem
+
+	cancel      context.CancelFunc
+	trashCancel context.CancelFunc
+
+	statusLock sync.RWMutex
+	status     SyncStatus
+
+	// routing is true for the stores of GetStore, which pass the calls on
+	// to the stores of the cluster in the context
+	routing bool
+}
 
-	cancel  context.CancelFunc
-	closing bool
+// SyncStatus reports how a store keeps up with the storage.
+type SyncStatus struct {
+	Resource HubKey `json:"resource"`
+	// Synced is false from the moment the watch closes until it's resumed
+	Synced bool `json:"synced"`
+	// Revision is the last revision observed from the storage
+	Revision     int64 `json:"revision"`
+	LastSyncTime int64 `json:"last_sync_time"`
+	// Retries is the number of attempts to resume since the last success
+	Retries   int    `json:"retries"`
+	LastError string `json:"last_error,omitempty"`
 }
 
 type GenericStoreOption struct {
@@ -72,6 +197,33 @@ type GenericStoreOption struct {
 	StockCheck func(obj interface{}, stockObj interface{}) error
 	Validator  Validator
 	HubKey     HubKey
+	// Indexes are looked up with IndexLookup by their names
+	Indexes map[string]IndexFunc
+	// HistoryPath is where the revisions are recorded, at most HistoryLimit
+	// revisions are kept for every object. No history is kept when either
+	// of them is unset.
+	HistoryPath  string
+	HistoryLimit int
+	// TrashPath is where the deleted objects are kept for TrashRetention,
+	// they are deleted for good when either of them is unset.
+	TrashPath      string
+	TrashRetention time.Duration
+}
+
+type expectedVersionKey struct{}
+
+// WithExpectedVersion returns a context that makes the next Update or
+// BatchDelete on a store conditional: the write only succeeds if the
+// stored object is still at the given resource version.
+func WithExpectedVersion(ctx context.Context, version int64) context.Context {
+	return context.WithValue(ctx, expectedVersionKey{}, version)
+}
+
+func expectedVersion(ctx context.Context) int64 {
+	if version, ok := ctx.Value(expectedVersionKey{}).(int64); ok {
+		return version
+	}
+	return 0
 }
 
 func NewGenericStore(opt GenericStoreOption) (*GenericStore, error) {
@@ -98,31 +250,57 @@ func NewGenericStore(opt GenericStoreOption) (*GenericStore, error) {
 	s := &GenericStore{
 		opt: opt,
 	}
-	s.Stg = storage.GenEtcdStorage()
+	s.Stg = storage.GenStorage()
 
 	return s, nil
 }
 
-func ReInit() error {
-	for _, store := range storeNeedReInit {
-		if err := store.Init(); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 func (s *GenericStore) Init() error {
 	s.initLock.Lock()
 	defer s.initLock.Unlock()
 	return s.listAndWatch()
 }
 
+// SyncStatus returns the sync status of the store.
+func (s *GenericStore) SyncStatus() SyncStatus {
+	s.statusLock.RLock()
+	defer s.statusLock.RUnlock()
+	status := s.status
+	status.Resource = s.opt.HubKey
+	return status
+}
+
+func (s *GenericStore) updateStatus(f func(status *SyncStatus)) {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	f(&s.status)
+}
+
+// observe records the revision seen from the storage.
+func (s *GenericStore) observe(revision int64) {
+	s.updateStatus(func(status *SyncStatus) {
+		if revision > status.Revision {
+			status.Revision = revision
+		}
+		status.LastSyncTime = time.Now().Unix()
+		status.Retries = 0
+		status.LastError = ""
+	})
+}
+
 func (s *GenericStore) Type() HubKey {
 	return s.opt.HubKey
 }
 
-func (s *GenericStore) Get(_ context.Context, key string) (interface{}, error) {
+// BasePath returns the storage path the objects are kept under.
+func (s *GenericStore) BasePath() string {
+	return s.opt.BasePath
+}
+
+func (s *GenericStore) Get(ctx context.Context, key string) (interface{}, error) {
+	if c := s.route(ctx); c != s {
+		return c.Get(ctx, key)
+	}
 	ret, ok := s.cache.Load(key)
 	if !ok {
 		log.Warnf("data not found by key: %s", key)
@@ -137,12 +315,22 @@ type ListInput struct {
 	PageSize  int
 	// start from 1
 	PageNumber int
-	Less       func(i, j interface{}) bool
+	// Cursor continues the listing after the page it was returned with, it
+	// can't be used with Less or a query changing the order
+	Cursor string
+	// WithTotal counts TotalSize with Cursor, the listing stops at the end
+	// of the page otherwise
+	WithTotal bool
+	Less      func(i, j interface{}) bool
+	// Query filters, orders and projects the rows on top of the above
+	Query ListQuery
 }
 
 type ListOutput struct {
 	Rows      []interface{} `json:"rows"`
 	TotalSize int           `json:"total_size"`
+	// NextCursor is set when there are more rows after this page
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 // NewListOutput returns JSON marshalling safe struct pointer for empty slice
@@ -150,21 +338,38 @@ func NewListOutput() *ListOutput {
 	return &ListOutput{Rows: make([]interface{}, 0)}
 }
 
+// defLessFunc orders the objects the same way as the snapshot, when their
+// keys are their ids.
 var defLessFunc = func(i, j interface{}) bool {
-	iBase := i.(entity.GetBaseInfo).GetBaseInfo()
-	jBase := j.(entity.GetBaseInfo).GetBaseInfo()
-	if iBase.CreateTime != jBase.CreateTime {
-		return iBase.CreateTime < jBase.CreateTime
+	iItem := newSnapshotItem(objID(i), i)
+	jItem := newSnapshotItem(objID(j), j)
+	return iItem.less(&jItem)
+}
+
+func (s *GenericStore) List(ctx context.Context, input ListInput) (*ListOutput, error) {
+	if c := s.route(ctx); c != s {
+		return c.List(ctx, input)
 	}
-	if iBase.UpdateTime != jBase.UpdateTime {
-		return iBase.UpdateTime < jBase.UpdateTime
+	query, err := s.compileQuery(input.Query)
+	if err != nil {
+		return nil, err
+	}
+	if predicate := input.Predicate; query.selector != nil {
+		input.Predicate = func(obj interface{}) bool {
+			return query.matches(obj) && (predicate == nil || predicate(obj))
+		}
+	}
+
+	if input.Less == nil {
+		return s.listSnapshot(input, query)
+	}
+	if input.Cursor != "" {
+		return nil, fmt.Errorf("cursor is invalid with a custom order")
+	}
+	if query.ordered() {
+		return nil, fmt.Errorf("sort_by and order are invalid with a custom order")
 	}
-	iID := utils.InterfaceToString(iBase.ID)
-	jID := utils.InterfaceToString(jBase.ID)
-	return iID < jID
-}
 
-func (s *GenericStore) List(_ context.Context, input ListInput) (*ListOutput, error) {
 	var ret []interface{}
 	s.cache.Range(func(key, value interface{}) bool {
 		if input.Predicate != nil && !input.Predicate(value) {
@@ -186,9 +391,6 @@ func (s *GenericStore) List(_ context.Context, input ListInput) (*ListOutput, er
 		Rows:      ret,
 		TotalSize: len(ret),
 	}
-	if input.Less == nil {
-		input.Less = defLessFunc
-	}
 
 	sort.Slice(output.Rows, func(i, j int) bool {
 		return input.Less(output.Rows[i], output.Rows[j])
@@ -196,28 +398,44 @@ func (s *GenericStore) List(_ context.Context, input ListInput) (*ListOutput, er
 
 	if input.PageSize > 0 && input.PageNumber > 0 {
 		skipCount := (input.PageNumber - 1) * input.PageSize
-		if skipCount > output.TotalSize {
-			output.Rows = []interface{}{}
-			return output, nil
-		}
-
 		endIdx := skipCount + input.PageSize
-		if endIdx >= output.TotalSize {
+		switch {
+		case skipCount > output.TotalSize:
+			output.Rows = []interface{}{}
+		case endIdx >= output.TotalSize:
 			output.Rows = ret[skipCount:]
-			return output, nil
+		default:
+			output.Rows = ret[skipCount:endIdx]
 		}
-		output.Rows = ret[skipCount:endIdx]
 	}
 
+	for i := range output.Rows {
+		if output.Rows[i], err = query.project(output.Rows[i]); err != nil {
+			return nil, err
+		}
+	}
 	return output, nil
 }
 
-func (s *GenericStore) Range(_ context.Context, f func(key string, obj interface{}) bool) {
+func (s *GenericStore) Range(ctx context.Context, f func(key string, obj interface{}) bool) {
+	if c := s.route(ctx); c != s {
+		c.Range(ctx, f)
+		return
+	}
 	s.cache.Range(func(key, value interface{}) bool {
 		return f(key.(string), value)
 	})
 }
 
+// Validate checks the object against the current schema of the store,
+// such as an object stored before the schema is changed.
+func (s *GenericStore) Validate(obj interface{}) error {
+	if s.opt.Validator == nil {
+		return nil
+	}
+	return s.opt.Validator.Validate(obj)
+}
+
 func (s *GenericStore) ingestValidate(obj interface{}) (err error) {
 	if s.opt.Validator != nil {
 		if err := s.opt.Validator.Validate(obj); err != nil {
@@ -237,8 +455,10 @@ func (s *GenericStore) ingestValidate(obj interface{}) (err error) {
 	return err
 }
 
-func (s *GenericStore) CreateCheck(obj interface{}) ([]byte, error) {
-
+func (s *GenericStore) CreateCheck(ctx context.Context, obj interface{}) ([]byte, error) {
+	if c := s.route(ctx); c != s {
+		return c.CreateCheck(ctx, obj)
+	}
 	if setter, ok := obj.(entity.GetBaseInfo); ok {
 		info := setter.GetBaseInfo()
 		info.Creating()
@@ -258,34 +478,108 @@ func (s *GenericStore) CreateCheck(obj interface{}) ([]byte, error) {
 		return nil, fmt.Errorf("key: %s is conflicted", key)
 	}
 
-	bytes, err := json.Marshal(obj)
-	if err != nil {
-		log.Errorf("json marshal failed: %s", err)
-		return nil, fmt.Errorf("json marshal failed: %s", err)
-	}
-
-	return bytes, nil
+	return marshal(obj)
 }
 
-func (s *GenericStore) Create(ctx context.Context, obj interface{}) (interface{}, error) {
+func (s *GenericStore) PrepareCreate(ctx context.Context, obj interface{}) (*Op, error) {
+	if c := s.route(ctx); c != s {
+		return c.PrepareCreate(ctx, obj)
+	}
 	if setter, ok := obj.(entity.GetBaseInfo); ok {
 		info := setter.GetBaseInfo()
 		info.Creating()
 	}
 
-	bytes, err := s.CreateCheck(obj)
+	bytes, err := s.CreateCheck(ctx, obj)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := s.Stg.Create(ctx, s.GetObjStorageKey(obj), string(bytes)); err != nil {
+	key := s.opt.KeyFunc(obj)
+	return &Op{
+		Op: storage.Op{
+			Type:  storage.OpCreate,
+			Key:   s.GetObjStorageKey(obj),
+			Value: string(bytes),
+		},
+		obj:     obj,
+		history: s.historyOp(ctx, key, RevisionActionCreate, bytes),
+		owner:   s,
+		key:     key,
+		action:  RevisionActionCreate,
+	}, nil
+}
+
+func (s *GenericStore) Create(ctx context.Context, obj interface{}) (interface{}, error) {
+	if c := s.route(ctx); c != s {
+		return c.Create(ctx, obj)
+	}
+	op, err := s.PrepareCreate(ctx, obj)
+	if err != nil {
 		return nil, err
 	}
+	if IsDryRun(ctx) {
+		return obj, nil
+	}
+
+	if op.history != nil {
+		if err := s.Commit(ctx, []*Op{op}); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	}
+
+	revision, err := s.Stg.Create(ctx, op.Key, op.Value)
+	if err != nil {
+		return nil, err
+	}
+	setResourceVersion(obj, revision)
+	recordOps(ctx, []*Op{op})
 
 	return obj, nil
 }
 
 func (s *GenericStore) Update(ctx context.Context, obj interface{}, createIfNotExist bool) (interface{}, error) {
+	if c := s.route(ctx); c != s {
+		return c.Update(ctx, obj, createIfNotExist)
+	}
+	op, err := s.PrepareUpdate(ctx, obj, createIfNotExist)
+	if err != nil {
+		return nil, err
+	}
+	if IsDryRun(ctx) {
+		if err := checkRevisions([]*Op{op}); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	}
+
+	if op.history != nil {
+		if err := s.Commit(ctx, []*Op{op}); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	}
+
+	var revision int64
+	if op.Type == storage.OpCreate {
+		revision, err = s.Stg.Create(ctx, op.Key, op.Value)
+	} else {
+		revision, err = s.Stg.Update(ctx, op.Key, op.Value, op.Revision)
+	}
+	if err != nil {
+		return nil, err
+	}
+	setResourceVersion(obj, revision)
+	recordOps(ctx, []*Op{op})
+
+	return obj, nil
+}
+
+func (s *GenericStore) PrepareUpdate(ctx context.Context, obj interface{}, createIfNotExist bool) (*Op, error) {
+	if c := s.route(ctx); c != s {
+		return c.PrepareUpdate(ctx, obj, createIfNotExist)
+	}
 	if err := s.ingestValidate(obj); err != nil {
 		return nil, err
 	}
@@ -296,8 +590,12 @@ func (s *GenericStore) Update(ctx context.Context, obj interface{}, createIfNotE
 	}
 	storedObj, ok := s.cache.Load(key)
 	if !ok {
+		if createIfNotExist && expectedVersion(ctx) > 0 {
+			log.Warnf("key: %s is expected to exist", key)
+			return nil, fmt.Errorf("key: %s: %w", key, storage.ErrRevisionMismatch)
+		}
 		if createIfNotExist {
-			return s.Create(ctx, obj)
+			return s.PrepareCreate(ctx, obj)
 		}
 		log.Warnf("key: %s is not found", key)
 		return nil, fmt.Errorf("key: %s is not found", key)
@@ -310,19 +608,124 @@ func (s *GenericStore) Update(ctx context.Context, obj interface{}, createIfNotE
 		info.Updating(storedInfo)
 	}
 
-	bs, err := json.Marshal(obj)
+	bs, err := marshal(obj)
 	if err != nil {
-		log.Errorf("json marshal failed: %s", err)
-		return nil, fmt.Errorf("json marshal failed: %s", err)
-	}
-	if err := s.Stg.Update(ctx, s.GetObjStorageKey(obj), string(bs)); err != nil {
 		return nil, err
 	}
 
-	return obj, nil
+	var before []byte
+	if audit.Enabled() {
+		if before, err = marshalCopy(storedObj); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Op{
+		Op: storage.Op{
+			Type:     storage.OpUpdate,
+			Key:      s.GetObjStorageKey(obj),
+			Value:    string(bs),
+			Revision: expectedVersion(ctx),
+		},
+		obj:     obj,
+		history: s.historyOp(ctx, key, RevisionActionUpdate, bs),
+		owner:   s,
+		key:     key,
+		action:  RevisionActionUpdate,
+		before:  before,
+	}, nil
+}
+
+func (s *GenericStore) PrepareDelete(ctx context.Context, key string) (*Op, error) {
+	if c := s.route(ctx); c != s {
+		return c.PrepareDelete(ctx, key)
+	}
+	storedObj, ok := s.cache.Load(key)
+	if !ok {
+		log.Warnf("key: %s is not found", key)
+		return nil, fmt.Errorf("key: %s is not found", key)
+	}
+
+	op := &Op{
+		Op: storage.Op{
+			Type:     storage.OpDelete,
+			Key:      s.GetStorageKey(key),
+			Revision: expectedVersion(ctx),
+		},
+		owner:  s,
+		key:    key,
+		action: RevisionActionDelete,
+	}
+	if s.historyEnabled() || s.trashEnabled() || audit.Enabled() {
+		bs, err := marshalCopy(storedObj)
+		if err != nil {
+			return nil, err
+		}
+		op.before = bs
+		op.history = s.historyOp(ctx, key, RevisionActionDelete, bs)
+		op.trash = s.trashOp(ctx, key, bs)
+	}
+	return op, nil
+}
+
+func (s *GenericStore) Commit(ctx context.Context, ops []*Op) error {
+	if c := s.route(ctx); c != s {
+		return c.Commit(ctx, ops)
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	if err := checkTxnSize(ops); err != nil {
+		return err
+	}
+	if IsDryRun(ctx) {
+		return checkRevisions(ops)
+	}
+
+	stgOps := make([]storage.Op, 0, len(ops))
+	for i := range ops {
+		stgOps = append(stgOps, ops[i].Op)
+	}
+	for i := range ops {
+		if ops[i].history != nil {
+			stgOps = append(stgOps, *ops[i].history)
+		}
+		if ops[i].trash != nil {
+			stgOps = append(stgOps, *ops[i].trash)
+		}
+	}
+	revision, err := s.Stg.Txn(ctx, stgOps)
+	if err != nil {
+		return err
+	}
+
+	for i := range ops {
+		if ops[i].obj != nil {
+			setResourceVersion(ops[i].obj, revision)
+		}
+		if ops[i].history != nil {
+			ops[i].owner.pruneHistory(ctx, ops[i].key)
+		}
+	}
+	recordOps(ctx, ops)
+	return nil
 }
 
 func (s *GenericStore) BatchDelete(ctx context.Context, keys []string) error {
+	if c := s.route(ctx); c != s {
+		return c.BatchDelete(ctx, keys)
+	}
+	if s.historyEnabled() || s.trashEnabled() || audit.Enabled() || IsDryRun(ctx) {
+		return s.batchDeleteTxn(ctx, keys)
+	}
+
+	if version := expectedVersion(ctx); version > 0 {
+		if len(keys) != 1 {
+			return fmt.Errorf("resource version is invalid when deleting multiple keys")
+		}
+		return s.Stg.Delete(ctx, s.GetStorageKey(keys[0]), version)
+	}
+
 	var storageKeys []string
 	for i := range keys {
 		storageKeys = append(storageKeys, s.GetStorageKey(keys[i]))
@@ -331,59 +734,126 @@ func (s *GenericStore) BatchDelete(ctx context.Context, keys []string) error {
 	return s.Stg.BatchDelete(ctx, storageKeys)
 }
 
+// batchDeleteTxn deletes the keys in transactions, along with recording
+// their revisions and audit entries, and moving them into the trash. The
+// keys are deleted in parts when there are too many for a transaction,
+// those deleted before a failed part stay deleted.
+func (s *GenericStore) batchDeleteTxn(ctx context.Context, keys []string) error {
+	if expectedVersion(ctx) > 0 && len(keys) != 1 {
+		return fmt.Errorf("resource version is invalid when deleting multiple keys")
+	}
+
+	ops := make([]*Op, 0, len(keys))
+	for i := range keys {
+		op, err := s.PrepareDelete(ctx, keys[i])
+		if err != nil {
+			return err
+		}
+		ops = append(ops, op)
+	}
+	for _, part := range splitOps(ops) {
+		if err := s.Commit(ctx, part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *GenericStore) listAndWatch() error {
+	// stop the watch of the last Init
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if err := s.relist(); err != nil {
+		return err
+	}
+
+	// start watch
+	s.cancel = s.watch()
+
+	return nil
+}
+
+// relist loads all the objects into the cache, and drops those which are
+// no longer there.
+func (s *GenericStore) relist() error {
 	lc, lcancel := context.WithTimeout(context.TODO(), 5*time.Second)
 	defer lcancel()
-	ret, err := s.Stg.List(lc, s.opt.BasePath)
+	ret, revision, err := s.Stg.List(lc, s.opt.BasePath)
 	if err != nil {
 		return err
 	}
+	listed := make(map[string]bool, len(ret))
+	quarantined := map[string]bool{}
 	for i := range ret {
 		key := ret[i].Key[len(s.opt.BasePath)+1:]
 		objPtr, err := s.StringToObjPtr(ret[i].Value, key)
 		if err != nil {
-			_, _ = fmt.Fprintf(os.Stderr, "Error occurred while initializing logical store: %s, err: %v", s.opt.BasePath, err)
-			return err
+			// a malformed entry mustn't keep the others from being loaded
+			quarantined[key] = true
+			s.quarantineStore(key, ret[i], err)
+			continue
 		}
+		setResourceVersion(objPtr, ret[i].Revision)
 
-		s.cache.Store(s.opt.KeyFunc(objPtr), objPtr)
+		listed[s.opt.KeyFunc(objPtr)] = true
+		s.cacheStore(s.opt.KeyFunc(objPtr), objPtr)
 	}
+	s.cache.Range(func(key, _ interface{}) bool {
+		if !listed[key.(string)] {
+			s.cacheDelete(key.(string))
+		}
+		return true
+	})
+	s.quarantine.Range(func(key, _ interface{}) bool {
+		if !quarantined[key.(string)] {
+			s.quarantine.Delete(key)
+		}
+		return true
+	})
 
-	// start watch
-	s.cancel = s.watch()
-
+	s.observe(revision)
 	return nil
 }
 
+// watch keeps watching the storage until the store is closed. A closed
+// watch is resumed from the last observed revision, and only when the
+// revision has been compacted, everything is listed again.
 func (s *GenericStore) watch() context.CancelFunc {
 	c, cancel := context.WithCancel(context.TODO())
-	ch := s.Stg.Watch(c, s.opt.BasePath)
 	go func() {
-		defer func() {
-			if !s.closing {
-				log.Errorf("etcd watch exception closed, restarting: resource: %s", s.Type())
-				storeNeedReInit = append(storeNeedReInit, s)
-			}
-		}()
 		defer runtime.HandlePanic()
-		for event := range ch {
-			if event.Canceled {
-				log.Warnf("etcd watch failed: %s", event.Error)
+		backoff := watchMinBackoff
+		for {
+			received, err := s.watchOnce(c)
+			if c.Err() != nil {
 				return
 			}
+			if received {
+				backoff = watchMinBackoff
+			}
+
+			log.Errorf("watch exception closed, resuming: resource: %s, error: %s", s.Type(), err)
+			s.updateStatus(func(status *SyncStatus) {
+				status.Synced = false
+				status.Retries++
+				status.LastError = err.Error()
+			})
+			select {
+			case <-time.After(backoff):
+			case <-c.Done():
+				return
+			}
+			if backoff *= 2; backoff > watchMaxBackoff {
+				backoff = watchMaxBackoff
+			}
 
-			for i := range event.Events {
-				switch event.Events[i].Type {
-				case storage.EventTypePut:
-					key := event.Events[i].Key[len(s.opt.BasePath)+1:]
-					objPtr, err := s.StringToObjPtr(event.Events[i].Value, key)
-					if err != nil {
-						log.Warnf("value convert to obj failed: %s", err)
-						continue
-					}
-					s.cache.Store(key, objPtr)
-				case storage.EventTypeDelete:
-					s.cache.Delete(event.Events[i].Key[len(s.opt.BasePath)+1:])
+			if errors.Is(err, storage.ErrCompacted) {
+				if err := s.relist(); err != nil {
+					log.Errorf("store relist failed: resource: %s, error: %s", s.Type(), err)
+					s.updateStatus(func(status *SyncStatus) {
+						status.LastError = err.Error()
+					})
 				}
 			}
 		}
@@ -391,9 +861,64 @@ func (s *GenericStore) watch() context.CancelFunc {
 	return cancel
 }
 
+// watchOnce applies the events of a single watch until it closes, and
+// tells if any event is received.
+func (s *GenericStore) watchOnce(ctx context.Context) (bool, error) {
+	var revision int64
+	s.statusLock.RLock()
+	if s.status.Revision > 0 {
+		revision = s.status.Revision + 1
+	}
+	s.statusLock.RUnlock()
+
+	ch := s.Stg.Watch(ctx, s.opt.BasePath, revision)
+	s.updateStatus(func(status *SyncStatus) {
+		status.Synced = true
+	})
+
+	received := false
+	for event := range ch {
+		if event.Canceled {
+			log.Warnf("watch failed: %s", event.Error)
+			if event.Error != nil {
+				return received, event.Error
+			}
+			return received, fmt.Errorf("watch canceled")
+		}
+		received = true
+
+		revision := event.Revision
+		for i := range event.Events {
+			if event.Events[i].Revision > revision {
+				revision = event.Events[i].Revision
+			}
+			switch event.Events[i].Type {
+			case storage.EventTypePut:
+				key := event.Events[i].Key[len(s.opt.BasePath)+1:]
+				objPtr, err := s.StringToObjPtr(event.Events[i].Value, key)
+				if err != nil {
+					s.quarantineStore(key, event.Events[i].Keypair, err)
+					continue
+				}
+				setResourceVersion(objPtr, event.Events[i].Revision)
+				s.quarantine.Delete(key)
+				s.cacheStore(key, objPtr)
+			case storage.EventTypeDelete:
+				key := event.Events[i].Key[len(s.opt.BasePath)+1:]
+				s.quarantine.Delete(key)
+				s.cacheDelete(key)
+			}
+		}
+		s.observe(revision)
+	}
+	return received, fmt.Errorf("watch closed")
+}
+
 func (s *GenericStore) Close() error {
-	s.closing = true
 	s.cancel()
+	if s.trashCancel != nil {
+		s.trashCancel()
+	}
 	return nil
 }
 
@@ -405,6 +930,7 @@ func (s *GenericStore) StringToObjPtr(str, key string) (interface{}, error) {
 		log.Errorf("json unmarshal failed: %s", err)
 		return nil, fmt.Errorf("json unmarshal failed\n\tRelated Key:\t\t%s\n\tError Description:\t%s", key, err)
 	}
+	encryption.Decrypt(ret)
 
 	if setter, ok := ret.(entity.GetBaseInfo); ok {
 		info := setter.GetBaseInfo()
@@ -414,6 +940,30 @@ func (s *GenericStore) StringToObjPtr(str, key string) (interface{}, error) {
 	return ret, nil
 }
 
+// marshal encodes obj for storage, the resource version is a read-only
+// attribute taken from the storage revision so it's never persisted, and
+// the sensitive fields are encrypted.
+func marshal(obj interface{}) ([]byte, error) {
+	if v, ok := obj.(entity.Versioned); ok {
+		version := v.GetResourceVersion()
+		v.SetResourceVersion(0)
+		defer v.SetResourceVersion(version)
+	}
+
+	bytes, err := encryption.Marshal(obj)
+	if err != nil {
+		log.Errorf("json marshal failed: %s", err)
+		return nil, fmt.Errorf("json marshal failed: %s", err)
+	}
+	return bytes, nil
+}
+
+func setResourceVersion(obj interface{}, version int64) {
+	if v, ok := obj.(entity.Versioned); ok && version > 0 {
+		v.SetResourceVersion(version)
+	}
+}
+
 func (s *GenericStore) GetObjStorageKey(obj interface{}) string {
 	return s.GetStorageKey(s.opt.KeyFunc(obj))
 }
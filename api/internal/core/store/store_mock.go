@@ -78,3 +78,83 @@ func (m *MockInterface) BatchDelete(ctx context.Context, keys []string) error {
 	ret := m.Mock.Called(ctx, keys)
 	return ret.Error(0)
 }
+
+func (m *MockInterface) PrepareCreate(ctx context.Context, obj interface{}) (*Op, error) {
+	ret := m.Mock.Called(ctx, obj)
+	op, _ := ret.Get(0).(*Op)
+	return op, ret.Error(1)
+}
+
+func (m *MockInterface) PrepareUpdate(ctx context.Context, obj interface{}, createIfNotExist bool) (*Op, error) {
+	ret := m.Mock.Called(ctx, obj, createIfNotExist)
+	op, _ := ret.Get(0).(*Op)
+	return op, ret.Error(1)
+}
+
+func (m *MockInterface) PrepareDelete(ctx context.Context, key string) (*Op, error) {
+	ret := m.Mock.Called(ctx, key)
+	op, _ := ret.Get(0).(*Op)
+	return op, ret.Error(1)
+}
+
+func (m *MockInterface) Commit(ctx context.Context, ops []*Op) error {
+	ret := m.Mock.Called(ctx, ops)
+	return ret.Error(0)
+}
+
+func (m *MockInterface) IndexLookup(ctx context.Context, index, value string) ([]interface{}, error) {
+	ret := m.Mock.Called(ctx, index, value)
+	objs, _ := ret.Get(0).([]interface{})
+	return objs, ret.Error(1)
+}
+
+func (m *MockInterface) History(ctx context.Context, key string) ([]*Revision, error) {
+	ret := m.Mock.Called(ctx, key)
+	revisions, _ := ret.Get(0).([]*Revision)
+	return revisions, ret.Error(1)
+}
+
+func (m *MockInterface) GetRevision(ctx context.Context, key string, revision int64) (interface{}, error) {
+	ret := m.Mock.Called(ctx, key, revision)
+	return ret.Get(0), ret.Error(1)
+}
+
+func (m *MockInterface) ListTrash(ctx context.Context) ([]*TrashItem, error) {
+	ret := m.Mock.Called(ctx)
+	items, _ := ret.Get(0).([]*TrashItem)
+	return items, ret.Error(1)
+}
+
+func (m *MockInterface) GetTrash(ctx context.Context, key string) (interface{}, error) {
+	ret := m.Mock.Called(ctx, key)
+	return ret.Get(0), ret.Error(1)
+}
+
+func (m *MockInterface) PrepareUndelete(ctx context.Context, obj interface{}) (*Op, error) {
+	ret := m.Mock.Called(ctx, obj)
+	op, _ := ret.Get(0).(*Op)
+	return op, ret.Error(1)
+}
+
+func (m *MockInterface) PurgeTrash(ctx context.Context, keys []string) error {
+	ret := m.Mock.Called(ctx, keys)
+	return ret.Error(0)
+}
+
+func (m *MockInterface) ListQuarantine(ctx context.Context) []*QuarantineItem {
+	ret := m.Mock.Called(ctx)
+	items, _ := ret.Get(0).([]*QuarantineItem)
+	return items
+}
+
+func (m *MockInterface) PrepareRepair(ctx context.Context, key, value string) (*Op, error) {
+	ret := m.Mock.Called(ctx, key, value)
+	op, _ := ret.Get(0).(*Op)
+	return op, ret.Error(1)
+}
+
+func (m *MockInterface) PrepareDiscard(ctx context.Context, key string) (*Op, error) {
+	ret := m.Mock.Called(ctx, key)
+	op, _ := ret.Get(0).(*Op)
+	return op, ret.Error(1)
+}
@@ -19,6 +19,7 @@ package store
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
@@ -29,6 +30,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
+	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/core/audit"
+	"github.com/apisix/manager-api/internal/core/encryption"
 	"github.com/apisix/manager-api/internal/core/entity"
 	"github.com/apisix/manager-api/internal/core/storage"
 	"github.com/apisix/manager-api/internal/utils"
@@ -97,7 +101,7 @@ func TestNewGenericStore(t *testing.T) {
 			continue
 		}
 		assert.Equal(t, tc.wantStore.Stg, s.Stg)
-		assert.Equal(t, tc.wantStore.cache, s.cache)
+		assert.Equal(t, &tc.wantStore.cache, &s.cache)
 		assert.Equal(t, tc.wantStore.opt.BasePath, s.opt.BasePath)
 		assert.Equal(t, tc.wantStore.opt.ObjType, s.opt.ObjType)
 		assert.Equal(t, reflect.TypeOf(tc.wantStore.opt.KeyFunc), reflect.TypeOf(s.opt.KeyFunc))
@@ -120,6 +124,7 @@ func TestGenericStore_Init(t *testing.T) {
 		giveResp        storage.WatchResponse
 		wantErr         error
 		wantCache       map[string]interface{}
+		wantQuarantine  []string
 		wantListCalled  bool
 		wantWatchCalled bool
 	}{
@@ -140,8 +145,9 @@ func TestGenericStore_Init(t *testing.T) {
 					Value: `{"Field1":"demo1-f1", "Field2":"demo1-f2"}`,
 				},
 				{
-					Key:   "test/demo2-f1",
-					Value: `{"Field1":"demo2-f1", "Field2":"demo2-f2"}`,
+					Key:      "test/demo2-f1",
+					Value:    `{"Field1":"demo2-f1", "Field2":"demo2-f2"}`,
+					Revision: 5,
 				},
 			},
 			giveWatchCh: make(chan storage.WatchResponse),
@@ -149,8 +155,9 @@ func TestGenericStore_Init(t *testing.T) {
 				Events: []storage.Event{
 					{
 						Keypair: storage.Keypair{
-							Key:   "test/demo3-f1",
-							Value: `{"Field1":"demo3-f1", "Field2":"demo3-f2"}`,
+							Key:      "test/demo3-f1",
+							Value:    `{"Field1":"demo3-f1", "Field2":"demo3-f2"}`,
+							Revision: 7,
 						},
 						Type: storage.EventTypePut,
 					},
@@ -164,12 +171,12 @@ func TestGenericStore_Init(t *testing.T) {
 			},
 			wantCache: map[string]interface{}{
 				"demo2-f1": &TestStruct{
-					BaseInfo: entity.BaseInfo{ID: "demo2-f1"},
+					BaseInfo: entity.BaseInfo{ID: "demo2-f1", ResourceVersion: 5},
 					Field1:   "demo2-f1",
 					Field2:   "demo2-f2",
 				},
 				"demo3-f1": &TestStruct{
-					BaseInfo: entity.BaseInfo{ID: "demo3-f1"},
+					BaseInfo: entity.BaseInfo{ID: "demo3-f1", ResourceVersion: 7},
 					Field1:   "demo3-f1",
 					Field2:   "demo3-f2",
 				},
@@ -185,7 +192,7 @@ func TestGenericStore_Init(t *testing.T) {
 			wantListCalled: true,
 		},
 		{
-			caseDesc: "json error",
+			caseDesc: "json error quarantined",
 			giveStore: &GenericStore{
 				opt: GenericStoreOption{
 					BasePath: "test",
@@ -205,9 +212,29 @@ func TestGenericStore_Init(t *testing.T) {
 					Value: `{"Field1":"demo2-f1", "Field2":"demo2-f2"}`,
 				},
 			},
-			wantErr: fmt.Errorf("json unmarshal failed\n\tRelated Key:\t\tdemo1-f1\n\tError Description:\t" +
-				"invalid character ',' after object key"),
-			wantListCalled: true,
+			giveWatchCh: make(chan storage.WatchResponse),
+			giveResp: storage.WatchResponse{
+				Events: []storage.Event{
+					{
+						Keypair: storage.Keypair{
+							Key:      "test/demo3-f1",
+							Value:    `{"Field1":1}`,
+							Revision: 7,
+						},
+						Type: storage.EventTypePut,
+					},
+				},
+			},
+			wantCache: map[string]interface{}{
+				"demo2-f1": &TestStruct{
+					BaseInfo: entity.BaseInfo{ID: "demo2-f1"},
+					Field1:   "demo2-f1",
+					Field2:   "demo2-f2",
+				},
+			},
+			wantQuarantine:  []string{"demo1-f1", "demo3-f1"},
+			wantListCalled:  true,
+			wantWatchCalled: true,
 		},
 	}
 
@@ -217,28 +244,118 @@ func TestGenericStore_Init(t *testing.T) {
 		mStorage.On("List", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
 			listCalled = true
 			assert.Equal(t, tc.giveStore.opt.BasePath, args[1], tc.caseDesc)
-		}).Return(tc.giveListRet, tc.giveListErr)
-		mStorage.On("Watch", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		}).Return(tc.giveListRet, int64(6), tc.giveListErr)
+		mStorage.On("Watch", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
 			watchCalled = true
 			assert.Equal(t, tc.giveStore.opt.BasePath, args[1], tc.caseDesc)
-		}).Return((<-chan storage.WatchResponse)(tc.giveWatchCh))
+			assert.Equal(t, int64(7), args[2], tc.caseDesc)
+		}).Return((<-chan storage.WatchResponse)(tc.giveWatchCh)).Once()
 
 		tc.giveStore.Stg = mStorage
 		err := tc.giveStore.Init()
 		assert.Equal(t, tc.wantListCalled, listCalled, tc.caseDesc)
-		assert.Equal(t, tc.wantWatchCalled, watchCalled, tc.caseDesc)
 		if err != nil {
 			assert.Equal(t, tc.wantErr.Error(), err.Error(), tc.caseDesc)
+			assert.False(t, watchCalled, tc.caseDesc)
 			continue
 		}
 		tc.giveWatchCh <- tc.giveResp
+		assert.Equal(t, tc.wantWatchCalled, watchCalled, tc.caseDesc)
 		time.Sleep(1 * time.Second)
-		close(tc.giveWatchCh)
 		tc.giveStore.cache.Range(func(key, value interface{}) bool {
 			assert.Equal(t, tc.wantCache[key.(string)], value)
 			return true
 		})
+		var quarantined []string
+		for _, item := range tc.giveStore.ListQuarantine(context.TODO()) {
+			quarantined = append(quarantined, item.Key)
+		}
+		assert.Equal(t, tc.wantQuarantine, quarantined, tc.caseDesc)
+		assert.Equal(t, int64(7), tc.giveStore.SyncStatus().Revision, tc.caseDesc)
+		assert.True(t, tc.giveStore.SyncStatus().Synced, tc.caseDesc)
+		_ = tc.giveStore.Close()
+	}
+}
+
+func TestGenericStore_WatchResume(t *testing.T) {
+	watchMinBackoff = 10 * time.Millisecond
+	defer func() {
+		watchMinBackoff = 500 * time.Millisecond
+	}()
+
+	s := &GenericStore{
+		opt: GenericStoreOption{
+			BasePath: "test",
+			ObjType:  reflect.TypeOf(TestStruct{}),
+			KeyFunc: func(obj interface{}) string {
+				return obj.(*TestStruct).Field1
+			},
+			HubKey: "test",
+		},
+	}
+	mStorage := &storage.MockInterface{}
+	mStorage.On("List", mock.Anything, mock.Anything).Return([]storage.Keypair{
+		{Key: "test/demo1", Value: `{"Field1":"demo1"}`, Revision: 2},
+	}, int64(3), nil).Once()
+	mStorage.On("List", mock.Anything, mock.Anything).Return([]storage.Keypair{
+		{Key: "test/demo2", Value: `{"Field1":"demo2"}`, Revision: 8},
+	}, int64(10), nil).Once()
+
+	// the first watch is closed after an event, the second is compacted,
+	// and the last one is kept open
+	watchChs := []chan storage.WatchResponse{
+		make(chan storage.WatchResponse, 1),
+		make(chan storage.WatchResponse, 1),
+		make(chan storage.WatchResponse),
+	}
+	watchChs[0] <- storage.WatchResponse{Events: []storage.Event{{
+		Keypair: storage.Keypair{Key: "test/demo3", Value: `{"Field1":"demo3"}`, Revision: 5},
+		Type:    storage.EventTypePut,
+	}}}
+	close(watchChs[0])
+	watchChs[1] <- storage.WatchResponse{Canceled: true, Error: storage.ErrCompacted}
+	close(watchChs[1])
+
+	revisions := make(chan int64, len(watchChs))
+	calls := 0
+	mStorage.On("Watch", mock.Anything, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, _ string, revision int64) <-chan storage.WatchResponse {
+			revisions <- revision
+			calls++
+			return watchChs[calls-1]
+		})
+
+	s.Stg = mStorage
+	assert.Nil(t, s.Init())
+	defer s.Close()
+
+	var got []int64
+	for len(got) < len(watchChs) {
+		select {
+		case revision := <-revisions:
+			got = append(got, revision)
+		case <-time.After(5 * time.Second):
+			t.Fatal("watch is not resumed")
+		}
 	}
+	assert.Equal(t, []int64{4, 6, 11}, got)
+
+	assert.Eventually(t, func() bool {
+		return s.SyncStatus().Synced
+	}, 5*time.Second, 10*time.Millisecond)
+	status := s.SyncStatus()
+	assert.Equal(t, HubKey("test"), status.Resource)
+	assert.Equal(t, int64(10), status.Revision)
+	assert.Equal(t, 0, status.Retries)
+
+	// the relist drops what is gone
+	_, err := s.Get(context.TODO(), "demo1")
+	assert.NotNil(t, err)
+	_, err = s.Get(context.TODO(), "demo3")
+	assert.NotNil(t, err)
+	_, err = s.Get(context.TODO(), "demo2")
+	assert.Nil(t, err)
+	mStorage.AssertNumberOfCalls(t, "List", 2)
 }
 
 func TestGenericStore_Get(t *testing.T) {
@@ -452,6 +569,123 @@ func TestGenericStore_List(t *testing.T) {
 	}
 }
 
+func TestGenericStore_ListCursor(t *testing.T) {
+	s := &GenericStore{}
+	for i := 1; i <= 5; i++ {
+		s.cacheStore(fmt.Sprintf("test%d", i), &TestStruct{
+			BaseInfo: entity.BaseInfo{ID: fmt.Sprintf("test%d", i), CreateTime: int64(10 - i)},
+		})
+	}
+	odd := func(obj interface{}) bool {
+		return obj.(*TestStruct).CreateTime%2 == 1
+	}
+
+	ret, err := s.List(context.Background(), ListInput{Predicate: odd, PageSize: 1, PageNumber: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, ret.TotalSize)
+	assert.Equal(t, "test5", ret.Rows[0].(*TestStruct).ID)
+	assert.NotEmpty(t, ret.NextCursor)
+
+	// continue from the page, whatever has been created since
+	s.cacheStore("test6", &TestStruct{BaseInfo: entity.BaseInfo{ID: "test6", CreateTime: 11}})
+	ret, err = s.List(context.Background(), ListInput{Predicate: odd, PageSize: 2, Cursor: ret.NextCursor, WithTotal: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, ret.TotalSize)
+	assert.Len(t, ret.Rows, 2)
+	assert.Equal(t, "test3", ret.Rows[0].(*TestStruct).ID)
+	assert.Equal(t, "test1", ret.Rows[1].(*TestStruct).ID)
+	ret, err = s.List(context.Background(), ListInput{Predicate: odd, PageSize: 2, Cursor: ret.NextCursor})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, ret.TotalSize)
+	assert.Len(t, ret.Rows, 1)
+	assert.Equal(t, "test6", ret.Rows[0].(*TestStruct).ID)
+	assert.Empty(t, ret.NextCursor)
+
+	// without the total, only the items from the cursor to the one after
+	// the page are scanned
+	var scanned []string
+	all := func(obj interface{}) bool {
+		scanned = append(scanned, obj.(*TestStruct).ID.(string))
+		return true
+	}
+	ret, err = s.List(context.Background(), ListInput{PageSize: 1, PageNumber: 1})
+	assert.Nil(t, err)
+	ret, err = s.List(context.Background(), ListInput{Predicate: all, PageSize: 1, Cursor: ret.NextCursor})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"test4", "test3"}, scanned)
+	assert.Equal(t, "test4", ret.Rows[0].(*TestStruct).ID)
+	assert.NotEmpty(t, ret.NextCursor)
+
+	// the snapshot is rebuilt after the cache changed
+	s.cacheDelete("test5")
+	ret, err = s.List(context.Background(), ListInput{})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, ret.TotalSize)
+	assert.Equal(t, "test4", ret.Rows[0].(*TestStruct).ID)
+
+	_, err = s.List(context.Background(), ListInput{Cursor: "bad"})
+	assert.Equal(t, fmt.Errorf("cursor bad is invalid"), err)
+	_, err = s.List(context.Background(), ListInput{Cursor: "bad", Less: defLessFunc})
+	assert.Equal(t, fmt.Errorf("cursor is invalid with a custom order"), err)
+}
+
+func TestGenericStore_ListQuery(t *testing.T) {
+	s := &GenericStore{opt: GenericStoreOption{ObjType: reflect.TypeOf(entity.Route{})}}
+	routes := []*entity.Route{
+		{BaseInfo: entity.BaseInfo{ID: "r1", CreateTime: 1}, Name: "b", Priority: 2, Labels: map[string]string{"env": "prod"}},
+		{BaseInfo: entity.BaseInfo{ID: "r2", CreateTime: 2}, Name: "a", Priority: 2, Labels: map[string]string{"env": "staging", "deprecated": "true"}},
+		{BaseInfo: entity.BaseInfo{ID: "r3", CreateTime: 3}, Name: "c", Priority: 1},
+	}
+	for _, r := range routes {
+		s.cacheStore(r.ID.(string), r)
+	}
+	ids := func(ret *ListOutput) []interface{} {
+		var ids []interface{}
+		for _, row := range ret.Rows {
+			ids = append(ids, row.(*entity.Route).ID)
+		}
+		return ids
+	}
+
+	ret, err := s.List(context.Background(), ListInput{Query: ListQuery{SortBy: "name"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"r2", "r1", "r3"}, ids(ret))
+
+	// ties are kept in the default order, which is reversed as well
+	ret, err = s.List(context.Background(), ListInput{Query: ListQuery{SortBy: "priority", Order: OrderDesc}, PageSize: 2, PageNumber: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"r2", "r1"}, ids(ret))
+	assert.Equal(t, 3, ret.TotalSize)
+	assert.Empty(t, ret.NextCursor)
+
+	ret, err = s.List(context.Background(), ListInput{Query: ListQuery{LabelSelector: "env in (prod,staging),!deprecated"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"r1"}, ids(ret))
+	ret, err = s.List(context.Background(), ListInput{Query: ListQuery{LabelSelector: "env!=prod"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"r2", "r3"}, ids(ret))
+
+	ret, err = s.List(context.Background(), ListInput{
+		Predicate: func(obj interface{}) bool {
+			return obj.(*entity.Route).Priority == 2
+		},
+		Query: ListQuery{Fields: "id,name,labels", Order: OrderDesc},
+	})
+	assert.Nil(t, err)
+	j, err := json.Marshal(ret.Rows)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[{"id":"r2","name":"a","labels":{"env":"staging","deprecated":"true"}},{"id":"r1","name":"b","labels":{"env":"prod"}}]`, string(j))
+
+	_, err = s.List(context.Background(), ListInput{Query: ListQuery{SortBy: "labels"}})
+	assert.Equal(t, fmt.Errorf("sort_by labels is invalid"), err)
+	_, err = s.List(context.Background(), ListInput{Query: ListQuery{Fields: "id,nope"}})
+	assert.Equal(t, fmt.Errorf("fields id,nope is invalid"), err)
+	_, err = s.List(context.Background(), ListInput{Query: ListQuery{Order: "up"}})
+	assert.Equal(t, fmt.Errorf("order up is invalid"), err)
+	_, err = s.List(context.Background(), ListInput{Query: ListQuery{SortBy: "name"}, Cursor: "x"})
+	assert.Equal(t, fmt.Errorf("cursor is invalid with a custom order"), err)
+}
+
 func TestGenericStore_ingestValidate(t *testing.T) {
 	tests := []struct {
 		giveStore       *GenericStore
@@ -607,7 +841,8 @@ func TestGenericStore_Create(t *testing.T) {
 			assert.NotEqual(t, 0, len(id), tc.caseDesc)
 			assert.NotEqual(t, 0, input.CreateTime, tc.caseDesc)
 			assert.NotEqual(t, 0, input.UpdateTime, tc.caseDesc)
-		}).Return(tc.giveErr)
+			assert.NotContains(t, args[2].(string), "resource_version", tc.caseDesc)
+		}).Return(int64(10), tc.giveErr)
 
 		mValidator := &MockValidator{}
 		mValidator.On("Validate", mock.Anything).Run(func(args mock.Arguments) {
@@ -628,6 +863,7 @@ func TestGenericStore_Create(t *testing.T) {
 		// The returned value (retTs) should be the same as the input (tc.giveObj)
 		assert.Equal(t, tc.giveObj.Field1, retTs.Field1, tc.caseDesc)
 		assert.Equal(t, tc.giveObj.Field2, retTs.Field2, tc.caseDesc)
+		assert.Equal(t, int64(10), retTs.ResourceVersion, tc.caseDesc)
 		assert.True(t, createCalled, tc.caseDesc)
 	}
 }
@@ -638,6 +874,7 @@ func TestGenericStore_Update(t *testing.T) {
 		giveStore       *GenericStore
 		giveCache       map[string]interface{}
 		giveObj         *TestStruct
+		giveVersion     int64
 		giveErr         error
 		giveValidateErr error
 		wantKey         string
@@ -702,6 +939,49 @@ func TestGenericStore_Update(t *testing.T) {
 			},
 			wantErr: fmt.Errorf("key: test1 is not found"),
 		},
+		{
+			caseDesc: "conditional update",
+			giveObj: &TestStruct{
+				BaseInfo: entity.BaseInfo{ResourceVersion: 3},
+				Field1:   "test1",
+				Field2:   "test2",
+			},
+			giveCache: map[string]interface{}{
+				"test1": &TestStruct{},
+			},
+			giveStore: &GenericStore{
+				opt: GenericStoreOption{
+					BasePath: "test/path",
+					KeyFunc: func(obj interface{}) string {
+						return obj.(*TestStruct).Field1
+					},
+				},
+			},
+			giveVersion: 3,
+			wantKey:     "test/path/test1",
+		},
+		{
+			caseDesc: "version mismatch",
+			giveObj: &TestStruct{
+				Field1: "test1",
+				Field2: "test2",
+			},
+			giveCache: map[string]interface{}{
+				"test1": &TestStruct{},
+			},
+			giveStore: &GenericStore{
+				opt: GenericStoreOption{
+					BasePath: "test/path",
+					KeyFunc: func(obj interface{}) string {
+						return obj.(*TestStruct).Field1
+					},
+				},
+			},
+			giveVersion: 2,
+			giveErr:     storage.ErrRevisionMismatch,
+			wantKey:     "test/path/test1",
+			wantErr:     storage.ErrRevisionMismatch,
+		},
 	}
 
 	for _, tc := range tests {
@@ -711,16 +991,18 @@ func TestGenericStore_Update(t *testing.T) {
 
 		createCalled, validateCalled := false, false
 		mStorage := &storage.MockInterface{}
-		mStorage.On("Update", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		mStorage.On("Update", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
 			createCalled = true
 			assert.Equal(t, tc.wantKey, args[1], tc.caseDesc)
+			assert.Equal(t, tc.giveVersion, args[3], tc.caseDesc)
+			assert.NotContains(t, args[2].(string), "resource_version", tc.caseDesc)
 			input := TestStruct{}
 			err := json.Unmarshal([]byte(args[2].(string)), &input)
 			assert.Nil(t, err)
 			assert.Equal(t, tc.giveObj.Field1, input.Field1, tc.caseDesc)
 			assert.Equal(t, tc.giveObj.Field2, input.Field2, tc.caseDesc)
 			assert.NotEqual(t, 0, input.UpdateTime, tc.caseDesc)
-		}).Return(tc.giveErr)
+		}).Return(int64(11), tc.giveErr)
 
 		mValidator := &MockValidator{}
 		mValidator.On("Validate", mock.Anything).Run(func(args mock.Arguments) {
@@ -731,7 +1013,8 @@ func TestGenericStore_Update(t *testing.T) {
 		tc.giveStore.Stg = mStorage
 		tc.giveStore.opt.Validator = mValidator
 
-		ret, err := tc.giveStore.Update(context.TODO(), tc.giveObj, false)
+		ctx := WithExpectedVersion(context.TODO(), tc.giveVersion)
+		ret, err := tc.giveStore.Update(ctx, tc.giveObj, false)
 		assert.True(t, validateCalled, tc.caseDesc)
 		if err != nil {
 			assert.Equal(t, tc.wantErr, err, tc.caseDesc)
@@ -742,18 +1025,20 @@ func TestGenericStore_Update(t *testing.T) {
 		// The returned value (retTs) should be the same as the input (tc.giveObj)
 		assert.Equal(t, tc.giveObj.Field1, retTs.Field1, tc.caseDesc)
 		assert.Equal(t, tc.giveObj.Field2, retTs.Field2, tc.caseDesc)
+		assert.Equal(t, int64(11), retTs.ResourceVersion, tc.caseDesc)
 		assert.True(t, createCalled, tc.caseDesc)
 	}
 }
 
 func TestGenericStore_Delete(t *testing.T) {
 	tests := []struct {
-		caseDesc  string
-		giveStore *GenericStore
-		giveKeys  []string
-		giveErr   error
-		wantKey   []string
-		wantErr   error
+		caseDesc    string
+		giveStore   *GenericStore
+		giveKeys    []string
+		giveVersion int64
+		giveErr     error
+		wantKey     []string
+		wantErr     error
 	}{
 		{
 			caseDesc: "sanity",
@@ -777,6 +1062,41 @@ func TestGenericStore_Delete(t *testing.T) {
 			giveErr: fmt.Errorf("delete failed"),
 			wantErr: fmt.Errorf("delete failed"),
 		},
+		{
+			caseDesc:    "conditional delete",
+			giveKeys:    []string{"test1"},
+			giveVersion: 4,
+			giveStore: &GenericStore{
+				opt: GenericStoreOption{
+					BasePath: "test/path",
+				},
+			},
+			wantKey: []string{"test/path/test1"},
+		},
+		{
+			caseDesc:    "conditional delete mismatch",
+			giveKeys:    []string{"test1"},
+			giveVersion: 4,
+			giveStore: &GenericStore{
+				opt: GenericStoreOption{
+					BasePath: "test/path",
+				},
+			},
+			wantKey: []string{"test/path/test1"},
+			giveErr: storage.ErrRevisionMismatch,
+			wantErr: storage.ErrRevisionMismatch,
+		},
+		{
+			caseDesc:    "conditional delete with multiple keys",
+			giveKeys:    []string{"test1", "test2"},
+			giveVersion: 4,
+			giveStore: &GenericStore{
+				opt: GenericStoreOption{
+					BasePath: "test/path",
+				},
+			},
+			wantErr: fmt.Errorf("resource version is invalid when deleting multiple keys"),
+		},
 	}
 
 	for _, tc := range tests {
@@ -786,10 +1106,16 @@ func TestGenericStore_Delete(t *testing.T) {
 			createCalled = true
 			assert.Equal(t, tc.wantKey, args[1], tc.caseDesc)
 		}).Return(tc.giveErr)
+		mStorage.On("Delete", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			createCalled = true
+			assert.Equal(t, tc.wantKey, []string{args[1].(string)}, tc.caseDesc)
+			assert.Equal(t, tc.giveVersion, args[2], tc.caseDesc)
+		}).Return(tc.giveErr)
 
 		tc.giveStore.Stg = mStorage
-		err := tc.giveStore.BatchDelete(context.TODO(), tc.giveKeys)
-		assert.True(t, createCalled, tc.caseDesc)
+		ctx := WithExpectedVersion(context.TODO(), tc.giveVersion)
+		err := tc.giveStore.BatchDelete(ctx, tc.giveKeys)
+		assert.Equal(t, tc.wantKey != nil, createCalled, tc.caseDesc)
 		assert.Equal(t, tc.wantErr, err, tc.caseDesc)
 	}
 }
@@ -810,3 +1136,672 @@ func TestGenericStore_StringToObjPtr(t *testing.T) {
 	ssl := sslInterface.(*entity.SSL)
 	assert.Equal(t, id, ssl.ID)
 }
+
+func TestGenericStore_MemoryStorage(t *testing.T) {
+	s := &GenericStore{
+		Stg: storage.NewMemoryStorage(),
+		opt: GenericStoreOption{
+			BasePath: "/apisix/routes",
+			ObjType:  reflect.TypeOf(entity.Route{}),
+			KeyFunc: func(obj interface{}) string {
+				return utils.InterfaceToString(obj.(*entity.Route).ID)
+			},
+		},
+	}
+	assert.Nil(t, s.Init())
+	defer s.Close()
+
+	ret, err := s.Create(context.TODO(), &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, URI: "/hello"})
+	assert.Nil(t, err)
+	route := ret.(*entity.Route)
+	assert.Equal(t, int64(2), route.ResourceVersion)
+
+	// the cache is filled by the watch
+	assert.Eventually(t, func() bool {
+		obj, err := s.Get(context.TODO(), "r1")
+		return err == nil && obj.(*entity.Route).ResourceVersion == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	route.URI = "/hi"
+	_, err = s.Update(WithExpectedVersion(context.TODO(), 1), route, false)
+	assert.True(t, errors.Is(err, storage.ErrRevisionMismatch))
+	_, err = s.Update(WithExpectedVersion(context.TODO(), 2), route, false)
+	assert.Nil(t, err)
+
+	err = s.BatchDelete(context.TODO(), []string{"r1"})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		_, err := s.Get(context.TODO(), "r1")
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestGenericStore_IndexLookup(t *testing.T) {
+	s := &GenericStore{
+		opt: GenericStoreOption{
+			BasePath: "/apisix/routes",
+			ObjType:  reflect.TypeOf(entity.Route{}),
+			KeyFunc: func(obj interface{}) string {
+				return utils.InterfaceToString(obj.(*entity.Route).ID)
+			},
+			Indexes: map[string]IndexFunc{
+				IndexUpstreamID: func(obj interface{}) []string {
+					return []string{utils.InterfaceToString(obj.(*entity.Route).UpstreamID)}
+				},
+				IndexLabel: func(obj interface{}) []string {
+					return labelIndex(obj.(*entity.Route).Labels)
+				},
+			},
+		},
+	}
+
+	r1 := &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, UpstreamID: "u1", Labels: map[string]string{"env": "prod"}}
+	r2 := &entity.Route{BaseInfo: entity.BaseInfo{ID: "r2"}, UpstreamID: "u1"}
+	s.cacheStore("r2", r2)
+	s.cacheStore("r1", r1)
+
+	ret, err := s.IndexLookup(context.TODO(), IndexUpstreamID, "u1")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{r1, r2}, ret)
+	ret, err = s.IndexLookup(context.TODO(), IndexLabel, "env")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{r1}, ret)
+	ret, err = s.IndexLookup(context.TODO(), IndexLabel, "env:prod")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{r1}, ret)
+
+	// an update moves the object between the values
+	r1New := &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, UpstreamID: "u2"}
+	s.cacheStore("r1", r1New)
+	ret, err = s.IndexLookup(context.TODO(), IndexUpstreamID, "u1")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{r2}, ret)
+	ret, err = s.IndexLookup(context.TODO(), IndexUpstreamID, "u2")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{r1New}, ret)
+	ret, err = s.IndexLookup(context.TODO(), IndexLabel, "env")
+	assert.Nil(t, err)
+	assert.Len(t, ret, 0)
+
+	s.cacheDelete("r2")
+	ret, err = s.IndexLookup(context.TODO(), IndexUpstreamID, "u1")
+	assert.Nil(t, err)
+	assert.Len(t, ret, 0)
+
+	_, err = s.IndexLookup(context.TODO(), IndexName, "r1")
+	assert.Equal(t, fmt.Errorf("index name is not defined"), err)
+}
+
+func TestGenericStore_History(t *testing.T) {
+	s := &GenericStore{
+		Stg: storage.NewMemoryStorage(),
+		opt: GenericStoreOption{
+			BasePath: "/apisix/routes",
+			ObjType:  reflect.TypeOf(entity.Route{}),
+			KeyFunc: func(obj interface{}) string {
+				return utils.InterfaceToString(obj.(*entity.Route).ID)
+			},
+			HistoryPath:  "/apisix/revisions/routes",
+			HistoryLimit: 2,
+		},
+	}
+	assert.Nil(t, s.Init())
+	defer s.Close()
+
+	cached := func(version int64) func() bool {
+		return func() bool {
+			obj, err := s.Get(context.TODO(), "r1")
+			if version == 0 {
+				return err != nil
+			}
+			return err == nil && obj.(*entity.Route).ResourceVersion == version
+		}
+	}
+
+	ctx := WithUser(context.TODO(), "admin")
+	ret, err := s.Create(ctx, &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, URI: "/hello"})
+	assert.Nil(t, err)
+	created := ret.(*entity.Route).ResourceVersion
+	assert.Eventually(t, cached(created), 5*time.Second, 10*time.Millisecond)
+
+	ret, err = s.Update(ctx, &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, URI: "/hi"}, false)
+	assert.Nil(t, err)
+	updated := ret.(*entity.Route).ResourceVersion
+	assert.Eventually(t, cached(updated), 5*time.Second, 10*time.Millisecond)
+
+	revisions, err := s.History(context.TODO(), "r1")
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 2)
+	assert.Equal(t, updated, revisions[0].Revision)
+	assert.Equal(t, RevisionActionUpdate, revisions[0].Action)
+	assert.Equal(t, "admin", revisions[0].User)
+	assert.Contains(t, string(revisions[0].Object), `"uri":"/hi"`)
+	assert.NotContains(t, string(revisions[0].Object), "resource_version")
+	assert.Equal(t, created, revisions[1].Revision)
+	assert.Equal(t, RevisionActionCreate, revisions[1].Action)
+
+	// the oldest revisions beyond the limit are dropped
+	err = s.BatchDelete(context.TODO(), []string{"r1"})
+	assert.Nil(t, err)
+	assert.Eventually(t, cached(0), 5*time.Second, 10*time.Millisecond)
+	revisions, err = s.History(context.TODO(), "r1")
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 2)
+	assert.Equal(t, RevisionActionDelete, revisions[0].Action)
+	assert.Equal(t, "", revisions[0].User)
+	assert.Contains(t, string(revisions[0].Object), `"uri":"/hi"`)
+	assert.Equal(t, updated, revisions[1].Revision)
+
+	// the revisions of the deleted object are kept
+	_, err = s.GetRevision(context.TODO(), "r1", 1)
+	assert.Equal(t, fmt.Errorf("revision 1 is not found"), err)
+	ret, err = s.GetRevision(context.TODO(), "r1", updated)
+	assert.Nil(t, err)
+	assert.Equal(t, "/hi", ret.(*entity.Route).URI)
+
+	s.opt.HistoryLimit = 0
+	_, err = s.History(context.TODO(), "r1")
+	assert.NotNil(t, err)
+}
+
+type recordingSink struct {
+	entries []*audit.Entry
+}
+
+func (s *recordingSink) Write(_ context.Context, entries []*audit.Entry) error {
+	s.entries = append(s.entries, entries...)
+	return nil
+}
+
+func TestGenericStore_Audit(t *testing.T) {
+	sink := &recordingSink{}
+	audit.SetSinks(sink)
+	defer audit.SetSinks()
+
+	s := &GenericStore{
+		Stg: storage.NewMemoryStorage(),
+		opt: GenericStoreOption{
+			BasePath: "/apisix/routes",
+			ObjType:  reflect.TypeOf(entity.Route{}),
+			KeyFunc: func(obj interface{}) string {
+				return utils.InterfaceToString(obj.(*entity.Route).ID)
+			},
+			HubKey: HubKeyRoute,
+		},
+	}
+	assert.Nil(t, s.Init())
+	defer s.Close()
+
+	ctx := audit.WithRequest(WithUser(context.TODO(), "admin"), "127.0.0.1", "req-1")
+	ret, err := s.Create(ctx, &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, URI: "/hello"})
+	assert.Nil(t, err)
+	version := ret.(*entity.Route).ResourceVersion
+	assert.Eventually(t, func() bool {
+		obj, err := s.Get(context.TODO(), "r1")
+		return err == nil && obj.(*entity.Route).ResourceVersion == version
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err = s.Update(ctx, &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, URI: "/hi"}, false)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		obj, err := s.Get(context.TODO(), "r1")
+		return err == nil && obj.(*entity.Route).URI == "/hi"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	err = s.BatchDelete(ctx, []string{"r1"})
+	assert.Nil(t, err)
+
+	assert.Len(t, sink.entries, 3)
+	for i, action := range []string{RevisionActionCreate, RevisionActionUpdate, RevisionActionDelete} {
+		e := sink.entries[i]
+		assert.Equal(t, action, e.Action)
+		assert.Equal(t, "admin", e.User)
+		assert.Equal(t, "127.0.0.1", e.IP)
+		assert.Equal(t, "req-1", e.RequestID)
+		assert.Equal(t, "route", e.Resource)
+		assert.Equal(t, "r1", e.ResourceID)
+	}
+	assert.Nil(t, sink.entries[0].Before)
+	assert.Contains(t, string(sink.entries[1].Before), `"uri":"/hello"`)
+	assert.Contains(t, string(sink.entries[1].Diff), `"uri":"/hi"`)
+	assert.Contains(t, string(sink.entries[2].Before), `"uri":"/hi"`)
+	assert.Nil(t, sink.entries[2].After)
+}
+
+func TestGenericStore_AuditRedact(t *testing.T) {
+	sink := &recordingSink{}
+	audit.SetSinks(sink)
+	defer audit.SetSinks()
+
+	newStore := func(hubKey HubKey, typ interface{}, keyFunc func(obj interface{}) string) *GenericStore {
+		s := &GenericStore{
+			Stg: storage.NewMemoryStorage(),
+			opt: GenericStoreOption{
+				BasePath: "/apisix/" + string(hubKey),
+				ObjType:  reflect.TypeOf(typ),
+				KeyFunc:  keyFunc,
+				HubKey:   hubKey,
+			},
+		}
+		assert.Nil(t, s.Init())
+		t.Cleanup(func() {
+			_ = s.Close()
+		})
+		return s
+	}
+	ssls := newStore(HubKeySsl, entity.SSL{}, func(obj interface{}) string {
+		return utils.InterfaceToString(obj.(*entity.SSL).ID)
+	})
+	consumers := newStore(HubKeyConsumer, entity.Consumer{}, func(obj interface{}) string {
+		return obj.(*entity.Consumer).Username
+	})
+
+	ctx := context.TODO()
+	_, err := ssls.Create(ctx, &entity.SSL{BaseInfo: entity.BaseInfo{ID: "s1"}, Cert: "cert", Key: "secret-key"})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		_, err := ssls.Get(ctx, "s1")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = ssls.Update(ctx, &entity.SSL{BaseInfo: entity.BaseInfo{ID: "s1"}, Cert: "cert2", Key: "secret-key2",
+		Keys: []string{"secret-key3"}}, false)
+	assert.Nil(t, err)
+	_, err = consumers.Create(ctx, &entity.Consumer{Username: "jack", Plugins: map[string]interface{}{
+		"key-auth":   map[string]interface{}{"key": "secret-key4"},
+		"basic-auth": map[string]interface{}{"username": "jack", "password": "secret-password"},
+	}})
+	assert.Nil(t, err)
+
+	assert.Len(t, sink.entries, 3)
+	for _, e := range sink.entries {
+		bs, err := json.Marshal(e)
+		assert.Nil(t, err)
+		assert.NotContains(t, string(bs), "secret")
+	}
+	assert.Contains(t, string(sink.entries[1].Diff), `"cert":"cert2"`)
+	assert.Contains(t, string(sink.entries[2].After), `"basic-auth":{"username":"jack"}`)
+}
+
+func TestGenericStore_BatchDeleteParts(t *testing.T) {
+	s := &GenericStore{
+		Stg: storage.NewMemoryStorage(),
+		opt: GenericStoreOption{
+			BasePath: "/apisix/routes",
+			ObjType:  reflect.TypeOf(entity.Route{}),
+			KeyFunc: func(obj interface{}) string {
+				return utils.InterfaceToString(obj.(*entity.Route).ID)
+			},
+			HistoryPath:    "/apisix/revisions/routes",
+			HistoryLimit:   10,
+			TrashPath:      "/apisix/trash/routes",
+			TrashRetention: time.Hour,
+		},
+	}
+	assert.Nil(t, s.Init())
+	defer s.Close()
+
+	// each delete takes 3 operations with its revision and trash entry,
+	// far more than a transaction carries
+	var keys []string
+	for i := 0; i < storage.MaxTxnOps; i++ {
+		id := fmt.Sprintf("r%d", i)
+		_, err := s.Create(context.TODO(), &entity.Route{BaseInfo: entity.BaseInfo{ID: id}, URI: "/" + id})
+		assert.Nil(t, err)
+		keys = append(keys, id)
+	}
+	assert.Eventually(t, func() bool {
+		ret, err := s.List(context.TODO(), ListInput{})
+		return err == nil && ret.TotalSize == len(keys)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Nil(t, s.BatchDelete(context.TODO(), keys))
+	assert.Eventually(t, func() bool {
+		ret, err := s.List(context.TODO(), ListInput{})
+		return err == nil && ret.TotalSize == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	items, err := s.ListTrash(context.TODO())
+	assert.Nil(t, err)
+	assert.Len(t, items, len(keys))
+}
+
+func TestGenericStore_Trash(t *testing.T) {
+	s := &GenericStore{
+		Stg: storage.NewMemoryStorage(),
+		opt: GenericStoreOption{
+			BasePath: "/apisix/routes",
+			ObjType:  reflect.TypeOf(entity.Route{}),
+			KeyFunc: func(obj interface{}) string {
+				return utils.InterfaceToString(obj.(*entity.Route).ID)
+			},
+			TrashPath:      "/apisix/trash/routes",
+			TrashRetention: time.Hour,
+		},
+	}
+	assert.Nil(t, s.Init())
+	defer s.Close()
+
+	cached := func(key string, exist bool) func() bool {
+		return func() bool {
+			_, err := s.Get(context.TODO(), key)
+			return (err == nil) == exist
+		}
+	}
+
+	ctx := WithUser(context.TODO(), "admin")
+	for _, id := range []string{"r1", "r2"} {
+		_, err := s.Create(ctx, &entity.Route{BaseInfo: entity.BaseInfo{ID: id}, URI: "/" + id})
+		assert.Nil(t, err)
+		assert.Eventually(t, cached(id, true), 5*time.Second, 10*time.Millisecond)
+	}
+	err := s.BatchDelete(ctx, []string{"r1", "r2"})
+	assert.Nil(t, err)
+	assert.Eventually(t, cached("r1", false), 5*time.Second, 10*time.Millisecond)
+
+	items, err := s.ListTrash(context.TODO())
+	assert.Nil(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, "r1", items[0].Key)
+	assert.Equal(t, "admin", items[0].User)
+	assert.Contains(t, string(items[0].Object), `"uri":"/r1"`)
+
+	// undelete recreates the object and takes it out of the trash
+	obj, err := s.GetTrash(context.TODO(), "r1")
+	assert.Nil(t, err)
+	assert.Equal(t, "/r1", obj.(*entity.Route).URI)
+	op, err := s.PrepareUndelete(context.TODO(), obj)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Commit(context.TODO(), []*Op{op}))
+	assert.Eventually(t, cached("r1", true), 5*time.Second, 10*time.Millisecond)
+	_, err = s.GetTrash(context.TODO(), "r1")
+	assert.Equal(t, fmt.Errorf("key: /apisix/trash/routes/r1 is not found"), err)
+
+	// the objects deleted before the retention are pruned
+	_, err = s.Stg.Update(context.TODO(), "/apisix/trash/routes/r3",
+		fmt.Sprintf(`{"key":"r3","delete_time":%d}`, time.Now().Add(-2*time.Hour).Unix()), 0)
+	assert.Nil(t, err)
+	s.pruneTrash(context.TODO())
+	items, err = s.ListTrash(context.TODO())
+	assert.Nil(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, "r2", items[0].Key)
+
+	assert.Nil(t, s.PurgeTrash(context.TODO(), []string{"r2"}))
+	items, err = s.ListTrash(context.TODO())
+	assert.Nil(t, err)
+	assert.Len(t, items, 0)
+}
+
+func TestGenericStore_DryRun(t *testing.T) {
+	s := &GenericStore{
+		Stg: storage.NewMemoryStorage(),
+		opt: GenericStoreOption{
+			BasePath: "/apisix/routes",
+			ObjType:  reflect.TypeOf(entity.Route{}),
+			KeyFunc: func(obj interface{}) string {
+				return utils.InterfaceToString(obj.(*entity.Route).ID)
+			},
+			HistoryPath:  "/apisix/revisions/routes",
+			HistoryLimit: 10,
+		},
+	}
+	assert.Nil(t, s.Init())
+	defer s.Close()
+
+	dryRun := WithDryRun(context.TODO())
+	stored := func() int {
+		ret, _, err := s.Stg.List(context.TODO(), "/apisix/")
+		assert.Nil(t, err)
+		return len(ret)
+	}
+
+	// the object is returned as it would be stored
+	ret, err := s.Create(dryRun, &entity.Route{URI: "/hello"})
+	assert.Nil(t, err)
+	route := ret.(*entity.Route)
+	assert.NotEmpty(t, route.ID)
+	assert.NotZero(t, route.CreateTime)
+	assert.Equal(t, 0, stored())
+
+	_, err = s.Create(context.TODO(), &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, URI: "/hello"})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		_, err := s.Get(context.TODO(), "r1")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, stored())
+
+	// the checks are the same
+	_, err = s.Create(dryRun, &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}})
+	assert.Equal(t, fmt.Errorf("key: r1 is conflicted"), err)
+	_, err = s.Update(WithExpectedVersion(dryRun, 1), &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}}, false)
+	assert.True(t, errors.Is(err, storage.ErrRevisionMismatch))
+
+	ret, err = s.Update(WithExpectedVersion(dryRun, 2), &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, URI: "/hi"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "/hi", ret.(*entity.Route).URI)
+	assert.Nil(t, s.BatchDelete(dryRun, []string{"r1"}))
+	assert.Equal(t, fmt.Errorf("key: r2 is not found"), s.BatchDelete(dryRun, []string{"r2"}))
+
+	obj, err := s.Get(context.TODO(), "r1")
+	assert.Nil(t, err)
+	assert.Equal(t, "/hello", obj.(*entity.Route).URI)
+	assert.Equal(t, 2, stored())
+}
+
+func TestGenericStore_Cluster(t *testing.T) {
+	newStore := func(routing bool) *GenericStore {
+		s := &GenericStore{
+			Stg:     storage.NewMemoryStorage(),
+			routing: routing,
+			opt: GenericStoreOption{
+				BasePath: "/apisix/routes",
+				HubKey:   HubKeyRoute,
+				ObjType:  reflect.TypeOf(entity.Route{}),
+				KeyFunc: func(obj interface{}) string {
+					return utils.InterfaceToString(obj.(*entity.Route).ID)
+				},
+			},
+		}
+		assert.Nil(t, s.Init())
+		return s
+	}
+	local, prod := newStore(true), newStore(false)
+	defer local.Close()
+	defer prod.Close()
+	AddCluster("prod", Hub{HubKeyRoute: prod})
+	defer RemoveCluster("prod")
+
+	ctx := WithCluster(context.TODO(), "prod")
+	assert.Equal(t, "prod", ClusterFromContext(ctx))
+	assert.Equal(t, "", ClusterFromContext(WithCluster(context.TODO(), "local")))
+
+	_, err := local.Create(ctx, &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, URI: "/hello"})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		_, err := prod.Get(context.TODO(), "r1")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the local cluster is left alone
+	_, err = local.Get(context.TODO(), "r1")
+	assert.NotNil(t, err)
+	_, err = local.Get(ctx, "r1")
+	assert.Nil(t, err)
+
+	// an unknown cluster falls back to the store itself
+	_, err = local.Get(WithCluster(context.TODO(), "staging"), "r1")
+	assert.NotNil(t, err)
+
+	assert.Equal(t, []string{"local", "prod"}, ClusterNames())
+}
+
+func TestStartCluster(t *testing.T) {
+	defer func(min, max time.Duration) {
+		watchMinBackoff, watchMaxBackoff = min, max
+	}(watchMinBackoff, watchMaxBackoff)
+	watchMinBackoff, watchMaxBackoff = 10*time.Millisecond, 20*time.Millisecond
+
+	// the cluster is unhealthy until it's connected
+	setStarting("prod", errNotStarted)
+	defer RemoveCluster("prod")
+	assert.Equal(t, []string{"local", "prod"}, ClusterNames())
+	assert.Equal(t, ClusterStatus{Name: "prod", Stores: []SyncStatus{}, LastError: "not connected yet"},
+		ClusterStatuses()[1])
+
+	attempts := 0
+	hub, ok := startCluster(context.TODO(), "prod", func() (Hub, error) {
+		if attempts++; attempts < 3 {
+			if attempts == 2 {
+				status := ClusterStatuses()[1]
+				assert.False(t, status.Healthy)
+				assert.Equal(t, "context deadline exceeded", status.LastError)
+			}
+			return nil, context.DeadlineExceeded
+		}
+		return Hub{}, nil
+	})
+	assert.True(t, ok)
+	assert.Equal(t, 3, attempts)
+	assert.Nil(t, ClusterError("prod"))
+	got, ok := GetCluster("prod")
+	assert.True(t, ok)
+	assert.Equal(t, hub, got)
+	assert.True(t, ClusterStatuses()[1].Healthy)
+
+	// and the attempts stop with the context
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	_, ok = startCluster(ctx, "staging", func() (Hub, error) {
+		return nil, context.DeadlineExceeded
+	})
+	assert.False(t, ok)
+	assert.NotNil(t, ClusterError("staging"))
+	RemoveCluster("staging")
+	assert.Nil(t, ClusterError("staging"))
+}
+
+func TestGenericStore_Encryption(t *testing.T) {
+	keyring := conf.DataEncryptionConfig.Keyring
+	conf.DataEncryptionConfig.Keyring = []conf.EncryptionKey{{ID: "k1", Key: "qeddd145sfvddff3"}}
+	assert.Nil(t, encryption.Init())
+	defer func() {
+		conf.DataEncryptionConfig.Keyring = keyring
+		_ = encryption.Init()
+	}()
+
+	stg := storage.NewMemoryStorage()
+	s := &GenericStore{
+		Stg: stg,
+		opt: GenericStoreOption{
+			BasePath: "/apisix/consumers",
+			ObjType:  reflect.TypeOf(entity.Consumer{}),
+			KeyFunc: func(obj interface{}) string {
+				return obj.(*entity.Consumer).Username
+			},
+		},
+	}
+	assert.Nil(t, s.Init())
+	defer s.Close()
+
+	consumer := &entity.Consumer{Username: "jack", Plugins: map[string]interface{}{
+		"key-auth": map[string]interface{}{"key": "auth-one"},
+	}}
+	_, err := s.Create(context.TODO(), consumer)
+	assert.Nil(t, err)
+	assert.Equal(t, "auth-one", consumer.Plugins["key-auth"].(map[string]interface{})["key"])
+
+	val, err := stg.Get(context.TODO(), "/apisix/consumers/jack")
+	assert.Nil(t, err)
+	assert.Contains(t, val, `"key":"4y+JvURBE6ZwRbbgaryrhg=="`)
+
+	assert.Eventually(t, func() bool {
+		obj, err := s.Get(context.TODO(), "jack")
+		return err == nil &&
+			obj.(*entity.Consumer).Plugins["key-auth"].(map[string]interface{})["key"] == "auth-one"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestGenericStore_Quarantine(t *testing.T) {
+	ctx := context.TODO()
+	stg := storage.NewMemoryStorage()
+	_, err := stg.Create(ctx, "/apisix/routes/r1", `{"id":"r1","uri":"/r1"}`)
+	assert.Nil(t, err)
+	_, err = stg.Create(ctx, "/apisix/routes/r2", `{"id":"r2","uri":`)
+	assert.Nil(t, err)
+
+	s := &GenericStore{
+		Stg: stg,
+		opt: GenericStoreOption{
+			BasePath: "/apisix/routes",
+			HubKey:   HubKeyRoute,
+			ObjType:  reflect.TypeOf(entity.Route{}),
+			KeyFunc: func(obj interface{}) string {
+				return utils.InterfaceToString(obj.(*entity.Route).ID)
+			},
+		},
+	}
+	assert.Nil(t, s.Init())
+	defer s.Close()
+
+	// the others are loaded
+	_, err = s.Get(ctx, "r1")
+	assert.Nil(t, err)
+	_, err = s.Get(ctx, "r2")
+	assert.Equal(t, data.ErrNotFound, err)
+
+	items := s.ListQuarantine(ctx)
+	assert.Len(t, items, 1)
+	assert.Equal(t, HubKeyRoute, items[0].Resource)
+	assert.Equal(t, "r2", items[0].Key)
+	assert.Equal(t, `{"id":"r2","uri":`, items[0].Value)
+	assert.Equal(t, 17, items[0].Size)
+	assert.Contains(t, items[0].Error, "unexpected end of JSON input")
+	assert.Empty(t, items[0].WithoutValue().Value)
+	assert.Equal(t, `{"id":"r2","uri":`, items[0].Value)
+
+	_, err = s.PrepareRepair(ctx, "r1", `{"id":"r1","uri":"/r1"}`)
+	assert.EqualError(t, err, "key: r1 is not found in the quarantine")
+	_, err = s.PrepareRepair(ctx, "r2", `{"id":"r3","uri":"/r2"}`)
+	assert.EqualError(t, err, "key: r2 doesn't match the key of the object: r3")
+
+	// nothing is written in a dry run
+	op, err := s.PrepareRepair(ctx, "r2", `{"uri":"/r2"}`)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Commit(WithDryRun(ctx), []*Op{op}))
+	assert.Len(t, s.ListQuarantine(ctx), 1)
+
+	assert.Nil(t, s.Commit(ctx, []*Op{op}))
+	assert.Eventually(t, func() bool {
+		obj, err := s.Get(ctx, "r2")
+		return err == nil && obj.(*entity.Route).URI == "/r2" && len(s.ListQuarantine(ctx)) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// an entry broken later is taken out of the cache
+	_, err = stg.Update(ctx, "/apisix/routes/r1", `{"id":"r1","uri":1}`, 0)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		_, err := s.Get(ctx, "r1")
+		return err == data.ErrNotFound && len(s.ListQuarantine(ctx)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the entry is modified since it's quarantined
+	op, err = s.PrepareDiscard(ctx, "r1")
+	assert.Nil(t, err)
+	_, err = stg.Update(ctx, "/apisix/routes/r1", `{"id":"r1","uri":2}`, 0)
+	assert.Nil(t, err)
+	err = s.Commit(ctx, []*Op{op})
+	assert.True(t, errors.Is(err, storage.ErrRevisionMismatch))
+
+	assert.Eventually(t, func() bool {
+		items := s.ListQuarantine(ctx)
+		return len(items) == 1 && items[0].Value == `{"id":"r1","uri":2}`
+	}, 5*time.Second, 10*time.Millisecond)
+	op, err = s.PrepareDiscard(ctx, "r1")
+	assert.Nil(t, err)
+	assert.Nil(t, s.Commit(ctx, []*Op{op}))
+	assert.Eventually(t, func() bool {
+		return len(s.ListQuarantine(ctx)) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = stg.Get(ctx, "/apisix/routes/r1")
+	assert.NotNil(t, err)
+}
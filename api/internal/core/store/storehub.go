@@ -18,10 +18,14 @@ package store
 
 import (
 	"fmt"
+	"path"
 	"reflect"
+	"sort"
+	"time"
 
 	"github.com/apisix/manager-api/internal/conf"
 	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/storage"
 	"github.com/apisix/manager-api/internal/log"
 	"github.com/apisix/manager-api/internal/utils"
 )
@@ -44,10 +48,94 @@ const (
 )
 
 var (
-	storeHub = map[HubKey]*GenericStore{}
+	storeHub = Hub{}
+
+	// hubsWithHistory are the stores recording the revisions of objects
+	hubsWithHistory = map[HubKey]struct{}{
+		HubKeyConsumer:     {},
+		HubKeyRoute:        {},
+		HubKeyService:      {},
+		HubKeySsl:          {},
+		HubKeyUpstream:     {},
+		HubKeyGlobalRule:   {},
+		HubKeyPluginConfig: {},
+		HubKeyProto:        {},
+		HubKeyStreamRoute:  {},
+	}
+
+	// hubsWithTrash are the stores keeping the deleted objects in the trash,
+	// the scripts are kept along with their routes
+	hubsWithTrash = map[HubKey]struct{}{
+		HubKeyConsumer:     {},
+		HubKeyRoute:        {},
+		HubKeyService:      {},
+		HubKeySsl:          {},
+		HubKeyUpstream:     {},
+		HubKeyScript:       {},
+		HubKeyGlobalRule:   {},
+		HubKeyPluginConfig: {},
+		HubKeyProto:        {},
+		HubKeyStreamRoute:  {},
+	}
 )
 
 func InitStore(key HubKey, opt GenericStoreOption) error {
+	opt.HubKey = key
+	s, err := newHubStore(opt, nil)
+	if err != nil {
+		return err
+	}
+
+	s.routing = true
+	utils.AppendToClosers(s.Close)
+	storeHub[key] = s
+	return nil
+}
+
+// Hub is the stores of all the resources of an APISIX cluster.
+type Hub map[HubKey]*GenericStore
+
+// NewHub initializes the stores of all the resources under the prefix of the
+// storage, such as the etcd of another APISIX cluster, with their revisions
+// and trash under dataPrefix. Unlike InitStores, they are kept apart from
+// the stores of GetStore, and are closed by Close.
+func NewHub(stg storage.Interface, prefix, dataPrefix string) (Hub, error) {
+	hub := Hub{}
+	for _, opt := range HubOptions(prefix, dataPrefix) {
+		s, err := newHubStore(opt, stg)
+		if err != nil {
+			_ = hub.Close()
+			return nil, err
+		}
+		hub[opt.HubKey] = s
+	}
+	return hub, nil
+}
+
+// SyncStatuses returns the sync status of every store of the hub, ordered
+// by resource.
+func (h Hub) SyncStatuses() []SyncStatus {
+	ret := make([]SyncStatus, 0, len(h))
+	for _, s := range h {
+		ret = append(ret, s.SyncStatus())
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Resource < ret[j].Resource
+	})
+	return ret
+}
+
+// Close closes all the stores of the hub.
+func (h Hub) Close() error {
+	for _, s := range h {
+		_ = s.Close()
+	}
+	return nil
+}
+
+// newHubStore initializes the store of opt.HubKey along with its schema
+// validator. The storage of the configuration is used when stg is nil.
+func newHubStore(opt GenericStoreOption, stg storage.Interface) (*GenericStore, error) {
 	hubsNeedCheck := map[HubKey]bool{
 		HubKeyConsumer:     true,
 		HubKeyRoute:        true,
@@ -59,27 +147,29 @@ func InitStore(key HubKey, opt GenericStoreOption) error {
 		HubKeySystemConfig: true,
 	}
 
+	key := opt.HubKey
 	if _, ok := hubsNeedCheck[key]; ok {
 		validator, err := NewAPISIXJsonSchemaValidator("main." + string(key))
 		if err != nil {
-			return err
+			return nil, err
 		}
 		opt.Validator = validator
 	}
-	opt.HubKey = key
 	s, err := NewGenericStore(opt)
 	if err != nil {
 		log.Errorf("NewGenericStore error: %s", err)
-		return err
+		return nil, err
+	}
+	if stg != nil {
+		s.Stg = stg
 	}
 	if err := s.Init(); err != nil {
 		log.Errorf("GenericStore init error: %s", err)
-		return err
+		return nil, err
 	}
 
-	utils.AppendToClosers(s.Close)
-	storeHub[key] = s
-	return nil
+	s.startTrashPruner()
+	return s, nil
 }
 
 func GetStore(key HubKey) *GenericStore {
@@ -99,150 +189,216 @@ func RangeStore(f func(key HubKey, store *GenericStore) bool) {
 	}
 }
 
+// SyncStatuses returns the sync status of every store, ordered by resource.
+func SyncStatuses() []SyncStatus {
+	return storeHub.SyncStatuses()
+}
+
 func InitStores() error {
-	err := InitStore(HubKeyConsumer, GenericStoreOption{
-		BasePath: conf.ETCDConfig.Prefix + "/consumers",
-		ObjType:  reflect.TypeOf(entity.Consumer{}),
-		KeyFunc: func(obj interface{}) string {
-			r := obj.(*entity.Consumer)
-			return r.Username
-		},
-	})
-	if err != nil {
-		return err
+	// there is no history with the standalone storage
+	dataPrefix := conf.ETCDConfig.DataPrefix
+	if conf.StorageConfig.Type == conf.StorageTypeStandalone {
+		dataPrefix = ""
 	}
-
-	err = InitStore(HubKeyRoute, GenericStoreOption{
-		BasePath: conf.ETCDConfig.Prefix + "/routes",
-		ObjType:  reflect.TypeOf(entity.Route{}),
-		KeyFunc: func(obj interface{}) string {
-			r := obj.(*entity.Route)
-			return utils.InterfaceToString(r.ID)
-		},
-	})
-	if err != nil {
-		return err
+	for _, opt := range HubOptions(conf.ETCDConfig.Prefix, dataPrefix) {
+		if err := InitStore(opt.HubKey, opt); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	err = InitStore(HubKeyService, GenericStoreOption{
-		BasePath: conf.ETCDConfig.Prefix + "/services",
-		ObjType:  reflect.TypeOf(entity.Service{}),
-		KeyFunc: func(obj interface{}) string {
-			r := obj.(*entity.Service)
-			return utils.InterfaceToString(r.ID)
+// HubOptions returns the options of the stores of all the resources under
+// the prefix. The stores keeping revisions and deleted objects keep them
+// under dataPrefix, there are none when it's empty. The schema validators
+// are added by NewHub and InitStores.
+func HubOptions(prefix, dataPrefix string) []GenericStoreOption {
+	opts := []GenericStoreOption{
+		{
+			HubKey:   HubKeyConsumer,
+			BasePath: prefix + "/consumers",
+			ObjType:  reflect.TypeOf(entity.Consumer{}),
+			KeyFunc: func(obj interface{}) string {
+				r := obj.(*entity.Consumer)
+				return r.Username
+			},
+			Indexes: map[string]IndexFunc{
+				IndexLabel: func(obj interface{}) []string {
+					return labelIndex(obj.(*entity.Consumer).Labels)
+				},
+				IndexProtoID: protoIDIndex,
+			},
 		},
-	})
-	if err != nil {
-		return err
-	}
-
-	err = InitStore(HubKeySsl, GenericStoreOption{
-		BasePath: conf.ETCDConfig.Prefix + "/ssls",
-		ObjType:  reflect.TypeOf(entity.SSL{}),
-		KeyFunc: func(obj interface{}) string {
-			r := obj.(*entity.SSL)
-			return utils.InterfaceToString(r.ID)
+		{
+			HubKey:   HubKeyRoute,
+			BasePath: prefix + "/routes",
+			ObjType:  reflect.TypeOf(entity.Route{}),
+			KeyFunc: func(obj interface{}) string {
+				r := obj.(*entity.Route)
+				return utils.InterfaceToString(r.ID)
+			},
+			Indexes: map[string]IndexFunc{
+				IndexName: func(obj interface{}) []string {
+					return []string{obj.(*entity.Route).Name}
+				},
+				IndexUpstreamID: func(obj interface{}) []string {
+					return []string{utils.InterfaceToString(obj.(*entity.Route).UpstreamID)}
+				},
+				IndexServiceID: func(obj interface{}) []string {
+					return []string{utils.InterfaceToString(obj.(*entity.Route).ServiceID)}
+				},
+				IndexPluginConfigID: func(obj interface{}) []string {
+					return []string{utils.InterfaceToString(obj.(*entity.Route).PluginConfigID)}
+				},
+				IndexLabel: func(obj interface{}) []string {
+					return labelIndex(obj.(*entity.Route).Labels)
+				},
+				IndexProtoID: protoIDIndex,
+			},
 		},
-	})
-	if err != nil {
-		return err
-	}
-
-	err = InitStore(HubKeyUpstream, GenericStoreOption{
-		BasePath: conf.ETCDConfig.Prefix + "/upstreams",
-		ObjType:  reflect.TypeOf(entity.Upstream{}),
-		KeyFunc: func(obj interface{}) string {
-			r := obj.(*entity.Upstream)
-			return utils.InterfaceToString(r.ID)
+		{
+			HubKey:   HubKeyService,
+			BasePath: prefix + "/services",
+			ObjType:  reflect.TypeOf(entity.Service{}),
+			KeyFunc: func(obj interface{}) string {
+				r := obj.(*entity.Service)
+				return utils.InterfaceToString(r.ID)
+			},
+			Indexes: map[string]IndexFunc{
+				IndexName: func(obj interface{}) []string {
+					return []string{obj.(*entity.Service).Name}
+				},
+				IndexUpstreamID: func(obj interface{}) []string {
+					return []string{utils.InterfaceToString(obj.(*entity.Service).UpstreamID)}
+				},
+				IndexLabel: func(obj interface{}) []string {
+					return labelIndex(obj.(*entity.Service).Labels)
+				},
+				IndexProtoID: protoIDIndex,
+			},
 		},
-	})
-	if err != nil {
-		return err
-	}
-
-	err = InitStore(HubKeyScript, GenericStoreOption{
-		BasePath: conf.ETCDConfig.Prefix + "/scripts",
-		ObjType:  reflect.TypeOf(entity.Script{}),
-		KeyFunc: func(obj interface{}) string {
-			r := obj.(*entity.Script)
-			return r.ID
+		{
+			HubKey:   HubKeySsl,
+			BasePath: prefix + "/ssls",
+			ObjType:  reflect.TypeOf(entity.SSL{}),
+			KeyFunc: func(obj interface{}) string {
+				r := obj.(*entity.SSL)
+				return utils.InterfaceToString(r.ID)
+			},
+			Indexes: map[string]IndexFunc{
+				IndexLabel: func(obj interface{}) []string {
+					return labelIndex(obj.(*entity.SSL).Labels)
+				},
+			},
 		},
-	})
-	if err != nil {
-		return err
-	}
-
-	err = InitStore(HubKeyGlobalRule, GenericStoreOption{
-		BasePath: conf.ETCDConfig.Prefix + "/global_rules",
-		ObjType:  reflect.TypeOf(entity.GlobalPlugins{}),
-		KeyFunc: func(obj interface{}) string {
-			r := obj.(*entity.GlobalPlugins)
-			return utils.InterfaceToString(r.ID)
+		{
+			HubKey:   HubKeyUpstream,
+			BasePath: prefix + "/upstreams",
+			ObjType:  reflect.TypeOf(entity.Upstream{}),
+			KeyFunc: func(obj interface{}) string {
+				r := obj.(*entity.Upstream)
+				return utils.InterfaceToString(r.ID)
+			},
+			Indexes: map[string]IndexFunc{
+				IndexName: func(obj interface{}) []string {
+					return []string{obj.(*entity.Upstream).Name}
+				},
+				IndexLabel: func(obj interface{}) []string {
+					return labelIndex(obj.(*entity.Upstream).Labels)
+				},
+			},
 		},
-	})
-	if err != nil {
-		return err
-	}
-
-	err = InitStore(HubKeyServerInfo, GenericStoreOption{
-		BasePath: conf.ETCDConfig.Prefix + "/data_plane/server_info",
-		ObjType:  reflect.TypeOf(entity.ServerInfo{}),
-		KeyFunc: func(obj interface{}) string {
-			r := obj.(*entity.ServerInfo)
-			return utils.InterfaceToString(r.ID)
+		{
+			HubKey:   HubKeyScript,
+			BasePath: prefix + "/scripts",
+			ObjType:  reflect.TypeOf(entity.Script{}),
+			KeyFunc: func(obj interface{}) string {
+				r := obj.(*entity.Script)
+				return r.ID
+			},
 		},
-	})
-	if err != nil {
-		return err
-	}
-
-	err = InitStore(HubKeyPluginConfig, GenericStoreOption{
-		BasePath: conf.ETCDConfig.Prefix + "/plugin_configs",
-		ObjType:  reflect.TypeOf(entity.PluginConfig{}),
-		KeyFunc: func(obj interface{}) string {
-			r := obj.(*entity.PluginConfig)
-			return utils.InterfaceToString(r.ID)
+		{
+			HubKey:   HubKeyGlobalRule,
+			BasePath: prefix + "/global_rules",
+			ObjType:  reflect.TypeOf(entity.GlobalPlugins{}),
+			KeyFunc: func(obj interface{}) string {
+				r := obj.(*entity.GlobalPlugins)
+				return utils.InterfaceToString(r.ID)
+			},
+			Indexes: map[string]IndexFunc{
+				IndexProtoID: protoIDIndex,
+			},
 		},
-	})
-	if err != nil {
-		return err
-	}
-
-	err = InitStore(HubKeyProto, GenericStoreOption{
-		BasePath: conf.ETCDConfig.Prefix + "/protos",
-		ObjType:  reflect.TypeOf(entity.Proto{}),
-		KeyFunc: func(obj interface{}) string {
-			r := obj.(*entity.Proto)
-			return utils.InterfaceToString(r.ID)
+		{
+			HubKey:   HubKeyServerInfo,
+			BasePath: prefix + "/data_plane/server_info",
+			ObjType:  reflect.TypeOf(entity.ServerInfo{}),
+			KeyFunc: func(obj interface{}) string {
+				r := obj.(*entity.ServerInfo)
+				return utils.InterfaceToString(r.ID)
+			},
 		},
-	})
-	if err != nil {
-		return err
-	}
-
-	err = InitStore(HubKeyStreamRoute, GenericStoreOption{
-		BasePath: conf.ETCDConfig.Prefix + "/stream_routes",
-		ObjType:  reflect.TypeOf(entity.StreamRoute{}),
-		KeyFunc: func(obj interface{}) string {
-			r := obj.(*entity.StreamRoute)
-			return utils.InterfaceToString(r.ID)
+		{
+			HubKey:   HubKeyPluginConfig,
+			BasePath: prefix + "/plugin_configs",
+			ObjType:  reflect.TypeOf(entity.PluginConfig{}),
+			KeyFunc: func(obj interface{}) string {
+				r := obj.(*entity.PluginConfig)
+				return utils.InterfaceToString(r.ID)
+			},
+			Indexes: map[string]IndexFunc{
+				IndexLabel: func(obj interface{}) []string {
+					return labelIndex(obj.(*entity.PluginConfig).Labels)
+				},
+				IndexProtoID: protoIDIndex,
+			},
 		},
-	})
-	if err != nil {
-		return err
-	}
-
-	err = InitStore(HubKeySystemConfig, GenericStoreOption{
-		BasePath: conf.ETCDConfig.Prefix + "/system_config",
-		ObjType:  reflect.TypeOf(entity.SystemConfig{}),
-		KeyFunc: func(obj interface{}) string {
-			r := obj.(*entity.SystemConfig)
-			return r.ConfigName
+		{
+			HubKey:   HubKeyProto,
+			BasePath: prefix + "/protos",
+			ObjType:  reflect.TypeOf(entity.Proto{}),
+			KeyFunc: func(obj interface{}) string {
+				r := obj.(*entity.Proto)
+				return utils.InterfaceToString(r.ID)
+			},
+		},
+		{
+			HubKey:   HubKeyStreamRoute,
+			BasePath: prefix + "/stream_routes",
+			ObjType:  reflect.TypeOf(entity.StreamRoute{}),
+			KeyFunc: func(obj interface{}) string {
+				r := obj.(*entity.StreamRoute)
+				return utils.InterfaceToString(r.ID)
+			},
+			Indexes: map[string]IndexFunc{
+				IndexUpstreamID: func(obj interface{}) []string {
+					return []string{utils.InterfaceToString(obj.(*entity.StreamRoute).UpstreamID)}
+				},
+			},
+		},
+		{
+			HubKey:   HubKeySystemConfig,
+			BasePath: prefix + "/system_config",
+			ObjType:  reflect.TypeOf(entity.SystemConfig{}),
+			KeyFunc: func(obj interface{}) string {
+				r := obj.(*entity.SystemConfig)
+				return r.ConfigName
+			},
 		},
-	})
-	if err != nil {
-		return err
 	}
-
-	return nil
+	if dataPrefix == "" {
+		return opts
+	}
+	for i := range opts {
+		opt := &opts[i]
+		if _, ok := hubsWithHistory[opt.HubKey]; ok {
+			opt.HistoryPath = dataPrefix + "/revisions/" + path.Base(opt.BasePath)
+			opt.HistoryLimit = conf.MaxRevisions
+		}
+		if _, ok := hubsWithTrash[opt.HubKey]; ok {
+			opt.TrashPath = dataPrefix + "/trash/" + path.Base(opt.BasePath)
+			opt.TrashRetention = time.Duration(conf.TrashRetention) * time.Second
+		}
+	}
+	return opts
 }
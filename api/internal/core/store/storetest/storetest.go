@@ -0,0 +1,47 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package storetest builds the stores of the hub for tests.
+package storetest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/core/store"
+)
+
+// NewStores returns the stores of all the resources under /apisix over stg,
+// with the options of the hub but without the schema validators. Revisions
+// and deleted objects are kept under dataPrefix, unless it's empty. The
+// stores are closed when the test finishes.
+func NewStores(t *testing.T, stg storage.Interface, dataPrefix string) map[store.HubKey]store.Interface {
+	stores := map[store.HubKey]store.Interface{}
+	for _, opt := range store.HubOptions("/apisix", dataPrefix) {
+		s, err := store.NewGenericStore(opt)
+		assert.Nil(t, err)
+		s.Stg = stg
+		assert.Nil(t, s.Init())
+		t.Cleanup(func() {
+			_ = s.Close()
+		})
+		stores[opt.HubKey] = s
+	}
+	return stores
+}
@@ -0,0 +1,214 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/log"
+	"github.com/apisix/manager-api/internal/utils/runtime"
+)
+
+// trashPruneInterval is how often the objects beyond the retention are
+// removed from the trash.
+var trashPruneInterval = time.Hour
+
+// TrashItem is a deleted object kept in the trash.
+type TrashItem struct {
+	Key        string          `json:"key"`
+	DeleteTime int64           `json:"delete_time"`
+	User       string          `json:"user,omitempty"`
+	Object     json.RawMessage `json:"object"`
+}
+
+func (s *GenericStore) trashEnabled() bool {
+	return s.opt.TrashPath != "" && s.opt.TrashRetention > 0
+}
+
+func (s *GenericStore) trashKey(key string) string {
+	return s.opt.TrashPath + "/" + key
+}
+
+// trashOp returns the write moving the deleted object into the trash, an
+// object deleted before is overwritten.
+func (s *GenericStore) trashOp(ctx context.Context, key string, value []byte) *storage.Op {
+	if !s.trashEnabled() {
+		return nil
+	}
+
+	bs, err := json.Marshal(TrashItem{
+		Key:        key,
+		DeleteTime: time.Now().Unix(),
+		User:       UserFromContext(ctx),
+		Object:     value,
+	})
+	if err != nil {
+		log.Errorf("json marshal failed: %s", err)
+		return nil
+	}
+	return &storage.Op{
+		Type:  storage.OpUpdate,
+		Key:   s.trashKey(key),
+		Value: string(bs),
+	}
+}
+
+// ListTrash returns the deleted objects kept in the trash, the latest
+// deleted first.
+func (s *GenericStore) ListTrash(ctx context.Context) ([]*TrashItem, error) {
+	if c := s.route(ctx); c != s {
+		return c.ListTrash(ctx)
+	}
+	if !s.trashEnabled() {
+		return nil, fmt.Errorf("trash of %s is not enabled", s.opt.HubKey)
+	}
+
+	ret, _, err := s.Stg.List(ctx, s.opt.TrashPath+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*TrashItem, 0, len(ret))
+	for i := range ret {
+		item := &TrashItem{}
+		if err := json.Unmarshal([]byte(ret[i].Value), item); err != nil {
+			log.Warnf("json unmarshal trash item of key: %s failed: %s", ret[i].Key, err)
+			continue
+		}
+		items = append(items, item)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].DeleteTime != items[j].DeleteTime {
+			return items[i].DeleteTime > items[j].DeleteTime
+		}
+		return items[i].Key < items[j].Key
+	})
+	return items, nil
+}
+
+// GetTrash returns the deleted object of the key.
+func (s *GenericStore) GetTrash(ctx context.Context, key string) (interface{}, error) {
+	if c := s.route(ctx); c != s {
+		return c.GetTrash(ctx, key)
+	}
+	if !s.trashEnabled() {
+		return nil, fmt.Errorf("trash of %s is not enabled", s.opt.HubKey)
+	}
+
+	val, err := s.Stg.Get(ctx, s.trashKey(key))
+	if err != nil {
+		return nil, err
+	}
+	item := &TrashItem{}
+	if err := json.Unmarshal([]byte(val), item); err != nil {
+		return nil, fmt.Errorf("json unmarshal failed: %s", err)
+	}
+	return s.StringToObjPtr(string(item.Object), key)
+}
+
+// PrepareUndelete runs the same checks as Create on the object taken from
+// the trash by GetTrash, and returns the write recreating it and removing
+// it from the trash.
+func (s *GenericStore) PrepareUndelete(ctx context.Context, obj interface{}) (*Op, error) {
+	if c := s.route(ctx); c != s {
+		return c.PrepareUndelete(ctx, obj)
+	}
+	op, err := s.PrepareCreate(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+	op.trash = &storage.Op{
+		Type: storage.OpDelete,
+		Key:  s.trashKey(op.key),
+	}
+	return op, nil
+}
+
+// PurgeTrash removes the deleted objects from the trash for good.
+func (s *GenericStore) PurgeTrash(ctx context.Context, keys []string) error {
+	if c := s.route(ctx); c != s {
+		return c.PurgeTrash(ctx, keys)
+	}
+	if !s.trashEnabled() {
+		return fmt.Errorf("trash of %s is not enabled", s.opt.HubKey)
+	}
+
+	var trashKeys []string
+	for _, key := range keys {
+		trashKeys = append(trashKeys, s.trashKey(key))
+	}
+	if IsDryRun(ctx) {
+		for _, key := range trashKeys {
+			if _, err := s.Stg.Get(ctx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return s.Stg.BatchDelete(ctx, trashKeys)
+}
+
+// pruneTrash removes the objects deleted before the retention.
+func (s *GenericStore) pruneTrash(ctx context.Context) {
+	items, err := s.ListTrash(ctx)
+	if err != nil {
+		log.Warnf("list trash of %s failed: %s", s.opt.HubKey, err)
+		return
+	}
+
+	deadline := time.Now().Add(-s.opt.TrashRetention).Unix()
+	var keys []string
+	for _, item := range items {
+		if item.DeleteTime < deadline {
+			keys = append(keys, item.Key)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	if err := s.PurgeTrash(ctx, keys); err != nil {
+		log.Warnf("prune trash of %s failed: %s", s.opt.HubKey, err)
+	}
+}
+
+// startTrashPruner prunes the trash periodically until the store is closed.
+func (s *GenericStore) startTrashPruner() {
+	if !s.trashEnabled() {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	s.trashCancel = cancel
+	go func() {
+		defer runtime.HandlePanic()
+		ticker := time.NewTicker(trashPruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.pruneTrash(ctx)
+			}
+		}
+	}()
+}
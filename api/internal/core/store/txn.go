@@ -0,0 +1,140 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// Txn collects writes across stores and commits them all-or-nothing.
+// All the stores in a Txn must share the same storage.
+type Txn struct {
+	ops []*Op
+	// committer is the store of the first write, used to commit all of them
+	committer Interface
+}
+
+func NewTxn() *Txn {
+	return &Txn{}
+}
+
+func (t *Txn) add(s Interface, op *Op) {
+	if t.committer == nil {
+		t.committer = s
+	}
+	t.ops = append(t.ops, op)
+}
+
+func (t *Txn) Create(ctx context.Context, s Interface, obj interface{}) error {
+	op, err := s.PrepareCreate(ctx, obj)
+	if err != nil {
+		return err
+	}
+	t.add(s, op)
+	return nil
+}
+
+func (t *Txn) Update(ctx context.Context, s Interface, obj interface{}, createIfNotExist bool) error {
+	op, err := s.PrepareUpdate(ctx, obj, createIfNotExist)
+	if err != nil {
+		return err
+	}
+	t.add(s, op)
+	return nil
+}
+
+func (t *Txn) Delete(ctx context.Context, s Interface, keys ...string) error {
+	if expectedVersion(ctx) > 0 && len(keys) != 1 {
+		return fmt.Errorf("resource version is invalid when deleting multiple keys")
+	}
+	for i := range keys {
+		op, err := s.PrepareDelete(ctx, keys[i])
+		if err != nil {
+			return err
+		}
+		t.add(s, op)
+	}
+	return nil
+}
+
+// Undelete recreates the object taken from the trash by GetTrash.
+func (t *Txn) Undelete(ctx context.Context, s Interface, obj interface{}) error {
+	op, err := s.PrepareUndelete(ctx, obj)
+	if err != nil {
+		return err
+	}
+	t.add(s, op)
+	return nil
+}
+
+// Append adds the writes of other after the writes of t.
+func (t *Txn) Append(other *Txn) {
+	for _, op := range other.ops {
+		t.add(other.committer, op)
+	}
+}
+
+// Len returns the number of writes in the transaction.
+func (t *Txn) Len() int {
+	return len(t.ops)
+}
+
+// Slice returns the writes from i to j in a transaction of their own.
+func (t *Txn) Slice(i, j int) *Txn {
+	return &Txn{ops: t.ops[i:j], committer: t.committer}
+}
+
+// CheckSize returns storage.ErrTxnTooLarge when the writes take more
+// storage operations than a transaction carries, so that Commit would fail.
+func (t *Txn) CheckSize() error {
+	return checkTxnSize(t.ops)
+}
+
+// Commit applies all the collected writes, nothing is applied on error.
+// Writes taking more than storage.MaxTxnOps storage operations, such as
+// recording their revisions, are rejected with storage.ErrTxnTooLarge,
+// see CommitParts.
+func (t *Txn) Commit(ctx context.Context) error {
+	if len(t.ops) == 0 {
+		return nil
+	}
+	return t.committer.Commit(ctx, t.ops)
+}
+
+// Split splits the writes in order into transactions within
+// storage.MaxTxnOps, for the writes which don't need to be applied
+// all-or-nothing. Each of them is committed atomically, but not across
+// them: when one fails, those before it are applied already.
+func (t *Txn) Split() []*Txn {
+	var txns []*Txn
+	for _, ops := range splitOps(t.ops) {
+		txns = append(txns, &Txn{ops: ops, committer: t.committer})
+	}
+	return txns
+}
+
+// CommitParts commits the writes of Split in order, and stops at the
+// first part failing. The parts before it are applied already.
+func (t *Txn) CommitParts(ctx context.Context) error {
+	for _, part := range t.Split() {
+		if err := part.Commit(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/apisix/manager-api/internal/core/storage"
+)
+
+func TestTxn_Commit(t *testing.T) {
+	keyFunc := func(obj interface{}) string {
+		return obj.(*TestStruct).Field1
+	}
+	tests := []struct {
+		caseDesc    string
+		giveErr     error
+		wantOps     []storage.Op
+		wantErr     error
+		wantVersion int64
+	}{
+		{
+			caseDesc: "sanity",
+			wantOps: []storage.Op{
+				{Type: storage.OpCreate, Key: "routes/r2"},
+				{Type: storage.OpUpdate, Key: "routes/r1", Revision: 3},
+				{Type: storage.OpDelete, Key: "scripts/s1"},
+			},
+			wantVersion: 10,
+		},
+		{
+			caseDesc: "txn failed",
+			giveErr:  fmt.Errorf("key: routes/r2 is conflicted"),
+			wantErr:  fmt.Errorf("key: routes/r2 is conflicted"),
+		},
+	}
+
+	for _, tc := range tests {
+		mStorage := &storage.MockInterface{}
+		var gotOps []storage.Op
+		mStorage.On("Txn", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			gotOps = args.Get(1).([]storage.Op)
+		}).Return(int64(10), tc.giveErr)
+
+		routeStore := &GenericStore{Stg: mStorage, opt: GenericStoreOption{BasePath: "routes", KeyFunc: keyFunc}}
+		routeStore.cache.Store("r1", &TestStruct{Field1: "r1"})
+		scriptStore := &GenericStore{Stg: mStorage, opt: GenericStoreOption{BasePath: "scripts", KeyFunc: keyFunc}}
+		scriptStore.cache.Store("s1", &TestStruct{Field1: "s1"})
+
+		created := &TestStruct{Field1: "r2"}
+		updated := &TestStruct{Field1: "r1"}
+		txn := NewTxn()
+		assert.Nil(t, txn.Create(context.TODO(), routeStore, created), tc.caseDesc)
+		assert.Nil(t, txn.Update(WithExpectedVersion(context.TODO(), 3), routeStore, updated, false), tc.caseDesc)
+		assert.Nil(t, txn.Delete(context.TODO(), scriptStore, "s1"), tc.caseDesc)
+		assert.Equal(t, 3, txn.Len(), tc.caseDesc)
+
+		err := txn.Commit(context.TODO())
+		assert.Equal(t, tc.wantErr, err, tc.caseDesc)
+		if err != nil {
+			continue
+		}
+		assert.Equal(t, len(tc.wantOps), len(gotOps), tc.caseDesc)
+		for i := range tc.wantOps {
+			assert.Equal(t, tc.wantOps[i].Type, gotOps[i].Type, tc.caseDesc)
+			assert.Equal(t, tc.wantOps[i].Key, gotOps[i].Key, tc.caseDesc)
+			assert.Equal(t, tc.wantOps[i].Revision, gotOps[i].Revision, tc.caseDesc)
+		}
+		assert.Equal(t, tc.wantVersion, created.ResourceVersion, tc.caseDesc)
+		assert.Equal(t, tc.wantVersion, updated.ResourceVersion, tc.caseDesc)
+	}
+}
+
+func TestTxn_Prepare(t *testing.T) {
+	keyFunc := func(obj interface{}) string {
+		return obj.(*TestStruct).Field1
+	}
+	s := &GenericStore{opt: GenericStoreOption{BasePath: "routes", KeyFunc: keyFunc}}
+	s.cache.Store("r1", &TestStruct{Field1: "r1"})
+
+	txn := NewTxn()
+	err := txn.Create(context.TODO(), s, &TestStruct{Field1: "r1"})
+	assert.Equal(t, fmt.Errorf("key: r1 is conflicted"), err)
+
+	err = txn.Update(context.TODO(), s, &TestStruct{Field1: "r2"}, false)
+	assert.Equal(t, fmt.Errorf("key: r2 is not found"), err)
+
+	err = txn.Delete(context.TODO(), s, "r2")
+	assert.Equal(t, fmt.Errorf("key: r2 is not found"), err)
+
+	err = txn.Delete(WithExpectedVersion(context.TODO(), 1), s, "r1", "r2")
+	assert.Equal(t, fmt.Errorf("resource version is invalid when deleting multiple keys"), err)
+
+	assert.Equal(t, 0, txn.Len())
+	assert.Nil(t, txn.Commit(context.TODO()))
+}
+
+func TestTxn_TooLarge(t *testing.T) {
+	s := &GenericStore{
+		Stg: storage.NewMemoryStorage(),
+		opt: GenericStoreOption{
+			BasePath: "/apisix/routes",
+			KeyFunc: func(obj interface{}) string {
+				return obj.(*TestStruct).Field1
+			},
+			HistoryPath:  "/apisix/revisions/routes",
+			HistoryLimit: 10,
+		},
+	}
+
+	// each create takes 2 operations with its revision
+	txn := NewTxn()
+	for i := 0; i < storage.MaxTxnOps/2+1; i++ {
+		assert.Nil(t, txn.Create(context.TODO(), s, &TestStruct{Field1: fmt.Sprintf("r%d", i)}))
+	}
+	err := txn.Commit(context.TODO())
+	assert.True(t, errors.Is(err, storage.ErrTxnTooLarge))
+	assert.Equal(t, "too many operations in a transaction: 65 writes take 130 operations, "+
+		"more than the limit of 128 set by etcd.max_txn_ops", err.Error())
+	ret, _, err := s.Stg.List(context.TODO(), "/apisix/")
+	assert.Nil(t, err)
+	assert.Len(t, ret, 0)
+
+	// nor in a dry run
+	err = txn.Commit(WithDryRun(context.TODO()))
+	assert.True(t, errors.Is(err, storage.ErrTxnTooLarge))
+
+	txns := txn.Split()
+	assert.Len(t, txns, 2)
+	assert.Equal(t, storage.MaxTxnOps/2, txns[0].Len())
+	assert.Equal(t, 1, txns[1].Len())
+	assert.Nil(t, txn.CommitParts(context.TODO()))
+	ret, _, err = s.Stg.List(context.TODO(), "/apisix/routes/")
+	assert.Nil(t, err)
+	assert.Len(t, ret, storage.MaxTxnOps/2+1)
+	ret, _, err = s.Stg.List(context.TODO(), "/apisix/revisions/routes/")
+	assert.Nil(t, err)
+	assert.Len(t, ret, storage.MaxTxnOps/2+1)
+}
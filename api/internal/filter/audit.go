@@ -0,0 +1,33 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package filter
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/apisix/manager-api/internal/core/audit"
+)
+
+// Audit attaches the client IP and the request ID to the request context,
+// which are recorded along with the writes.
+func Audit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := audit.WithRequest(c.Request.Context(), c.ClientIP(), c.GetString("X-Request-Id"))
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/golang-jwt/jwt"
 
 	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/core/store"
 	"github.com/apisix/manager-api/internal/log"
 )
 
@@ -79,11 +80,13 @@ func Authentication() gin.HandlerFunc {
 				c.AbortWithStatusJSON(http.StatusUnauthorized, errResp)
 				return
 			}
+			c.Request = c.Request.WithContext(store.WithUser(c.Request.Context(), claims.Subject))
 		} else {
 			if cookie.Values["oidc_id"] != conf.OidcId {
 				c.AbortWithStatusJSON(http.StatusUnauthorized, errResp)
 				return
 			}
+			c.Request = c.Request.WithContext(store.WithUser(c.Request.Context(), conf.OidcId))
 		}
 
 		c.Next()
@@ -0,0 +1,73 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package filter
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/utils/consts"
+)
+
+// ClusterHeader names the cluster a request is for, the local one by default.
+const ClusterHeader = "X-APISIX-Cluster"
+
+const clusterPathPrefix = "/apisix/admin/clusters/"
+
+// ClusterPath serves /apisix/admin/clusters/<name>/<path> as
+// /apisix/admin/<path> with the ClusterHeader set to the name. It wraps the
+// router since the path has to be rewritten before the routes are matched.
+func ClusterPath(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rest := strings.TrimPrefix(r.URL.Path, clusterPathPrefix); rest != r.URL.Path {
+			if i := strings.Index(rest, "/"); i > 0 {
+				r.Header.Set(ClusterHeader, rest[:i])
+				r.URL.Path = "/apisix/admin" + rest[i:]
+				r.URL.RawPath = ""
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Cluster makes the stores read and write the cluster named by the
+// ClusterHeader of a request.
+func Cluster() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.GetHeader(ClusterHeader)
+		if name == "" {
+			c.Next()
+			return
+		}
+		if _, ok := store.GetCluster(name); !ok {
+			if err := store.ClusterError(name); err != nil {
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable,
+					consts.Unavailable(fmt.Sprintf("cluster %s is unavailable: %s", name, err)))
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusNotFound,
+				consts.NotFound(fmt.Sprintf("cluster %s not found", name)))
+			return
+		}
+		c.Request = c.Request.WithContext(store.WithCluster(c.Request.Context(), name))
+		c.Next()
+	}
+}
@@ -0,0 +1,62 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package filter
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/core/store"
+)
+
+func TestCluster(t *testing.T) {
+	store.AddCluster("prod", store.Hub{})
+	defer store.RemoveCluster("prod")
+
+	r := gin.New()
+	r.Use(Cluster())
+	r.GET("/apisix/admin/routes", func(c *gin.Context) {
+		c.String(200, store.ClusterFromContext(c.Request.Context()))
+	})
+	h := ClusterPath(r)
+
+	w := performRequest(h, "GET", "/apisix/admin/routes", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "", w.Body.String())
+
+	w = performRequest(h, "GET", "/apisix/admin/routes", map[string]string{ClusterHeader: "prod"})
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "prod", w.Body.String())
+
+	w = performRequest(h, "GET", "/apisix/admin/routes", map[string]string{ClusterHeader: "local"})
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "", w.Body.String())
+
+	w = performRequest(h, "GET", "/apisix/admin/clusters/prod/routes", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "prod", w.Body.String())
+
+	w = performRequest(h, "GET", "/apisix/admin/routes", map[string]string{ClusterHeader: "staging"})
+	assert.Equal(t, 404, w.Code)
+	assert.Contains(t, w.Body.String(), "cluster staging not found")
+
+	w = performRequest(h, "GET", "/apisix/admin/clusters/staging/routes", nil)
+	assert.Equal(t, 404, w.Code)
+	assert.Contains(t, w.Body.String(), "cluster staging not found")
+}
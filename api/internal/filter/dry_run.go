@@ -0,0 +1,58 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package filter
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/utils/consts"
+)
+
+// DryRun makes the writes of a request checked but not applied, when the
+// dry_run query parameter is true. The handlers return what would be
+// stored as usual.
+func DryRun() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			c.Next()
+			return
+		}
+
+		value, ok := c.GetQuery("dry_run")
+		if !ok {
+			c.Next()
+			return
+		}
+		dryRun, err := strconv.ParseBool(value)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest,
+				consts.InvalidParam(fmt.Sprintf("dry_run %s is invalid", value)))
+			return
+		}
+		if dryRun {
+			c.Request = c.Request.WithContext(store.WithDryRun(c.Request.Context()))
+		}
+		c.Next()
+	}
+}
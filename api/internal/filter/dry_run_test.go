@@ -0,0 +1,60 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package filter
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/core/store"
+)
+
+func TestDryRun(t *testing.T) {
+	r := gin.New()
+	r.Use(DryRun())
+	handle := func(c *gin.Context) {
+		c.String(200, strconv.FormatBool(store.IsDryRun(c.Request.Context())))
+	}
+	r.GET("/routes", handle)
+	r.PUT("/routes", handle)
+	r.DELETE("/routes", handle)
+
+	w := performRequest(r, "PUT", "/routes?dry_run=true", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "true", w.Body.String())
+
+	w = performRequest(r, "DELETE", "/routes?dry_run=1", nil)
+	assert.Equal(t, "true", w.Body.String())
+
+	w = performRequest(r, "PUT", "/routes?dry_run=false", nil)
+	assert.Equal(t, "false", w.Body.String())
+
+	w = performRequest(r, "PUT", "/routes", nil)
+	assert.Equal(t, "false", w.Body.String())
+
+	// reads are never affected
+	w = performRequest(r, "GET", "/routes?dry_run=yes", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "false", w.Body.String())
+
+	w = performRequest(r, "PUT", "/routes?dry_run=yes", nil)
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "dry_run yes is invalid")
+}
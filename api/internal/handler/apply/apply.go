@@ -0,0 +1,118 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package apply
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shiningrush/droplet"
+	"github.com/shiningrush/droplet/data"
+	"github.com/shiningrush/droplet/wrapper"
+	wgin "github.com/shiningrush/droplet/wrapper/gin"
+
+	"github.com/apisix/manager-api/internal/core/apply"
+	"github.com/apisix/manager-api/internal/core/batch"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/handler"
+	handlerbatch "github.com/apisix/manager-api/internal/handler/batch"
+)
+
+type Handler struct {
+	planner *batch.Planner
+}
+
+func NewHandler() (handler.RouteRegister, error) {
+	return &Handler{planner: handlerbatch.NewPlanner()}, nil
+}
+
+func (h *Handler) ApplyRoute(r *gin.Engine) {
+	r.POST("/apisix/admin/apply", wgin.Wraps(h.Apply,
+		wrapper.InputType(reflect.TypeOf(ApplyInput{}))))
+}
+
+type ApplyInput struct {
+	Selector string `auto_read:"selector,query"`
+	Prune    bool   `auto_read:"prune,query"`
+	Body     []byte `auto_read:"@body"`
+}
+
+// swagger:operation POST /apisix/admin/apply applyBundle
+//
+// Bring the objects to the bundle: the missing ones are created, the
+// different ones are updated and, with prune, the ones in scope but not in
+// the bundle are deleted, all in one transaction. The changes are returned
+// in the order they are applied.
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: selector
+//   in: query
+//   description: label selector scoping the apply, like "team=core", everything is in scope when it's empty
+//   required: false
+//   type: string
+// - name: prune
+//   in: query
+//   description: delete the objects in scope which are missing in the bundle
+//   required: false
+//   type: boolean
+// - name: body
+//   in: body
+//   description: the objects by their types, such as {"upstream": [...], "route": [...]}, every object must have its id, or username for consumers
+//   required: true
+//   schema:
+//     type: object
+// responses:
+//   '0':
+//     description: the changes and the number of the unchanged objects
+//     schema:
+//       type: object
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) Apply(c droplet.Context) (interface{}, error) {
+	input := c.Input().(*ApplyInput)
+
+	bundle := apply.Bundle{}
+	if err := json.Unmarshal(input.Body, &bundle); err != nil {
+		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest},
+			fmt.Errorf("bundle is invalid: %s", err)
+	}
+
+	plan, err := apply.Diff(c.Context(), h.planner, bundle, apply.Options{
+		Selector: input.Selector,
+		Prune:    input.Prune,
+	})
+	if err != nil {
+		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, err
+	}
+	if err := plan.Apply(c.Context(), h.planner); err != nil {
+		var changeErr *apply.ChangeError
+		if errors.As(err, &changeErr) && !errors.Is(err, storage.ErrRevisionMismatch) {
+			return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, err
+		}
+		return handler.SpecCodeResponse(err), err
+	}
+	return plan, nil
+}
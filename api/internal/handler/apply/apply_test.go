@@ -0,0 +1,95 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package apply
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/shiningrush/droplet"
+	"github.com/shiningrush/droplet/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/apisix/manager-api/internal/core/apply"
+	"github.com/apisix/manager-api/internal/core/batch"
+	"github.com/apisix/manager-api/internal/core/graph"
+	"github.com/apisix/manager-api/internal/core/store"
+)
+
+func TestHandler_Apply(t *testing.T) {
+	tests := []struct {
+		caseDesc   string
+		input      *ApplyInput
+		wantErr    string
+		wantStatus int
+		wantPlan   []*apply.Change
+	}{
+		{
+			caseDesc: "create",
+			input:    &ApplyInput{Body: []byte(`{"upstream": [{"id": "u1"}], "route": [{"id": "r1", "upstream_id": "u1"}]}`)},
+			wantPlan: []*apply.Change{
+				{Action: "create", Type: store.HubKeyUpstream, ID: "u1"},
+				{Action: "create", Type: store.HubKeyRoute, ID: "r1"},
+			},
+		},
+		{
+			caseDesc:   "invalid bundle",
+			input:      &ApplyInput{Body: []byte(`[]`)},
+			wantErr:    "bundle is invalid: json: cannot unmarshal array into Go value of type apply.Bundle",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			caseDesc:   "out of scope",
+			input:      &ApplyInput{Selector: "team=core", Body: []byte(`{"route": [{"id": "r1"}]}`)},
+			wantErr:    "route/r1 doesn't match the selector team=core",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			caseDesc:   "dangling id",
+			input:      &ApplyInput{Body: []byte(`{"route": [{"id": "r1", "upstream_id": "u1"}]}`)},
+			wantErr:    "create route/r1: upstream id: u1 not found",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			stores := map[store.HubKey]store.Interface{}
+			for _, typ := range graph.Types {
+				s := &store.MockInterface{}
+				s.On("List", mock.Anything).Return(store.NewListOutput(), nil)
+				s.On("Get", mock.Anything).Return(nil, data.ErrNotFound)
+				s.On("PrepareCreate", mock.Anything, mock.Anything).Return(&store.Op{}, nil)
+				s.On("Commit", mock.Anything, mock.Anything).Return(nil)
+				stores[typ] = s
+			}
+			h := &Handler{planner: &batch.Planner{Stores: stores}}
+
+			ctx := droplet.NewContext()
+			ctx.SetInput(tc.input)
+			ret, err := h.Apply(ctx)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				assert.Equal(t, tc.wantStatus, ret.(*data.SpecCodeResponse).StatusCode)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.wantPlan, ret.(*apply.Plan).Changes)
+		})
+	}
+}
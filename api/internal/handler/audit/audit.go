@@ -0,0 +1,147 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package audit
+
+import (
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shiningrush/droplet"
+	"github.com/shiningrush/droplet/wrapper"
+	wgin "github.com/shiningrush/droplet/wrapper/gin"
+
+	"github.com/apisix/manager-api/internal/core/audit"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/handler"
+)
+
+type Handler struct{}
+
+func NewHandler() (handler.RouteRegister, error) {
+	return &Handler{}, nil
+}
+
+func (h *Handler) ApplyRoute(r *gin.Engine) {
+	r.GET("/apisix/admin/audit", wgin.Wraps(h.List,
+		wrapper.InputType(reflect.TypeOf(ListInput{}))))
+}
+
+type ListInput struct {
+	store.Pagination
+	User       string `auto_read:"user,query"`
+	Resource   string `auto_read:"resource,query"`
+	ResourceID string `auto_read:"resource_id,query"`
+	Action     string `auto_read:"action,query"`
+	// From and To are unix timestamps, inclusive
+	From int `auto_read:"from,query"`
+	To   int `auto_read:"to,query"`
+}
+
+// swagger:operation GET /apisix/admin/audit getAuditList
+//
+// Return the audit entries of the writes, the latest first.
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: page
+//   in: query
+//   description: page number
+//   required: false
+//   type: integer
+// - name: page_size
+//   in: query
+//   description: page size
+//   required: false
+//   type: integer
+// - name: user
+//   in: query
+//   description: the user making the writes
+//   required: false
+//   type: string
+// - name: resource
+//   in: query
+//   description: the resource type, such as route and upstream
+//   required: false
+//   type: string
+// - name: resource_id
+//   in: query
+//   description: the id of the resource
+//   required: false
+//   type: string
+// - name: action
+//   in: query
+//   description: one of create, update and delete
+//   required: false
+//   type: string
+// - name: from
+//   in: query
+//   description: the unix timestamp the entries are recorded since
+//   required: false
+//   type: integer
+// - name: to
+//   in: query
+//   description: the unix timestamp the entries are recorded until
+//   required: false
+//   type: integer
+// responses:
+//   '0':
+//     description: list response
+//     schema:
+//       type: array
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) List(c droplet.Context) (interface{}, error) {
+	input := c.Input().(*ListInput)
+
+	entries, err := audit.Query(c.Context(), audit.Filter{
+		User:       input.User,
+		Resource:   input.Resource,
+		ResourceID: input.ResourceID,
+		Action:     input.Action,
+		From:       int64(input.From),
+		To:         int64(input.To),
+	})
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	output := &store.ListOutput{TotalSize: len(entries)}
+	if input.PageSize > 0 && input.PageNumber > 0 {
+		start := (input.PageNumber - 1) * input.PageSize
+		end := start + input.PageSize
+		if start > len(entries) {
+			start = len(entries)
+		}
+		if end > len(entries) {
+			end = len(entries)
+		}
+		entries = entries[start:end]
+	}
+	output.Rows = make([]interface{}, 0, len(entries))
+	for _, e := range entries {
+		// the entries recorded before the secrets are redacted
+		typ := store.HubKey(e.Resource)
+		e.Before, e.After = store.RedactJSON(typ, e.Before), store.RedactJSON(typ, e.After)
+		e.Diff = store.RedactJSON(typ, e.Diff)
+		output.Rows = append(output.Rows, e)
+	}
+	return output, nil
+}
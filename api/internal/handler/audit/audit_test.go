@@ -0,0 +1,105 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package audit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shiningrush/droplet"
+	"github.com/shiningrush/droplet/data"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/core/audit"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/core/store"
+)
+
+func TestHandler_List(t *testing.T) {
+	sink := audit.NewStorageSink(storage.NewMemoryStorage(), "/apisix/audit", time.Hour)
+	audit.SetSinks(sink)
+	defer func() {
+		audit.SetSinks()
+		_ = sink.Close()
+	}()
+	for _, id := range []string{"r1", "r2", "r3"} {
+		audit.Record(context.TODO(), &audit.Entry{User: "admin", Resource: "route", ResourceID: id, Action: "create"})
+	}
+	entries, err := audit.Query(context.TODO(), audit.Filter{})
+	assert.Nil(t, err)
+
+	tests := []struct {
+		desc      string
+		giveInput *ListInput
+		wantRet   interface{}
+	}{
+		{
+			desc:      "list all",
+			giveInput: &ListInput{},
+			wantRet: &store.ListOutput{
+				Rows:      []interface{}{entries[0], entries[1], entries[2]},
+				TotalSize: 3,
+			},
+		},
+		{
+			desc:      "list with filter",
+			giveInput: &ListInput{User: "admin", ResourceID: "r2"},
+			wantRet: &store.ListOutput{
+				Rows:      []interface{}{entries[1]},
+				TotalSize: 1,
+			},
+		},
+		{
+			desc:      "list with page",
+			giveInput: &ListInput{Pagination: store.Pagination{PageSize: 2, PageNumber: 2}},
+			wantRet: &store.ListOutput{
+				Rows:      []interface{}{entries[2]},
+				TotalSize: 3,
+			},
+		},
+		{
+			desc:      "list beyond the last page",
+			giveInput: &ListInput{Pagination: store.Pagination{PageSize: 2, PageNumber: 3}},
+			wantRet: &store.ListOutput{
+				Rows:      []interface{}{},
+				TotalSize: 3,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			h := &Handler{}
+			ctx := droplet.NewContext()
+			ctx.SetInput(tc.giveInput)
+			ret, err := h.List(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.wantRet, ret)
+		})
+	}
+
+	audit.SetSinks()
+	h := &Handler{}
+	ctx := droplet.NewContext()
+	ctx.SetInput(&ListInput{})
+	ret, err := h.List(ctx)
+	assert.Equal(t, fmt.Errorf("audit query is not enabled"), err)
+	assert.Equal(t, &data.SpecCodeResponse{StatusCode: http.StatusInternalServerError}, ret)
+}
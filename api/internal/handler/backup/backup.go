@@ -0,0 +1,279 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backup
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shiningrush/droplet"
+	"github.com/shiningrush/droplet/data"
+	"github.com/shiningrush/droplet/wrapper"
+	wgin "github.com/shiningrush/droplet/wrapper/gin"
+
+	"github.com/apisix/manager-api/internal/core/backup"
+	"github.com/apisix/manager-api/internal/core/migrate"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/handler"
+	"github.com/apisix/manager-api/internal/utils/consts"
+)
+
+var errDisabled = errors.New("backup is disabled: backup.dir is required")
+
+type Handler struct {
+	manager *backup.Manager
+}
+
+func NewHandler() (handler.RouteRegister, error) {
+	return &Handler{manager: backup.Default()}, nil
+}
+
+func (h *Handler) ApplyRoute(r *gin.Engine) {
+	r.GET("/apisix/admin/backups", wgin.Wraps(h.List))
+	r.POST("/apisix/admin/backups", wgin.Wraps(h.Create))
+	r.GET("/apisix/admin/backups/public_key", wgin.Wraps(h.PublicKey))
+	r.GET("/apisix/admin/backups/:name", h.Download)
+	r.POST("/apisix/admin/backups/restore", wgin.Wraps(h.Restore,
+		wrapper.InputType(reflect.TypeOf(RestoreInput{}))))
+}
+
+var modeMap = map[string]migrate.ConflictMode{
+	"return":    migrate.ModeReturn,
+	"overwrite": migrate.ModeOverwrite,
+	"skip":      migrate.ModeSkip,
+}
+
+// swagger:operation GET /apisix/admin/backups listBackups
+//
+// Return the backup bundles with their manifests, the latest first.
+//
+// ---
+// produces:
+// - application/json
+// responses:
+//   '0':
+//     description: the bundles
+//     schema:
+//       type: array
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) List(_ droplet.Context) (interface{}, error) {
+	if h.manager == nil {
+		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, errDisabled
+	}
+	infos, err := h.manager.List()
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+	return infos, nil
+}
+
+// swagger:operation POST /apisix/admin/backups createBackup
+//
+// Write a bundle of all the resources of the cluster now, the bundles
+// beyond the retention are removed afterwards.
+//
+// ---
+// produces:
+// - application/json
+// responses:
+//   '0':
+//     description: the bundle written
+//     schema:
+//       type: object
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) Create(c droplet.Context) (interface{}, error) {
+	if h.manager == nil {
+		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, errDisabled
+	}
+	info, err := h.manager.Create(c.Context())
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+	return info, nil
+}
+
+type PublicKeyOutput struct {
+	KeyID string `json:"key_id"`
+	// PublicKey is in PEM, for backup.trusted_keys of the instances
+	// restoring the bundles
+	PublicKey string `json:"public_key"`
+}
+
+// swagger:operation GET /apisix/admin/backups/public_key getBackupPublicKey
+//
+// Return the public key of the key signing the bundles, the other
+// instances trust it to restore the bundles.
+//
+// ---
+// produces:
+// - application/json
+// responses:
+//   '0':
+//     description: the public key in PEM and its id
+//     schema:
+//       type: object
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) PublicKey(_ droplet.Context) (interface{}, error) {
+	if h.manager == nil {
+		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, errDisabled
+	}
+	pub := h.manager.PublicKey()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+	return &PublicKeyOutput{
+		KeyID:     backup.KeyID(pub),
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	}, nil
+}
+
+// Download sends the bundle as an attachment.
+func (h *Handler) Download(c *gin.Context) {
+	if h.manager == nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, consts.InvalidParam(errDisabled.Error()))
+		return
+	}
+	path, err := h.manager.Path(c.Param("name"))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.AbortWithStatusJSON(http.StatusNotFound, consts.NotFound(err.Error()))
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusBadRequest, consts.InvalidParam(err.Error()))
+		return
+	}
+	c.FileAttachment(path, c.Param("name"))
+}
+
+type RestoreInput struct {
+	// Name is the bundle restored, the one selected by At by default
+	Name string `json:"name"`
+	// At selects the latest bundle created at or before it, in RFC 3339
+	// or unix seconds. The latest bundle is restored when both are empty
+	At string `json:"at"`
+	// Mode is return by default
+	Mode     string   `json:"mode"`
+	Types    []string `json:"types"`
+	Selector string   `json:"selector"`
+}
+
+type RestoreOutput struct {
+	Backup *backup.Info          `json:"backup"`
+	Result *migrate.ImportResult `json:"result"`
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("at %s is invalid", value)
+	}
+	return t, nil
+}
+
+// swagger:operation POST /apisix/admin/backups/restore restoreBackup
+//
+// Verify the signature of a bundle, and import its selected objects into
+// the cluster. The bundle is chosen by its name, or as the latest one at a
+// point in time. The objects existing already are handled by the mode the
+// same as the migrate import, and the outcome of each is reported. The
+// objects are restored at once, a bundle too large for a transaction of
+// etcd is refused and can be restored by types or selector. Nothing is
+// written with dry_run=true.
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: body
+//   in: body
+//   description: name or at, mode (return, overwrite or skip, return by default), types and selector
+//   required: false
+//   schema:
+//     type: object
+// responses:
+//   '0':
+//     description: the bundle restored and the outcomes of its objects
+//     schema:
+//       type: object
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) Restore(c droplet.Context) (interface{}, error) {
+	input := c.Input().(*RestoreInput)
+	if h.manager == nil {
+		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, errDisabled
+	}
+	mode := migrate.ModeReturn
+	if input.Mode != "" {
+		m, ok := modeMap[input.Mode]
+		if !ok {
+			return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest},
+				fmt.Errorf("mode %s is invalid", input.Mode)
+		}
+		mode = m
+	}
+	at, err := parseTime(input.At)
+	if err != nil {
+		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, err
+	}
+	opt := migrate.Options{Selector: input.Selector}
+	for _, typ := range input.Types {
+		opt.Types = append(opt.Types, store.HubKey(typ))
+	}
+
+	info, result, err := h.manager.Restore(c.Context(), input.Name, at, mode, opt)
+	if err != nil {
+		if err == migrate.ErrConflict {
+			var keys []string
+			for _, o := range result.Outcomes {
+				if o.Outcome == migrate.OutcomeConflict {
+					keys = append(keys, string(o.Type)+"/"+o.Key)
+				}
+			}
+			return &data.SpecCodeResponse{StatusCode: http.StatusConflict},
+				fmt.Errorf("%d objects exist already: %s", len(keys), strings.Join(keys, ", "))
+		}
+		return handler.SpecCodeResponse(err), err
+	}
+	return &RestoreOutput{Backup: info, Result: result}, nil
+}
@@ -0,0 +1,94 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backup
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shiningrush/droplet"
+	"github.com/shiningrush/droplet/data"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/core/backup"
+)
+
+func TestHandler(t *testing.T) {
+	disabled := &Handler{}
+	c := droplet.NewContext()
+	ret, err := disabled.List(c)
+	assert.Equal(t, errDisabled, err)
+	assert.Equal(t, http.StatusBadRequest, ret.(*data.SpecCodeResponse).StatusCode)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	m, err := backup.NewManager(t.TempDir(), 0, key, nil)
+	assert.Nil(t, err)
+	h := &Handler{manager: m}
+
+	ret, err = h.PublicKey(c)
+	assert.Nil(t, err)
+	assert.Equal(t, backup.KeyID(key.Public().(ed25519.PublicKey)), ret.(*PublicKeyOutput).KeyID)
+	assert.Contains(t, ret.(*PublicKeyOutput).PublicKey, "-----BEGIN PUBLIC KEY-----")
+
+	c.SetInput(&RestoreInput{Mode: "merge"})
+	ret, err = h.Restore(c)
+	assert.EqualError(t, err, "mode merge is invalid")
+	assert.Equal(t, http.StatusBadRequest, ret.(*data.SpecCodeResponse).StatusCode)
+	c.SetInput(&RestoreInput{At: "yesterday"})
+	_, err = h.Restore(c)
+	assert.EqualError(t, err, "at yesterday is invalid")
+	c.SetInput(&RestoreInput{})
+	ret, err = h.Restore(c)
+	assert.EqualError(t, err, "backup is not found")
+	assert.Equal(t, http.StatusNotFound, ret.(*data.SpecCodeResponse).StatusCode)
+
+	ret, err = h.Create(c)
+	assert.Nil(t, err)
+	info := ret.(*backup.Info)
+	assert.Equal(t, "local", info.Manifest.Cluster)
+
+	r := gin.New()
+	h.ApplyRoute(r)
+	for path, status := range map[string]int{
+		"/apisix/admin/backups/" + info.Name:                          http.StatusOK,
+		"/apisix/admin/backups/apisix-backup-20261001T030000Z.tar.gz": http.StatusNotFound,
+		"/apisix/admin/backups/backup.key":                            http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, status, w.Code, path)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	at, err := parseTime("")
+	assert.Nil(t, err)
+	assert.True(t, at.IsZero())
+	at, err = parseTime("1790000000")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1790000000), at.Unix())
+	at, err = parseTime("2026-10-01T03:00:00+08:00")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2026, 9, 30, 19, 0, 0, 0, time.UTC), at.UTC())
+}
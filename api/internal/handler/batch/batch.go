@@ -0,0 +1,170 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package batch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shiningrush/droplet"
+	"github.com/shiningrush/droplet/data"
+	"github.com/shiningrush/droplet/wrapper"
+	wgin "github.com/shiningrush/droplet/wrapper/gin"
+
+	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/core/batch"
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/handler"
+	"github.com/apisix/manager-api/internal/handler/consumer"
+	"github.com/apisix/manager-api/internal/handler/ssl"
+)
+
+type Handler struct {
+	planner *batch.Planner
+}
+
+func NewHandler() (handler.RouteRegister, error) {
+	return &Handler{planner: NewPlanner()}, nil
+}
+
+// NewPlanner returns the planner preparing the objects the same way as the
+// APIs of their types.
+func NewPlanner() *batch.Planner {
+	return NewClusterPlanner(nil)
+}
+
+// NewClusterPlanner is NewPlanner on the stores of another cluster, the
+// stores of GetStore are used for the types missing in stores.
+func NewClusterPlanner(stores map[store.HubKey]store.Interface) *batch.Planner {
+	p := &batch.Planner{Stores: stores}
+	p.Normalizers = map[store.HubKey]batch.Normalizer{
+		store.HubKeyRoute:    normalizeRoute,
+		store.HubKeySsl:      normalizeSSL,
+		store.HubKeyConsumer: consumerNormalizer(p.Store(store.HubKeyConsumer)),
+	}
+	return p
+}
+
+func (h *Handler) ApplyRoute(r *gin.Engine) {
+	r.POST("/apisix/admin/batch", wgin.Wraps(h.Apply,
+		wrapper.InputType(reflect.TypeOf(ApplyInput{}))))
+}
+
+type ApplyInput struct {
+	Operations []batch.Operation `json:"operations"`
+}
+
+type ApplyOutput struct {
+	Results []*batch.Result `json:"results"`
+}
+
+// swagger:operation POST /apisix/admin/batch applyBatch
+//
+// Apply the operations all-or-nothing. They are validated in order, then
+// committed in one transaction. A created object can be referred to by the
+// later operations with "$ref:<ref>" in their ids and bodies.
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: operations
+//   in: body
+//   description: the operations, each with op (create, update, patch or delete), type (route, service, upstream, plugin_config, stream_route, proto, ssl, consumer or global_rule), id, ref, resource_version and body
+//   required: true
+//   schema:
+//     type: array
+// responses:
+//   '0':
+//     description: the results of the operations in order
+//     schema:
+//       type: object
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) Apply(c droplet.Context) (interface{}, error) {
+	input := c.Input().(*ApplyInput)
+
+	txn, results, err := h.planner.Plan(c.Context(), input.Operations)
+	if err != nil {
+		var opErr *batch.OperationError
+		if errors.As(err, &opErr) && !errors.Is(err, storage.ErrRevisionMismatch) {
+			return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, err
+		}
+		if errors.Is(err, storage.ErrTxnTooLarge) {
+			err = fmt.Errorf("split the batch of %d operations into smaller ones: %w", len(input.Operations), err)
+		}
+		return handler.SpecCodeResponse(err), err
+	}
+	if err := txn.Commit(c.Context()); err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	for _, ret := range results {
+		if s, ok := ret.Object.(*entity.SSL); ok {
+			s.Key = ""
+			s.Keys = nil
+		}
+	}
+	return &ApplyOutput{Results: results}, nil
+}
+
+// normalizeRoute refuses the scripts, which are generated into lua code by
+// the route API.
+func normalizeRoute(_ context.Context, obj interface{}) error {
+	if obj.(*entity.Route).Script != nil {
+		return fmt.Errorf("script is invalid in a batch, set it by the route API")
+	}
+	return nil
+}
+
+// normalizeSSL fills the SSL from its cert, the same as the SSL API.
+func normalizeSSL(_ context.Context, obj interface{}) error {
+	input := obj.(*entity.SSL)
+	parsed, err := ssl.ParseCert(input.Cert, input.Key)
+	if err != nil {
+		return err
+	}
+	parsed.ID = input.ID
+	parsed.Labels = input.Labels
+	parsed.Status = conf.SSLDefaultStatus
+	*input = *parsed
+	return nil
+}
+
+// consumerNormalizer keeps the create time of the consumers, which have no
+// BaseInfo to maintain it.
+func consumerNormalizer(consumerStore store.Interface) batch.Normalizer {
+	return func(ctx context.Context, obj interface{}) error {
+		input := obj.(*entity.Consumer)
+		consumer.EnsurePluginsDefValue(input.Plugins)
+		input.CreateTime = time.Now().Unix()
+		input.UpdateTime = time.Now().Unix()
+		if saved, _ := consumerStore.Get(ctx, input.Username); saved != nil {
+			input.CreateTime = saved.(*entity.Consumer).CreateTime
+		}
+		return nil
+	}
+}
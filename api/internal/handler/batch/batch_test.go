@@ -0,0 +1,131 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package batch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/shiningrush/droplet"
+	"github.com/shiningrush/droplet/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/apisix/manager-api/internal/core/batch"
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/graph"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/core/store"
+)
+
+func TestHandler_Apply(t *testing.T) {
+	var large []string
+	for i := 0; i <= storage.MaxTxnOps; i++ {
+		large = append(large, fmt.Sprintf(`{"op": "create", "type": "route", "id": "r%d", "body": {"uri": "/a"}}`, i))
+	}
+
+	tests := []struct {
+		caseDesc   string
+		ops        string
+		commitErr  error
+		wantErr    string
+		wantStatus int
+		wantIDs    []string
+	}{
+		{
+			caseDesc: "create with refs",
+			ops: `[
+				{"op": "create", "type": "consumer", "ref": "c", "body": {"username": "jack"}},
+				{"op": "create", "type": "route", "id": "r1", "body": {"uri": "/a",
+					"plugins": {"consumer-restriction": {"whitelist": ["$ref:c"]}}}}
+			]`,
+			wantIDs: []string{"jack", "r1"},
+		},
+		{
+			caseDesc:   "script",
+			ops:        `[{"op": "create", "type": "route", "id": "r1", "body": {"uri": "/a", "script": "lua"}}]`,
+			wantErr:    "operations[0]: script is invalid in a batch, set it by the route API",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			caseDesc:   "dangling id",
+			ops:        `[{"op": "create", "type": "route", "id": "r1", "body": {"uri": "/a", "service_id": "s1"}}]`,
+			wantErr:    "operations[0]: service id: s1 not found",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			caseDesc: "too large",
+			ops:      "[" + strings.Join(large, ",") + "]",
+			wantErr: "split the batch of 129 operations into smaller ones: " +
+				"too many operations in a transaction: 129 writes take 129 operations, more than the limit of 128 set by etcd.max_txn_ops",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			caseDesc:   "revision mismatch",
+			ops:        `[{"op": "create", "type": "route", "id": "r1", "body": {"uri": "/a"}}]`,
+			commitErr:  storage.ErrRevisionMismatch,
+			wantErr:    storage.ErrRevisionMismatch.Error(),
+			wantStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			stores := map[store.HubKey]store.Interface{}
+			for _, typ := range graph.Types {
+				s := &store.MockInterface{}
+				s.On("List", mock.Anything).Return(store.NewListOutput(), nil)
+				s.On("Get", mock.Anything).Return(nil, data.ErrNotFound)
+				s.On("PrepareCreate", mock.Anything, mock.Anything).Return(&store.Op{}, nil)
+				s.On("Commit", mock.Anything, mock.Anything).Return(tc.commitErr)
+				stores[typ] = s
+			}
+			h := &Handler{planner: &batch.Planner{
+				Stores: stores,
+				Normalizers: map[store.HubKey]batch.Normalizer{
+					store.HubKeyRoute:    normalizeRoute,
+					store.HubKeyConsumer: consumerNormalizer(stores[store.HubKeyConsumer]),
+				},
+			}}
+
+			input := &ApplyInput{}
+			assert.Nil(t, json.Unmarshal([]byte(tc.ops), &input.Operations))
+			ctx := droplet.NewContext()
+			ctx.SetInput(input)
+			ret, err := h.Apply(ctx)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				assert.Equal(t, tc.wantStatus, ret.(*data.SpecCodeResponse).StatusCode)
+				return
+			}
+			assert.Nil(t, err)
+
+			var ids []string
+			for _, r := range ret.(*ApplyOutput).Results {
+				ids = append(ids, r.ID)
+			}
+			assert.Equal(t, tc.wantIDs, ids)
+			consumer := ret.(*ApplyOutput).Results[0].Object.(*entity.Consumer)
+			assert.NotZero(t, consumer.CreateTime)
+			route := ret.(*ApplyOutput).Results[1].Object.(*entity.Route)
+			assert.Equal(t, []interface{}{"jack"}, route.Plugins["consumer-restriction"].(map[string]interface{})["whitelist"])
+		})
+	}
+}
@@ -14,35 +14,27 @@
  * See the License for the specific language governing permissions and
  * limitations under the License.
  */
-
-package migrate
+package cluster
 
 import (
-	"context"
+	"testing"
+
+	"github.com/shiningrush/droplet"
+	"github.com/stretchr/testify/assert"
 
 	"github.com/apisix/manager-api/internal/core/store"
-	"github.com/apisix/manager-api/internal/log"
 )
 
-func isConflicted(ctx context.Context, new *DataSet) (bool, *DataSet) {
-	isConflict := false
-	conflictedData := newDataSet()
-	store.RangeStore(func(key store.HubKey, s *store.GenericStore) bool {
-		new.rangeData(key, func(i int, obj interface{}) bool {
-			// Only check key of store conflict for now.
-			// TODO: Maybe check name of some entiries.
-			_, err := s.CreateCheck(obj)
-			if err != nil {
-				isConflict = true
-				err = conflictedData.Add(obj)
-				if err != nil {
-					log.Errorf("Add obj to conflict list failed:%s", err)
-					return true
-				}
-			}
-			return true
-		})
-		return true
-	})
-	return isConflict, conflictedData
+func TestHandler_List(t *testing.T) {
+	store.AddCluster("prod", store.Hub{})
+	defer store.RemoveCluster("prod")
+
+	h := &Handler{}
+	ret, err := h.List(droplet.NewContext())
+	assert.Nil(t, err)
+	statuses := ret.([]store.ClusterStatus)
+	assert.Len(t, statuses, 2)
+	assert.Equal(t, "local", statuses[0].Name)
+	assert.Equal(t, "prod", statuses[1].Name)
+	assert.True(t, statuses[1].Healthy)
 }
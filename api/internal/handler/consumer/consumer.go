@@ -26,18 +26,27 @@ import (
 	"github.com/shiningrush/droplet/wrapper"
 	wgin "github.com/shiningrush/droplet/wrapper/gin"
 
+	"github.com/apisix/manager-api/internal/core/deletion"
 	"github.com/apisix/manager-api/internal/core/entity"
 	"github.com/apisix/manager-api/internal/core/store"
 	"github.com/apisix/manager-api/internal/handler"
 )
 
 type Handler struct {
-	consumerStore store.Interface
+	consumerStore     store.Interface
+	routeStore        store.Interface
+	serviceStore      store.Interface
+	pluginConfigStore store.Interface
+	globalRuleStore   store.Interface
 }
 
 func NewHandler() (handler.RouteRegister, error) {
 	return &Handler{
-		consumerStore: store.GetStore(store.HubKeyConsumer),
+		consumerStore:     store.GetStore(store.HubKeyConsumer),
+		routeStore:        store.GetStore(store.HubKeyRoute),
+		serviceStore:      store.GetStore(store.HubKeyService),
+		pluginConfigStore: store.GetStore(store.HubKeyPluginConfig),
+		globalRuleStore:   store.GetStore(store.HubKeyGlobalRule),
 	}, nil
 }
 
@@ -71,6 +80,7 @@ func (h *Handler) Get(c droplet.Context) (interface{}, error) {
 type ListInput struct {
 	Username string `auto_read:"username,query"`
 	store.Pagination
+	store.ListQuery
 }
 
 // swagger:operation GET /apisix/admin/consumers getConsumerList
@@ -91,6 +101,36 @@ type ListInput struct {
 //   description: page size
 //   required: false
 //   type: integer
+// - name: cursor
+//   in: query
+//   description: next_cursor of the previous page, the page number is ignored when it's set
+//   required: false
+//   type: string
+// - name: with_total
+//   in: query
+//   description: count total_size of a cursor page, which stops at the end of the page otherwise
+//   required: false
+//   type: boolean
+// - name: sort_by
+//   in: query
+//   description: top level field to sort by, create_time by default
+//   required: false
+//   type: string
+// - name: order
+//   in: query
+//   description: asc or desc
+//   required: false
+//   type: string
+// - name: fields
+//   in: query
+//   description: comma separated top level fields to return
+//   required: false
+//   type: string
+// - name: label_selector
+//   in: query
+//   description: label selector like "env in (prod,staging),!deprecated,team!=core"
+//   required: false
+//   type: string
 // - name: username
 //   in: query
 //   description: username of consumer
@@ -117,22 +157,14 @@ func (h *Handler) List(c droplet.Context) (interface{}, error) {
 			}
 			return true
 		},
-		Less: func(i, j interface{}) bool {
-			iBase := i.(*entity.Consumer)
-			jBase := j.(*entity.Consumer)
-			if iBase.CreateTime != jBase.CreateTime {
-				return iBase.CreateTime < jBase.CreateTime
-			}
-			if iBase.UpdateTime != jBase.UpdateTime {
-				return iBase.UpdateTime < jBase.UpdateTime
-			}
-			return iBase.Username < jBase.Username
-		},
 		PageSize:   input.PageSize,
 		PageNumber: input.PageNumber,
+		Cursor:     input.Cursor,
+		WithTotal:  input.WithTotal,
+		Query:      input.ListQuery,
 	})
 	if err != nil {
-		return nil, err
+		return handler.SpecCodeResponse(err), err
 	}
 
 	return ret, nil
@@ -141,6 +173,7 @@ func (h *Handler) List(c droplet.Context) (interface{}, error) {
 type SetInput struct {
 	entity.Consumer
 	Username string `auto_read:"username,path"`
+	IfMatch  string `auto_read:"If-Match,header" json:"-"`
 }
 
 func (h *Handler) Set(c droplet.Context) (interface{}, error) {
@@ -148,7 +181,7 @@ func (h *Handler) Set(c droplet.Context) (interface{}, error) {
 	if input.Username != "" {
 		input.Consumer.Username = input.Username
 	}
-	ensurePluginsDefValue(input.Plugins)
+	EnsurePluginsDefValue(input.Plugins)
 
 	// Because the ID of consumer has been removed,
 	// `BaseInfo` is no longer embedded in consumer's struct,
@@ -160,7 +193,12 @@ func (h *Handler) Set(c droplet.Context) (interface{}, error) {
 		input.Consumer.CreateTime = savedConsumer.(*entity.Consumer).CreateTime
 	}
 
-	ret, err := h.consumerStore.Update(c.Context(), &input.Consumer, true)
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	ret, err := h.consumerStore.Update(ctx, &input.Consumer, true)
 	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
@@ -168,7 +206,8 @@ func (h *Handler) Set(c droplet.Context) (interface{}, error) {
 	return ret, nil
 }
 
-func ensurePluginsDefValue(plugins map[string]interface{}) {
+// EnsurePluginsDefValue sets the default values of the consumer plugins.
+func EnsurePluginsDefValue(plugins map[string]interface{}) {
 	if plugins["jwt-auth"] != nil {
 		jwtAuth, ok := plugins["jwt-auth"].(map[string]interface{})
 		if ok && jwtAuth["exp"] == nil {
@@ -179,12 +218,34 @@ func ensurePluginsDefValue(plugins map[string]interface{}) {
 
 type BatchDeleteInput struct {
 	UserNames string `auto_read:"usernames,path"`
+	IfMatch   string `auto_read:"If-Match,header"`
+	Mode      string `auto_read:"mode,query"`
 }
 
 func (h *Handler) BatchDelete(c droplet.Context) (interface{}, error) {
 	input := c.Input().(*BatchDeleteInput)
 
-	if err := h.consumerStore.BatchDelete(c.Context(), strings.Split(input.UserNames, ",")); err != nil {
+	mode, err := deletion.ParseMode(input.Mode)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	txn, err := deletion.Plan(ctx, mode, map[store.HubKey]store.Interface{
+		store.HubKeyConsumer:     h.consumerStore,
+		store.HubKeyRoute:        h.routeStore,
+		store.HubKeyService:      h.serviceStore,
+		store.HubKeyPluginConfig: h.pluginConfigStore,
+		store.HubKeyGlobalRule:   h.globalRuleStore,
+	}, store.HubKeyConsumer, strings.Split(input.UserNames, ","))
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+	if err := txn.CommitParts(ctx); err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
 
@@ -135,6 +135,7 @@ func TestHandler_List(t *testing.T) {
 			giveData: []*entity.Consumer{},
 			giveErr:  fmt.Errorf("list failed"),
 			wantErr:  fmt.Errorf("list failed"),
+			wantRet:  &data.SpecCodeResponse{StatusCode: http.StatusInternalServerError},
 		},
 	}
 
@@ -400,11 +401,18 @@ func TestHandler_Update(t *testing.T) {
 }
 
 func TestHandler_BatchDelete(t *testing.T) {
+	restricted := &entity.Route{
+		BaseInfo: entity.BaseInfo{ID: "r1"},
+		Plugins: map[string]interface{}{
+			"consumer-restriction": map[string]interface{}{"whitelist": []interface{}{"user1"}},
+		},
+	}
 	tests := []struct {
 		caseDesc  string
 		giveInput *BatchDeleteInput
 		giveCtx   context.Context
 		giveErr   error
+		giveRoute *entity.Route
 		wantErr   error
 		wantInput []string
 		wantRet   interface{}
@@ -436,25 +444,82 @@ func TestHandler_BatchDelete(t *testing.T) {
 				StatusCode: http.StatusInternalServerError,
 			},
 		},
+		{
+			caseDesc: "delete failed, whitelisted by route",
+			giveInput: &BatchDeleteInput{
+				UserNames: "user1,user2",
+			},
+			giveCtx:   context.WithValue(context.Background(), "test", "value"),
+			giveRoute: restricted,
+			wantInput: []string{
+				"user1",
+				"user2",
+			},
+			wantErr: fmt.Errorf("consumer/user1 is referenced by route/r1 (plugins.consumer-restriction.whitelist)"),
+			wantRet: &data.SpecCodeResponse{
+				StatusCode: http.StatusBadRequest,
+			},
+		},
+		{
+			caseDesc: "delete failed, mode invalid",
+			giveInput: &BatchDeleteInput{
+				UserNames: "user1",
+				Mode:      "force",
+			},
+			giveCtx: context.WithValue(context.Background(), "test", "value"),
+			wantErr: fmt.Errorf("mode force is invalid"),
+			wantRet: &data.SpecCodeResponse{
+				StatusCode: http.StatusBadRequest,
+			},
+		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.caseDesc, func(t *testing.T) {
-			methodCalled := true
+			var deleted []string
 			mStore := &store.MockInterface{}
-			mStore.On("BatchDelete", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
-				methodCalled = true
+			mStore.On("PrepareDelete", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				deleted = append(deleted, args.Get(1).(string))
+			}).Return(&store.Op{}, nil)
+			mStore.On("Commit", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
 				assert.Equal(t, tc.giveCtx, args.Get(0))
-				assert.Equal(t, tc.wantInput, args.Get(1))
 			}).Return(tc.giveErr)
 
-			h := Handler{consumerStore: mStore}
+			list := func(ret ...interface{}) *store.MockInterface {
+				s := &store.MockInterface{}
+				s.On("List", mock.Anything).Return(func(input store.ListInput) *store.ListOutput {
+					output := store.NewListOutput()
+					for _, obj := range ret {
+						if input.Predicate(obj) {
+							output.Rows = append(output.Rows, obj)
+						}
+					}
+					return output
+				}, nil)
+				return s
+			}
+			routeStore := list()
+			if tc.giveRoute != nil {
+				routeStore = list(tc.giveRoute)
+			}
+
+			h := Handler{
+				consumerStore:     mStore,
+				routeStore:        routeStore,
+				serviceStore:      list(),
+				pluginConfigStore: list(),
+				globalRuleStore:   list(),
+			}
 			ctx := droplet.NewContext()
 			ctx.SetInput(tc.giveInput)
 			ctx.SetContext(tc.giveCtx)
 			ret, err := h.BatchDelete(ctx)
-			assert.True(t, methodCalled)
-			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantInput, deleted)
+			if tc.wantErr != nil {
+				assert.EqualError(t, err, tc.wantErr.Error())
+			} else {
+				assert.Nil(t, err)
+			}
 			assert.Equal(t, tc.wantRet, ret)
 		})
 	}
@@ -198,69 +198,59 @@ func (h *ImportHandler) preCheck(ctx context.Context, data *loader.DataSets) map
 	return errs
 }
 
-// Create parsed resources
+// Create parsed resources, either all of them are created or none
 func (h *ImportHandler) createEntities(ctx context.Context, data *loader.DataSets) map[store.HubKey][]string {
 	errs := make(map[store.HubKey][]string)
 
-	for _, route := range data.Routes {
-		_, err := h.routeStore.Create(ctx, &route)
-		if err != nil {
-			errs[store.HubKeyRoute] = append(errs[store.HubKeyRoute], err.Error())
+	txn := store.NewTxn()
+	prepare := func(key store.HubKey, s store.Interface, obj interface{}) {
+		if err := txn.Create(ctx, s, obj); err != nil {
+			errs[key] = append(errs[key], err.Error())
 		}
 	}
-	for _, upstream := range data.Upstreams {
-		_, err := h.upstreamStore.Create(ctx, &upstream)
-		if err != nil {
-			errs[store.HubKeyUpstream] = append(errs[store.HubKeyUpstream], err.Error())
-		}
+	for i := range data.Routes {
+		prepare(store.HubKeyRoute, h.routeStore, &data.Routes[i])
 	}
-	for _, service := range data.Services {
-		_, err := h.serviceStore.Create(ctx, &service)
-		if err != nil {
-			errs[store.HubKeyService] = append(errs[store.HubKeyService], err.Error())
-		}
+	for i := range data.Upstreams {
+		prepare(store.HubKeyUpstream, h.upstreamStore, &data.Upstreams[i])
 	}
-	for _, consumer := range data.Consumers {
-		_, err := h.consumerStore.Create(ctx, &consumer)
-		if err != nil {
-			errs[store.HubKeyConsumer] = append(errs[store.HubKeyConsumer], err.Error())
-		}
+	for i := range data.Services {
+		prepare(store.HubKeyService, h.serviceStore, &data.Services[i])
 	}
-	for _, ssl := range data.SSLs {
-		_, err := h.sslStore.Create(ctx, &ssl)
-		if err != nil {
-			errs[store.HubKeySsl] = append(errs[store.HubKeySsl], err.Error())
-		}
+	for i := range data.Consumers {
+		prepare(store.HubKeyConsumer, h.consumerStore, &data.Consumers[i])
 	}
-	for _, route := range data.StreamRoutes {
-		_, err := h.streamRouteStore.Create(ctx, &route)
-		if err != nil {
-			errs[store.HubKeyStreamRoute] = append(errs[store.HubKeyStreamRoute], err.Error())
-		}
+	for i := range data.SSLs {
+		prepare(store.HubKeySsl, h.sslStore, &data.SSLs[i])
 	}
-	for _, plugin := range data.GlobalPlugins {
-		_, err := h.globalPluginStore.Create(ctx, &plugin)
-		if err != nil {
-			errs[store.HubKeyGlobalRule] = append(errs[store.HubKeyGlobalRule], err.Error())
-		}
+	for i := range data.StreamRoutes {
+		prepare(store.HubKeyStreamRoute, h.streamRouteStore, &data.StreamRoutes[i])
 	}
-	for _, config := range data.PluginConfigs {
-		_, err := h.pluginConfigStore.Create(ctx, &config)
-		if err != nil {
-			errs[store.HubKeyPluginConfig] = append(errs[store.HubKeyPluginConfig], err.Error())
-		}
+	for i := range data.GlobalPlugins {
+		prepare(store.HubKeyGlobalRule, h.globalPluginStore, &data.GlobalPlugins[i])
 	}
-	for _, proto := range data.Protos {
-		_, err := h.protoStore.Create(ctx, &proto)
-		if err != nil {
-			errs[store.HubKeyProto] = append(errs[store.HubKeyProto], err.Error())
+	for i := range data.PluginConfigs {
+		prepare(store.HubKeyPluginConfig, h.pluginConfigStore, &data.PluginConfigs[i])
+	}
+	for i := range data.Protos {
+		prepare(store.HubKeyProto, h.protoStore, &data.Protos[i])
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	if err := txn.Commit(ctx); err != nil {
+		// nothing is created, so every resource is failed
+		for key, result := range h.convertToImportResult(data, nil) {
+			for i := 0; i < result.Total; i++ {
+				errs[key] = append(errs[key], err.Error())
+			}
 		}
 	}
 
 	return errs
 }
 
-// Convert import errors to response result
 func (ImportHandler) convertToImportResult(data *loader.DataSets, errs map[store.HubKey][]string) map[store.HubKey]ImportResult {
 	return map[store.HubKey]ImportResult{
 		store.HubKeyRoute: {
@@ -0,0 +1,136 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package dependency
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shiningrush/droplet"
+	"github.com/shiningrush/droplet/data"
+	"github.com/shiningrush/droplet/wrapper"
+	wgin "github.com/shiningrush/droplet/wrapper/gin"
+
+	"github.com/apisix/manager-api/internal/core/graph"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/handler"
+)
+
+type Handler struct {
+	build func(ctx context.Context) *graph.Graph
+}
+
+func NewHandler() (handler.RouteRegister, error) {
+	return &Handler{build: graph.BuildFromStores}, nil
+}
+
+func (h *Handler) ApplyRoute(r *gin.Engine) {
+	r.GET("/apisix/admin/dependencies/:type/:id", wgin.Wraps(h.Get,
+		wrapper.InputType(reflect.TypeOf(GetInput{}))))
+}
+
+type GetInput struct {
+	Type string `auto_read:"type,path" validate:"required"`
+	ID   string `auto_read:"id,path" validate:"required"`
+	// Format is json by default, or dot and mermaid
+	Format string `auto_read:"format,query"`
+}
+
+// swagger:operation GET /apisix/admin/dependencies/{type}/{id} getDependencies
+//
+// Return the references of the object to others (outbound), and of others
+// to it (inbound).
+//
+// ---
+// produces:
+// - application/json
+// - text/plain
+// parameters:
+// - name: type
+//   in: path
+//   description: one of route, service, upstream, plugin_config, script, stream_route, proto, ssl, consumer and global_rule
+//   required: true
+//   type: string
+// - name: id
+//   in: path
+//   description: id of the object, the username for consumers
+//   required: true
+//   type: string
+// - name: format
+//   in: query
+//   description: json by default, dot or mermaid renders the edges as text
+//   required: false
+//   type: string
+// responses:
+//   '0':
+//     description: the dependencies
+//     schema:
+//       type: object
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) Get(c droplet.Context) (interface{}, error) {
+	input := c.Input().(*GetInput)
+
+	if !validType(input.Type) {
+		err := fmt.Errorf("type %s is invalid", input.Type)
+		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, err
+	}
+	switch input.Format {
+	case "", graph.FormatJSON, graph.FormatDOT, graph.FormatMermaid:
+	default:
+		err := fmt.Errorf("format %s is invalid", input.Format)
+		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, err
+	}
+
+	deps, err := h.build(c.Context()).Dependencies(graph.Node{Type: store.HubKey(input.Type), ID: input.ID})
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	var text string
+	edges := append(append([]graph.Edge{}, deps.Outbound...), deps.Inbound...)
+	switch input.Format {
+	case graph.FormatDOT:
+		text = graph.DOT(edges)
+	case graph.FormatMermaid:
+		text = graph.Mermaid(edges)
+	default:
+		return deps, nil
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	return &data.RawResponse{
+		StatusCode: http.StatusOK,
+		Header:     header,
+		Body:       []byte(text),
+	}, nil
+}
+
+func validType(typ string) bool {
+	for _, t := range graph.Types {
+		if string(t) == typ {
+			return true
+		}
+	}
+	return false
+}
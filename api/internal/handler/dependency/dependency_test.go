@@ -0,0 +1,89 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package dependency
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/shiningrush/droplet"
+	"github.com/shiningrush/droplet/data"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/graph"
+	"github.com/apisix/manager-api/internal/core/store"
+)
+
+type mapSource map[string]interface{}
+
+func (m mapSource) Range(_ context.Context, f func(key string, obj interface{}) bool) {
+	for k, v := range m {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+func TestHandler_Get(t *testing.T) {
+	h := &Handler{build: func(ctx context.Context) *graph.Graph {
+		return graph.Build(ctx, map[store.HubKey]graph.Source{
+			store.HubKeyRoute:    mapSource{"r1": &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, UpstreamID: "u1"}},
+			store.HubKeyUpstream: mapSource{"u1": &entity.Upstream{BaseInfo: entity.BaseInfo{ID: "u1"}}},
+		})
+	}}
+	get := func(input *GetInput) (interface{}, error) {
+		ctx := droplet.NewContext()
+		ctx.SetInput(input)
+		return h.Get(ctx)
+	}
+
+	ret, err := get(&GetInput{Type: "upstream", ID: "u1"})
+	assert.Nil(t, err)
+	deps := ret.(*graph.Dependencies)
+	assert.Equal(t, graph.Node{Type: store.HubKeyUpstream, ID: "u1"}, deps.Node)
+	assert.Len(t, deps.Outbound, 0)
+	assert.Equal(t, []graph.Edge{{
+		From:  graph.Node{Type: store.HubKeyRoute, ID: "r1"},
+		To:    graph.Node{Type: store.HubKeyUpstream, ID: "u1"},
+		Field: graph.FieldUpstreamID,
+	}}, deps.Inbound)
+
+	ret, err = get(&GetInput{Type: "route", ID: "r1", Format: "dot"})
+	assert.Nil(t, err)
+	raw := ret.(*data.RawResponse)
+	assert.Equal(t, http.StatusOK, raw.StatusCode)
+	assert.Equal(t, "text/plain; charset=utf-8", raw.Header.Get("Content-Type"))
+	assert.Contains(t, string(raw.Body), `"route/r1" -> "upstream/u1"`)
+
+	ret, err = get(&GetInput{Type: "route", ID: "r1", Format: "mermaid"})
+	assert.Nil(t, err)
+	assert.Contains(t, string(ret.(*data.RawResponse).Body), "graph LR\n")
+
+	ret, err = get(&GetInput{Type: "routes", ID: "r1"})
+	assert.EqualError(t, err, "type routes is invalid")
+	assert.Equal(t, http.StatusBadRequest, ret.(*data.SpecCodeResponse).StatusCode)
+
+	ret, err = get(&GetInput{Type: "route", ID: "r1", Format: "svg"})
+	assert.EqualError(t, err, "format svg is invalid")
+	assert.Equal(t, http.StatusBadRequest, ret.(*data.SpecCodeResponse).StatusCode)
+
+	ret, err = get(&GetInput{Type: "route", ID: "r2"})
+	assert.EqualError(t, err, "route id: r2 not found")
+	assert.Equal(t, http.StatusNotFound, ret.(*data.SpecCodeResponse).StatusCode)
+}
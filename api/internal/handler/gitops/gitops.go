@@ -0,0 +1,58 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package gitops
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/shiningrush/droplet"
+	wgin "github.com/shiningrush/droplet/wrapper/gin"
+
+	"github.com/apisix/manager-api/internal/core/gitops"
+	"github.com/apisix/manager-api/internal/handler"
+)
+
+type Handler struct{}
+
+func NewHandler() (handler.RouteRegister, error) {
+	return &Handler{}, nil
+}
+
+func (h *Handler) ApplyRoute(r *gin.Engine) {
+	r.GET("/apisix/admin/gitops/status", wgin.Wraps(h.Status))
+}
+
+// swagger:operation GET /apisix/admin/gitops/status getGitOpsStatus
+//
+// Return the status of the GitOps reconciler: the commit last synced, the
+// errors of the resource files and the drift found in the stores.
+//
+// ---
+// produces:
+// - application/json
+// responses:
+//   '0':
+//     description: the status, enabled is false when GitOps isn't configured
+//     schema:
+//       type: object
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) Status(c droplet.Context) (interface{}, error) {
+	status := gitops.GetStatus()
+	return &status, nil
+}
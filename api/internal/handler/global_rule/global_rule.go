@@ -27,6 +27,7 @@ import (
 	"github.com/shiningrush/droplet/wrapper"
 	wgin "github.com/shiningrush/droplet/wrapper/gin"
 
+	"github.com/apisix/manager-api/internal/core/deletion"
 	"github.com/apisix/manager-api/internal/core/entity"
 	"github.com/apisix/manager-api/internal/core/store"
 	"github.com/apisix/manager-api/internal/handler"
@@ -79,6 +80,7 @@ func (h *Handler) Get(c droplet.Context) (interface{}, error) {
 
 type ListInput struct {
 	store.Pagination
+	store.ListQuery
 }
 
 // swagger:operation GET /apisix/admin/global_rules getGlobalRuleList
@@ -99,6 +101,36 @@ type ListInput struct {
 //   description: page size
 //   required: false
 //   type: integer
+// - name: cursor
+//   in: query
+//   description: next_cursor of the previous page, the page number is ignored when it's set
+//   required: false
+//   type: string
+// - name: with_total
+//   in: query
+//   description: count total_size of a cursor page, which stops at the end of the page otherwise
+//   required: false
+//   type: boolean
+// - name: sort_by
+//   in: query
+//   description: top level field to sort by, create_time by default
+//   required: false
+//   type: string
+// - name: order
+//   in: query
+//   description: asc or desc
+//   required: false
+//   type: string
+// - name: fields
+//   in: query
+//   description: comma separated top level fields to return
+//   required: false
+//   type: string
+// - name: label_selector
+//   in: query
+//   description: label selector like "env in (prod,staging),!deprecated,team!=core"
+//   required: false
+//   type: string
 // responses:
 //   '0':
 //     description: list response
@@ -116,9 +148,12 @@ func (h *Handler) List(c droplet.Context) (interface{}, error) {
 	ret, err := h.globalRuleStore.List(c.Context(), store.ListInput{
 		PageSize:   input.PageSize,
 		PageNumber: input.PageNumber,
+		Cursor:     input.Cursor,
+		WithTotal:  input.WithTotal,
+		Query:      input.ListQuery,
 	})
 	if err != nil {
-		return nil, err
+		return handler.SpecCodeResponse(err), err
 	}
 
 	return ret, nil
@@ -126,7 +161,8 @@ func (h *Handler) List(c droplet.Context) (interface{}, error) {
 
 type SetInput struct {
 	entity.GlobalPlugins
-	ID string `auto_read:"id,path"`
+	ID      string `auto_read:"id,path"`
+	IfMatch string `auto_read:"If-Match,header" json:"-"`
 }
 
 func (h *Handler) Set(c droplet.Context) (interface{}, error) {
@@ -142,7 +178,12 @@ func (h *Handler) Set(c droplet.Context) (interface{}, error) {
 		input.GlobalPlugins.ID = input.ID
 	}
 
-	ret, err := h.globalRuleStore.Update(c.Context(), &input.GlobalPlugins, true)
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	ret, err := h.globalRuleStore.Update(ctx, &input.GlobalPlugins, true)
 	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
@@ -153,6 +194,7 @@ func (h *Handler) Set(c droplet.Context) (interface{}, error) {
 type PatchInput struct {
 	ID      string `auto_read:"id,path"`
 	SubPath string `auto_read:"path,path"`
+	IfMatch string `auto_read:"If-Match,header"`
 	Body    []byte `auto_read:"@body"`
 }
 
@@ -179,7 +221,12 @@ func (h *Handler) Patch(c droplet.Context) (interface{}, error) {
 		return handler.SpecCodeResponse(err), err
 	}
 
-	ret, err := routeStore.Update(c.Context(), &globalRule, false)
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, stored.(*entity.GlobalPlugins).ResourceVersion)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	ret, err := routeStore.Update(ctx, &globalRule, false)
 	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
@@ -188,13 +235,31 @@ func (h *Handler) Patch(c droplet.Context) (interface{}, error) {
 }
 
 type BatchDeleteInput struct {
-	ID string `auto_read:"id,path"`
+	ID      string `auto_read:"id,path"`
+	IfMatch string `auto_read:"If-Match,header"`
+	Mode    string `auto_read:"mode,query"`
 }
 
 func (h *Handler) BatchDelete(c droplet.Context) (interface{}, error) {
 	input := c.Input().(*BatchDeleteInput)
 
-	if err := h.globalRuleStore.BatchDelete(c.Context(), []string{input.ID}); err != nil {
+	mode, err := deletion.ParseMode(input.Mode)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	txn, err := deletion.Plan(ctx, mode, map[store.HubKey]store.Interface{
+		store.HubKeyGlobalRule: h.globalRuleStore,
+	}, store.HubKeyGlobalRule, []string{input.ID})
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+	if err := txn.CommitParts(ctx); err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
 
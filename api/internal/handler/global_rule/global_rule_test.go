@@ -170,6 +170,7 @@ func TestHandler_List(t *testing.T) {
 			giveData: []*entity.GlobalPlugins{},
 			giveErr:  fmt.Errorf("list failed"),
 			wantErr:  fmt.Errorf("list failed"),
+			wantRet:  &data.SpecCodeResponse{StatusCode: http.StatusInternalServerError},
 		},
 	}
 
@@ -333,12 +334,13 @@ func TestHandler_BatchDelete(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.caseDesc, func(t *testing.T) {
-			methodCalled := true
+			var deleted []string
 			mStore := &store.MockInterface{}
-			mStore.On("BatchDelete", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
-				methodCalled = true
+			mStore.On("PrepareDelete", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				deleted = append(deleted, args.Get(1).(string))
+			}).Return(&store.Op{}, nil)
+			mStore.On("Commit", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
 				assert.Equal(t, tc.giveCtx, args.Get(0))
-				assert.Equal(t, tc.wantInput, args.Get(1))
 			}).Return(tc.giveErr)
 
 			h := Handler{globalRuleStore: mStore}
@@ -346,7 +348,7 @@ func TestHandler_BatchDelete(t *testing.T) {
 			ctx.SetInput(tc.giveInput)
 			ctx.SetContext(tc.giveCtx)
 			ret, err := h.BatchDelete(ctx)
-			assert.True(t, methodCalled)
+			assert.Equal(t, tc.wantInput, deleted)
 			assert.Equal(t, tc.wantErr, err)
 			assert.Equal(t, tc.wantRet, ret)
 		})
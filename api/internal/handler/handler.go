@@ -36,8 +36,11 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -45,7 +48,9 @@ import (
 	"github.com/shiningrush/droplet/data"
 	"github.com/shiningrush/droplet/middleware"
 
+	"github.com/apisix/manager-api/internal/core/deletion"
 	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/storage"
 	"github.com/apisix/manager-api/internal/core/store"
 	"github.com/apisix/manager-api/internal/utils"
 )
@@ -57,6 +62,17 @@ type RouteRegister interface {
 }
 
 func SpecCodeResponse(err error) *data.SpecCodeResponse {
+	if errors.Is(err, storage.ErrRevisionMismatch) {
+		return &data.SpecCodeResponse{StatusCode: http.StatusConflict}
+	}
+	if errors.Is(err, storage.ErrTxnTooLarge) {
+		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}
+	}
+	var refErr *deletion.ReferencedError
+	if errors.As(err, &refErr) {
+		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}
+	}
+
 	errMsg := err.Error()
 	if strings.Contains(errMsg, "required") ||
 		strings.Contains(errMsg, "conflicted") ||
@@ -94,6 +110,73 @@ func (mw *ErrorTransformMiddleware) Handle(ctx droplet.Context) error {
 	return nil
 }
 
+// ResourceVersionMiddleware exposes the resource version of a returned
+// entity as the ETag header, so that clients can send it back in If-Match.
+type ResourceVersionMiddleware struct {
+	middleware.BaseMiddleware
+}
+
+func (mw *ResourceVersionMiddleware) Handle(ctx droplet.Context) error {
+	if err := mw.BaseMiddleware.Handle(ctx); err != nil {
+		return err
+	}
+
+	resp, ok := ctx.Output().(*data.Response)
+	if !ok || resp.Code != 0 {
+		return nil
+	}
+	v, ok := resp.Data.(entity.Versioned)
+	if !ok || v.GetResourceVersion() <= 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return nil
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("ETag", strconv.Quote(strconv.FormatInt(v.GetResourceVersion(), 10)))
+	ctx.SetOutput(&data.RawResponse{
+		StatusCode: http.StatusOK,
+		Header:     header,
+		Body:       body,
+	})
+	return nil
+}
+
+// ParseIfMatch parses the value of an If-Match header into the expected
+// resource version, 0 means the write is unconditional.
+func ParseIfMatch(ifMatch string) (int64, error) {
+	ifMatch = strings.TrimSpace(ifMatch)
+	if ifMatch == "" || ifMatch == "*" {
+		return 0, nil
+	}
+
+	tag := strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`)
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("If-Match header %s is invalid", ifMatch)
+	}
+	return version, nil
+}
+
+// ExpectedVersionContext returns a context making the store write
+// conditional on the If-Match header, or on fallback if the header is absent.
+func ExpectedVersionContext(ctx context.Context, ifMatch string, fallback int64) (context.Context, error) {
+	version, err := ParseIfMatch(ifMatch)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		version = fallback
+	}
+	if version == 0 {
+		return ctx, nil
+	}
+	return store.WithExpectedVersion(ctx, version), nil
+}
+
 func IDCompare(idOnPath string, idOnBody interface{}) error {
 	idOnBodyStr, ok := idOnBody.(string)
 	if !ok {
@@ -109,38 +192,14 @@ func IDCompare(idOnPath string, idOnBody interface{}) error {
 }
 
 func NameExistCheck(ctx context.Context, stg store.Interface, resource, name string, excludeID interface{}) (interface{}, error) {
-	ret, err := stg.List(ctx, store.ListInput{
-		Predicate: func(obj interface{}) bool {
-			var objName string
-			var objID interface{}
-			switch resource {
-			case "route":
-				objID = obj.(*entity.Route).ID
-				objName = obj.(*entity.Route).Name
-			case "service":
-				objID = obj.(*entity.Service).ID
-				objName = obj.(*entity.Service).Name
-			case "upstream":
-				objID = obj.(*entity.Upstream).ID
-				objName = obj.(*entity.Upstream).Name
-			default:
-				panic("bad resource")
-			}
-
-			if excludeID != nil && objID == excludeID {
-				return false
-			}
-			if objName == name {
-				return true
-			}
-
-			return false
-		},
-	})
+	ret, err := stg.IndexLookup(ctx, store.IndexName, name)
 	if err != nil {
 		return &data.SpecCodeResponse{StatusCode: http.StatusInternalServerError}, err
 	}
-	if ret.TotalSize > 0 {
+	for _, obj := range ret {
+		if excludeID != nil && obj.(entity.GetBaseInfo).GetBaseInfo().ID == excludeID {
+			continue
+		}
 		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest},
 			fmt.Errorf("%s name exists", resource)
 	}
@@ -18,6 +18,7 @@ package handler
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"testing"
 
@@ -26,6 +27,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/storage"
 	"github.com/apisix/manager-api/internal/core/store"
 )
 
@@ -38,11 +41,113 @@ func TestSpecCodeResponse(t *testing.T) {
 	resp = SpecCodeResponse(err)
 	assert.Equal(t, &data.SpecCodeResponse{StatusCode: http.StatusNotFound}, resp)
 
+	err = fmt.Errorf("key: /apisix/routes/1: %w", storage.ErrRevisionMismatch)
+	resp = SpecCodeResponse(err)
+	assert.Equal(t, &data.SpecCodeResponse{StatusCode: http.StatusConflict}, resp)
+
 	err = errors.New("system error")
 	resp = SpecCodeResponse(err)
 	assert.Equal(t, &data.SpecCodeResponse{StatusCode: http.StatusInternalServerError}, resp)
 }
 
+func TestParseIfMatch(t *testing.T) {
+	cases := []struct {
+		desc, ifMatch string
+		wantVersion   int64
+		wantErr       error
+	}{
+		{
+			desc: "empty",
+		},
+		{
+			desc:    "any",
+			ifMatch: "*",
+		},
+		{
+			desc:        "quoted",
+			ifMatch:     `"12"`,
+			wantVersion: 12,
+		},
+		{
+			desc:        "weak",
+			ifMatch:     `W/"12"`,
+			wantVersion: 12,
+		},
+		{
+			desc:        "unquoted",
+			ifMatch:     "12",
+			wantVersion: 12,
+		},
+		{
+			desc:    "not a number",
+			ifMatch: `"abc"`,
+			wantErr: errors.New(`If-Match header "abc" is invalid`),
+		},
+		{
+			desc:    "negative",
+			ifMatch: "-1",
+			wantErr: errors.New("If-Match header -1 is invalid"),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			version, err := ParseIfMatch(c.ifMatch)
+			assert.Equal(t, c.wantErr, err)
+			assert.Equal(t, c.wantVersion, version)
+		})
+	}
+}
+
+func TestResourceVersionMiddleware(t *testing.T) {
+	cases := []struct {
+		desc     string
+		giveOut  interface{}
+		wantETag string
+	}{
+		{
+			desc: "versioned entity",
+			giveOut: &data.Response{Data: &entity.Route{
+				BaseInfo: entity.BaseInfo{ID: "1", ResourceVersion: 7},
+			}},
+			wantETag: `"7"`,
+		},
+		{
+			desc:    "entity without version",
+			giveOut: &data.Response{Data: &entity.Route{BaseInfo: entity.BaseInfo{ID: "1"}}},
+		},
+		{
+			desc:    "not an entity",
+			giveOut: &data.Response{Data: &store.ListOutput{}},
+		},
+		{
+			desc:    "error response",
+			giveOut: &data.SpecCodeResponse{StatusCode: http.StatusNotFound},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			mw := &ResourceVersionMiddleware{}
+			mw.SetNext(droplet.NewHandlerMiddleware(func(ctx droplet.Context) (interface{}, error) {
+				return c.giveOut, nil
+			}))
+
+			ctx := droplet.NewContext()
+			err := mw.Handle(ctx)
+			assert.Nil(t, err)
+			if c.wantETag == "" {
+				assert.Equal(t, c.giveOut, ctx.Output())
+				return
+			}
+
+			raw, ok := ctx.Output().(*data.RawResponse)
+			assert.True(t, ok)
+			assert.Equal(t, http.StatusOK, raw.StatusCode)
+			assert.Equal(t, c.wantETag, raw.Header.Get("ETag"))
+			assert.Contains(t, string(raw.Body), `"resource_version":7`)
+		})
+	}
+}
+
 func TestIDCompare(t *testing.T) {
 	// init
 	cases := []struct {
@@ -106,7 +211,7 @@ func TestNameExistCheck(t *testing.T) {
 			name:     "test",
 		},
 		{
-			caseDesc: "get list error",
+			caseDesc: "index lookup error",
 			resource: "route",
 			name:     "test",
 			mockErr:  errors.New("test error"),
@@ -117,21 +222,22 @@ func TestNameExistCheck(t *testing.T) {
 			caseDesc: "name exists",
 			resource: "upstream",
 			name:     "test",
-			mockRet:  []interface{}{"test"},
+			mockRet:  []interface{}{&entity.Upstream{BaseInfo: entity.BaseInfo{ID: "u1"}}},
 			wantErr:  errors.New("upstream name exists"),
 			wantRet:  &data.SpecCodeResponse{StatusCode: http.StatusBadRequest},
 		},
+		{
+			caseDesc: "name exists, but it's itself",
+			resource: "upstream",
+			name:     "test",
+			id:       "u1",
+			mockRet:  []interface{}{&entity.Upstream{BaseInfo: entity.BaseInfo{ID: "u1"}}},
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.caseDesc, func(t *testing.T) {
 			mStore := &store.MockInterface{}
-			mStore.On("List", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
-			}).Return(func(input store.ListInput) *store.ListOutput {
-				return &store.ListOutput{
-					Rows:      tc.mockRet,
-					TotalSize: len(tc.mockRet),
-				}
-			}, tc.mockErr)
+			mStore.On("IndexLookup", mock.Anything, store.IndexName, tc.name).Return(tc.mockRet, tc.mockErr)
 
 			ctx := droplet.NewContext()
 			res, err := NameExistCheck(ctx.Context(), mStore, tc.resource, tc.name, tc.id)
@@ -0,0 +1,305 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package integrity
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shiningrush/droplet"
+	"github.com/shiningrush/droplet/data"
+	"github.com/shiningrush/droplet/wrapper"
+	wgin "github.com/shiningrush/droplet/wrapper/gin"
+
+	"github.com/apisix/manager-api/internal/core/integrity"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/handler"
+)
+
+type Handler struct {
+	stores  map[store.HubKey]store.Interface
+	checker *integrity.Checker
+}
+
+func NewHandler() (handler.RouteRegister, error) {
+	h := &Handler{
+		stores:  map[store.HubKey]store.Interface{},
+		checker: integrity.NewChecker(),
+	}
+	store.RangeStore(func(key store.HubKey, s *store.GenericStore) bool {
+		h.stores[key] = s
+		return true
+	})
+	return h, nil
+}
+
+func (h *Handler) ApplyRoute(r *gin.Engine) {
+	r.GET("/apisix/admin/integrity/report", wgin.Wraps(h.Report))
+	r.POST("/apisix/admin/integrity/gc", wgin.Wraps(h.GC,
+		wrapper.InputType(reflect.TypeOf(GCInput{}))))
+	r.GET("/apisix/admin/integrity/quarantine", wgin.Wraps(h.ListQuarantine,
+		wrapper.InputType(reflect.TypeOf(ListQuarantineInput{}))))
+	r.PUT("/apisix/admin/integrity/quarantine/:resource/:key", wgin.Wraps(h.Repair,
+		wrapper.InputType(reflect.TypeOf(RepairInput{}))))
+	r.DELETE("/apisix/admin/integrity/quarantine/:resource/:key", wgin.Wraps(h.Discard,
+		wrapper.InputType(reflect.TypeOf(DiscardInput{}))))
+}
+
+// swagger:operation GET /apisix/admin/integrity/report getIntegrityReport
+//
+// Scan all the resources, and return the dangling references, the scripts
+// and the protos nothing refers to, the server info of the nodes which
+// stopped reporting, the objects failing the current schema and the
+// malformed entries.
+//
+// ---
+// produces:
+// - application/json
+// responses:
+//   '0':
+//     description: the issues found, ordered by their kinds and objects
+//     schema:
+//       type: object
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) Report(c droplet.Context) (interface{}, error) {
+	return h.checker.Scan(c.Context()), nil
+}
+
+type GCInput struct {
+	// Kinds are orphan and stale, both by default
+	Kinds []string `json:"kinds"`
+}
+
+// swagger:operation POST /apisix/admin/integrity/gc collectIntegrityGarbage
+//
+// Delete the orphans and the stale server info found by a fresh scan in a
+// single transaction, the orphans are kept in the recycle bin like the
+// other deletions. Nothing is deleted with dry_run=true.
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: body
+//   in: body
+//   description: the kinds of the issues to collect, orphan and stale
+//   required: false
+//   schema:
+//     type: object
+// responses:
+//   '0':
+//     description: the deleted objects
+//     schema:
+//       type: array
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) GC(c droplet.Context) (interface{}, error) {
+	input := c.Input().(*GCInput)
+
+	deleted, err := h.checker.Collect(c.Context(), input.Kinds)
+	if err != nil {
+		if strings.Contains(err.Error(), "not collectable") {
+			return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, err
+		}
+		return handler.SpecCodeResponse(err), err
+	}
+	return deleted, nil
+}
+
+func (h *Handler) store(resource string) (store.Interface, error) {
+	s, ok := h.stores[store.HubKey(resource)]
+	if !ok {
+		return nil, fmt.Errorf("resource %s not found", resource)
+	}
+	return s, nil
+}
+
+type ListQuarantineInput struct {
+	Resource string `auto_read:"resource,query"`
+	Raw      bool   `auto_read:"raw,query"`
+}
+
+// swagger:operation GET /apisix/admin/integrity/quarantine listQuarantine
+//
+// Return the entries of the storage which can't be loaded, such as the
+// malformed objects written by other tools. They're left out of the
+// resources until they're repaired or deleted.
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: resource
+//   in: query
+//   description: the resource of the entries, such as route and ssl, all by default
+//   required: false
+//   type: string
+// - name: raw
+//   in: query
+//   description: return the raw values as well, which may carry secrets such as the private keys of SSLs
+//   required: false
+//   type: boolean
+// responses:
+//   '0':
+//     description: the entries with their errors and the sizes of their values
+//     schema:
+//       type: array
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) ListQuarantine(c droplet.Context) (interface{}, error) {
+	input := c.Input().(*ListQuarantineInput)
+
+	items := make([]*store.QuarantineItem, 0)
+	if input.Resource != "" {
+		s, err := h.store(input.Resource)
+		if err != nil {
+			return &data.SpecCodeResponse{StatusCode: http.StatusNotFound}, err
+		}
+		items = append(items, s.ListQuarantine(c.Context())...)
+	} else {
+		for _, s := range h.stores {
+			items = append(items, s.ListQuarantine(c.Context())...)
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].Resource < items[j].Resource
+		})
+	}
+
+	if !input.Raw {
+		for i := range items {
+			items[i] = items[i].WithoutValue()
+		}
+	}
+	return items, nil
+}
+
+type RepairInput struct {
+	Resource string `auto_read:"resource,path" validate:"required"`
+	Key      string `auto_read:"key,path" validate:"required"`
+	Body     []byte `auto_read:"@body"`
+}
+
+// swagger:operation PUT /apisix/admin/integrity/quarantine/{resource}/{key} repairQuarantine
+//
+// Replace the quarantined entry with the object in the body, which is
+// checked the same as creating it. It fails when the entry is modified
+// since it's quarantined.
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: resource
+//   in: path
+//   description: the resource of the entry, such as route and ssl
+//   required: true
+//   type: string
+// - name: key
+//   in: path
+//   description: the key of the entry
+//   required: true
+//   type: string
+// responses:
+//   '0':
+//     description: repair success
+//     schema:
+//       type: object
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) Repair(c droplet.Context) (interface{}, error) {
+	input := c.Input().(*RepairInput)
+	s, err := h.store(input.Resource)
+	if err != nil {
+		return &data.SpecCodeResponse{StatusCode: http.StatusNotFound}, err
+	}
+
+	op, err := s.PrepareRepair(c.Context(), input.Key, string(input.Body))
+	if err != nil {
+		// the others are the faults of the body
+		if strings.Contains(err.Error(), "not found") {
+			return &data.SpecCodeResponse{StatusCode: http.StatusNotFound}, err
+		}
+		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, err
+	}
+	if err := s.Commit(c.Context(), []*store.Op{op}); err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+	return nil, nil
+}
+
+type DiscardInput struct {
+	Resource string `auto_read:"resource,path" validate:"required"`
+	Key      string `auto_read:"key,path" validate:"required"`
+}
+
+// swagger:operation DELETE /apisix/admin/integrity/quarantine/{resource}/{key} discardQuarantine
+//
+// Delete the quarantined entry from the storage for good. It fails when the
+// entry is modified since it's quarantined.
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: resource
+//   in: path
+//   description: the resource of the entry, such as route and ssl
+//   required: true
+//   type: string
+// - name: key
+//   in: path
+//   description: the key of the entry
+//   required: true
+//   type: string
+// responses:
+//   '0':
+//     description: delete success
+//     schema:
+//       type: object
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) Discard(c droplet.Context) (interface{}, error) {
+	input := c.Input().(*DiscardInput)
+	s, err := h.store(input.Resource)
+	if err != nil {
+		return &data.SpecCodeResponse{StatusCode: http.StatusNotFound}, err
+	}
+
+	op, err := s.PrepareDiscard(c.Context(), input.Key)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+	if err := s.Commit(c.Context(), []*store.Op{op}); err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+	return nil, nil
+}
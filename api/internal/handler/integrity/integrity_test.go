@@ -0,0 +1,172 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package integrity
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shiningrush/droplet"
+	"github.com/shiningrush/droplet/data"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/integrity"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/utils"
+)
+
+func TestHandler_Quarantine(t *testing.T) {
+	ctx := context.TODO()
+	stg := storage.NewMemoryStorage()
+	_, err := stg.Create(ctx, "/apisix/routes/r1", `{"id":"r1","uri":`)
+	assert.Nil(t, err)
+	_, err = stg.Create(ctx, "/apisix/routes/r2", `{"id":"r2","uri":2}`)
+	assert.Nil(t, err)
+
+	s, err := store.NewGenericStore(store.GenericStoreOption{
+		BasePath: "/apisix/routes",
+		HubKey:   store.HubKeyRoute,
+		ObjType:  reflect.TypeOf(entity.Route{}),
+		KeyFunc: func(obj interface{}) string {
+			return utils.InterfaceToString(obj.(*entity.Route).ID)
+		},
+	})
+	assert.Nil(t, err)
+	s.Stg = stg
+	assert.Nil(t, s.Init())
+	defer s.Close()
+	h := &Handler{stores: map[store.HubKey]store.Interface{store.HubKeyRoute: s}}
+
+	quarantined := func() []string {
+		ctx := droplet.NewContext()
+		ctx.SetInput(&ListQuarantineInput{})
+		ret, err := h.ListQuarantine(ctx)
+		assert.Nil(t, err)
+		var keys []string
+		for _, item := range ret.([]*store.QuarantineItem) {
+			// the values are left out unless they're asked for
+			assert.Empty(t, item.Value)
+			assert.NotZero(t, item.Size)
+			keys = append(keys, item.Key)
+		}
+		return keys
+	}
+	assert.Equal(t, []string{"r1", "r2"}, quarantined())
+
+	c := droplet.NewContext()
+	c.SetInput(&ListQuarantineInput{Resource: "route", Raw: true})
+	ret, err := h.ListQuarantine(c)
+	assert.Nil(t, err)
+	items := ret.([]*store.QuarantineItem)
+	assert.Len(t, items, 2)
+	assert.Equal(t, `{"id":"r1","uri":`, items[0].Value)
+	assert.Equal(t, `{"id":"r2","uri":2}`, items[1].Value)
+
+	c = droplet.NewContext()
+	c.SetInput(&ListQuarantineInput{Resource: "foo"})
+	ret, err = h.ListQuarantine(c)
+	assert.EqualError(t, err, "resource foo not found")
+	assert.Equal(t, http.StatusNotFound, ret.(*data.SpecCodeResponse).StatusCode)
+
+	tests := []struct {
+		caseDesc   string
+		input      *RepairInput
+		wantErr    string
+		wantStatus int
+	}{
+		{
+			caseDesc:   "unknown resource",
+			input:      &RepairInput{Resource: "foo", Key: "r1", Body: []byte(`{"uri":"/r1"}`)},
+			wantErr:    "resource foo not found",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			caseDesc:   "not quarantined",
+			input:      &RepairInput{Resource: "route", Key: "r3", Body: []byte(`{"uri":"/r3"}`)},
+			wantErr:    "key: r3 is not found in the quarantine",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			caseDesc:   "malformed body",
+			input:      &RepairInput{Resource: "route", Key: "r1", Body: []byte(`{"uri":1}`)},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			caseDesc: "repaired",
+			input:    &RepairInput{Resource: "route", Key: "r1", Body: []byte(`{"uri":"/r1"}`)},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			c := droplet.NewContext()
+			c.SetInput(tc.input)
+			ret, err := h.Repair(c)
+			if tc.wantStatus != 0 {
+				assert.NotNil(t, err)
+				if tc.wantErr != "" {
+					assert.EqualError(t, err, tc.wantErr)
+				}
+				assert.Equal(t, tc.wantStatus, ret.(*data.SpecCodeResponse).StatusCode)
+				return
+			}
+			assert.Nil(t, err)
+		})
+	}
+	assert.Eventually(t, func() bool {
+		obj, err := s.Get(ctx, "r1")
+		return err == nil && obj.(*entity.Route).URI == "/r1"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	c = droplet.NewContext()
+	c.SetInput(&DiscardInput{Resource: "route", Key: "r2"})
+	_, err = h.Discard(c)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		return len(quarantined()) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	c = droplet.NewContext()
+	c.SetInput(&DiscardInput{Resource: "route", Key: "r2"})
+	ret, err = h.Discard(c)
+	assert.EqualError(t, err, "key: r2 is not found in the quarantine")
+	assert.Equal(t, http.StatusNotFound, ret.(*data.SpecCodeResponse).StatusCode)
+}
+
+func TestHandler_GC(t *testing.T) {
+	h := &Handler{checker: &integrity.Checker{Stores: map[store.HubKey]integrity.Store{}}}
+
+	ret, err := h.Report(droplet.NewContext())
+	assert.Nil(t, err)
+	assert.Empty(t, ret.(*integrity.Report).Issues)
+
+	c := droplet.NewContext()
+	c.SetInput(&GCInput{Kinds: []string{integrity.KindDangling}})
+	ret, err = h.GC(c)
+	assert.EqualError(t, err, "kind dangling is not collectable")
+	assert.Equal(t, http.StatusBadRequest, ret.(*data.SpecCodeResponse).StatusCode)
+
+	c = droplet.NewContext()
+	c.SetInput(&GCInput{})
+	ret, err = h.GC(c)
+	assert.Nil(t, err)
+	assert.Empty(t, ret)
+}
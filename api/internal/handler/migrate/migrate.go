@@ -18,14 +18,21 @@ package migrate
 
 import (
 	"encoding/binary"
+	"errors"
 	"hash/crc32"
 	"io/ioutil"
 	"net/http"
+	"reflect"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/shiningrush/droplet"
 	"github.com/shiningrush/droplet/data"
+	"github.com/shiningrush/droplet/wrapper"
+	wgin "github.com/shiningrush/droplet/wrapper/gin"
 
 	"github.com/apisix/manager-api/internal/core/migrate"
+	"github.com/apisix/manager-api/internal/core/store"
 	"github.com/apisix/manager-api/internal/handler"
 	"github.com/apisix/manager-api/internal/log"
 	"github.com/apisix/manager-api/internal/utils/consts"
@@ -45,13 +52,35 @@ func NewHandler() (handler.RouteRegister, error) {
 func (h *Handler) ApplyRoute(r *gin.Engine) {
 	r.GET("/apisix/admin/migrate/export", h.ExportConfig)
 	r.POST("/apisix/admin/migrate/import", h.ImportConfig)
+	r.GET("/apisix/admin/migrate/version", wgin.Wraps(h.Version))
+	r.POST("/apisix/admin/migrate/upgrade", wgin.Wraps(h.Upgrade,
+		wrapper.InputType(reflect.TypeOf(UpgradeInput{}))))
 }
 
 type ExportInput struct{}
 
+// exportOptions returns the options of the types and the selector, which
+// are comma separated resources such as route,upstream and a label
+// selector.
+func exportOptions(types, selector string) migrate.Options {
+	opt := migrate.Options{Selector: selector}
+	for _, typ := range strings.Split(types, ",") {
+		if typ = strings.TrimSpace(typ); typ != "" {
+			opt.Types = append(opt.Types, store.HubKey(typ))
+		}
+	}
+	return opt
+}
+
 func (h *Handler) ExportConfig(c *gin.Context) {
-	data, err := migrate.Export(c)
+	opt := exportOptions(c.Query("types"), c.Query("selector"))
+	data, err := migrate.Export(c.Request.Context(), opt)
 	if err != nil {
+		var optErr *migrate.OptionsError
+		if errors.As(err, &optErr) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, consts.InvalidParam(err.Error()))
+			return
+		}
 		log.Errorf("Export: %s", err)
 		c.JSON(http.StatusInternalServerError, err)
 		return
@@ -75,6 +104,21 @@ func (h *Handler) ExportConfig(c *gin.Context) {
 
 type ImportOutput struct {
 	ConflictItems *migrate.DataSet
+	Header        *migrate.Header
+	Summary       map[string]int
+	Outcomes      []*migrate.Outcome
+}
+
+func importOutput(result *migrate.ImportResult) ImportOutput {
+	if result == nil {
+		return ImportOutput{}
+	}
+	return ImportOutput{
+		ConflictItems: result.Conflicts,
+		Header:        result.Header,
+		Summary:       result.Summary,
+		Outcomes:      result.Outcomes,
+	}
 }
 
 var modeMap = map[string]migrate.ConflictMode{
@@ -99,6 +143,13 @@ func (h *Handler) ImportConfig(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, err)
 		return
 	}
+	if len(content) < checksumLength {
+		c.JSON(http.StatusOK, &data.BaseError{
+			Code:    consts.ErrBadRequest,
+			Message: "File is too short,maybe file broken",
+		})
+		return
+	}
 	// checksum uint32,4 bytes
 	importData := content[:len(content)-4]
 	checksum := binary.BigEndian.Uint32(content[len(content)-4:])
@@ -109,25 +160,120 @@ func (h *Handler) ImportConfig(c *gin.Context) {
 		})
 		return
 	}
-	conflictData, err := migrate.Import(c, importData, mode)
+	opt := exportOptions(c.PostForm("types"), c.PostForm("selector"))
+	result, err := migrate.Import(c.Request.Context(), importData, mode, opt)
 	if err != nil {
 		if err == migrate.ErrConflict {
 			c.JSON(http.StatusOK, &data.BaseError{
 				Code:    consts.ErrBadRequest,
 				Message: "Config conflict",
-				Data:    ImportOutput{ConflictItems: conflictData},
+				Data:    importOutput(result),
 			})
 		} else {
 			log.Errorf("Import failed: %s", err)
 			c.JSON(http.StatusOK, &data.BaseError{
 				Code:    consts.ErrBadRequest,
 				Message: err.Error(),
-				Data:    ImportOutput{ConflictItems: conflictData},
+				Data:    importOutput(result),
 			})
 		}
 		return
 	}
 	c.JSON(http.StatusOK, &data.Response{
-		Data: ImportOutput{ConflictItems: conflictData},
+		Data: importOutput(result),
 	})
 }
+
+type VersionOutput struct {
+	// Applied is the record of the last upgrade, null when the data was
+	// never upgraded
+	Applied *migrate.AppliedVersion `json:"applied"`
+	Latest  string                  `json:"latest"`
+	// Pending are the migrations an upgrade to the latest version runs
+	Pending []*migrate.Migration `json:"pending"`
+}
+
+// swagger:operation GET /apisix/admin/migrate/version getMigrationVersion
+//
+// Return the version the stored data was last upgraded to, and the
+// migrations an upgrade to the latest version runs.
+//
+// ---
+// produces:
+// - application/json
+// responses:
+//   '0':
+//     description: the applied version and the pending migrations
+//     schema:
+//       type: object
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) Version(c droplet.Context) (interface{}, error) {
+	m, err := migrate.NewMigrator(c.Context())
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+	applied, err := m.Version(c.Context())
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	output := &VersionOutput{Applied: applied, Latest: migrate.LatestVersion()}
+	from := ""
+	if applied != nil {
+		from = applied.Version
+	}
+	output.Pending = append([]*migrate.Migration{}, migrate.Pending(from, output.Latest)...)
+	return output, nil
+}
+
+type UpgradeInput struct {
+	// To is the APISIX version to upgrade to, the latest by default
+	To string `json:"to"`
+	// BatchSize is the number of the objects written in a transaction, at
+	// most half of etcd.max_txn_ops
+	BatchSize int `json:"batch_size"`
+}
+
+// swagger:operation POST /apisix/admin/migrate/upgrade upgradeData
+//
+// Run the migrations from the applied version to the target one, which
+// rewrite the stored objects for the APISIX version. The objects are
+// written in batches along with their backups, and the target version is
+// recorded at last. With dry_run=true only the changes are reported.
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: body
+//   in: body
+//   description: the target version and the batch size
+//   required: false
+//   schema:
+//     type: object
+// responses:
+//   '0':
+//     description: the changes of every migration
+//     schema:
+//       type: object
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) Upgrade(c droplet.Context) (interface{}, error) {
+	input := c.Input().(*UpgradeInput)
+
+	m, err := migrate.NewMigrator(c.Context())
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+	m.BatchSize = input.BatchSize
+	report, err := m.Upgrade(c.Context(), input.To)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+	return report, nil
+}
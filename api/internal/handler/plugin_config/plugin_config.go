@@ -29,6 +29,7 @@ import (
 	"github.com/shiningrush/droplet/wrapper"
 	wgin "github.com/shiningrush/droplet/wrapper/gin"
 
+	"github.com/apisix/manager-api/internal/core/deletion"
 	"github.com/apisix/manager-api/internal/core/entity"
 	"github.com/apisix/manager-api/internal/core/store"
 	"github.com/apisix/manager-api/internal/handler"
@@ -86,6 +87,7 @@ type ListInput struct {
 	Search string `auto_read:"search,query"`
 	Label  string `auto_read:"label,query"`
 	store.Pagination
+	store.ListQuery
 }
 
 // swagger:operation GET /apisix/admin/plugin_configs getPluginConfigList
@@ -106,6 +108,36 @@ type ListInput struct {
 //   description: page size
 //   required: false
 //   type: integer
+// - name: cursor
+//   in: query
+//   description: next_cursor of the previous page, the page number is ignored when it's set
+//   required: false
+//   type: string
+// - name: with_total
+//   in: query
+//   description: count total_size of a cursor page, which stops at the end of the page otherwise
+//   required: false
+//   type: boolean
+// - name: sort_by
+//   in: query
+//   description: top level field to sort by, create_time by default
+//   required: false
+//   type: string
+// - name: order
+//   in: query
+//   description: asc or desc
+//   required: false
+//   type: string
+// - name: fields
+//   in: query
+//   description: comma separated top level fields to return
+//   required: false
+//   type: string
+// - name: label_selector
+//   in: query
+//   description: label selector like "env in (prod,staging),!deprecated,team!=core"
+//   required: false
+//   type: string
 // - name: search
 //   in: query
 //   description: search keyword
@@ -144,9 +176,12 @@ func (h *Handler) List(c droplet.Context) (interface{}, error) {
 		},
 		PageSize:   input.PageSize,
 		PageNumber: input.PageNumber,
+		Cursor:     input.Cursor,
+		WithTotal:  input.WithTotal,
+		Query:      input.ListQuery,
 	})
 	if err != nil {
-		return nil, err
+		return handler.SpecCodeResponse(err), err
 	}
 
 	return ret, nil
@@ -164,7 +199,8 @@ func (h *Handler) Create(c droplet.Context) (interface{}, error) {
 }
 
 type UpdateInput struct {
-	ID string `auto_read:"id,path"`
+	ID      string `auto_read:"id,path"`
+	IfMatch string `auto_read:"If-Match,header" json:"-"`
 	entity.PluginConfig
 }
 
@@ -180,7 +216,12 @@ func (h *Handler) Update(c droplet.Context) (interface{}, error) {
 		input.PluginConfig.ID = input.ID
 	}
 
-	ret, err := h.pluginConfigStore.Update(c.Context(), &input.PluginConfig, true)
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	ret, err := h.pluginConfigStore.Update(ctx, &input.PluginConfig, true)
 	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
@@ -189,38 +230,32 @@ func (h *Handler) Update(c droplet.Context) (interface{}, error) {
 }
 
 type BatchDelete struct {
-	IDs string `auto_read:"ids,path"`
+	IDs     string `auto_read:"ids,path"`
+	IfMatch string `auto_read:"If-Match,header"`
+	Mode    string `auto_read:"mode,query"`
 }
 
 func (h *Handler) BatchDelete(c droplet.Context) (interface{}, error) {
 	input := c.Input().(*BatchDelete)
 
-	IDs := strings.Split(input.IDs, ",")
-	IDMap := map[string]bool{}
-	for _, id := range IDs {
-		IDMap[id] = true
+	mode, err := deletion.ParseMode(input.Mode)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
 	}
-	ret, err := h.routeStore.List(c.Context(), store.ListInput{
-		Predicate: func(obj interface{}) bool {
-			id := utils.InterfaceToString(obj.(*entity.Route).PluginConfigID)
-			if _, ok := IDMap[id]; ok {
-				return true
-			}
-			return false
-		},
-	})
 
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
 	if err != nil {
-		return nil, err
+		return handler.SpecCodeResponse(err), err
 	}
 
-	if len(ret.Rows) > 0 {
-		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest},
-			fmt.Errorf("please disconnect the route (ID: %s) with this plugin config first",
-				ret.Rows[0].(*entity.Route).ID)
+	txn, err := deletion.Plan(ctx, mode, map[store.HubKey]store.Interface{
+		store.HubKeyPluginConfig: h.pluginConfigStore,
+		store.HubKeyRoute:        h.routeStore,
+	}, store.HubKeyPluginConfig, strings.Split(input.IDs, ","))
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
 	}
-
-	if err := h.pluginConfigStore.BatchDelete(c.Context(), strings.Split(input.IDs, ",")); err != nil {
+	if err := txn.CommitParts(ctx); err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
 
@@ -230,6 +265,7 @@ func (h *Handler) BatchDelete(c droplet.Context) (interface{}, error) {
 type PatchInput struct {
 	ID      string `auto_read:"id,path"`
 	SubPath string `auto_read:"path,path"`
+	IfMatch string `auto_read:"If-Match,header"`
 	Body    []byte `auto_read:"@body"`
 }
 
@@ -257,7 +293,12 @@ func (h *Handler) Patch(c droplet.Context) (interface{}, error) {
 		return handler.SpecCodeResponse(err), err
 	}
 
-	ret, err := h.pluginConfigStore.Update(c.Context(), &pluginConfig, false)
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, stored.(*entity.PluginConfig).ResourceVersion)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	ret, err := h.pluginConfigStore.Update(ctx, &pluginConfig, false)
 	if err != nil {
 		log.Warnf("update failed: %s", err)
 		return handler.SpecCodeResponse(err), err
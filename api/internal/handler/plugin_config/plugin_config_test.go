@@ -621,7 +621,7 @@ func TestPluginConfigs_Delete(t *testing.T) {
 		caseDesc  string
 		giveInput *BatchDelete
 		giveErr   error
-		listRet   *store.ListOutput
+		lookupRet []interface{}
 		wantInput []string
 		wantErr   error
 		wantRet   interface{}
@@ -631,10 +631,6 @@ func TestPluginConfigs_Delete(t *testing.T) {
 			giveInput: &BatchDelete{
 				IDs: "1",
 			},
-			listRet: &store.ListOutput{
-				Rows:      []interface{}{},
-				TotalSize: 0,
-			},
 			wantInput: []string{"1"},
 		},
 		{
@@ -642,15 +638,11 @@ func TestPluginConfigs_Delete(t *testing.T) {
 			giveInput: &BatchDelete{
 				IDs: "1,s2",
 			},
-			listRet: &store.ListOutput{
-				Rows:      []interface{}{},
-				TotalSize: 0,
-			},
 			wantInput: []string{"1", "s2"},
 		},
 
 		{
-			caseDesc: "delete failed - being used by user",
+			caseDesc: "delete failed - being used by routes",
 			giveInput: &BatchDelete{
 				IDs: "001,002",
 			},
@@ -659,14 +651,12 @@ func TestPluginConfigs_Delete(t *testing.T) {
 				"001",
 				"002",
 			},
-			listRet: &store.ListOutput{
-				Rows: []interface{}{
-					&entity.Route{BaseInfo: entity.BaseInfo{ID: "a"}},
-					&entity.Route{BaseInfo: entity.BaseInfo{ID: "b"}},
-				},
-				TotalSize: 2,
+			lookupRet: []interface{}{
+				&entity.Route{BaseInfo: entity.BaseInfo{ID: "a"}},
+				&entity.Route{BaseInfo: entity.BaseInfo{ID: "b"}},
 			},
-			wantErr: errors.New("please disconnect the route (ID: a) with this plugin config first"),
+			wantErr: errors.New("plugin_config/001 is referenced by route/a (plugin_config_id), route/b (plugin_config_id); " +
+				"plugin_config/002 is referenced by route/a (plugin_config_id), route/b (plugin_config_id)"),
 			wantRet: &data.SpecCodeResponse{
 				StatusCode: http.StatusBadRequest,
 			},
@@ -677,10 +667,6 @@ func TestPluginConfigs_Delete(t *testing.T) {
 			giveInput: &BatchDelete{
 				IDs: "1",
 			},
-			listRet: &store.ListOutput{
-				Rows:      []interface{}{},
-				TotalSize: 0,
-			},
 			giveErr:   fmt.Errorf("delete error"),
 			wantInput: []string{"1"},
 			wantRet:   handler.SpecCodeResponse(fmt.Errorf("delete error")),
@@ -690,26 +676,27 @@ func TestPluginConfigs_Delete(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.caseDesc, func(t *testing.T) {
-			getCalled := false
+			var deleted []string
 			pluginConfigStore := &store.MockInterface{}
-			pluginConfigStore.On("BatchDelete", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
-				getCalled = true
-				input := args.Get(1).([]string)
-				assert.Equal(t, tc.wantInput, input)
-			}).Return(tc.giveErr)
+			pluginConfigStore.On("PrepareDelete", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				deleted = append(deleted, args.Get(1).(string))
+			}).Return(&store.Op{}, nil)
+			pluginConfigStore.On("Commit", mock.Anything, mock.Anything).Return(tc.giveErr)
 
 			mockRouteStore := &store.MockInterface{}
-			mockRouteStore.On("List", mock.Anything).Run(func(args mock.Arguments) {
-				getCalled = true
-			}).Return(tc.listRet, nil)
+			mockRouteStore.On("IndexLookup", mock.Anything, store.IndexPluginConfigID, mock.Anything).Return(tc.lookupRet, nil)
 
 			h := Handler{pluginConfigStore: pluginConfigStore, routeStore: mockRouteStore}
 			ctx := droplet.NewContext()
 			ctx.SetInput(tc.giveInput)
 			ret, err := h.BatchDelete(ctx)
-			assert.True(t, getCalled)
+			assert.Equal(t, tc.wantInput, deleted)
 			assert.Equal(t, tc.wantRet, ret)
-			assert.Equal(t, tc.wantErr, err)
+			if tc.wantErr != nil {
+				assert.EqualError(t, err, tc.wantErr.Error())
+			} else {
+				assert.Nil(t, err)
+			}
 		})
 	}
 }
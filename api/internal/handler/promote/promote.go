@@ -0,0 +1,203 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package promote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shiningrush/droplet"
+	"github.com/shiningrush/droplet/data"
+	"github.com/shiningrush/droplet/wrapper"
+	wgin "github.com/shiningrush/droplet/wrapper/gin"
+
+	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/core/graph"
+	"github.com/apisix/manager-api/internal/core/migrate"
+	"github.com/apisix/manager-api/internal/core/promote"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/handler"
+)
+
+type Handler struct{}
+
+func NewHandler() (handler.RouteRegister, error) {
+	return &Handler{}, nil
+}
+
+func (h *Handler) ApplyRoute(r *gin.Engine) {
+	r.POST("/apisix/admin/promotion/diff", wgin.Wraps(h.Diff,
+		wrapper.InputType(reflect.TypeOf(PromoteInput{}))))
+	r.POST("/apisix/admin/promotion/promote", wgin.Wraps(h.Promote,
+		wrapper.InputType(reflect.TypeOf(PromoteInput{}))))
+}
+
+var modeMap = map[string]migrate.ConflictMode{
+	"return":    migrate.ModeReturn,
+	"overwrite": migrate.ModeOverwrite,
+	"skip":      migrate.ModeSkip,
+}
+
+type PromoteInput struct {
+	// Source is local by default
+	Source    string            `json:"source"`
+	Target    string            `json:"target"`
+	Resources []graph.Node      `json:"resources"`
+	Selector  string            `json:"selector"`
+	IDs       map[string]string `json:"ids"`
+	// Mode is return by default
+	Mode string `json:"mode"`
+}
+
+// swagger:operation POST /apisix/admin/promotion/diff diffPromotion
+//
+// Compare the selected objects of the source, along with the objects they
+// refer to, against the target after their ids are rewritten. Nothing is
+// written.
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: body
+//   in: body
+//   description: source (local by default), target, resources ([{"type": "route", "id": "r1"}]), selector (label selector like "team=core") and ids (the ids in the target like {"upstream/u1": "u2"})
+//   required: true
+//   schema:
+//     type: object
+// responses:
+//   '0':
+//     description: the objects in the order they are promoted, each with its action (create, update, unchanged or conflict) and diff
+//     schema:
+//       type: object
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) Diff(c droplet.Context) (interface{}, error) {
+	input := c.Input().(*PromoteInput)
+	source, target, err := clusters(input)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+	ret, err := promote.Diff(localContext(c), source, target, options(input))
+	if err != nil {
+		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, err
+	}
+	return ret, nil
+}
+
+// swagger:operation POST /apisix/admin/promotion/promote promote
+//
+// Promote the selected objects of the source, along with the objects they
+// refer to, to the target. The objects differing from the target fail the
+// promotion with the return mode, overwrite the target with the overwrite
+// mode, and are left alone with the skip mode. The objects are written in
+// parts within a transaction each, the referred ones first; when a part
+// fails, the error tells how many objects are promoted already, and
+// promoting again writes the rest.
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: body
+//   in: body
+//   description: the same as the diff, along with mode (return, overwrite or skip, return by default)
+//   required: true
+//   schema:
+//     type: object
+// responses:
+//   '0':
+//     description: the objects in the order they are promoted, each with its action (create, update, unchanged or skip)
+//     schema:
+//       type: object
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) Promote(c droplet.Context) (interface{}, error) {
+	input := c.Input().(*PromoteInput)
+	mode := migrate.ModeReturn
+	if input.Mode != "" {
+		m, ok := modeMap[input.Mode]
+		if !ok {
+			return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest},
+				fmt.Errorf("mode %s is invalid", input.Mode)
+		}
+		mode = m
+	}
+	source, target, err := clusters(input)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	ctx := localContext(c)
+	ret, err := promote.Diff(ctx, source, target, options(input))
+	if err != nil {
+		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, err
+	}
+	if err := ret.Promote(ctx, target, mode); err != nil {
+		var conflictErr *promote.ConflictError
+		if errors.As(err, &conflictErr) || errors.Is(err, storage.ErrRevisionMismatch) {
+			return &data.SpecCodeResponse{StatusCode: http.StatusConflict}, err
+		}
+		var itemErr *promote.ItemError
+		if errors.As(err, &itemErr) {
+			return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, err
+		}
+		return handler.SpecCodeResponse(err), err
+	}
+	return ret, nil
+}
+
+func clusters(input *PromoteInput) (*promote.Cluster, *promote.Cluster, error) {
+	if input.Source == "" {
+		input.Source = conf.ClusterLocal
+	}
+	if input.Target == "" {
+		return nil, nil, errors.New("target is required")
+	}
+	source, err := promote.GetCluster(input.Source)
+	if err != nil {
+		return nil, nil, err
+	}
+	target, err := promote.GetCluster(input.Target)
+	if err != nil {
+		return nil, nil, err
+	}
+	return source, target, nil
+}
+
+// localContext is the context of the request for the local cluster, the
+// clusters are named by the input rather than by the request.
+func localContext(c droplet.Context) context.Context {
+	return store.WithCluster(c.Context(), conf.ClusterLocal)
+}
+
+func options(input *PromoteInput) promote.Options {
+	return promote.Options{
+		Resources: input.Resources,
+		Selector:  input.Selector,
+		IDs:       input.IDs,
+	}
+}
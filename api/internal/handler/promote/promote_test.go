@@ -0,0 +1,73 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package promote
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/shiningrush/droplet"
+	"github.com/shiningrush/droplet/data"
+	"github.com/stretchr/testify/assert"
+
+)
+
+func TestHandler_Promote(t *testing.T) {
+	h := &Handler{}
+
+	tests := []struct {
+		caseDesc   string
+		input      *PromoteInput
+		wantErr    string
+		wantStatus int
+	}{
+		{
+			caseDesc:   "invalid mode",
+			input:      &PromoteInput{Target: "prod", Mode: "merge"},
+			wantErr:    "mode merge is invalid",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			caseDesc:   "no target",
+			input:      &PromoteInput{},
+			wantErr:    "target is required",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			caseDesc:   "target not found",
+			input:      &PromoteInput{Target: "prod"},
+			wantErr:    "cluster prod not found",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			caseDesc:   "same cluster",
+			input:      &PromoteInput{Target: "local", Selector: "team=core"},
+			wantErr:    "source and target are the same cluster local",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.caseDesc, func(t *testing.T) {
+			ctx := droplet.NewContext()
+			ctx.SetInput(tc.input)
+			ret, err := h.Promote(ctx)
+			assert.EqualError(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantStatus, ret.(*data.SpecCodeResponse).StatusCode)
+		})
+	}
+}
@@ -17,10 +17,8 @@
 package proto
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
 	"reflect"
 	"strings"
@@ -31,6 +29,7 @@ import (
 	"github.com/shiningrush/droplet/wrapper"
 	wgin "github.com/shiningrush/droplet/wrapper/gin"
 
+	"github.com/apisix/manager-api/internal/core/deletion"
 	"github.com/apisix/manager-api/internal/core/entity"
 	"github.com/apisix/manager-api/internal/core/store"
 	"github.com/apisix/manager-api/internal/handler"
@@ -76,8 +75,6 @@ func (h *Handler) ApplyRoute(r *gin.Engine) {
 		wrapper.InputType(reflect.TypeOf(BatchDeleteInput{}))))
 }
 
-var plugins = []string{"grpc-transcode"}
-
 type GetInput struct {
 	ID string `auto_read:"id,path" validate:"required"`
 }
@@ -96,6 +93,7 @@ func (h *Handler) Get(c droplet.Context) (interface{}, error) {
 type ListInput struct {
 	Desc string `auto_read:"desc,query"`
 	store.Pagination
+	store.ListQuery
 }
 
 func (h *Handler) List(c droplet.Context) (interface{}, error) {
@@ -110,9 +108,12 @@ func (h *Handler) List(c droplet.Context) (interface{}, error) {
 		},
 		PageSize:   input.PageSize,
 		PageNumber: input.PageNumber,
+		Cursor:     input.Cursor,
+		WithTotal:  input.WithTotal,
+		Query:      input.ListQuery,
 	})
 	if err != nil {
-		return nil, err
+		return handler.SpecCodeResponse(err), err
 	}
 
 	return ret, nil
@@ -143,7 +144,8 @@ func (h *Handler) Create(c droplet.Context) (interface{}, error) {
 }
 
 type UpdateInput struct {
-	ID string `auto_read:"id,path"`
+	ID      string `auto_read:"id,path"`
+	IfMatch string `auto_read:"If-Match,header" json:"-"`
 	entity.Proto
 }
 
@@ -159,7 +161,12 @@ func (h *Handler) Update(c droplet.Context) (interface{}, error) {
 		input.Proto.ID = input.ID
 	}
 
-	res, err := h.protoStore.Update(c.Context(), &input.Proto, true)
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	res, err := h.protoStore.Update(ctx, &input.Proto, true)
 	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
@@ -170,6 +177,7 @@ func (h *Handler) Update(c droplet.Context) (interface{}, error) {
 type PatchInput struct {
 	ID      string `auto_read:"id,path"`
 	SubPath string `auto_read:"path,path"`
+	IfMatch string `auto_read:"If-Match,header"`
 	Body    []byte `auto_read:"@body"`
 }
 
@@ -195,7 +203,12 @@ func (h *Handler) Patch(c droplet.Context) (interface{}, error) {
 		return handler.SpecCodeResponse(err), err
 	}
 
-	ret, err := h.protoStore.Update(c.Context(), &proto, false)
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, stored.(*entity.Proto).ResourceVersion)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	ret, err := h.protoStore.Update(ctx, &proto, false)
 	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
@@ -204,57 +217,38 @@ func (h *Handler) Patch(c droplet.Context) (interface{}, error) {
 }
 
 type BatchDeleteInput struct {
-	IDs string `auto_read:"ids,path"`
+	IDs     string `auto_read:"ids,path"`
+	IfMatch string `auto_read:"If-Match,header"`
+	Mode    string `auto_read:"mode,query"`
 }
 
 func (h *Handler) BatchDelete(c droplet.Context) (interface{}, error) {
 	input := c.Input().(*BatchDeleteInput)
 
-	ids := strings.Split(input.IDs, ",")
-	checklist := []store.Interface{h.routeStore, h.consumerStore, h.serviceStore, h.pluginConfigStore, h.globalRuleStore}
-
-	for _, id := range ids {
-		for _, store := range checklist {
-			if err := h.checkProtoUsed(c.Context(), store, id); err != nil {
-				return handler.SpecCodeResponse(err), err
-			}
-		}
+	mode, err := deletion.ParseMode(input.Mode)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
 	}
 
-	if err := h.protoStore.BatchDelete(c.Context(), ids); err != nil {
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
+	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
 
-	return nil, nil
-}
-
-func (h *Handler) checkProtoUsed(ctx context.Context, storeInterface store.Interface, key string) error {
-	ret, err := storeInterface.List(ctx, store.ListInput{
-		Predicate: func(obj interface{}) bool {
-			record := obj.(entity.GetPlugins)
-			for _, plugin := range plugins {
-				if _, ok := record.GetPlugins()[plugin]; ok {
-					configs := record.GetPlugins()[plugin].(map[string]interface{})
-					protoId := utils.InterfaceToString(configs["proto_id"])
-					if protoId == key {
-						return true
-					}
-				}
-			}
-			return false
-		},
-		Format: func(obj interface{}) interface{} {
-			return obj.(entity.GetPlugins)
-		},
-		PageSize:   0,
-		PageNumber: 0,
-	})
+	txn, err := deletion.Plan(ctx, mode, map[store.HubKey]store.Interface{
+		store.HubKeyProto:        h.protoStore,
+		store.HubKeyRoute:        h.routeStore,
+		store.HubKeyService:      h.serviceStore,
+		store.HubKeyConsumer:     h.consumerStore,
+		store.HubKeyPluginConfig: h.pluginConfigStore,
+		store.HubKeyGlobalRule:   h.globalRuleStore,
+	}, store.HubKeyProto, strings.Split(input.IDs, ","))
 	if err != nil {
-		return err
+		return handler.SpecCodeResponse(err), err
 	}
-	if ret.TotalSize > 0 {
-		return fmt.Errorf("proto used check invalid: %s: %s is using this proto", storeInterface.Type(), ret.Rows[0].(entity.GetBaseInfo).GetBaseInfo().ID)
+	if err := txn.CommitParts(ctx); err != nil {
+		return handler.SpecCodeResponse(err), err
 	}
-	return nil
 
+	return nil, nil
 }
@@ -0,0 +1,240 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package recycle
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shiningrush/droplet"
+	"github.com/shiningrush/droplet/data"
+	"github.com/shiningrush/droplet/wrapper"
+	wgin "github.com/shiningrush/droplet/wrapper/gin"
+
+	"github.com/apisix/manager-api/internal/core/batch"
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/handler"
+	"github.com/apisix/manager-api/internal/utils"
+)
+
+// resources maps the paths of the recycle bin to the stores keeping the
+// deleted objects.
+var resources = []struct {
+	path   string
+	hubKey store.HubKey
+}{
+	{"routes", store.HubKeyRoute},
+	{"services", store.HubKeyService},
+	{"upstreams", store.HubKeyUpstream},
+	{"consumers", store.HubKeyConsumer},
+	{"ssl", store.HubKeySsl},
+	{"plugin_configs", store.HubKeyPluginConfig},
+	{"proto", store.HubKeyProto},
+	{"stream_routes", store.HubKeyStreamRoute},
+	{"global_rules", store.HubKeyGlobalRule},
+}
+
+type Handler struct {
+	stores      map[store.HubKey]store.Interface
+	scriptStore store.Interface
+	planner     *batch.Planner
+}
+
+func NewHandler() (handler.RouteRegister, error) {
+	h := &Handler{
+		stores:      map[store.HubKey]store.Interface{},
+		scriptStore: store.GetStore(store.HubKeyScript),
+		planner:     &batch.Planner{},
+	}
+	for _, res := range resources {
+		h.stores[res.hubKey] = store.GetStore(res.hubKey)
+	}
+	return h, nil
+}
+
+func (h *Handler) ApplyRoute(r *gin.Engine) {
+	for _, res := range resources {
+		base := "/apisix/admin/recycle_bin/" + res.path
+		r.GET(base, wgin.Wraps(h.list(res.hubKey)))
+		r.POST(base+"/:key/restore", wgin.Wraps(h.restore(res.hubKey),
+			wrapper.InputType(reflect.TypeOf(RestoreInput{}))))
+		r.DELETE(base+"/:keys", wgin.Wraps(h.purge(res.hubKey),
+			wrapper.InputType(reflect.TypeOf(PurgeInput{}))))
+	}
+}
+
+// swagger:operation GET /apisix/admin/recycle_bin/{resource} listRecycleBin
+//
+// Return the deleted objects of the resource kept in the recycle bin, the
+// latest deleted first.
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: resource
+//   in: path
+//   description: one of routes, services, upstreams, consumers, ssl, plugin_configs, proto, stream_routes and global_rules
+//   required: true
+//   type: string
+// responses:
+//   '0':
+//     description: list response
+//     schema:
+//       type: array
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) list(hubKey store.HubKey) droplet.Handler {
+	return func(c droplet.Context) (interface{}, error) {
+		ret, err := h.stores[hubKey].ListTrash(c.Context())
+		if err != nil {
+			return handler.SpecCodeResponse(err), err
+		}
+		for _, item := range ret {
+			item.Object = store.RedactJSON(hubKey, item.Object)
+		}
+		return ret, nil
+	}
+}
+
+type RestoreInput struct {
+	Key string `auto_read:"key,path" validate:"required"`
+}
+
+// swagger:operation POST /apisix/admin/recycle_bin/{resource}/{key}/restore restoreRecycleBin
+//
+// Recreate the deleted object, the objects it refers to must still exist
+// and its name must still be unique. The script of a route is restored
+// along with it.
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: key
+//   in: path
+//   description: id of the object, the username for consumers
+//   required: true
+//   type: string
+// responses:
+//   '0':
+//     description: the restored object
+//     schema:
+//       type: object
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) restore(hubKey store.HubKey) droplet.Handler {
+	return func(c droplet.Context) (interface{}, error) {
+		input := c.Input().(*RestoreInput)
+		s := h.stores[hubKey]
+
+		obj, err := s.GetTrash(c.Context(), input.Key)
+		if err != nil {
+			return handler.SpecCodeResponse(err), err
+		}
+		var script interface{}
+		if route, ok := obj.(*entity.Route); ok && route.ScriptID != nil {
+			script, err = h.scriptStore.GetTrash(c.Context(), utils.InterfaceToString(route.ScriptID))
+			if err != nil {
+				return handler.SpecCodeResponse(err), err
+			}
+		}
+
+		// the same checks as a batch creating them
+		objs := []interface{}{obj}
+		if script != nil {
+			objs = append(objs, script)
+		}
+		if err := h.planner.Check(c.Context(), objs...); err != nil {
+			return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, err
+		}
+
+		txn := store.NewTxn()
+		if err := txn.Undelete(c.Context(), s, obj); err != nil {
+			return handler.SpecCodeResponse(err), err
+		}
+		if script != nil {
+			if err := txn.Undelete(c.Context(), h.scriptStore, script); err != nil {
+				return handler.SpecCodeResponse(err), err
+			}
+		}
+		if err := txn.Commit(c.Context()); err != nil {
+			return handler.SpecCodeResponse(err), err
+		}
+
+		store.Redact(obj)
+		return obj, nil
+	}
+}
+
+type PurgeInput struct {
+	Keys string `auto_read:"keys,path" validate:"required"`
+}
+
+// swagger:operation DELETE /apisix/admin/recycle_bin/{resource}/{keys} purgeRecycleBin
+//
+// Delete the objects in the recycle bin for good, along with the scripts of
+// the routes.
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: keys
+//   in: path
+//   description: comma separated ids of the objects
+//   required: true
+//   type: string
+// responses:
+//   '0':
+//     description: purge success
+//     schema:
+//       type: object
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) purge(hubKey store.HubKey) droplet.Handler {
+	return func(c droplet.Context) (interface{}, error) {
+		input := c.Input().(*PurgeInput)
+		keys := strings.Split(input.Keys, ",")
+
+		if err := h.stores[hubKey].PurgeTrash(c.Context(), keys); err != nil {
+			return handler.SpecCodeResponse(err), err
+		}
+
+		if hubKey == store.HubKeyRoute {
+			// not every route has a script
+			for _, key := range keys {
+				if _, err := h.scriptStore.GetTrash(c.Context(), key); err != nil {
+					continue
+				}
+				if err := h.scriptStore.PurgeTrash(c.Context(), []string{key}); err != nil {
+					return handler.SpecCodeResponse(err), err
+				}
+			}
+		}
+		return nil, nil
+	}
+}
@@ -0,0 +1,208 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package recycle
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shiningrush/droplet"
+	"github.com/shiningrush/droplet/data"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apisix/manager-api/internal/core/batch"
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/graph"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/utils"
+)
+
+func newTestStore(t *testing.T, stg storage.Interface, name string, typ interface{}, keyFunc func(obj interface{}) string) *store.GenericStore {
+	s, err := store.NewGenericStore(store.GenericStoreOption{
+		BasePath:       "/apisix/" + name,
+		ObjType:        reflect.TypeOf(typ),
+		KeyFunc:        keyFunc,
+		TrashPath:      "/apisix/trash/" + name,
+		TrashRetention: time.Hour,
+	})
+	assert.Nil(t, err)
+	s.Stg = stg
+	assert.Nil(t, s.Init())
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	return s
+}
+
+func TestHandler_Restore(t *testing.T) {
+	stg := storage.NewMemoryStorage()
+	types := map[store.HubKey]interface{}{
+		store.HubKeyRoute:        entity.Route{},
+		store.HubKeyService:      entity.Service{},
+		store.HubKeyUpstream:     entity.Upstream{},
+		store.HubKeyPluginConfig: entity.PluginConfig{},
+		store.HubKeyStreamRoute:  entity.StreamRoute{},
+		store.HubKeyProto:        entity.Proto{},
+		store.HubKeySsl:          entity.SSL{},
+		store.HubKeyConsumer:     entity.Consumer{},
+		store.HubKeyGlobalRule:   entity.GlobalPlugins{},
+		store.HubKeyScript:       entity.Script{},
+	}
+	stores := map[store.HubKey]store.Interface{}
+	for _, hubKey := range graph.Types {
+		stores[hubKey] = newTestStore(t, stg, string(hubKey), types[hubKey], batch.ObjectKey)
+	}
+	routeStore, upstreamStore, scriptStore := stores[store.HubKeyRoute], stores[store.HubKeyUpstream], stores[store.HubKeyScript]
+	protoStore, sslStore := stores[store.HubKeyProto], stores[store.HubKeySsl]
+	h := &Handler{
+		stores:      stores,
+		scriptStore: scriptStore,
+		planner:     &batch.Planner{Stores: stores},
+	}
+
+	exists := func(s store.Interface, key string, exist bool) func() bool {
+		return func() bool {
+			_, err := s.Get(context.TODO(), key)
+			return (err == nil) == exist
+		}
+	}
+	ctx := context.TODO()
+
+	_, err := upstreamStore.Create(ctx, &entity.Upstream{BaseInfo: entity.BaseInfo{ID: "u1"}})
+	assert.Nil(t, err)
+	_, err = routeStore.Create(ctx, &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, URI: "/hello", UpstreamID: "u1", ScriptID: "r1"})
+	assert.Nil(t, err)
+	_, err = scriptStore.Create(ctx, &entity.Script{ID: "r1", Script: "local _M = {} return _M"})
+	assert.Nil(t, err)
+	assert.Eventually(t, exists(upstreamStore, "u1", true), 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, exists(routeStore, "r1", true), 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, exists(scriptStore, "r1", true), 5*time.Second, 10*time.Millisecond)
+
+	txn := store.NewTxn()
+	assert.Nil(t, txn.Delete(ctx, routeStore, "r1"))
+	assert.Nil(t, txn.Delete(ctx, scriptStore, "r1"))
+	assert.Nil(t, txn.Commit(ctx))
+	assert.Nil(t, upstreamStore.BatchDelete(ctx, []string{"u1"}))
+	assert.Eventually(t, exists(routeStore, "r1", false), 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, exists(upstreamStore, "u1", false), 5*time.Second, 10*time.Millisecond)
+
+	restore := func(hubKey store.HubKey, key string) (interface{}, error) {
+		c := droplet.NewContext()
+		c.SetInput(&RestoreInput{Key: key})
+		return h.restore(hubKey)(c)
+	}
+
+	// the upstream of the route is gone
+	ret, err := restore(store.HubKeyRoute, "r1")
+	assert.Equal(t, fmt.Errorf("upstream id: u1 not found"), err)
+	assert.Equal(t, &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, ret)
+
+	_, err = restore(store.HubKeyUpstream, "u1")
+	assert.Nil(t, err)
+	assert.Eventually(t, exists(upstreamStore, "u1", true), 5*time.Second, 10*time.Millisecond)
+
+	// the script is restored along with the route
+	ret, err = restore(store.HubKeyRoute, "r1")
+	assert.Nil(t, err)
+	assert.Equal(t, "/hello", ret.(*entity.Route).URI)
+	assert.Eventually(t, exists(routeStore, "r1", true), 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, exists(scriptStore, "r1", true), 5*time.Second, 10*time.Millisecond)
+
+	c := droplet.NewContext()
+	c.SetInput(&RestoreInput{Key: "r2"})
+	ret, err = h.restore(store.HubKeyRoute)(c)
+	assert.Equal(t, fmt.Errorf("key: /apisix/trash/route/r2 is not found"), err)
+	assert.Equal(t, &data.SpecCodeResponse{StatusCode: http.StatusNotFound}, ret)
+
+	// the proto of the route is purged
+	_, err = protoStore.Create(ctx, &entity.Proto{BaseInfo: entity.BaseInfo{ID: "p1"}, Content: "syntax = \"proto3\";"})
+	assert.Nil(t, err)
+	assert.Eventually(t, exists(protoStore, "p1", true), 5*time.Second, 10*time.Millisecond)
+	_, err = routeStore.Create(ctx, &entity.Route{BaseInfo: entity.BaseInfo{ID: "r3"}, URI: "/grpc", Name: "web",
+		Plugins: map[string]interface{}{"grpc-transcode": map[string]interface{}{"proto_id": "p1"}}})
+	assert.Nil(t, err)
+	assert.Eventually(t, exists(routeStore, "r3", true), 5*time.Second, 10*time.Millisecond)
+	assert.Nil(t, routeStore.BatchDelete(ctx, []string{"r3"}))
+	assert.Nil(t, protoStore.BatchDelete(ctx, []string{"p1"}))
+	assert.Nil(t, protoStore.PurgeTrash(ctx, []string{"p1"}))
+	assert.Eventually(t, exists(protoStore, "p1", false), 5*time.Second, 10*time.Millisecond)
+	ret, err = restore(store.HubKeyRoute, "r3")
+	assert.Equal(t, fmt.Errorf("proto id: p1 not found"), err)
+	assert.Equal(t, &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, ret)
+
+	// the name of the route is taken since
+	_, err = protoStore.Create(ctx, &entity.Proto{BaseInfo: entity.BaseInfo{ID: "p1"}, Content: "syntax = \"proto3\";"})
+	assert.Nil(t, err)
+	_, err = routeStore.Create(ctx, &entity.Route{BaseInfo: entity.BaseInfo{ID: "r4"}, URI: "/web", Name: "web"})
+	assert.Nil(t, err)
+	assert.Eventually(t, exists(protoStore, "p1", true), 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, exists(routeStore, "r4", true), 5*time.Second, 10*time.Millisecond)
+	ret, err = restore(store.HubKeyRoute, "r3")
+	assert.Equal(t, fmt.Errorf("route name exists"), err)
+	assert.Equal(t, &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, ret)
+
+	// the private key of an SSL is hidden
+	_, err = sslStore.Create(ctx, &entity.SSL{BaseInfo: entity.BaseInfo{ID: "s1"}, Cert: "cert", Key: "secret-key"})
+	assert.Nil(t, err)
+	assert.Eventually(t, exists(sslStore, "s1", true), 5*time.Second, 10*time.Millisecond)
+	assert.Nil(t, sslStore.BatchDelete(ctx, []string{"s1"}))
+	assert.Eventually(t, exists(sslStore, "s1", false), 5*time.Second, 10*time.Millisecond)
+	items, err := h.list(store.HubKeySsl)(c)
+	assert.Nil(t, err)
+	assert.Len(t, items, 1)
+	assert.NotContains(t, string(items.([]*store.TrashItem)[0].Object), "secret")
+	ret, err = restore(store.HubKeySsl, "s1")
+	assert.Nil(t, err)
+	assert.Equal(t, "", ret.(*entity.SSL).Key)
+}
+
+func TestHandler_Purge(t *testing.T) {
+	stg := storage.NewMemoryStorage()
+	routeStore := newTestStore(t, stg, "routes", entity.Route{}, func(obj interface{}) string {
+		return utils.InterfaceToString(obj.(*entity.Route).ID)
+	})
+	scriptStore := newTestStore(t, stg, "scripts", entity.Script{}, func(obj interface{}) string {
+		return obj.(*entity.Script).ID
+	})
+	h := &Handler{
+		stores:      map[store.HubKey]store.Interface{store.HubKeyRoute: routeStore},
+		scriptStore: scriptStore,
+	}
+
+	ctx := context.TODO()
+	for _, key := range []string{"/apisix/trash/routes/r1", "/apisix/trash/routes/r2", "/apisix/trash/scripts/r1"} {
+		_, err := stg.Create(ctx, key, `{"key":"r1","delete_time":1,"object":{"id":"r1"}}`)
+		assert.Nil(t, err)
+	}
+
+	c := droplet.NewContext()
+	c.SetInput(&PurgeInput{Keys: "r1,r2"})
+	_, err := h.purge(store.HubKeyRoute)(c)
+	assert.Nil(t, err)
+	ret, _, err := stg.List(ctx, "/apisix/trash/")
+	assert.Nil(t, err)
+	assert.Len(t, ret, 0)
+
+	ret2, err := h.list(store.HubKeyRoute)(c)
+	assert.Nil(t, err)
+	assert.Equal(t, []*store.TrashItem{}, ret2)
+}
@@ -0,0 +1,338 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package revision
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/gin-gonic/gin"
+	"github.com/shiningrush/droplet"
+	"github.com/shiningrush/droplet/data"
+	"github.com/shiningrush/droplet/wrapper"
+	wgin "github.com/shiningrush/droplet/wrapper/gin"
+
+	"github.com/apisix/manager-api/internal/core/batch"
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/store"
+	"github.com/apisix/manager-api/internal/handler"
+	"github.com/apisix/manager-api/internal/utils"
+)
+
+// resources maps the paths of the admin API to the stores keeping history,
+// param is the name of the key in the existing routes of the resource.
+var resources = []struct {
+	path   string
+	param  string
+	hubKey store.HubKey
+}{
+	{"routes", "id", store.HubKeyRoute},
+	{"services", "id", store.HubKeyService},
+	{"upstreams", "id", store.HubKeyUpstream},
+	{"consumers", "username", store.HubKeyConsumer},
+	{"ssl", "id", store.HubKeySsl},
+	{"plugin_configs", "id", store.HubKeyPluginConfig},
+	{"proto", "id", store.HubKeyProto},
+	{"stream_routes", "id", store.HubKeyStreamRoute},
+	{"global_rules", "id", store.HubKeyGlobalRule},
+}
+
+type Handler struct {
+	stores      map[store.HubKey]store.Interface
+	scriptStore store.Interface
+	planner     *batch.Planner
+}
+
+func NewHandler() (handler.RouteRegister, error) {
+	h := &Handler{
+		stores:      map[store.HubKey]store.Interface{},
+		scriptStore: store.GetStore(store.HubKeyScript),
+		planner:     &batch.Planner{},
+	}
+	for _, res := range resources {
+		h.stores[res.hubKey] = store.GetStore(res.hubKey)
+	}
+	return h, nil
+}
+
+func (h *Handler) ApplyRoute(r *gin.Engine) {
+	for _, res := range resources {
+		s := h.stores[res.hubKey]
+		base := fmt.Sprintf("/apisix/admin/%s/:%s/revisions", res.path, res.param)
+		r.GET(base, wgin.Wraps(h.list(s),
+			wrapper.InputType(reflect.TypeOf(ListInput{}))))
+		r.GET(base+"/diff", wgin.Wraps(h.diff(s),
+			wrapper.InputType(reflect.TypeOf(DiffInput{}))))
+		r.POST(base+"/:revision/restore", wgin.Wraps(h.restore(s),
+			wrapper.InputType(reflect.TypeOf(RestoreInput{}))))
+	}
+}
+
+// Key is the key of the object on the path, consumers are keyed by the
+// username.
+type Key struct {
+	ID       string `auto_read:"id,path"`
+	Username string `auto_read:"username,path"`
+}
+
+func (k *Key) key() string {
+	if k.Username != "" {
+		return k.Username
+	}
+	return k.ID
+}
+
+type ListInput struct {
+	Key
+}
+
+// swagger:operation GET /apisix/admin/{resource}/{id}/revisions listRevisions
+//
+// Return the recorded revisions of the object, the latest first.
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: resource
+//   in: path
+//   description: one of routes, services, upstreams, consumers, ssl, plugin_configs, proto, stream_routes and global_rules
+//   required: true
+//   type: string
+// - name: id
+//   in: path
+//   description: id of the object, the username for consumers
+//   required: true
+//   type: string
+// responses:
+//   '0':
+//     description: list response
+//     schema:
+//       type: array
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) list(s store.Interface) droplet.Handler {
+	return func(c droplet.Context) (interface{}, error) {
+		input := c.Input().(*ListInput)
+
+		ret, err := s.History(c.Context(), input.key())
+		if err != nil {
+			return handler.SpecCodeResponse(err), err
+		}
+		for _, r := range ret {
+			r.Object = store.RedactJSON(s.Type(), r.Object)
+		}
+		return ret, nil
+	}
+}
+
+type DiffInput struct {
+	Key
+	From string `auto_read:"from,query" validate:"required"`
+	// To is the latest revision by default
+	To string `auto_read:"to,query"`
+}
+
+type DiffOutput struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+	// Patch is the JSON merge patch turning From into To
+	Patch json.RawMessage `json:"patch"`
+}
+
+// swagger:operation GET /apisix/admin/{resource}/{id}/revisions/diff diffRevisions
+//
+// Return the JSON merge patch (RFC 7396) between two revisions of the object.
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: from
+//   in: query
+//   description: the revision to diff from
+//   required: true
+//   type: integer
+// - name: to
+//   in: query
+//   description: the revision to diff to, the latest by default
+//   required: false
+//   type: integer
+// responses:
+//   '0':
+//     description: diff response
+//     schema:
+//       type: object
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) diff(s store.Interface) droplet.Handler {
+	return func(c droplet.Context) (interface{}, error) {
+		input := c.Input().(*DiffInput)
+
+		revisions, err := s.History(c.Context(), input.key())
+		if err != nil {
+			return handler.SpecCodeResponse(err), err
+		}
+		from, err := findRevision(revisions, input.From)
+		if err != nil {
+			return handler.SpecCodeResponse(err), err
+		}
+		to := revisions[0]
+		if input.To != "" {
+			to, err = findRevision(revisions, input.To)
+			if err != nil {
+				return handler.SpecCodeResponse(err), err
+			}
+		}
+
+		patch, err := jsonpatch.CreateMergePatch(store.RedactJSON(s.Type(), from.Object),
+			store.RedactJSON(s.Type(), to.Object))
+		if err != nil {
+			return &data.SpecCodeResponse{StatusCode: http.StatusInternalServerError}, err
+		}
+		return &DiffOutput{From: from.Revision, To: to.Revision, Patch: patch}, nil
+	}
+}
+
+type RestoreInput struct {
+	Key
+	Revision string `auto_read:"revision,path"`
+	IfMatch  string `auto_read:"If-Match,header"`
+}
+
+// swagger:operation POST /apisix/admin/{resource}/{id}/revisions/{revision}/restore restoreRevision
+//
+// Restore the object to the revision, and recreate it if it has been
+// deleted. The objects it refers to must still exist and its name must
+// still be unique, the same as updating it. The script of a route is
+// restored as its lua code.
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: revision
+//   in: path
+//   description: the revision to restore
+//   required: true
+//   type: integer
+// responses:
+//   '0':
+//     description: the restored object
+//     schema:
+//       type: object
+//   default:
+//     description: unexpected error
+//     schema:
+//       "$ref": "#/definitions/ApiError"
+func (h *Handler) restore(s store.Interface) droplet.Handler {
+	return func(c droplet.Context) (interface{}, error) {
+		input := c.Input().(*RestoreInput)
+
+		revision, err := parseRevision(input.Revision)
+		if err != nil {
+			return handler.SpecCodeResponse(err), err
+		}
+		ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
+		if err != nil {
+			return handler.SpecCodeResponse(err), err
+		}
+
+		obj, err := s.GetRevision(ctx, input.key(), revision)
+		if err != nil {
+			return handler.SpecCodeResponse(err), err
+		}
+		objs := []interface{}{obj}
+		script, err := routeScript(obj)
+		if err != nil {
+			return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, err
+		}
+		if script != nil {
+			objs = append(objs, script)
+		}
+		if err := h.planner.Check(ctx, objs...); err != nil {
+			return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, err
+		}
+
+		// the route and its script are written in one transaction
+		txn := store.NewTxn()
+		if err := txn.Update(ctx, s, obj, true); err != nil {
+			return handler.SpecCodeResponse(err), err
+		}
+		if script != nil {
+			// the script is unconditional, the same as the route API writes it
+			if err := txn.Update(store.WithExpectedVersion(ctx, 0), h.scriptStore, script, true); err != nil {
+				return handler.SpecCodeResponse(err), err
+			}
+		}
+		if err := txn.Commit(ctx); err != nil {
+			return handler.SpecCodeResponse(err), err
+		}
+
+		store.Redact(obj)
+		return obj, nil
+	}
+}
+
+// routeScript returns the script of the route to restore along with it. The
+// route keeps the lua code generated from its script, which is restored as
+// a script of lua code the same as the route API takes one.
+func routeScript(obj interface{}) (*entity.Script, error) {
+	route, ok := obj.(*entity.Route)
+	if !ok || route.Script == nil {
+		return nil, nil
+	}
+	code, ok := route.Script.(string)
+	if !ok {
+		return nil, fmt.Errorf("script of the revision is invalid")
+	}
+	if err := utils.ValidateLuaCode(code); err != nil {
+		return nil, err
+	}
+	id := utils.InterfaceToString(route.ID)
+	route.ScriptID = id
+	return &entity.Script{ID: id, Script: code}, nil
+}
+
+func parseRevision(revision string) (int64, error) {
+	num, err := strconv.ParseInt(revision, 10, 64)
+	if err != nil || num <= 0 {
+		return 0, fmt.Errorf("revision %s is invalid", revision)
+	}
+	return num, nil
+}
+
+func findRevision(revisions []*store.Revision, revision string) (*store.Revision, error) {
+	num, err := parseRevision(revision)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range revisions {
+		if r.Revision == num {
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("revision %s is not found", revision)
+}
@@ -0,0 +1,247 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package revision
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/shiningrush/droplet"
+	"github.com/shiningrush/droplet/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/apisix/manager-api/internal/core/batch"
+	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/graph"
+	"github.com/apisix/manager-api/internal/core/storage"
+	"github.com/apisix/manager-api/internal/core/store"
+)
+
+func TestHandler_Diff(t *testing.T) {
+	revisions := []*store.Revision{
+		{Revision: 5, Action: store.RevisionActionUpdate, Object: json.RawMessage(`{"id":"r1","uri":"/hi","labels":{"env":"prod"}}`)},
+		{Revision: 3, Action: store.RevisionActionUpdate, Object: json.RawMessage(`{"id":"r1","uri":"/hello","name":"r1"}`)},
+		{Revision: 2, Action: store.RevisionActionCreate, Object: json.RawMessage(`{"id":"r1","uri":"/hello"}`)},
+	}
+	tests := []struct {
+		desc      string
+		giveInput *DiffInput
+		wantRet   interface{}
+		wantErr   error
+	}{
+		{
+			desc:      "diff to the latest",
+			giveInput: &DiffInput{Key: Key{ID: "r1"}, From: "3"},
+			wantRet: &DiffOutput{
+				From:  3,
+				To:    5,
+				Patch: json.RawMessage(`{"labels":{"env":"prod"},"name":null,"uri":"/hi"}`),
+			},
+		},
+		{
+			desc:      "diff two revisions",
+			giveInput: &DiffInput{Key: Key{ID: "r1"}, From: "2", To: "3"},
+			wantRet: &DiffOutput{
+				From:  2,
+				To:    3,
+				Patch: json.RawMessage(`{"name":"r1"}`),
+			},
+		},
+		{
+			desc:      "revision not found",
+			giveInput: &DiffInput{Key: Key{ID: "r1"}, From: "4"},
+			wantRet:   &data.SpecCodeResponse{StatusCode: http.StatusNotFound},
+			wantErr:   fmt.Errorf("revision 4 is not found"),
+		},
+		{
+			desc:      "revision invalid",
+			giveInput: &DiffInput{Key: Key{ID: "r1"}, From: "2", To: "x"},
+			wantRet:   &data.SpecCodeResponse{StatusCode: http.StatusBadRequest},
+			wantErr:   fmt.Errorf("revision x is invalid"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			mStore := &store.MockInterface{}
+			mStore.On("History", mock.Anything, "r1").Return(revisions, nil)
+
+			h := &Handler{}
+			ctx := droplet.NewContext()
+			ctx.SetInput(tc.giveInput)
+			ret, err := h.diff(mStore)(ctx)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantRet, ret)
+		})
+	}
+}
+
+func newTestStores(t *testing.T) map[store.HubKey]*store.GenericStore {
+	stg := storage.NewMemoryStorage()
+	stores := map[store.HubKey]*store.GenericStore{}
+	for _, hubKey := range graph.Types {
+		typ := map[store.HubKey]interface{}{
+			store.HubKeyRoute:        entity.Route{},
+			store.HubKeyService:      entity.Service{},
+			store.HubKeyUpstream:     entity.Upstream{},
+			store.HubKeyPluginConfig: entity.PluginConfig{},
+			store.HubKeyStreamRoute:  entity.StreamRoute{},
+			store.HubKeyProto:        entity.Proto{},
+			store.HubKeySsl:          entity.SSL{},
+			store.HubKeyConsumer:     entity.Consumer{},
+			store.HubKeyGlobalRule:   entity.GlobalPlugins{},
+			store.HubKeyScript:       entity.Script{},
+		}[hubKey]
+		opt := store.GenericStoreOption{
+			BasePath: "/apisix/" + string(hubKey),
+			HubKey:   hubKey,
+			ObjType:  reflect.TypeOf(typ),
+			KeyFunc:  batch.ObjectKey,
+		}
+		if hubKey != store.HubKeyScript {
+			opt.HistoryPath = "/apisix_dashboard/revisions/" + string(hubKey)
+			opt.HistoryLimit = 10
+		}
+		s, err := store.NewGenericStore(opt)
+		assert.Nil(t, err)
+		s.Stg = stg
+		assert.Nil(t, s.Init())
+		t.Cleanup(func() {
+			_ = s.Close()
+		})
+		stores[hubKey] = s
+	}
+	return stores
+}
+
+func write(t *testing.T, s store.Interface, obj interface{}) int64 {
+	ret, err := s.Update(context.TODO(), obj, true)
+	assert.Nil(t, err)
+	version := ret.(entity.Versioned).GetResourceVersion()
+	assert.Eventually(t, func() bool {
+		obj, err := s.Get(context.TODO(), batch.ObjectKey(ret))
+		return err == nil && obj.(entity.Versioned).GetResourceVersion() == version
+	}, 5*time.Second, 10*time.Millisecond)
+	return version
+}
+
+func TestHandler_List(t *testing.T) {
+	stores := newTestStores(t)
+	s := stores[store.HubKeySsl]
+	write(t, s, &entity.SSL{BaseInfo: entity.BaseInfo{ID: "s1"}, Cert: "cert", Key: "key", Keys: []string{"key2"}})
+	write(t, s, &entity.SSL{BaseInfo: entity.BaseInfo{ID: "s1"}, Cert: "cert2", Key: "key3"})
+
+	h := &Handler{}
+	ctx := droplet.NewContext()
+	ctx.SetInput(&ListInput{Key: Key{ID: "s1"}})
+	ret, err := h.list(s)(ctx)
+	assert.Nil(t, err)
+	revisions := ret.([]*store.Revision)
+	assert.Len(t, revisions, 2)
+	for _, r := range revisions {
+		assert.NotContains(t, string(r.Object), "key")
+	}
+
+	ctx.SetInput(&DiffInput{Key: Key{ID: "s1"}, From: strconv.FormatInt(revisions[1].Revision, 10)})
+	ret, err = h.diff(s)(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"cert":"cert2"}`, string(ret.(*DiffOutput).Patch))
+}
+
+func TestHandler_Restore(t *testing.T) {
+	stores := newTestStores(t)
+	routes := stores[store.HubKeyRoute]
+	h := &Handler{scriptStore: stores[store.HubKeyScript], planner: &batch.Planner{Stores: map[store.HubKey]store.Interface{}}}
+	for key, s := range stores {
+		h.planner.Stores[key] = s
+	}
+
+	write(t, stores[store.HubKeyUpstream], &entity.Upstream{BaseInfo: entity.BaseInfo{ID: "u1"}})
+	withUpstream := write(t, routes, &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, URI: "/a", UpstreamID: "u1"})
+	named := write(t, routes, &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, URI: "/b", Name: "web"})
+	withScript := write(t, routes, &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, URI: "/c", Script: "local _M = {} return _M", ScriptID: "r1"})
+	write(t, routes, &entity.Route{BaseInfo: entity.BaseInfo{ID: "r1"}, URI: "/d"})
+	write(t, routes, &entity.Route{BaseInfo: entity.BaseInfo{ID: "r2"}, URI: "/e", Name: "web"})
+	assert.Nil(t, stores[store.HubKeyUpstream].BatchDelete(context.TODO(), []string{"u1"}))
+	assert.Eventually(t, func() bool {
+		_, err := stores[store.HubKeyUpstream].Get(context.TODO(), "u1")
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	restore := func(revision string) (interface{}, error) {
+		ctx := droplet.NewContext()
+		ctx.SetInput(&RestoreInput{Key: Key{ID: "r1"}, Revision: revision})
+		return h.restore(routes)(ctx)
+	}
+	tests := []struct {
+		desc     string
+		revision string
+		wantRet  interface{}
+		wantErr  error
+	}{
+		{
+			desc:     "revision invalid",
+			revision: "0",
+			wantRet:  &data.SpecCodeResponse{StatusCode: http.StatusBadRequest},
+			wantErr:  fmt.Errorf("revision 0 is invalid"),
+		},
+		{
+			desc:     "revision not found",
+			revision: "1000",
+			wantRet:  &data.SpecCodeResponse{StatusCode: http.StatusNotFound},
+			wantErr:  fmt.Errorf("revision 1000 is not found"),
+		},
+		{
+			desc:     "upstream deleted since",
+			revision: strconv.FormatInt(withUpstream, 10),
+			wantRet:  &data.SpecCodeResponse{StatusCode: http.StatusBadRequest},
+			wantErr:  fmt.Errorf("upstream id: u1 not found"),
+		},
+		{
+			desc:     "name taken since",
+			revision: strconv.FormatInt(named, 10),
+			wantRet:  &data.SpecCodeResponse{StatusCode: http.StatusBadRequest},
+			wantErr:  fmt.Errorf("route name exists"),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			ret, err := restore(tc.revision)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantRet, ret)
+		})
+	}
+
+	// the script is restored along with the route
+	ret, err := restore(strconv.FormatInt(withScript, 10))
+	assert.Nil(t, err)
+	assert.Equal(t, "/c", ret.(*entity.Route).URI)
+	assert.Eventually(t, func() bool {
+		obj, err := routes.Get(context.TODO(), "r1")
+		return err == nil && obj.(*entity.Route).URI == "/c"
+	}, 5*time.Second, 10*time.Millisecond)
+	script, err := stores[store.HubKeyScript].Get(context.TODO(), "r1")
+	assert.Nil(t, err)
+	assert.Equal(t, "local _M = {} return _M", script.(*entity.Script).Script)
+}
@@ -34,6 +34,7 @@ import (
 	lua "github.com/yuin/gopher-lua"
 
 	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/core/deletion"
 	"github.com/apisix/manager-api/internal/core/entity"
 	"github.com/apisix/manager-api/internal/core/store"
 	"github.com/apisix/manager-api/internal/handler"
@@ -85,6 +86,7 @@ func (h *Handler) ApplyRoute(r *gin.Engine) {
 type PatchInput struct {
 	ID      string `auto_read:"id,path"`
 	SubPath string `auto_read:"path,path"`
+	IfMatch string `auto_read:"If-Match,header"`
 	Body    []byte `auto_read:"@body"`
 }
 
@@ -110,7 +112,12 @@ func (h *Handler) Patch(c droplet.Context) (interface{}, error) {
 		return handler.SpecCodeResponse(err), err
 	}
 
-	ret, err := h.routeStore.Update(c.Context(), &route, false)
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, stored.(*entity.Route).ResourceVersion)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	ret, err := h.routeStore.Update(ctx, &route, false)
 	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
@@ -140,6 +147,36 @@ type GetInput struct {
 //   description: page size
 //   required: false
 //   type: integer
+// - name: cursor
+//   in: query
+//   description: next_cursor of the previous page, the page number is ignored when it's set
+//   required: false
+//   type: string
+// - name: with_total
+//   in: query
+//   description: count total_size of a cursor page, which stops at the end of the page otherwise
+//   required: false
+//   type: boolean
+// - name: sort_by
+//   in: query
+//   description: top level field to sort by, create_time by default
+//   required: false
+//   type: string
+// - name: order
+//   in: query
+//   description: asc or desc
+//   required: false
+//   type: string
+// - name: fields
+//   in: query
+//   description: comma separated top level fields to return
+//   required: false
+//   type: string
+// - name: label_selector
+//   in: query
+//   description: label selector like "env in (prod,staging),!deprecated,team!=core"
+//   required: false
+//   type: string
 // - name: name
 //   in: query
 //   description: name of route
@@ -198,6 +235,7 @@ type ListInput struct {
 	ID string `auto_read:"id,query"`
 	Desc string `auto_read:"desc,query"`
 	store.Pagination
+	store.ListQuery
 }
 
 func uriContains(obj *entity.Route, uri string) bool {
@@ -255,31 +293,27 @@ func (h *Handler) List(c droplet.Context) (interface{}, error) {
 
 			return true
 		},
+		// format the rows before they are projected
 		Format: func(obj interface{}) interface{} {
 			route := obj.(*entity.Route)
 			if route.Upstream != nil && route.Upstream.Nodes != nil {
 				route.Upstream.Nodes = entity.NodesFormat(route.Upstream.Nodes)
 			}
+			script, _ := h.scriptStore.Get(c.Context(), utils.InterfaceToString(route.ID))
+			if script != nil {
+				route.Script = script.(*entity.Script).Script
+			}
 			return route
 		},
 		PageSize:   input.PageSize,
 		PageNumber: input.PageNumber,
+		Cursor:     input.Cursor,
+		WithTotal:  input.WithTotal,
+		Query:      input.ListQuery,
 	})
 
 	if err != nil {
-		return nil, err
-	}
-
-	//format respond
-	var route *entity.Route
-	for i, item := range ret.Rows {
-		route = item.(*entity.Route)
-		id := utils.InterfaceToString(route.ID)
-		script, _ := h.scriptStore.Get(c.Context(), id)
-		if script != nil {
-			route.Script = script.(*entity.Script).Script
-		}
-		ret.Rows[i] = route
+		return handler.SpecCodeResponse(err), err
 	}
 
 	return ret, nil
@@ -354,6 +388,8 @@ func (h *Handler) Create(c droplet.Context) (interface{}, error) {
 			fmt.Errorf("script_id must be the same as id")
 	}
 
+	// the route and its script are written in one transaction
+	txn := store.NewTxn()
 	if input.Script != nil {
 		if utils.InterfaceToString(input.ID) == "" {
 			input.ID = utils.GetFlakeUidStr()
@@ -381,8 +417,8 @@ func (h *Handler) Create(c droplet.Context) (interface{}, error) {
 		}
 
 		//save original conf
-		if _, err = h.scriptStore.Create(c.Context(), script); err != nil {
-			return nil, err
+		if err = txn.Create(c.Context(), h.scriptStore, script); err != nil {
+			return handler.SpecCodeResponse(err), err
 		}
 
 		// After saving the Script entity, always set route's script_id
@@ -403,16 +439,19 @@ func (h *Handler) Create(c droplet.Context) (interface{}, error) {
 	}
 
 	// create
-	res, err := h.routeStore.Create(c.Context(), input)
-	if err != nil {
+	if err := txn.Create(c.Context(), h.routeStore, input); err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+	if err := txn.Commit(c.Context()); err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
 
-	return res, nil
+	return input, nil
 }
 
 type UpdateInput struct {
-	ID string `auto_read:"id,path"`
+	ID      string `auto_read:"id,path"`
+	IfMatch string `auto_read:"If-Match,header" json:"-"`
 	entity.Route
 }
 
@@ -429,6 +468,11 @@ func (h *Handler) Update(c droplet.Context) (interface{}, error) {
 		input.Route.ID = input.ID
 	}
 
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
 	//check depend
 	if input.ServiceID != nil {
 		serviceID := utils.InterfaceToString(input.ServiceID)
@@ -459,6 +503,8 @@ func (h *Handler) Update(c droplet.Context) (interface{}, error) {
 			fmt.Errorf("script_id must be the same as id")
 	}
 
+	// the route and its script are written in one transaction
+	txn := store.NewTxn()
 	if input.Script != nil {
 		script := &entity.Script{}
 		script.ID = input.ID
@@ -483,15 +529,8 @@ func (h *Handler) Update(c droplet.Context) (interface{}, error) {
 		}
 
 		//save original conf
-		if _, err = h.scriptStore.Update(c.Context(), script, true); err != nil {
-			//if not exists, create
-			if err.Error() == fmt.Sprintf("key: %s is not found", script.ID) {
-				if _, err := h.scriptStore.Create(c.Context(), script); err != nil {
-					return handler.SpecCodeResponse(err), err
-				}
-			} else {
-				return handler.SpecCodeResponse(err), err
-			}
+		if err = txn.Update(c.Context(), h.scriptStore, script, true); err != nil {
+			return handler.SpecCodeResponse(err), err
 		}
 
 		// After updating the Script entity, always set route's script_id
@@ -507,8 +546,8 @@ func (h *Handler) Update(c droplet.Context) (interface{}, error) {
 		id := utils.InterfaceToString(input.Route.ID)
 		script, _ := h.scriptStore.Get(c.Context(), id)
 		if script != nil {
-			if err := h.scriptStore.BatchDelete(c.Context(), strings.Split(id, ",")); err != nil {
-				log.Warnf("delete script %s failed", input.Route.ID)
+			if err := txn.Delete(c.Context(), h.scriptStore, id); err != nil {
+				return handler.SpecCodeResponse(err), err
 			}
 		}
 	}
@@ -520,33 +559,44 @@ func (h *Handler) Update(c droplet.Context) (interface{}, error) {
 	}
 
 	// create
-	res, err := h.routeStore.Update(c.Context(), &input.Route, true)
-	if err != nil {
+	if err := txn.Update(ctx, h.routeStore, &input.Route, true); err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+	if err := txn.Commit(c.Context()); err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
 
-	return res, nil
+	return &input.Route, nil
 }
 
 type BatchDelete struct {
-	IDs string `auto_read:"ids,path"`
+	IDs     string `auto_read:"ids,path"`
+	IfMatch string `auto_read:"If-Match,header"`
+	Mode    string `auto_read:"mode,query"`
 }
 
 func (h *Handler) BatchDelete(c droplet.Context) (interface{}, error) {
 	input := c.Input().(*BatchDelete)
 
-	//delete route
-	if err := h.routeStore.BatchDelete(c.Context(), strings.Split(input.IDs, ",")); err != nil {
+	mode, err := deletion.ParseMode(input.Mode)
+	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
 
-	//delete stored script
-	if err := h.scriptStore.BatchDelete(c.Context(), strings.Split(input.IDs, ",")); err != nil {
-		//try again
-		log.Warn("try to delete script %s again", input.IDs)
-		if err := h.scriptStore.BatchDelete(c.Context(), strings.Split(input.IDs, ",")); err != nil {
-			return nil, nil
-		}
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	txn, err := deletion.Plan(ctx, mode, map[store.HubKey]store.Interface{
+		store.HubKeyRoute:  h.routeStore,
+		store.HubKeyScript: h.scriptStore,
+	}, store.HubKeyRoute, strings.Split(input.IDs, ","))
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+	if err := txn.CommitParts(ctx); err != nil {
+		return handler.SpecCodeResponse(err), err
 	}
 
 	return nil, nil
@@ -46,10 +46,12 @@ type testCase struct {
 	serviceInput string
 	scriptRet    interface{}
 	scriptErr    error
+	scriptRefs   []interface{}
 	serviceRet   interface{}
 	serviceErr   error
 	upstreamRet  interface{}
 	upstreamErr  error
+	commitErr    error
 	nameExistRet []interface{}
 }
 
@@ -958,6 +960,36 @@ func TestRoute_Create(t *testing.T) {
 			wantErr: nil,
 			called:  true,
 		},
+		{
+			caseDesc: "create route failed, commit error",
+			giveInput: &entity.Route{
+				BaseInfo: entity.BaseInfo{
+					ID:         "s1",
+					CreateTime: 1609746531,
+				},
+				Name:       "s1",
+				UpstreamID: "u1",
+				ServiceID:  "s1",
+				Script:     "",
+			},
+			mockInput: &entity.Route{
+				BaseInfo: entity.BaseInfo{
+					ID:         "s1",
+					CreateTime: 1609746531,
+				},
+				Name:       "s1",
+				UpstreamID: "u1",
+				ServiceID:  "s1",
+				ScriptID:   "s1",
+				Script:     "",
+			},
+			serviceRet:  "service",
+			upstreamRet: "upstream",
+			commitErr:   fmt.Errorf("key: /apisix/scripts/s1 is conflicted"),
+			wantRet:     &data.SpecCodeResponse{StatusCode: http.StatusBadRequest},
+			wantErr:     fmt.Errorf("key: /apisix/scripts/s1 is conflicted"),
+			called:      true,
+		},
 	}
 
 	for _, tc := range tests {
@@ -965,19 +997,14 @@ func TestRoute_Create(t *testing.T) {
 			getCalled := false
 
 			mStore := &store.MockInterface{}
-			mStore.On("Create", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			mStore.On("PrepareCreate", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
 				getCalled = true
 				route := args.Get(1).(*entity.Route)
 				assert.Equal(t, tc.mockInput, route)
-			}).Return(tc.mockRet, tc.mockErr)
+			}).Return(&store.Op{}, tc.mockErr)
+			mStore.On("Commit", mock.Anything, mock.Anything).Return(tc.commitErr)
 
-			mStore.On("List", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
-			}).Return(func(input store.ListInput) *store.ListOutput {
-				return &store.ListOutput{
-					Rows:      tc.nameExistRet,
-					TotalSize: len(tc.nameExistRet),
-				}
-			}, nil)
+			mStore.On("IndexLookup", mock.Anything, store.IndexName, mock.Anything).Return(tc.nameExistRet, nil)
 
 			svcStore := &store.MockInterface{}
 			svcStore.On("Get", mock.Anything, mock.Anything).Return(tc.serviceRet, tc.serviceErr)
@@ -986,7 +1013,8 @@ func TestRoute_Create(t *testing.T) {
 			uStore.On("Get", mock.Anything, mock.Anything).Return(tc.upstreamRet, tc.upstreamErr)
 
 			scriptStore := &store.MockInterface{}
-			scriptStore.On("Create", mock.Anything, mock.Anything).Return(tc.scriptRet, tc.serviceErr)
+			scriptStore.On("PrepareCreate", mock.Anything, mock.Anything).Return(&store.Op{}, tc.serviceErr)
+			scriptStore.On("Commit", mock.Anything, mock.Anything).Return(tc.commitErr)
 
 			h := Handler{routeStore: mStore, svcStore: svcStore, upstreamStore: uStore, scriptStore: scriptStore}
 
@@ -1214,21 +1242,16 @@ func TestRoute_Update(t *testing.T) {
 			getCalled := false
 			routeStore := &store.MockInterface{}
 
-			routeStore.On("Update", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			routeStore.On("PrepareUpdate", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
 				getCalled = true
 				input := args.Get(1).(*entity.Route)
 				createIfNotExist := args.Get(2).(bool)
 				assert.Equal(t, tc.mockInput, input)
 				assert.True(t, createIfNotExist)
-			}).Return(tc.mockRet, tc.mockErr)
+			}).Return(&store.Op{}, tc.mockErr)
+			routeStore.On("Commit", mock.Anything, mock.Anything).Return(tc.commitErr)
 
-			routeStore.On("List", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
-			}).Return(func(input store.ListInput) *store.ListOutput {
-				return &store.ListOutput{
-					Rows:      tc.nameExistRet,
-					TotalSize: len(tc.nameExistRet),
-				}
-			}, nil)
+			routeStore.On("IndexLookup", mock.Anything, store.IndexName, mock.Anything).Return(tc.nameExistRet, nil)
 
 			serviceStore := &store.MockInterface{}
 			serviceStore.On("Get", mock.Anything, mock.Anything).Return(tc.serviceRet, tc.serviceErr)
@@ -1238,7 +1261,9 @@ func TestRoute_Update(t *testing.T) {
 
 			scriptStore := &store.MockInterface{}
 			scriptStore.On("Get", mock.Anything, mock.Anything).Return(tc.scriptRet, tc.scriptErr)
-			scriptStore.On("Update", mock.Anything, mock.Anything, mock.Anything).Return(luaScript, nil)
+			scriptStore.On("PrepareUpdate", mock.Anything, mock.Anything, mock.Anything).Return(&store.Op{}, nil)
+			scriptStore.On("PrepareDelete", mock.Anything, mock.Anything).Return(&store.Op{}, nil)
+			scriptStore.On("Commit", mock.Anything, mock.Anything).Return(tc.commitErr)
 
 			h := Handler{svcStore: serviceStore, upstreamStore: upstreamStore, scriptStore: scriptStore,
 				routeStore: routeStore}
@@ -1519,14 +1544,38 @@ func TestRoute_Delete(t *testing.T) {
 			called:    true,
 		},
 		{
-			caseDesc: "delete failed, script delete error",
+			caseDesc: "delete with script success",
 			giveInput: &BatchDelete{
 				IDs: "r1",
 			},
 			mockInput: []string{"r1"},
-			scriptErr: fmt.Errorf("delete error"),
-			wantRet:   nil,
-			wantErr:   nil,
+			scriptRet: &entity.Script{ID: "r1"},
+			called:    true,
+		},
+		{
+			caseDesc: "delete failed, script is shared",
+			giveInput: &BatchDelete{
+				IDs: "r1",
+			},
+			mockInput: []string{"r1"},
+			scriptRet: &entity.Script{ID: "r1"},
+			scriptRefs: []interface{}{
+				&entity.Route{BaseInfo: entity.BaseInfo{ID: "r2"}, ScriptID: "r1"},
+			},
+			wantRet: &data.SpecCodeResponse{StatusCode: http.StatusBadRequest},
+			wantErr: fmt.Errorf("script/r1 is referenced by route/r2 (script_id)"),
+			called:  true,
+		},
+		{
+			caseDesc: "delete failed, commit error",
+			giveInput: &BatchDelete{
+				IDs: "r1",
+			},
+			mockInput: []string{"r1"},
+			scriptRet: &entity.Script{ID: "r1"},
+			commitErr: fmt.Errorf("etcd txn failed"),
+			wantRet:   handler.SpecCodeResponse(fmt.Errorf("etcd txn failed")),
+			wantErr:   fmt.Errorf("etcd txn failed"),
 			called:    true,
 		},
 	}
@@ -1534,21 +1583,40 @@ func TestRoute_Delete(t *testing.T) {
 	for _, tc := range tests {
 		t.Run(tc.caseDesc, func(t *testing.T) {
 			getCalled := false
+			var deleted, scriptDeleted []string
 			routeStore := &store.MockInterface{}
-			routeStore.On("BatchDelete", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			routeStore.On("PrepareDelete", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
 				getCalled = true
-				input := args.Get(1).([]string)
-				assert.Equal(t, tc.mockInput, input)
-			}).Return(tc.mockErr)
+				deleted = append(deleted, args.Get(1).(string))
+			}).Return(&store.Op{}, tc.mockErr)
+			routeStore.On("Commit", mock.Anything, mock.Anything).Return(tc.commitErr)
+			routeStore.On("List", mock.Anything).Return(func(input store.ListInput) *store.ListOutput {
+				output := store.NewListOutput()
+				for _, obj := range tc.scriptRefs {
+					if input.Predicate(obj) {
+						output.Rows = append(output.Rows, obj)
+					}
+				}
+				return output
+			}, nil)
 
 			scriptStore := &store.MockInterface{}
-			scriptStore.On("BatchDelete", mock.Anything, mock.Anything).Return(tc.serviceRet, tc.scriptErr)
+			scriptStore.On("Get", mock.Anything).Return(tc.scriptRet, tc.scriptErr)
+			scriptStore.On("PrepareDelete", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				scriptDeleted = append(scriptDeleted, args.Get(1).(string))
+			}).Return(&store.Op{}, nil)
 
 			h := Handler{routeStore: routeStore, scriptStore: scriptStore}
 			ctx := droplet.NewContext()
 			ctx.SetInput(tc.giveInput)
 			ret, err := h.BatchDelete(ctx)
 			assert.True(t, getCalled)
+			if tc.mockErr == nil {
+				assert.Equal(t, tc.mockInput, deleted)
+			}
+			if tc.scriptRet != nil {
+				assert.Equal(t, tc.mockInput, scriptDeleted)
+			}
 			assert.Equal(t, tc.wantRet, ret)
 			if tc.wantErr != nil && err != nil {
 				assert.Error(t, tc.wantErr.(error), err.Error())
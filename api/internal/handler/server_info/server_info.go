@@ -79,10 +79,12 @@ func (h *Handler) List(c droplet.Context) (interface{}, error) {
 		},
 		PageSize:   input.PageSize,
 		PageNumber: input.PageNumber,
+		Cursor:     input.Cursor,
+		WithTotal:  input.WithTotal,
 	})
 
 	if err != nil {
-		return nil, err
+		return handler.SpecCodeResponse(err), err
 	}
 
 	return ret, nil
@@ -18,6 +18,7 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -29,8 +30,10 @@ import (
 	"github.com/stretchr/testify/mock"
 
 	"github.com/apisix/manager-api/internal/core/entity"
+	"github.com/apisix/manager-api/internal/core/storage"
 	"github.com/apisix/manager-api/internal/core/store"
 	"github.com/apisix/manager-api/internal/handler"
+	"github.com/apisix/manager-api/internal/utils"
 )
 
 func TestService_Get(t *testing.T) {
@@ -398,13 +401,7 @@ func TestService_Create(t *testing.T) {
 				assert.Equal(t, tc.upstreamInput, id)
 			}).Return(tc.upstreamRet, tc.upstreamErr)
 
-			serviceStore.On("List", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
-			}).Return(func(input store.ListInput) *store.ListOutput {
-				return &store.ListOutput{
-					Rows:      tc.nameExistRet,
-					TotalSize: len(tc.nameExistRet),
-				}
-			}, nil)
+			serviceStore.On("IndexLookup", mock.Anything, store.IndexName, mock.Anything).Return(tc.nameExistRet, nil)
 
 			h := Handler{serviceStore: serviceStore, upstreamStore: upstreamStore}
 			ctx := droplet.NewContext()
@@ -541,6 +538,36 @@ func TestService_Update(t *testing.T) {
 			wantErr: fmt.Errorf("update failed"),
 			wantRet: handler.SpecCodeResponse(fmt.Errorf("update failed")),
 		},
+		{
+			caseDesc:  "update failed, resource version mismatch",
+			getCalled: true,
+			giveInput: &UpdateInput{
+				ID:      "s1",
+				IfMatch: `"3"`,
+				Service: entity.Service{
+					Name: "s1",
+				},
+			},
+			giveErr: fmt.Errorf("key: s1: %w", storage.ErrRevisionMismatch),
+			wantInput: &entity.Service{
+				BaseInfo: entity.BaseInfo{ID: "s1"},
+				Name:     "s1",
+			},
+			wantErr: fmt.Errorf("key: s1: %w", storage.ErrRevisionMismatch),
+			wantRet: &data.SpecCodeResponse{StatusCode: http.StatusConflict},
+		},
+		{
+			caseDesc: "update failed, invalid If-Match",
+			giveInput: &UpdateInput{
+				ID:      "s1",
+				IfMatch: `"abc"`,
+				Service: entity.Service{
+					Name: "s1",
+				},
+			},
+			wantErr: fmt.Errorf(`If-Match header "abc" is invalid`),
+			wantRet: &data.SpecCodeResponse{StatusCode: http.StatusBadRequest},
+		},
 	}
 
 	for _, tc := range tests {
@@ -561,16 +588,11 @@ func TestService_Update(t *testing.T) {
 				assert.Equal(t, tc.upstreamInput, id)
 			}).Return(tc.upstreamRet, tc.upstreamErr)
 
-			serviceStore.On("List", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
-			}).Return(func(input store.ListInput) *store.ListOutput {
-				return &store.ListOutput{
-					Rows:      tc.nameExistRet,
-					TotalSize: len(tc.nameExistRet),
-				}
-			}, nil)
+			serviceStore.On("IndexLookup", mock.Anything, store.IndexName, mock.Anything).Return(tc.nameExistRet, nil)
 
 			h := Handler{serviceStore: serviceStore, upstreamStore: upstreamStore}
 			ctx := droplet.NewContext()
+			ctx.SetContext(context.TODO())
 			ctx.SetInput(tc.giveInput)
 			ret, err := h.Update(ctx)
 			assert.Equal(t, tc.getCalled, getCalled)
@@ -883,7 +905,7 @@ func TestServices_Delete(t *testing.T) {
 			routeMockErr: nil,
 			getCalled:    false,
 			wantRet:      &data.SpecCodeResponse{StatusCode: 400},
-			wantErr:      errors.New("route: route1 is using this service"),
+			wantErr:      errors.New("service/s1 is referenced by route/r1 (service_id)"),
 		},
 		{
 			caseDesc: "delete failed, route list error",
@@ -902,40 +924,40 @@ func TestServices_Delete(t *testing.T) {
 	for _, tc := range tests {
 		t.Run(tc.caseDesc, func(t *testing.T) {
 			getCalled := false
+			var deleted []string
 			serviceStore := &store.MockInterface{}
-			serviceStore.On("BatchDelete", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			serviceStore.On("PrepareDelete", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				deleted = append(deleted, args.Get(1).(string))
+			}).Return(&store.Op{}, nil)
+			serviceStore.On("Commit", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
 				getCalled = true
-				input := args.Get(1).([]string)
-				assert.Equal(t, tc.wantInput, input)
 			}).Return(tc.giveErr)
 
 			routeStore := &store.MockInterface{}
-			routeStore.On("List", mock.Anything).Return(func(input store.ListInput) *store.ListOutput {
-				var returnData []interface{}
-				for _, c := range tc.routeMockData {
-					if input.Predicate(c) {
-						if input.Format == nil {
-							returnData = append(returnData, c)
-							continue
-						}
-
-						returnData = append(returnData, input.Format(c))
-					}
-				}
-
-				return &store.ListOutput{
-					Rows:      returnData,
-					TotalSize: len(returnData),
-				}
-			}, tc.routeMockErr)
+			routesUsing := map[string][]interface{}{}
+			for _, c := range tc.routeMockData {
+				id := utils.InterfaceToString(c.ServiceID)
+				routesUsing[id] = append(routesUsing[id], c)
+			}
+			for id, rows := range routesUsing {
+				routeStore.On("IndexLookup", mock.Anything, store.IndexServiceID, id).Return(rows, tc.routeMockErr)
+			}
+			routeStore.On("IndexLookup", mock.Anything, store.IndexServiceID, mock.Anything).Return(nil, tc.routeMockErr)
 
 			h := Handler{serviceStore: serviceStore, routeStore: routeStore}
 			ctx := droplet.NewContext()
 			ctx.SetInput(tc.giveInput)
 			ret, err := h.BatchDelete(ctx)
 			assert.Equal(t, tc.getCalled, getCalled)
+			if tc.getCalled {
+				assert.Equal(t, tc.wantInput, deleted)
+			}
 			assert.Equal(t, tc.wantRet, ret)
-			assert.Equal(t, tc.wantErr, err)
+			if tc.wantErr != nil {
+				assert.EqualError(t, err, tc.wantErr.Error())
+			} else {
+				assert.Nil(t, err)
+			}
 		})
 	}
 }
@@ -33,6 +33,7 @@ import (
 	wgin "github.com/shiningrush/droplet/wrapper/gin"
 
 	"github.com/apisix/manager-api/internal/conf"
+	"github.com/apisix/manager-api/internal/core/deletion"
 	"github.com/apisix/manager-api/internal/core/entity"
 	"github.com/apisix/manager-api/internal/core/store"
 	"github.com/apisix/manager-api/internal/handler"
@@ -131,6 +132,7 @@ func (h *Handler) Get(c droplet.Context) (interface{}, error) {
 type ListInput struct {
 	SNI string `auto_read:"sni,query"`
 	store.Pagination
+	store.ListQuery
 }
 
 // swagger:operation GET /apisix/admin/ssl getSSLList
@@ -151,6 +153,36 @@ type ListInput struct {
 //   description: page size
 //   required: false
 //   type: integer
+// - name: cursor
+//   in: query
+//   description: next_cursor of the previous page, the page number is ignored when it's set
+//   required: false
+//   type: string
+// - name: with_total
+//   in: query
+//   description: count total_size of a cursor page, which stops at the end of the page otherwise
+//   required: false
+//   type: boolean
+// - name: sort_by
+//   in: query
+//   description: top level field to sort by, create_time by default
+//   required: false
+//   type: string
+// - name: order
+//   in: query
+//   description: asc or desc
+//   required: false
+//   type: string
+// - name: fields
+//   in: query
+//   description: comma separated top level fields to return
+//   required: false
+//   type: string
+// - name: label_selector
+//   in: query
+//   description: label selector like "env in (prod,staging),!deprecated,team!=core"
+//   required: false
+//   type: string
 // - name: sni
 //   in: query
 //   description: sni of SSL
@@ -186,31 +218,29 @@ func (h *Handler) List(c droplet.Context) (interface{}, error) {
 			}
 			return true
 		},
+		// format the rows before they are projected, so the keys are never
+		// returned
+		Format: func(obj interface{}) interface{} {
+			ssl := &entity.SSL{}
+			_ = utils.ObjectClone(obj, ssl)
+			x509_validity, _ := x509CertValidity(ssl.Cert)
+			if x509_validity != nil {
+				ssl.ValidityStart = x509_validity.NotBefore
+				ssl.ValidityEnd = x509_validity.NotAfter
+			}
+			ssl.Key = ""
+			ssl.Keys = nil
+			return ssl
+		},
 		PageSize:   input.PageSize,
 		PageNumber: input.PageNumber,
+		Cursor:     input.Cursor,
+		WithTotal:  input.WithTotal,
+		Query:      input.ListQuery,
 	})
 	if err != nil {
-		return nil, err
-	}
-
-	//format respond
-	var list []interface{}
-	for _, item := range ret.Rows {
-		ssl := &entity.SSL{}
-		_ = utils.ObjectClone(item, ssl)
-		x509_validity, _ := x509CertValidity(ssl.Cert)
-		if x509_validity != nil {
-			ssl.ValidityStart = x509_validity.NotBefore
-			ssl.ValidityEnd = x509_validity.NotAfter
-		}
-		ssl.Key = ""
-		ssl.Keys = nil
-		list = append(list, ssl)
-	}
-	if list == nil {
-		list = []interface{}{}
+		return handler.SpecCodeResponse(err), err
 	}
-	ret.Rows = list
 
 	return ret, nil
 }
@@ -239,7 +269,8 @@ func (h *Handler) Create(c droplet.Context) (interface{}, error) {
 }
 
 type UpdateInput struct {
-	ID string `auto_read:"id,path"`
+	ID      string `auto_read:"id,path"`
+	IfMatch string `auto_read:"If-Match,header" json:"-"`
 	entity.SSL
 }
 
@@ -266,7 +297,12 @@ func (h *Handler) Update(c droplet.Context) (interface{}, error) {
 
 	//set default value for SSL status, if not set, it will be 0 which means disable.
 	ssl.Status = conf.SSLDefaultStatus
-	ret, err := h.sslStore.Update(c.Context(), ssl, true)
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	ret, err := h.sslStore.Update(ctx, ssl, true)
 	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
@@ -281,6 +317,7 @@ func (h *Handler) Update(c droplet.Context) (interface{}, error) {
 type PatchInput struct {
 	ID      string `auto_read:"id,path"`
 	SubPath string `auto_read:"path,path"`
+	IfMatch string `auto_read:"If-Match,header"`
 	Body    []byte `auto_read:"@body"`
 }
 
@@ -306,7 +343,12 @@ func (h *Handler) Patch(c droplet.Context) (interface{}, error) {
 		return handler.SpecCodeResponse(err), err
 	}
 
-	ret, err := h.sslStore.Update(c.Context(), &ssl, false)
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, stored.(*entity.SSL).ResourceVersion)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	ret, err := h.sslStore.Update(ctx, &ssl, false)
 	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
@@ -319,13 +361,31 @@ func (h *Handler) Patch(c droplet.Context) (interface{}, error) {
 }
 
 type BatchDelete struct {
-	Ids string `auto_read:"ids,path"`
+	Ids     string `auto_read:"ids,path"`
+	IfMatch string `auto_read:"If-Match,header"`
+	Mode    string `auto_read:"mode,query"`
 }
 
 func (h *Handler) BatchDelete(c droplet.Context) (interface{}, error) {
 	input := c.Input().(*BatchDelete)
 
-	if err := h.sslStore.BatchDelete(c.Context(), strings.Split(input.Ids, ",")); err != nil {
+	mode, err := deletion.ParseMode(input.Mode)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	txn, err := deletion.Plan(ctx, mode, map[store.HubKey]store.Interface{
+		store.HubKeySsl: h.sslStore,
+	}, store.HubKeySsl, strings.Split(input.Ids, ","))
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+	if err := txn.CommitParts(ctx); err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
 
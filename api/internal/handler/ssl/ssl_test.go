@@ -715,19 +715,18 @@ func TestSSLs_Delete(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.caseDesc, func(t *testing.T) {
-			getCalled := false
+			var deleted []string
 			sslStore := &store.MockInterface{}
-			sslStore.On("BatchDelete", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
-				getCalled = true
-				input := args.Get(1).([]string)
-				assert.Equal(t, tc.wantInput, input)
-			}).Return(tc.giveErr)
+			sslStore.On("PrepareDelete", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				deleted = append(deleted, args.Get(1).(string))
+			}).Return(&store.Op{}, nil)
+			sslStore.On("Commit", mock.Anything, mock.Anything).Return(tc.giveErr)
 
 			h := Handler{sslStore: sslStore}
 			ctx := droplet.NewContext()
 			ctx.SetInput(tc.giveInput)
 			ret, err := h.BatchDelete(ctx)
-			assert.True(t, getCalled)
+			assert.Equal(t, tc.wantInput, deleted)
 			assert.Equal(t, tc.wantRet, ret)
 			assert.Equal(t, tc.wantErr, err)
 		})
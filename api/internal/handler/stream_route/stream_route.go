@@ -28,6 +28,7 @@ import (
 	"github.com/shiningrush/droplet/wrapper"
 	wgin "github.com/shiningrush/droplet/wrapper/gin"
 
+	"github.com/apisix/manager-api/internal/core/deletion"
 	"github.com/apisix/manager-api/internal/core/entity"
 	"github.com/apisix/manager-api/internal/core/store"
 	"github.com/apisix/manager-api/internal/handler"
@@ -80,6 +81,7 @@ type ListInput struct {
 	ServerPort int    `auto_read:"server_port,query"`
 	SNI        string `auto_read:"sni,query"`
 	store.Pagination
+	store.ListQuery
 }
 
 func (h *Handler) List(c droplet.Context) (interface{}, error) {
@@ -106,10 +108,13 @@ func (h *Handler) List(c droplet.Context) (interface{}, error) {
 		},
 		PageSize:   input.PageSize,
 		PageNumber: input.PageNumber,
+		Cursor:     input.Cursor,
+		WithTotal:  input.WithTotal,
+		Query:      input.ListQuery,
 	})
 
 	if err != nil {
-		return nil, err
+		return handler.SpecCodeResponse(err), err
 	}
 	return ret, nil
 }
@@ -135,7 +140,8 @@ func (h *Handler) Create(c droplet.Context) (interface{}, error) {
 }
 
 type UpdateInput struct {
-	ID string `auto_read:"id,path"`
+	ID      string `auto_read:"id,path"`
+	IfMatch string `auto_read:"If-Match,header" json:"-"`
 	entity.StreamRoute
 }
 
@@ -163,7 +169,13 @@ func (h *Handler) Update(c droplet.Context) (interface{}, error) {
 			return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest}, err
 		}
 	}
-	res, err := h.streamRouteStore.Update(c.Context(), &input.StreamRoute, true)
+
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	res, err := h.streamRouteStore.Update(ctx, &input.StreamRoute, true)
 	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
@@ -172,13 +184,31 @@ func (h *Handler) Update(c droplet.Context) (interface{}, error) {
 }
 
 type BatchDelete struct {
-	IDs string `auto_read:"ids,path"`
+	IDs     string `auto_read:"ids,path"`
+	IfMatch string `auto_read:"If-Match,header"`
+	Mode    string `auto_read:"mode,query"`
 }
 
 func (h *Handler) BatchDelete(c droplet.Context) (interface{}, error) {
 	input := c.Input().(*BatchDelete)
 
-	if err := h.streamRouteStore.BatchDelete(c.Context(), strings.Split(input.IDs, ",")); err != nil {
+	mode, err := deletion.ParseMode(input.Mode)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	txn, err := deletion.Plan(ctx, mode, map[store.HubKey]store.Interface{
+		store.HubKeyStreamRoute: h.streamRouteStore,
+	}, store.HubKeyStreamRoute, strings.Split(input.IDs, ","))
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+	if err := txn.CommitParts(ctx); err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
 
@@ -46,7 +46,7 @@ func (h *Handler) ApplyRoute(r *gin.Engine) {
 	r.POST("/apisix/admin/system_config", wgin.Wraps(h.Post,
 		wrapper.InputType(reflect.TypeOf(entity.SystemConfig{}))))
 	r.PUT("/apisix/admin/system_config", wgin.Wraps(h.Put,
-		wrapper.InputType(reflect.TypeOf(entity.SystemConfig{}))))
+		wrapper.InputType(reflect.TypeOf(PutInput{}))))
 	r.DELETE("/apisix/admin/system_config/:config_name", wgin.Wraps(h.Delete,
 		wrapper.InputType(reflect.TypeOf(DeleteInput{}))))
 }
@@ -80,12 +80,22 @@ func (h *Handler) Post(c droplet.Context) (interface{}, error) {
 	return res, nil
 }
 
+type PutInput struct {
+	entity.SystemConfig
+	IfMatch string `auto_read:"If-Match,header" json:"-"`
+}
+
 func (h *Handler) Put(c droplet.Context) (interface{}, error) {
-	input := c.Input().(*entity.SystemConfig)
+	input := c.Input().(*PutInput)
 	input.UpdateTime = time.Now().Unix()
 
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
 	// update
-	res, err := h.systemConfig.Update(c.Context(), input, false)
+	res, err := h.systemConfig.Update(ctx, &input.SystemConfig, false)
 	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
@@ -95,12 +105,17 @@ func (h *Handler) Put(c droplet.Context) (interface{}, error) {
 
 type DeleteInput struct {
 	ConfigName string `auto_read:"config_name,path" validate:"required"`
+	IfMatch    string `auto_read:"If-Match,header"`
 }
 
 func (h *Handler) Delete(c droplet.Context) (interface{}, error) {
 	input := c.Input().(*DeleteInput)
-	err := h.systemConfig.BatchDelete(c.Context(), []string{input.ConfigName})
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
 
+	err = h.systemConfig.BatchDelete(ctx, []string{input.ConfigName})
 	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
@@ -150,17 +150,19 @@ func TestSystem_Put(t *testing.T) {
 	t.Parallel()
 	type testCase struct {
 		caseDesc  string
-		giveInput *entity.SystemConfig
+		giveInput *PutInput
 		wantErr   error
 		wantRet   interface{}
 		mockStore store.Interface
 		mockFunc  func(tc *testCase)
 	}
 
-	systemConfig := entity.SystemConfig{
-		ConfigName: "grafana",
-		Payload: map[string]interface{}{
-			"url": "http://127.0.0.1:3000",
+	systemConfig := PutInput{
+		SystemConfig: entity.SystemConfig{
+			ConfigName: "grafana",
+			Payload: map[string]interface{}{
+				"url": "http://127.0.0.1:3000",
+			},
 		},
 	}
 
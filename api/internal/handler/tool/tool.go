@@ -21,6 +21,7 @@ import (
 	"github.com/shiningrush/droplet"
 	wgin "github.com/shiningrush/droplet/wrapper/gin"
 
+	"github.com/apisix/manager-api/internal/core/store"
 	"github.com/apisix/manager-api/internal/handler"
 	"github.com/apisix/manager-api/internal/utils"
 )
@@ -39,6 +40,7 @@ func NewHandler() (handler.RouteRegister, error) {
 
 func (h *Handler) ApplyRoute(r *gin.Engine) {
 	r.GET("/apisix/admin/tool/version", wgin.Wraps(h.Version))
+	r.GET("/apisix/admin/tool/sync_status", wgin.Wraps(h.SyncStatus))
 }
 
 func (h *Handler) Version(_ droplet.Context) (interface{}, error) {
@@ -48,3 +50,8 @@ func (h *Handler) Version(_ droplet.Context) (interface{}, error) {
 		Version: version,
 	}, nil
 }
+
+// SyncStatus reports whether each resource is in sync with the storage.
+func (h *Handler) SyncStatus(_ droplet.Context) (interface{}, error) {
+	return store.SyncStatuses(), nil
+}
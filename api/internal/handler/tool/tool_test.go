@@ -22,6 +22,7 @@ import (
 	"github.com/shiningrush/droplet"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/apisix/manager-api/internal/core/store"
 	"github.com/apisix/manager-api/internal/utils"
 )
 
@@ -38,3 +39,12 @@ func TestTool_Version(t *testing.T) {
 		Version: version,
 	}, ret)
 }
+
+func TestTool_SyncStatus(t *testing.T) {
+	h := Handler{}
+	ctx := droplet.NewContext()
+
+	ret, err := h.SyncStatus(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []store.SyncStatus{}, ret)
+}
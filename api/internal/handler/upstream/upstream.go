@@ -18,7 +18,6 @@ package upstream
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"reflect"
 	"strings"
@@ -29,6 +28,7 @@ import (
 	"github.com/shiningrush/droplet/wrapper"
 	wgin "github.com/shiningrush/droplet/wrapper/gin"
 
+	"github.com/apisix/manager-api/internal/core/deletion"
 	"github.com/apisix/manager-api/internal/core/entity"
 	"github.com/apisix/manager-api/internal/core/store"
 	"github.com/apisix/manager-api/internal/handler"
@@ -99,6 +99,7 @@ type ListInput struct {
 	ID string `auto_read:"id,query"`
 	Desc string `auto_read:"desc,query"`
 	store.Pagination
+	store.ListQuery
 }
 
 // swagger:operation GET /apisix/admin/upstreams getUpstreamList
@@ -119,6 +120,36 @@ type ListInput struct {
 //   description: page size
 //   required: false
 //   type: integer
+// - name: cursor
+//   in: query
+//   description: next_cursor of the previous page, the page number is ignored when it's set
+//   required: false
+//   type: string
+// - name: with_total
+//   in: query
+//   description: count total_size of a cursor page, which stops at the end of the page otherwise
+//   required: false
+//   type: boolean
+// - name: sort_by
+//   in: query
+//   description: top level field to sort by, create_time by default
+//   required: false
+//   type: string
+// - name: order
+//   in: query
+//   description: asc or desc
+//   required: false
+//   type: string
+// - name: fields
+//   in: query
+//   description: comma separated top level fields to return
+//   required: false
+//   type: string
+// - name: label_selector
+//   in: query
+//   description: label selector like "env in (prod,staging),!deprecated,team!=core"
+//   required: false
+//   type: string
 // - name: name
 //   in: query
 //   description: name of upstream
@@ -159,9 +190,12 @@ func (h *Handler) List(c droplet.Context) (interface{}, error) {
 		},
 		PageSize:   input.PageSize,
 		PageNumber: input.PageNumber,
+		Cursor:     input.Cursor,
+		WithTotal:  input.WithTotal,
+		Query:      input.ListQuery,
 	})
 	if err != nil {
-		return nil, err
+		return handler.SpecCodeResponse(err), err
 	}
 
 	return ret, nil
@@ -186,7 +220,8 @@ func (h *Handler) Create(c droplet.Context) (interface{}, error) {
 }
 
 type UpdateInput struct {
-	ID string `auto_read:"id,path"`
+	ID      string `auto_read:"id,path"`
+	IfMatch string `auto_read:"If-Match,header" json:"-"`
 	entity.Upstream
 }
 
@@ -208,7 +243,12 @@ func (h *Handler) Update(c droplet.Context) (interface{}, error) {
 		return ret, err
 	}
 
-	res, err := h.upstreamStore.Update(c.Context(), &input.Upstream, true)
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	res, err := h.upstreamStore.Update(ctx, &input.Upstream, true)
 	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
@@ -217,82 +257,34 @@ func (h *Handler) Update(c droplet.Context) (interface{}, error) {
 }
 
 type BatchDelete struct {
-	IDs string `auto_read:"ids,path"`
+	IDs     string `auto_read:"ids,path"`
+	IfMatch string `auto_read:"If-Match,header"`
+	Mode    string `auto_read:"mode,query"`
 }
 
 func (h *Handler) BatchDelete(c droplet.Context) (interface{}, error) {
 	input := c.Input().(*BatchDelete)
 
-	ids := strings.Split(input.IDs, ",")
-	mp := make(map[string]struct{})
-	for _, id := range ids {
-		mp[id] = struct{}{}
-	}
-
-	ret, err := h.routeStore.List(c.Context(), store.ListInput{
-		Predicate: func(obj interface{}) bool {
-			route := obj.(*entity.Route)
-			if _, exist := mp[utils.InterfaceToString(route.UpstreamID)]; exist {
-				return true
-			}
-
-			return false
-		},
-		PageSize:   0,
-		PageNumber: 0,
-	})
+	mode, err := deletion.ParseMode(input.Mode)
 	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
 
-	if ret.TotalSize > 0 {
-		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest},
-			fmt.Errorf("route: %s is using this upstream", ret.Rows[0].(*entity.Route).Name)
-	}
-
-	ret, err = h.serviceStore.List(c.Context(), store.ListInput{
-		Predicate: func(obj interface{}) bool {
-			service := obj.(*entity.Service)
-			if _, exist := mp[utils.InterfaceToString(service.UpstreamID)]; exist {
-				return true
-			}
-
-			return false
-		},
-		PageSize:   0,
-		PageNumber: 0,
-	})
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, 0)
 	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
 
-	if ret.TotalSize > 0 {
-		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest},
-			fmt.Errorf("service: %s is using this upstream", ret.Rows[0].(*entity.Service).Name)
-	}
-
-	ret, err = h.streamRouteStore.List(c.Context(), store.ListInput{
-		Predicate: func(obj interface{}) bool {
-			streamRoute := obj.(*entity.StreamRoute)
-			if _, exist := mp[utils.InterfaceToString(streamRoute.UpstreamID)]; exist {
-				return true
-			}
-
-			return false
-		},
-		PageSize:   0,
-		PageNumber: 0,
-	})
+	txn, err := deletion.Plan(ctx, mode, map[store.HubKey]store.Interface{
+		store.HubKeyUpstream:    h.upstreamStore,
+		store.HubKeyRoute:       h.routeStore,
+		store.HubKeyService:     h.serviceStore,
+		store.HubKeyStreamRoute: h.streamRouteStore,
+	}, store.HubKeyUpstream, strings.Split(input.IDs, ","))
 	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
-
-	if ret.TotalSize > 0 {
-		return &data.SpecCodeResponse{StatusCode: http.StatusBadRequest},
-			fmt.Errorf("stream route: %s is using this upstream", ret.Rows[0].(*entity.StreamRoute).ID)
-	}
-
-	if err = h.upstreamStore.BatchDelete(c.Context(), ids); err != nil {
+	if err := txn.CommitParts(ctx); err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
 
@@ -302,6 +294,7 @@ func (h *Handler) BatchDelete(c droplet.Context) (interface{}, error) {
 type PatchInput struct {
 	ID      string `auto_read:"id,path"`
 	SubPath string `auto_read:"path,path"`
+	IfMatch string `auto_read:"If-Match,header"`
 	Body    []byte `auto_read:"@body"`
 }
 
@@ -327,7 +320,12 @@ func (h *Handler) Patch(c droplet.Context) (interface{}, error) {
 		return handler.SpecCodeResponse(err), err
 	}
 
-	ret, err := h.upstreamStore.Update(c.Context(), &upstream, false)
+	ctx, err := handler.ExpectedVersionContext(c.Context(), input.IfMatch, stored.(*entity.Upstream).ResourceVersion)
+	if err != nil {
+		return handler.SpecCodeResponse(err), err
+	}
+
+	ret, err := h.upstreamStore.Update(ctx, &upstream, false)
 	if err != nil {
 		return handler.SpecCodeResponse(err), err
 	}
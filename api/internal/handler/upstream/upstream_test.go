@@ -32,6 +32,7 @@ import (
 	"github.com/apisix/manager-api/internal/core/entity"
 	"github.com/apisix/manager-api/internal/core/store"
 	"github.com/apisix/manager-api/internal/handler"
+	"github.com/apisix/manager-api/internal/utils"
 	"github.com/apisix/manager-api/internal/utils/consts"
 )
 
@@ -718,13 +719,7 @@ func TestUpstream_Create(t *testing.T) {
 				assert.Equal(t, tc.wantInput, input)
 			}).Return(tc.giveRet, tc.giveErr)
 
-			upstreamStore.On("List", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
-			}).Return(func(input store.ListInput) *store.ListOutput {
-				return &store.ListOutput{
-					Rows:      tc.nameExistRet,
-					TotalSize: len(tc.nameExistRet),
-				}
-			}, nil)
+			upstreamStore.On("IndexLookup", mock.Anything, store.IndexName, mock.Anything).Return(tc.nameExistRet, nil)
 
 			h := Handler{upstreamStore: upstreamStore}
 
@@ -1016,13 +1011,7 @@ func TestUpstream_Update(t *testing.T) {
 				assert.True(t, createIfNotExist)
 			}).Return(tc.giveRet, tc.giveErr)
 
-			upstreamStore.On("List", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
-			}).Return(func(input store.ListInput) *store.ListOutput {
-				return &store.ListOutput{
-					Rows:      tc.nameExistRet,
-					TotalSize: len(tc.nameExistRet),
-				}
-			}, nil)
+			upstreamStore.On("IndexLookup", mock.Anything, store.IndexName, mock.Anything).Return(tc.nameExistRet, nil)
 
 			h := Handler{upstreamStore: upstreamStore}
 			ctx := droplet.NewContext()
@@ -1560,7 +1549,7 @@ func TestUpstreams_Delete(t *testing.T) {
 			routeMockErr: nil,
 			getCalled:    false,
 			wantRet:      &data.SpecCodeResponse{StatusCode: 400},
-			wantErr:      errors.New("route: route1 is using this upstream"),
+			wantErr:      errors.New("upstream/u1 is referenced by route/r1 (upstream_id)"),
 		},
 		{
 			caseDesc: "delete failed, route list error",
@@ -1593,7 +1582,25 @@ func TestUpstreams_Delete(t *testing.T) {
 			serviceMockErr: nil,
 			getCalled:      false,
 			wantRet:        &data.SpecCodeResponse{StatusCode: 400},
-			wantErr:        errors.New("service: service1 is using this upstream"),
+			wantErr:        errors.New("upstream/u1 is referenced by service/s1 (upstream_id)"),
+		},
+		{
+			caseDesc: "delete failed, all the referrers are listed",
+			giveInput: &BatchDelete{
+				IDs: "u1",
+			},
+			wantInput: []string{"u1"},
+			routeMockData: []*entity.Route{
+				{BaseInfo: entity.BaseInfo{ID: "r1"}, UpstreamID: "u1"},
+				{BaseInfo: entity.BaseInfo{ID: "r2"}, UpstreamID: "u1"},
+			},
+			serviceMockData: []*entity.Service{
+				{BaseInfo: entity.BaseInfo{ID: "s1"}, UpstreamID: "u1"},
+			},
+			getCalled: false,
+			wantRet:   &data.SpecCodeResponse{StatusCode: 400},
+			wantErr: errors.New("upstream/u1 is referenced by route/r1 (upstream_id), " +
+				"route/r2 (upstream_id), service/s1 (upstream_id)"),
 		},
 		{
 			caseDesc: "delete failed, service list error",
@@ -1611,80 +1618,62 @@ func TestUpstreams_Delete(t *testing.T) {
 	for _, tc := range tests {
 		t.Run(tc.caseDesc, func(t *testing.T) {
 			getCalled := false
+			var deleted []string
 			upstreamStore := &store.MockInterface{}
-			upstreamStore.On("BatchDelete", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			upstreamStore.On("PrepareDelete", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				deleted = append(deleted, args.Get(1).(string))
+			}).Return(&store.Op{}, nil)
+			upstreamStore.On("Commit", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
 				getCalled = true
-				input := args.Get(1).([]string)
-				assert.Equal(t, tc.wantInput, input)
 			}).Return(tc.giveErr)
 
 			routeStore := &store.MockInterface{}
-			routeStore.On("List", mock.Anything).Return(func(input store.ListInput) *store.ListOutput {
-				var returnData []interface{}
-				for _, c := range tc.routeMockData {
-					if input.Predicate(c) {
-						if input.Format == nil {
-							returnData = append(returnData, c)
-							continue
-						}
-
-						returnData = append(returnData, input.Format(c))
-					}
-				}
-
-				return &store.ListOutput{
-					Rows:      returnData,
-					TotalSize: len(returnData),
-				}
-			}, tc.routeMockErr)
+			routesUsing := map[string][]interface{}{}
+			for _, c := range tc.routeMockData {
+				id := utils.InterfaceToString(c.UpstreamID)
+				routesUsing[id] = append(routesUsing[id], c)
+			}
+			for id, rows := range routesUsing {
+				routeStore.On("IndexLookup", mock.Anything, store.IndexUpstreamID, id).Return(rows, tc.routeMockErr)
+			}
+			routeStore.On("IndexLookup", mock.Anything, store.IndexUpstreamID, mock.Anything).Return(nil, tc.routeMockErr)
 
 			serviceStore := &store.MockInterface{}
-			serviceStore.On("List", mock.Anything).Return(func(input store.ListInput) *store.ListOutput {
-				var returnData []interface{}
-				for _, c := range tc.serviceMockData {
-					if input.Predicate(c) {
-						if input.Format == nil {
-							returnData = append(returnData, c)
-							continue
-						}
-
-						returnData = append(returnData, input.Format(c))
-					}
-				}
-
-				return &store.ListOutput{
-					Rows:      returnData,
-					TotalSize: len(returnData),
-				}
-			}, tc.serviceMockErr)
+			servicesUsing := map[string][]interface{}{}
+			for _, c := range tc.serviceMockData {
+				id := utils.InterfaceToString(c.UpstreamID)
+				servicesUsing[id] = append(servicesUsing[id], c)
+			}
+			for id, rows := range servicesUsing {
+				serviceStore.On("IndexLookup", mock.Anything, store.IndexUpstreamID, id).Return(rows, tc.serviceMockErr)
+			}
+			serviceStore.On("IndexLookup", mock.Anything, store.IndexUpstreamID, mock.Anything).Return(nil, tc.serviceMockErr)
 
 			streamRouteStore := &store.MockInterface{}
-			streamRouteStore.On("List", mock.Anything).Return(func(input store.ListInput) *store.ListOutput {
-				var returnData []interface{}
-				for _, c := range tc.streamRouteMockData {
-					if input.Predicate(c) {
-						if input.Format == nil {
-							returnData = append(returnData, c)
-							continue
-						}
-
-						returnData = append(returnData, input.Format(c))
-					}
-				}
-
-				return &store.ListOutput{
-					Rows:      returnData,
-					TotalSize: len(returnData),
-				}
-			}, tc.streamRouteMockErr)
+			streamRoutesUsing := map[string][]interface{}{}
+			for _, c := range tc.streamRouteMockData {
+				id := utils.InterfaceToString(c.UpstreamID)
+				streamRoutesUsing[id] = append(streamRoutesUsing[id], c)
+			}
+			for id, rows := range streamRoutesUsing {
+				streamRouteStore.On("IndexLookup", mock.Anything, store.IndexUpstreamID, id).Return(rows, tc.streamRouteMockErr)
+			}
+			streamRouteStore.On("IndexLookup", mock.Anything, store.IndexUpstreamID, mock.Anything).Return(nil, tc.streamRouteMockErr)
 
 			h := Handler{upstreamStore: upstreamStore, routeStore: routeStore, serviceStore: serviceStore, streamRouteStore: streamRouteStore}
 			ctx := droplet.NewContext()
 			ctx.SetInput(tc.giveInput)
 			ret, err := h.BatchDelete(ctx)
 			assert.Equal(t, tc.getCalled, getCalled)
+			if tc.getCalled {
+				assert.Equal(t, tc.wantInput, deleted)
+			}
 			assert.Equal(t, tc.wantRet, ret)
-			assert.Equal(t, tc.wantErr, err)
+			if tc.wantErr != nil {
+				assert.EqualError(t, err, tc.wantErr.Error())
+			} else {
+				assert.Nil(t, err)
+			}
 		})
 	}
 }
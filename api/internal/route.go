@@ -28,15 +28,26 @@ import (
 	"github.com/apisix/manager-api/internal/conf"
 	"github.com/apisix/manager-api/internal/filter"
 	"github.com/apisix/manager-api/internal/handler"
+	"github.com/apisix/manager-api/internal/handler/apply"
+	"github.com/apisix/manager-api/internal/handler/audit"
 	"github.com/apisix/manager-api/internal/handler/authentication"
+	"github.com/apisix/manager-api/internal/handler/backup"
+	"github.com/apisix/manager-api/internal/handler/batch"
+	"github.com/apisix/manager-api/internal/handler/cluster"
 	"github.com/apisix/manager-api/internal/handler/consumer"
 	"github.com/apisix/manager-api/internal/handler/data_loader"
+	"github.com/apisix/manager-api/internal/handler/dependency"
+	"github.com/apisix/manager-api/internal/handler/gitops"
 	"github.com/apisix/manager-api/internal/handler/global_rule"
 	"github.com/apisix/manager-api/internal/handler/healthz"
+	"github.com/apisix/manager-api/internal/handler/integrity"
 	"github.com/apisix/manager-api/internal/handler/label"
 	"github.com/apisix/manager-api/internal/handler/migrate"
 	"github.com/apisix/manager-api/internal/handler/plugin_config"
+	"github.com/apisix/manager-api/internal/handler/promote"
 	"github.com/apisix/manager-api/internal/handler/proto"
+	"github.com/apisix/manager-api/internal/handler/recycle"
+	"github.com/apisix/manager-api/internal/handler/revision"
 	"github.com/apisix/manager-api/internal/handler/route"
 	"github.com/apisix/manager-api/internal/handler/schema"
 	"github.com/apisix/manager-api/internal/handler/server_info"
@@ -67,7 +78,7 @@ func SetUpRouter() *gin.Engine {
 	r.Use(filter.Authentication())
 
 	// misc
-	r.Use(gzip.Gzip(gzip.DefaultCompression), filter.CORS(), filter.RequestId(), filter.SchemaCheck(), filter.RecoverHandler())
+	r.Use(gzip.Gzip(gzip.DefaultCompression), filter.CORS(), filter.RequestId(), filter.Cluster(), filter.Audit(), filter.DryRun(), filter.SchemaCheck(), filter.RecoverHandler())
 	r.Use(static.Serve("/", static.LocalFile(filepath.Join(conf.WorkDir, conf.WebDir), false)))
 	r.NoRoute(func(c *gin.Context) {
 		c.File(fmt.Sprintf("%s/index.html", filepath.Join(conf.WorkDir, conf.WebDir)))
@@ -94,6 +105,17 @@ func SetUpRouter() *gin.Engine {
 		proto.NewHandler,
 		stream_route.NewHandler,
 		system_config.NewHandler,
+		revision.NewHandler,
+		audit.NewHandler,
+		recycle.NewHandler,
+		dependency.NewHandler,
+		batch.NewHandler,
+		apply.NewHandler,
+		gitops.NewHandler,
+		cluster.NewHandler,
+		integrity.NewHandler,
+		promote.NewHandler,
+		backup.NewHandler,
 	}
 
 	for i := range factories {
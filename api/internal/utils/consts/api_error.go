@@ -46,3 +46,7 @@ func SystemError(message string) *ApiError {
 func NotFound(message string) *ApiError {
 	return &ApiError{404, 10002, message}
 }
+
+func Unavailable(message string) *ApiError {
+	return &ApiError{503, 10003, message}
+}
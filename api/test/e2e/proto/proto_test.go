@@ -237,7 +237,7 @@ var _ = Describe("Proto", func() {
 				Method:       http.MethodDelete,
 				Path:         "/apisix/admin/proto/1",
 				Headers:      map[string]string{"Authorization": base.GetToken()},
-				ExpectBody:   "proto used check invalid: route",
+				ExpectBody:   "proto/1 is referenced by route/1 (plugins.grpc-transcode.proto_id)",
 				ExpectStatus: http.StatusBadRequest,
 			})
 		}),
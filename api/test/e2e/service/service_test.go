@@ -580,7 +580,7 @@ var _ = Describe("test service delete", func() {
 			Path:         "/apisix/admin/services/s1",
 			Headers:      map[string]string{"Authorization": base.GetToken()},
 			ExpectStatus: http.StatusBadRequest,
-			ExpectBody:   "route: route1 is using this service",
+			ExpectBody:   "service/s1 is referenced by route/r1 (service_id)",
 		}),
 		Entry("delete route first", base.HttpTestCase{
 			Desc:         "delete route first",
@@ -911,7 +911,7 @@ var _ = Describe("test upstream delete (route is in use)", func() {
 			Path:         "/apisix/admin/upstreams/u1",
 			Headers:      map[string]string{"Authorization": base.GetToken()},
 			ExpectStatus: http.StatusBadRequest,
-			ExpectBody:   "route: route1 is using this upstream",
+			ExpectBody:   "upstream/u1 is referenced by route/r1 (upstream_id)",
 		}),
 		Entry("delete route first", base.HttpTestCase{
 			Desc:         "delete route first",
@@ -988,7 +988,7 @@ var _ = Describe("test upstream delete (service is in use)", func() {
 			Path:         "/apisix/admin/upstreams/u1",
 			Headers:      map[string]string{"Authorization": base.GetToken()},
 			ExpectStatus: http.StatusBadRequest,
-			ExpectBody:   "service: service1 is using this upstream",
+			ExpectBody:   "upstream/u1 is referenced by service/s1 (upstream_id)",
 		}),
 		Entry("delete service first", base.HttpTestCase{
 			Desc:         "delete service first",